@@ -55,7 +55,7 @@ func (c *Catalog) load() error {
 	return nil
 }
 
-// save saves table schemas to disk
+// save writes the schema cache to disk
 func (c *Catalog) save() error {
 	data, err := json.MarshalIndent(c.schemas, "", "  ")
 	if err != nil {
@@ -64,27 +64,14 @@ func (c *Catalog) save() error {
 	return os.WriteFile(c.catalogFile(), data, 0644)
 }
 
-// CreateTable creates a new table
+// CreateTable creates a new table. Databases record a SCHEMA_CREATED event and
+// Apply it instead; this is for catalogs used without an event log.
 func (c *Catalog) CreateTable(tableName string, columns []schema.Column) error {
-	if _, exists := c.schemas[tableName]; exists {
-		return fmt.Errorf("table '%s' already exists", tableName)
-	}
-
-	table := &schema.Table{
-		Name:    tableName,
-		Columns: columns,
-	}
-
-	// Identify primary key
-	for _, col := range columns {
-		if col.PrimaryKey {
-			if table.PrimaryKey != "" {
-				return fmt.Errorf("multiple primary keys not allowed")
-			}
-			table.PrimaryKey = col.Name
-		}
+	if err := c.ValidateCreate(tableName, columns, nil); err != nil {
+		return err
 	}
 
+	table, _ := NewTable(tableName, columns)
 	c.schemas[tableName] = table
 	return c.save()
 }
@@ -104,6 +91,16 @@ func (c *Catalog) TableExists(tableName string) bool {
 	return exists
 }
 
+// FindIndex returns the table holding the named index and the index itself
+func (c *Catalog) FindIndex(name string) (*schema.Table, schema.Index, bool) {
+	for _, table := range c.schemas {
+		if idx, exists := table.GetIndex(name); exists {
+			return table, idx, true
+		}
+	}
+	return nil, schema.Index{}, false
+}
+
 // GetAllTables returns all table schemas
 func (c *Catalog) GetAllTables() map[string]*schema.Table {
 	return c.schemas
@@ -1,14 +1,15 @@
 // Package catalog provides database catalog management functionality.
 //
 // The catalog is responsible for maintaining metadata about all tables in the database,
-// including their schemas, column definitions, and primary keys. It persists this
-// information to disk in JSON format and provides a simple interface for creating,
-// retrieving, and querying table schemas.
+// including their schemas, column definitions, and primary keys. It is a projection of
+// the SCHEMA_CREATED and SCHEMA_EVOLVED events in the log: _catalog.json is only a
+// cache, rebuilt from the log whenever it is missing or disagrees with it.
 //
 // Key Responsibilities:
 //   - Managing table schema definitions
-//   - Persisting catalog metadata to disk (_catalog.json)
-//   - Loading catalog metadata on database initialization
+//   - Projecting table schemas from schema events (Apply, Rebuild)
+//   - Deriving the schemas in effect at a past event for temporal queries (Project)
+//   - Caching catalog metadata on disk (_catalog.json)
 //   - Validating table creation (e.g., preventing duplicate tables)
 //   - Identifying primary keys from column definitions
 //
@@ -0,0 +1,298 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"rdbms/eventlog"
+	"rdbms/index"
+	"rdbms/parser"
+	"rdbms/schema"
+	"reflect"
+)
+
+// NewTable builds a table definition from its columns, identifying the primary
+// key. Several PRIMARY KEY columns form a composite key in column order.
+func NewTable(tableName string, columns []schema.Column) (*schema.Table, error) {
+	return NewTableWithKey(tableName, columns, nil)
+}
+
+// NewTableWithKey builds a table definition whose primary key is the given
+// columns, in key order, as declared by PRIMARY KEY (a, b). A nil key takes
+// the columns marked PRIMARY KEY.
+func NewTableWithKey(tableName string, columns []schema.Column, key []string) (*schema.Table, error) {
+	table := &schema.Table{
+		Name:    tableName,
+		Columns: columns,
+	}
+
+	if key == nil {
+		for _, col := range columns {
+			if col.PrimaryKey {
+				key = append(key, col.Name)
+			}
+		}
+	} else {
+		table.Columns = make([]schema.Column, len(columns))
+		copy(table.Columns, columns)
+
+		inKey := make(map[string]bool)
+		for _, name := range key {
+			if inKey[name] {
+				return nil, fmt.Errorf("column '%s' appears twice in the primary key", name)
+			}
+			inKey[name] = true
+			if !hasColumn(table, name) {
+				return nil, fmt.Errorf("primary key column '%s' does not exist", name)
+			}
+		}
+		for i, col := range table.Columns {
+			if col.PrimaryKey && !inKey[col.Name] {
+				return nil, fmt.Errorf("column '%s' is marked PRIMARY KEY but is not in the table's primary key", col.Name)
+			}
+			table.Columns[i].PrimaryKey = inKey[col.Name]
+		}
+	}
+
+	switch len(key) {
+	case 0:
+	case 1:
+		table.PrimaryKey = key[0]
+	default:
+		table.PrimaryKeyColumns = key
+	}
+	return table, nil
+}
+
+// Project derives the table schemas in effect right after the given event
+// from SCHEMA_CREATED, SCHEMA_EVOLVED, INDEX_CREATED and INDEX_DROPPED events.
+// 0 means all events.
+func Project(events []*eventlog.Event, upToEventID uint64) (map[string]*schema.Table, error) {
+	schemas := make(map[string]*schema.Table)
+	for _, e := range events {
+		if upToEventID > 0 && e.ID > upToEventID {
+			break
+		}
+		if err := applyEvent(schemas, e); err != nil {
+			return nil, err
+		}
+	}
+	return schemas, nil
+}
+
+// Apply folds a schema or index event into the catalog and refreshes the
+// cache file. Row and snapshot events are ignored.
+func (c *Catalog) Apply(e *eventlog.Event) error {
+	switch e.Type {
+	case eventlog.SchemaCreated, eventlog.SchemaEvolved, eventlog.IndexCreated, eventlog.IndexDropped:
+	default:
+		return nil
+	}
+	if err := applyEvent(c.schemas, e); err != nil {
+		return err
+	}
+	return c.save()
+}
+
+// Rebuild replaces the catalog with the projection of the event log. The cache
+// file is rewritten only when it disagrees with the log.
+func (c *Catalog) Rebuild(events []*eventlog.Event) error {
+	schemas, err := Project(events, 0)
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(schemas, c.schemas) {
+		return nil
+	}
+	c.schemas = schemas
+	return c.save()
+}
+
+// ValidateCreate checks that a table can be created, without changing the
+// catalog. key is as for NewTableWithKey. The names of system tables are
+// reserved: see schema.IsSystemTable.
+func (c *Catalog) ValidateCreate(tableName string, columns []schema.Column, key []string) error {
+	if _, exists := c.schemas[tableName]; exists {
+		return fmt.Errorf("table '%s' already exists", tableName)
+	}
+	if schema.IsSystemTable(tableName) {
+		return fmt.Errorf("table name '%s' is reserved for rows whose migration failed", tableName)
+	}
+	_, err := NewTableWithKey(tableName, columns, key)
+	return err
+}
+
+// applyEvent updates schemas in place for a single schema event
+func applyEvent(schemas map[string]*schema.Table, e *eventlog.Event) error {
+	switch e.Type {
+	case eventlog.SchemaCreated:
+		var payload eventlog.SchemaCreatedPayload
+		if err := decodePayload(e, &payload); err != nil {
+			return err
+		}
+		var key []string
+		if len(payload.PrimaryKeyColumns) > 0 {
+			key = payload.PrimaryKeyColumns
+		}
+		table, err := NewTableWithKey(payload.TableName, schema.ColumnsFromDefinitions(payload.Columns), key)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", e.ID, err)
+		}
+		if len(table.KeyColumns()) == 0 {
+			table.PrimaryKey = payload.PrimaryKey
+		}
+		schemas[payload.TableName] = table
+
+	case eventlog.SchemaEvolved:
+		var payload eventlog.SchemaEvolvedPayload
+		if err := decodePayload(e, &payload); err != nil {
+			return err
+		}
+		table, err := NewTable(payload.TableName, schema.ColumnsFromDefinitions(payload.NewSchema))
+		if err != nil {
+			return fmt.Errorf("event %d: %w", e.ID, err)
+		}
+		if old, exists := schemas[payload.TableName]; exists {
+			if len(table.KeyColumns()) == 0 && hasColumn(table, old.PrimaryKey) {
+				table.PrimaryKey = old.PrimaryKey
+			}
+			table.PrimaryKeyColumns = carryKeyOrder(old.PrimaryKeyColumns, table.PrimaryKeyColumns, payload.Evolution.RenamedColumns)
+			table.Indexes = carryIndexes(old.Indexes, table, payload.Evolution.RenamedColumns)
+		}
+		schemas[payload.TableName] = table
+
+	case eventlog.IndexCreated:
+		var payload eventlog.IndexCreatedPayload
+		if err := decodePayload(e, &payload); err != nil {
+			return err
+		}
+		table, exists := schemas[payload.TableName]
+		if !exists {
+			return fmt.Errorf("event %d: index '%s' on unknown table '%s'", e.ID, payload.IndexName, payload.TableName)
+		}
+		table.Indexes = append(table.Indexes, schema.Index{
+			Name:      payload.IndexName,
+			Columns:   payload.Columns,
+			Unique:    payload.Unique,
+			Method:    schema.IndexMethod(payload.Method),
+			Stemming:  payload.Stemming,
+			Stopwords: payload.Stopwords,
+			Where:     payload.Where,
+		})
+
+	case eventlog.IndexDropped:
+		var payload eventlog.IndexDroppedPayload
+		if err := decodePayload(e, &payload); err != nil {
+			return err
+		}
+		if table, exists := schemas[payload.TableName]; exists {
+			table.Indexes = withoutIndex(table.Indexes, payload.IndexName)
+		}
+	}
+	return nil
+}
+
+// carryIndexes keeps a table's indexes across an evolution, following renamed
+// columns in their keys and predicates. An index on a column the new schema no
+// longer has is dropped.
+func carryIndexes(indexes []schema.Index, table *schema.Table, renamed map[string]string) []schema.Index {
+	var kept []schema.Index
+	for _, idx := range indexes {
+		columns := make([]string, len(idx.Columns))
+		valid := true
+		for i, col := range idx.Columns {
+			columns[i] = carryRef(col, renamed)
+			_, name, _ := index.SplitRef(columns[i])
+			valid = valid && hasColumn(table, name)
+		}
+		if idx.Where != "" {
+			predicate, err := parser.ParseWhere(idx.Where)
+			valid = valid && err == nil
+			for _, c := range predicate.Conditions() {
+				c.Column = carryRef(c.Column, renamed)
+				_, name, _ := index.SplitRef(c.Column)
+				valid = valid && hasColumn(table, name)
+			}
+			if valid {
+				idx.Where = predicate.String()
+			}
+		}
+		if valid {
+			idx.Columns = columns
+			kept = append(kept, idx)
+		}
+	}
+	return kept
+}
+
+// carryRef follows a rename in a column reference: email becomes mail, and
+// LOWER(email) becomes LOWER(mail)
+func carryRef(ref string, renamed map[string]string) string {
+	fn, name, err := index.SplitRef(ref)
+	if err != nil {
+		return ref
+	}
+	if newName, ok := renamed[name]; ok {
+		name = newName
+	}
+	if fn == "" {
+		return name
+	}
+	return fmt.Sprintf("%s(%s)", fn, name)
+}
+
+// carryKeyOrder keeps the key order of a composite primary key across an
+// evolution when it still covers the same, possibly renamed, columns. Column
+// definitions only mark key columns, so they alone give the key in column order.
+func carryKeyOrder(oldKey, newKey []string, renamed map[string]string) []string {
+	if len(oldKey) != len(newKey) {
+		return newKey
+	}
+	inNew := make(map[string]bool)
+	for _, name := range newKey {
+		inNew[name] = true
+	}
+	ordered := make([]string, len(oldKey))
+	for i, name := range oldKey {
+		if newName, ok := renamed[name]; ok {
+			name = newName
+		}
+		if !inNew[name] {
+			return newKey
+		}
+		ordered[i] = name
+	}
+	return ordered
+}
+
+// withoutIndex returns indexes minus the one with the given name
+func withoutIndex(indexes []schema.Index, name string) []schema.Index {
+	var kept []schema.Index
+	for _, idx := range indexes {
+		if idx.Name != name {
+			kept = append(kept, idx)
+		}
+	}
+	return kept
+}
+
+// decodePayload converts a generic event payload into a typed one
+func decodePayload(e *eventlog.Event, target interface{}) error {
+	data, err := json.Marshal(e.Payload)
+	if err != nil {
+		return fmt.Errorf("event %d: %w", e.ID, err)
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("event %d: invalid %s payload: %w", e.ID, e.Type, err)
+	}
+	return nil
+}
+
+// hasColumn reports whether a table defines the named column
+func hasColumn(table *schema.Table, name string) bool {
+	for _, col := range table.Columns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
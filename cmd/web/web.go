@@ -3,6 +3,7 @@ package web
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -54,15 +55,79 @@ func (app *TaskApp) handleGetTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := app.db.Select("tasks", nil)
+	rows, err := app.db.Query(&parser.ParsedStatement{Type: "SELECT", TableName: "tasks"})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer rows.Close()
 
+	// Write the array a task at a time rather than building it in memory
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(rows)
+	fmt.Fprint(w, "[")
+	for n := 0; rows.Next(); n++ {
+		if n > 0 {
+			fmt.Fprint(w, ",")
+		}
+		data, _ := json.Marshal(rows.Row())
+		w.Write(data)
+	}
+	fmt.Fprintln(w, "]")
+}
+
+// HandleQuery runs a SELECT or JOIN given as ?sql= or as the request body and
+// streams its rows as newline-delimited JSON, flushing each one. An error
+// after the first row ends the stream with an {"error": ...} line. A client
+// that disconnects cancels the query, removing any spill files it made.
+func (app *TaskApp) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	sql := r.URL.Query().Get("sql")
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		sql = string(body)
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stmt, err := parser.New().Parse(sql)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if stmt.Type != "SELECT" && stmt.Type != "JOIN" {
+		http.Error(w, "Only SELECT and JOIN queries are accepted", http.StatusBadRequest)
+		return
+	}
+	rows, err := app.db.Query(stmt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for rows.Next() {
+		if r.Context().Err() != nil {
+			return // The client went away
+		}
+		if err := encoder.Encode(rows.Row()); err != nil {
+			return // The client went away
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		encoder.Encode(map[string]string{"error": err.Error()})
+	}
 }
 
 func (app *TaskApp) handleCreateTask(w http.ResponseWriter, r *http.Request) {
@@ -204,6 +269,8 @@ func RunServer(db *database.Database, port string) error {
 		}
 	})
 
+	http.HandleFunc("/query", app.HandleQuery)
+
 	fmt.Printf("🚀 Task API server running on http://localhost:%s\n", port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		return fmt.Errorf("error starting server: %w", err)
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"math"
+
+	"rdbms/index"
+	"rdbms/parser"
+)
+
+// Cost model. Costs are in units of reading one row in a full scan; the
+// planner compares them only with each other.
+const (
+	seqRowCost    = 1.0  // Reading a row in a full scan
+	fetchRowCost  = 1.5  // Fetching a row an index found, out of scan order
+	rowIDCost     = 0.1  // Collecting a row ID for an index intersection
+	sortRowCost   = 0.1  // Each comparison of a sort
+	joinPairCost  = 0.01 // Comparing a pair of rows in a nested loop join
+	hashBuildCost = 1.0  // Hashing a row into a hash join's table
+	hashProbeCost = 0.2  // Probing a hash join's table with a row
+)
+
+// Estimates for tables and columns ANALYZE has not seen, after PostgreSQL's
+const (
+	defaultRowCount   = 1000
+	defaultDistinct   = 200
+	defaultEqualSel   = 0.005
+	defaultRangeSel   = 1.0 / 3
+	defaultBetweenSel = 0.005
+)
+
+// estimator estimates how many rows of a table satisfy conditions
+type estimator struct {
+	rows  float64
+	stats *TableStats // nil before ANALYZE
+}
+
+// estimator returns the estimator of a table (caller holds db.mu)
+func (db *Database) estimator(tableName string) estimator {
+	ts, exists := db.stats[tableName]
+	if !exists {
+		return estimator{rows: defaultRowCount}
+	}
+	return estimator{rows: float64(ts.RowCount), stats: ts}
+}
+
+// column returns the statistics of the column a reference reads, or nil
+func (e estimator) column(ref string) *ColumnStats {
+	if e.stats == nil {
+		return nil
+	}
+	_, column, err := index.SplitRef(ref)
+	if err != nil {
+		return nil
+	}
+	return e.stats.Columns[column]
+}
+
+// distinct estimates the distinct values of a column
+func (e estimator) distinct(ref string) float64 {
+	cs := e.column(ref)
+	switch {
+	case cs == nil:
+		return math.Max(1, math.Min(e.rows, defaultDistinct))
+	case cs.Distinct < 0:
+		return math.Max(1, -cs.Distinct*e.rows)
+	default:
+		return math.Max(1, cs.Distinct)
+	}
+}
+
+// selectivity estimates the fraction of rows satisfying every condition,
+// taking the conditions to be independent
+func (e estimator) selectivity(conds ...*parser.WhereClause) float64 {
+	sel := 1.0
+	for _, c := range conds {
+		sel *= e.conditionSelectivity(c)
+	}
+	return sel
+}
+
+// conditionSelectivity estimates the fraction of rows satisfying one condition
+func (e estimator) conditionSelectivity(c *parser.WhereClause) float64 {
+	cs := e.column(c.Column)
+	fn, _, _ := index.SplitRef(c.Column)
+	least := 1 / math.Max(1, e.rows)
+
+	switch c.Operator {
+	case "", "=":
+		if cs == nil {
+			return defaultEqualSel
+		}
+		// Values outside the column's range match next to nothing
+		if fn == "" && (cs.Min == nil || index.Compare(c.Value, cs.Min) < 0 || index.Compare(c.Value, cs.Max) > 0) {
+			return least
+		}
+		return math.Max(least, (1-cs.NullFraction)/e.distinct(c.Column))
+	case "<", "<=", ">", ">=", "BETWEEN":
+		// The histogram holds the column's values, not a function's
+		if cs == nil || fn != "" || len(cs.Histogram) < 2 {
+			if c.Operator == "BETWEEN" {
+				return defaultBetweenSel
+			}
+			return defaultRangeSel
+		}
+		var lo, hi float64
+		switch c.Operator {
+		case "<", "<=":
+			lo, hi = 0, cs.below(c.Value)
+		case ">", ">=":
+			lo, hi = cs.below(c.Value), 1
+		default:
+			lo, hi = cs.below(c.Value), cs.below(c.High)
+		}
+		return math.Max(least, (1-cs.NullFraction)*math.Max(0, hi-lo))
+	}
+	return defaultRangeSel
+}
+
+// below estimates the fraction of a column's non-NULL values less than a
+// value from its histogram, interpolating within a bucket of numbers
+func (cs *ColumnStats) below(val interface{}) float64 {
+	h := cs.Histogram
+	last := len(h) - 1
+	if index.Compare(val, h[0]) <= 0 {
+		return 0
+	}
+	if index.Compare(val, h[last]) > 0 {
+		return 1
+	}
+	for i := 0; i < last; i++ {
+		if index.Compare(val, h[i+1]) > 0 {
+			continue
+		}
+		within := 0.5
+		v, ok1 := val.(float64)
+		lo, ok2 := h[i].(float64)
+		hi, ok3 := h[i+1].(float64)
+		if ok1 && ok2 && ok3 && hi > lo {
+			within = (v - lo) / (hi - lo)
+		}
+		return (float64(i) + within) / float64(last)
+	}
+	return 1
+}
+
+// probeCost is the cost of finding the first key in an index
+func probeCost(ti *tableIndex, rows float64) float64 {
+	if ti.ordered() {
+		return math.Log2(rows + 1)
+	}
+	return 1
+}
+
+// sortCost is the cost of sorting rows
+func sortCost(rows float64) float64 {
+	if rows < 2 {
+		return 0
+	}
+	return sortRowCost * rows * math.Log2(rows)
+}
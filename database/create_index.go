@@ -0,0 +1,263 @@
+package database
+
+import (
+	"fmt"
+
+	"rdbms/eventlog"
+	"rdbms/index"
+	"rdbms/parser"
+	"rdbms/schema"
+)
+
+// CreateIndex defines a secondary index and builds it. The INDEX_CREATED event
+// is written first, so the definition survives restarts. The index is then
+// built from the state as of that event without holding the database lock;
+// writes made meanwhile are queued and applied once the build is done, and the
+// planner ignores the index until then. A unique index whose rows turn out to
+// hold duplicates is dropped again and an error returned.
+func (db *Database) CreateIndex(tableName string, def schema.Index) error {
+	ti, eventID, err := db.defineIndex(tableName, &def)
+	if err != nil {
+		return err
+	}
+
+	built, err := db.buildIndex(tableName, def, eventID)
+	if err != nil {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return db.abandonIndex(tableName, ti, err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if current := db.indexes[tableName][def.Name]; current != ti {
+		if current == nil {
+			return fmt.Errorf("index '%s' was dropped before its build finished", def.Name)
+		}
+		// A schema change rebuilt the table's indexes, this one included
+		return nil
+	}
+
+	for _, change := range ti.pending {
+		if !change.add {
+			built.Remove(change.key, change.rowID)
+			continue
+		}
+		if rowIDs, found := built.Lookup(change.key); def.Unique && found && len(rowIDs) > 0 {
+			return db.abandonIndex(tableName, ti, duplicateKeyError(def, change.key))
+		}
+		built.Add(change.key, change.rowID)
+	}
+
+	ti.idx = built
+	ti.building = false
+	ti.pending = nil
+
+	// Save the build; if this fails, the next open rebuilds the table instead
+	if eventID, root, err := db.logPosition(); err == nil {
+		db.checkpointTableIndexes(tableName, eventID, root)
+	}
+	return nil
+}
+
+// defineIndex validates a definition, records it and registers the index as
+// building. It returns the index and the ID of its INDEX_CREATED event.
+func (db *Database) defineIndex(tableName string, def *schema.Index) (*tableIndex, uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	table, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := db.validateIndex(table, def); err != nil {
+		return nil, 0, err
+	}
+
+	txID := fmt.Sprintf("tx_%d", db.eventStore.GetLastEventID())
+	event, err := db.eventStore.RecordIndexCreated(&eventlog.IndexCreatedPayload{
+		TableName: tableName,
+		IndexName: def.Name,
+		Columns:   def.Columns,
+		Unique:    def.Unique,
+		Method:    string(def.Method),
+		Stemming:  def.Stemming,
+		Stopwords: def.Stopwords,
+		Where:     def.Where,
+	}, txID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := db.catalog.Apply(event); err != nil {
+		return nil, 0, err
+	}
+
+	ti := newDefinedIndex(*def)
+	ti.building = true
+	db.indexes[tableName][def.Name] = ti
+	return ti, event.ID, nil
+}
+
+// validateIndex checks a definition against a table, defaulting its method to BTREE
+func (db *Database) validateIndex(table *schema.Table, def *schema.Index) error {
+	if def.Name == "" {
+		return fmt.Errorf("index name is required")
+	}
+	if _, _, exists := db.catalog.FindIndex(def.Name); exists {
+		return fmt.Errorf("index '%s' already exists", def.Name)
+	}
+	for tableName := range db.indexes {
+		if _, exists := db.indexes[tableName][def.Name]; exists {
+			return fmt.Errorf("index '%s' already exists", def.Name)
+		}
+	}
+
+	switch def.Method {
+	case "":
+		def.Method = schema.IndexBTree
+	case schema.IndexHash, schema.IndexBTree, schema.IndexFullText:
+	default:
+		return fmt.Errorf("unknown index method '%s'", def.Method)
+	}
+	if def.Method != schema.IndexFullText && (def.Stemming || def.Stopwords) {
+		return fmt.Errorf("index '%s': stemming and stopwords apply to FULLTEXT indexes only", def.Name)
+	}
+
+	if len(def.Columns) == 0 {
+		return fmt.Errorf("index '%s' needs at least one column", def.Name)
+	}
+	seen := make(map[string]bool)
+	for _, name := range def.Columns {
+		if seen[name] {
+			return fmt.Errorf("column '%s' appears twice in index '%s'", name, def.Name)
+		}
+		seen[name] = true
+		if err := checkColumnRef(table, name); err != nil {
+			return fmt.Errorf("index '%s': %v", def.Name, err)
+		}
+	}
+
+	if def.Where != "" {
+		if def.Method == schema.IndexFullText {
+			return fmt.Errorf("FULLTEXT index '%s' cannot be partial", def.Name)
+		}
+		predicate, err := parser.ParseWhere(def.Where)
+		if err != nil {
+			return fmt.Errorf("index '%s': invalid predicate: %v", def.Name, err)
+		}
+		for _, c := range predicate.Conditions() {
+			if c.Operator == "MATCH" {
+				return fmt.Errorf("index '%s': a predicate cannot use MATCH", def.Name)
+			}
+			if err := checkColumnRef(table, c.Column); err != nil {
+				return fmt.Errorf("index '%s' predicate: %v", def.Name, err)
+			}
+		}
+	}
+
+	if def.Method == schema.IndexFullText {
+		if len(def.Columns) != 1 {
+			return fmt.Errorf("FULLTEXT index '%s' must cover exactly one column", def.Name)
+		}
+		if def.Unique {
+			return fmt.Errorf("FULLTEXT index '%s' cannot be UNIQUE", def.Name)
+		}
+		for _, col := range table.Columns {
+			if col.Name == def.Columns[0] && col.Type != schema.TypeText {
+				return fmt.Errorf("FULLTEXT index '%s' needs a TEXT column, '%s' is %s", def.Name, col.Name, col.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// buildIndex builds an index from a table's rows as of an event
+func (db *Database) buildIndex(tableName string, def schema.Index, eventID uint64) (index.Interface, error) {
+	state, err := db.queryEngine.GetStateAsOf(eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	ti := newDefinedIndex(def)
+	built := ti.idx
+	for _, r := range rowsByID(state, tableName) {
+		key, exists := ti.key(r.Row)
+		if !exists || !ti.covers(r.Row) {
+			continue
+		}
+		if def.Unique && built.Exists(key) {
+			return nil, duplicateKeyError(def, key)
+		}
+		built.Add(key, r.ID)
+	}
+	return built, nil
+}
+
+// abandonIndex drops an index whose build failed, recording the drop so the
+// definition does not come back on restart
+func (db *Database) abandonIndex(tableName string, ti *tableIndex, cause error) error {
+	if db.indexes[tableName][ti.name] != ti {
+		return cause
+	}
+	if err := db.dropIndex(tableName, ti.name); err != nil {
+		return fmt.Errorf("%v (and dropping it failed: %v)", cause, err)
+	}
+	return cause
+}
+
+// DropIndex removes an index created with CREATE INDEX. Indexes backing a
+// PRIMARY KEY or UNIQUE column cannot be dropped.
+func (db *Database) DropIndex(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	table, _, exists := db.catalog.FindIndex(name)
+	if !exists {
+		for tableName := range db.indexes {
+			if _, implicit := db.indexes[tableName][name]; implicit {
+				return fmt.Errorf("index '%s' enforces a constraint on table '%s' and cannot be dropped", name, tableName)
+			}
+		}
+		return fmt.Errorf("index '%s' does not exist", name)
+	}
+	return db.dropIndex(table.Name, name)
+}
+
+// dropIndex records an INDEX_DROPPED event and removes the index (caller holds db.mu)
+func (db *Database) dropIndex(tableName, name string) error {
+	txID := fmt.Sprintf("tx_%d", db.eventStore.GetLastEventID())
+	event, err := db.eventStore.RecordIndexDropped(tableName, name, txID)
+	if err != nil {
+		return err
+	}
+	if err := db.catalog.Apply(event); err != nil {
+		return err
+	}
+	delete(db.indexes[tableName], name)
+	return nil
+}
+
+// duplicateKeyError reports a value that stops a unique index from being built
+func duplicateKeyError(def schema.Index, key interface{}) error {
+	return fmt.Errorf("could not create unique index '%s': duplicate value '%v'", def.Name, key)
+}
+
+// checkColumnRef checks that a column reference names one of the table's
+// columns, and that a function such as LOWER is applied to a TEXT column
+func checkColumnRef(table *schema.Table, ref string) error {
+	fn, name, err := index.SplitRef(ref)
+	if err != nil {
+		return err
+	}
+	for _, col := range table.Columns {
+		if col.Name != name {
+			continue
+		}
+		if fn != "" && col.Type != schema.TypeText {
+			return fmt.Errorf("%s needs a TEXT column, '%s' is %s", fn, name, col.Type)
+		}
+		return nil
+	}
+	return fmt.Errorf("column '%s' does not exist in table '%s'", name, table.Name)
+}
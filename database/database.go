@@ -2,22 +2,27 @@ package database
 
 import (
 	"sync"
+	"time"
 
 	"rdbms/catalog"
-	"rdbms/index"
 	"rdbms/storage"
 )
 
 // Database is the main database interface - now backed by immutable event log
 type Database struct {
-	mu               sync.RWMutex
-	eventStore       *storage.EventStore
-	queryEngine      *storage.QueryEngine
-	snapshotManager  *storage.SnapshotManager
-	catalog          *catalog.Catalog
-	indexes          map[string]map[string]*index.Index // table -> column -> index
-	nextRowID        map[string]int64                   // table -> next row ID
-	snapshotInterval int64                              // Create snapshot every N events
+	mu                sync.RWMutex
+	dataDir           string
+	eventStore        *storage.EventStore
+	queryEngine       *storage.QueryEngine
+	snapshotManager   *storage.SnapshotManager
+	snapshotScheduler *storage.SnapshotScheduler
+	migrationRewriter *storage.MigrationRewriter
+	catalog           *catalog.Catalog
+	indexes           map[string]map[string]*tableIndex // table -> index name -> index
+	nextRowID         map[string]int64                  // table -> next row ID
+	indexRecovery     IndexRecovery                     // how indexes were restored on open
+	stats             map[string]*TableStats            // table -> statistics collected by ANALYZE
+	queryMemory       int64                             // bytes a query may hold before spilling
 }
 
 // New creates a new database instance backed by event log
@@ -33,30 +38,54 @@ func New(dataDir string) (*Database, error) {
 	if err != nil {
 		return nil, err
 	}
+	snapshotManager.SetEventStore(eventStore)
 
-	// Initialize query engine (snapshots + event replay)
+	// Initialize query engine (snapshots + event replay); rows written in older
+	// schema versions are migrated as they are read
 	queryEngine := storage.NewQueryEngine(eventStore, snapshotManager)
+	queryEngine.SetLazyMigration(true)
 
-	// Load catalog (schema)
+	// Load the catalog cache, then bring it in line with the schema events
 	cat, err := catalog.New(dataDir)
 	if err != nil {
 		return nil, err
 	}
+	events, err := eventStore.ReadAllEvents()
+	if err != nil {
+		return nil, err
+	}
+	if err := cat.Rebuild(events); err != nil {
+		return nil, err
+	}
 
 	db := &Database{
-		eventStore:       eventStore,
-		queryEngine:      queryEngine,
-		snapshotManager:  snapshotManager,
-		catalog:          cat,
-		indexes:          make(map[string]map[string]*index.Index),
-		nextRowID:        make(map[string]int64),
-		snapshotInterval: 1000, // Snapshot every 1000 events
+		dataDir:         dataDir,
+		eventStore:      eventStore,
+		queryEngine:     queryEngine,
+		snapshotManager: snapshotManager,
+		snapshotScheduler: storage.NewSnapshotScheduler(eventStore, snapshotManager, queryEngine,
+			storage.DefaultSnapshotPolicy(), storage.DefaultRetentionPolicy()),
+		migrationRewriter: storage.NewMigrationRewriter(queryEngine, snapshotManager, time.Minute),
+		catalog:           cat,
+		indexes:           make(map[string]map[string]*tableIndex),
+		nextRowID:         make(map[string]int64),
+		queryMemory:       DefaultQueryMemory,
+	}
+
+	// Spill files belong to queries that died with the last process
+	if err := removeSpillFiles(dataDir); err != nil {
+		return nil, err
 	}
 
-	// Rebuild indexes from current state
-	if err := db.rebuildAllIndexes(); err != nil {
+	// Load index checkpoints and catch them up from the log, rebuilding the
+	// indexes of any table whose checkpoint cannot be used
+	if err := db.loadIndexes(); err != nil {
 		return nil, err
 	}
+	db.loadStatistics()
+
+	// Snapshot in the background, independently of which statements run
+	db.snapshotScheduler.Start()
 
 	return db, nil
 }
@@ -66,7 +95,24 @@ func (db *Database) GetEventStore() *storage.EventStore {
 	return db.eventStore
 }
 
-// Close closes the database
+// SnapshotScheduler returns the background snapshot scheduler
+func (db *Database) SnapshotScheduler() *storage.SnapshotScheduler {
+	return db.snapshotScheduler
+}
+
+// MigrationRewriter returns the background task that rewrites rows still in an
+// older schema version. It is not started by default.
+func (db *Database) MigrationRewriter() *storage.MigrationRewriter {
+	return db.migrationRewriter
+}
+
+// Close checkpoints the indexes and statistics and closes the database
 func (db *Database) Close() error {
-	return db.eventStore.Close()
+	db.migrationRewriter.Stop()
+	db.snapshotScheduler.Stop()
+	checkpointErr := db.CheckpointIndexes()
+	if err := db.eventStore.Close(); err != nil {
+		return err
+	}
+	return checkpointErr
 }
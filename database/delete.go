@@ -26,18 +26,20 @@ func (db *Database) Delete(tableName string, where *parser.WhereClause) (int, er
 	}
 
 	// Find rows matching WHERE clause
+	plan, err := db.planSelect(tableName, where, nil, 0)
+	if err != nil {
+		return 0, err
+	}
 	rows := state.GetTableRows(tableName)
 
 	count := 0
 	txID := fmt.Sprintf("tx_%d", db.eventStore.GetLastEventID())
 
 	for _, r := range rows {
-		if val, exists := r.Row[where.Column]; exists && valuesEqual(val, where.Value) {
+		if plan.matches(r) {
 			// Remove from indexes
-			for colName, idx := range db.indexes[tableName] {
-				if colVal, exists := r.Row[colName]; exists {
-					idx.Remove(colVal, r.ID)
-				}
+			for _, ti := range db.indexes[tableName] {
+				ti.remove(r.Row, r.ID)
 			}
 
 			// Record the deletion event (preserve row data for recovery)
@@ -45,6 +47,7 @@ func (db *Database) Delete(tableName string, where *parser.WhereClause) (int, er
 			if err != nil {
 				return count, err
 			}
+			db.noteDelete(tableName)
 
 			count++
 		}
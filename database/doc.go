@@ -6,8 +6,8 @@
 //
 // Architecture:
 //   - Event-Sourced: All changes are recorded as events in an append-only log
-//   - Snapshot-Based: Periodic snapshots speed up query performance
-//   - Indexed: Hash-based indexes on columns for fast lookups
+//   - Snapshot-Based: A background scheduler snapshots state to speed up queries
+//   - Indexed: Hash and B-tree indexes, checkpointed to disk and caught up from the log on open
 //   - Thread-Safe: Uses mutexes to ensure concurrent access safety
 //
 // Key Responsibilities:
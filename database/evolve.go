@@ -0,0 +1,72 @@
+package database
+
+import (
+	"fmt"
+
+	"rdbms/catalog"
+	"rdbms/eventlog"
+	"rdbms/schema"
+	"rdbms/storage"
+)
+
+// EvolveTable records a SCHEMA_EVOLVED event moving a table to a new set of
+// columns, then folds it into the catalog and rebuilds the table's indexes.
+// migrationID and direction identify the migration file that produced the
+// change; both are empty for ad-hoc evolutions.
+func (db *Database) EvolveTable(tableName string, newColumns []schema.Column, evolution eventlog.SchemaEvolution, migrationID, direction string) (*eventlog.Event, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	table, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(newColumns) == 0 {
+		return nil, fmt.Errorf("table '%s' must keep at least one column", tableName)
+	}
+	newTable, err := catalog.NewTable(tableName, newColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	txID := fmt.Sprintf("tx_%d", db.eventStore.GetLastEventID())
+	event, err := db.eventStore.RecordSchemaEvolution(&eventlog.SchemaEvolvedPayload{
+		TableName: tableName,
+		Evolution: evolution,
+		OldSchema: schema.ColumnDefinitions(table.Columns),
+		NewSchema: schema.ColumnDefinitions(newTable.Columns),
+		Migration: migrationID,
+		Direction: direction,
+	}, txID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.catalog.Apply(event); err != nil {
+		return nil, err
+	}
+
+	evolved, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.rebuildIndexes(tableName, evolved); err != nil {
+		return nil, err
+	}
+	db.forgetColumnStats(tableName)
+
+	return event, nil
+}
+
+// MigrationErrors returns the rows that could not be migrated to their table's
+// current schema version. The same rows are queryable as schema.MigrationErrorTable.
+func (db *Database) MigrationErrors() ([]storage.MigrationError, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	state, err := db.queryEngine.GetCurrentState()
+	if err != nil {
+		return nil, err
+	}
+	return state.MigrationErrors(), nil
+}
@@ -0,0 +1,346 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"rdbms/parser"
+	"rdbms/storage"
+)
+
+// PlanNode is one operator of a query plan, as EXPLAIN shows it
+type PlanNode struct {
+	Operator string   `json:"operator"`          // e.g. "Index Range on users.age (btree)"
+	Details  []string `json:"details,omitempty"` // e.g. "Index Cond: age > 30", "Filter: name = 'ann'"
+
+	// Estimates: cost includes the operator's inputs
+	Cost float64 `json:"cost"`
+	Rows float64 `json:"rows"`
+
+	// What EXPLAIN ANALYZE saw: rows returned and time spent, including the
+	// operator's inputs. An operator run once per outer row sums its runs.
+	ActualRows int           `json:"actual_rows"`
+	Time       time.Duration `json:"time"`
+	SpillFiles int           `json:"spill_files,omitempty"` // Written when it ran over the query's memory
+
+	Children []*PlanNode `json:"children,omitempty"`
+}
+
+// Explanation is the plan EXPLAIN chose for a query. EXPLAIN ANALYZE also runs
+// the query and reports how the state it read was built and how long it took.
+type Explanation struct {
+	Plan     *PlanNode           `json:"plan"`
+	Analyzed bool                `json:"analyzed"`
+	State    storage.StateSource `json:"state"`
+	Time     time.Duration       `json:"time"` // Building the state and running the plan
+}
+
+// selectNodes are the nodes a single-table plan records into; nil ones record nothing
+type selectNodes struct {
+	limit, sort, access *PlanNode
+	probes              []*PlanNode
+}
+
+// joinNodes are the nodes a join plan records into; nil ones record nothing
+type joinNodes struct {
+	join, inner *PlanNode
+	left, right selectNodes
+}
+
+// record adds rows an operator returned and the time since it started
+func (n *PlanNode) record(rows int, start time.Time) {
+	if n == nil {
+		return
+	}
+	n.ActualRows += rows
+	n.Time += time.Since(start)
+}
+
+// ExplainSelect describes the plan SelectOrdered would run. With analyze set
+// it runs the plan and records what each operator did.
+func (db *Database) ExplainSelect(tableName string, where *parser.WhereClause, order *parser.OrderBy, limit int, analyze bool) (*Explanation, error) {
+	return db.Explain(&parser.ParsedStatement{Type: "SELECT", TableName: tableName, Where: where, OrderBy: order, Limit: limit}, analyze)
+}
+
+// ExplainJoin describes the plan Join would run. With analyze set it runs the
+// plan and records what each operator did.
+func (db *Database) ExplainJoin(leftTable, rightTable string, condition *parser.JoinCondition, where *parser.WhereClause, analyze bool) (*Explanation, error) {
+	return db.Explain(&parser.ParsedStatement{Type: "JOIN", TableName: leftTable, JoinTable: rightTable, JoinCondition: condition, Where: where}, analyze)
+}
+
+// Explain describes the plan Query would run for a SELECT or JOIN. With
+// analyze set it runs the plan to the end and records what each operator did.
+func (db *Database) Explain(stmt *parser.ParsedStatement, analyze bool) (*Explanation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if stmt.AsOf > 0 {
+		return nil, fmt.Errorf("EXPLAIN does not support AS OF queries")
+	}
+	plan, err := db.planQuery(stmt)
+	if err != nil {
+		return nil, err
+	}
+	root, nodes := plan.explain()
+	explanation := &Explanation{Plan: root, Analyzed: analyze}
+	if !analyze {
+		return explanation, nil
+	}
+
+	start := time.Now()
+	state, source, err := db.queryEngine.GetCurrentStateWithSource()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := collect(plan.open(state, nil, db.newQueryMemory(), nodes)); err != nil {
+		return nil, err
+	}
+	explanation.State, explanation.Time = source, time.Since(start)
+	return explanation, nil
+}
+
+// explain builds the query's operator tree: the plan reading the rows, then
+// any aggregate with its sort and limit, then any projection
+func (q *queryPlan) explain() (*PlanNode, queryNodes) {
+	var nodes queryNodes
+	if q.join != nil {
+		root, joinNodes := q.join.explain()
+		nodes.join = joinNodes
+		return root, nodes
+	}
+
+	root, selNodes := q.sel.explain()
+	nodes.sel = selNodes
+	if q.aggregated {
+		operator, groups := "Aggregate", q.groups
+		if len(q.groupBy) > 0 {
+			operator = "Group by " + strings.Join(q.groupBy, ", ")
+		}
+		cost := root.Cost + root.Rows*hashProbeCost
+		root = &PlanNode{Operator: operator, Details: []string{"Output: " + itemsString(q.items)}, Cost: cost, Rows: groups, Children: []*PlanNode{root}}
+		nodes.aggregate = root
+
+		if q.order != nil {
+			direction := ""
+			if q.order.Desc {
+				direction = " DESC"
+			}
+			cost += sortCost(groups)
+			root = &PlanNode{Operator: fmt.Sprintf("Sort by %s%s", q.order.Column, direction), Cost: cost, Rows: groups, Children: []*PlanNode{root}}
+			nodes.sort = root
+		}
+		if q.limit > 0 {
+			root = &PlanNode{Operator: fmt.Sprintf("Limit %d", q.limit), Cost: cost, Rows: math.Min(groups, float64(q.limit)), Children: []*PlanNode{root}}
+			nodes.limit = root
+		}
+		return root, nodes
+	}
+	if q.items != nil {
+		root = &PlanNode{Operator: "Project " + itemsString(q.items), Cost: root.Cost, Rows: root.Rows, Children: []*PlanNode{root}}
+		nodes.project = root
+	}
+	return root, nodes
+}
+
+// explain builds the plan's operator tree: the access path, then a sort
+// unless the path yields ORDER BY order, then a limit
+func (p *selectPlan) explain() (*PlanNode, selectNodes) {
+	var nodes selectNodes
+	sorting := p.order != nil && !p.ordered
+
+	access := &PlanNode{Operator: p.operator(), Cost: p.cost, Rows: p.rows}
+	if sorting {
+		access.Cost -= sortCost(p.rows)
+	}
+	if len(p.indexed) > 0 && p.method != indexIntersection {
+		access.Details = append(access.Details, "Index Cond: "+conditionsString(p.indexed))
+	}
+	if filter := p.filter(); len(filter) > 0 {
+		access.Details = append(access.Details, "Filter: "+conditionsString(filter))
+	}
+	for _, probe := range p.probes {
+		node := &PlanNode{
+			Operator: probe.operator(),
+			Details:  []string{"Index Cond: " + conditionsString(probe.indexed)},
+			Cost:     probe.cost,
+			Rows:     probe.rows,
+		}
+		access.Children = append(access.Children, node)
+		nodes.probes = append(nodes.probes, node)
+	}
+	nodes.access = access
+	root := access
+
+	if sorting {
+		direction := ""
+		if p.order.Desc {
+			direction = " DESC"
+		}
+		root = &PlanNode{Operator: fmt.Sprintf("Sort by %s%s", p.order.Column, direction), Cost: p.cost, Rows: p.rows, Children: []*PlanNode{root}}
+		nodes.sort = root
+	}
+	if p.limit > 0 {
+		root = &PlanNode{Operator: fmt.Sprintf("Limit %d", p.limit), Cost: p.cost, Rows: math.Min(p.rows, float64(p.limit)), Children: []*PlanNode{root}}
+		nodes.limit = root
+	}
+	return root, nodes
+}
+
+// operator names the plan's access path, e.g. "Index Range on users.id (btree)"
+func (p *selectPlan) operator() string {
+	switch p.method {
+	case indexLookup:
+		return "Index Lookup on " + p.target()
+	case indexPrefix:
+		return fmt.Sprintf("Index Prefix on %s, %d of %d columns", p.target(), p.used, len(p.columns))
+	case indexRange:
+		return "Index Range on " + p.target()
+	case indexOrder:
+		return "Index Order on " + p.target()
+	case fullTextSearch:
+		return "Full-Text Search on " + p.target()
+	case indexIntersection:
+		return "Index Intersection on " + p.table
+	}
+	return "Full Scan on " + p.table
+}
+
+// filter returns the conditions checked on each row the access path yields
+func (p *selectPlan) filter() []*parser.WhereClause {
+	var filter []*parser.WhereClause
+	for _, c := range p.where.Conditions() {
+		if !containsCondition(p.indexed, c) {
+			filter = append(filter, c)
+		}
+	}
+	return filter
+}
+
+// explain builds the join's operator tree: the join over each side's access
+// path, or over the outer side and the inner index for an index nested loop
+func (p *joinPlan) explain() (*PlanNode, joinNodes) {
+	var nodes joinNodes
+	on := fmt.Sprintf("%s.%s = %s.%s", p.left.table, p.condition.LeftColumn, p.right.table, p.condition.RightColumn)
+	join := &PlanNode{Cost: p.cost, Rows: p.rows}
+
+	left, leftNodes := p.left.explain()
+	right, rightNodes := p.right.explain()
+	switch p.method {
+	case hashJoin:
+		join.Operator = "Hash Join on " + on
+		build := p.right.table
+		if p.leftInner {
+			build = p.left.table
+		}
+		join.Details = append(join.Details, "Build: "+build)
+		join.Children = []*PlanNode{left, right}
+		nodes.left, nodes.right = leftNodes, rightNodes
+
+	case indexNestedLoop:
+		join.Operator = "Index Nested Loop Join on " + on
+		innerPlan, innerColumn, outer, outerColumn := p.right, p.condition.RightColumn, left, p.condition.LeftColumn
+		outerTable := p.left.table
+		if p.leftInner {
+			innerPlan, innerColumn, outer, outerColumn = p.left, p.condition.LeftColumn, right, p.condition.RightColumn
+			outerTable = p.right.table
+		}
+		probe := &selectPlan{table: innerPlan.table, index: p.inner.idx, columns: p.inner.columns}
+		inner := &PlanNode{
+			Operator: "Index Lookup on " + probe.target(),
+			Details:  []string{fmt.Sprintf("Index Cond: %s.%s = %s.%s", innerPlan.table, innerColumn, outerTable, outerColumn)},
+			Cost:     p.cost - outer.Cost,
+			Rows:     p.rows,
+		}
+		if filter := innerPlan.where.Conditions(); len(filter) > 0 {
+			inner.Details = append(inner.Details, "Filter: "+conditionsString(filter))
+		}
+		join.Children = []*PlanNode{outer, inner}
+		nodes.inner = inner
+		if p.leftInner {
+			join.Children = []*PlanNode{inner, outer}
+			nodes.right = rightNodes
+		} else {
+			nodes.left = leftNodes
+		}
+
+	default:
+		join.Operator = "Nested Loop Join on " + on
+		join.Children = []*PlanNode{left, right}
+		nodes.left, nodes.right = leftNodes, rightNodes
+	}
+	if residual := p.residual.Conditions(); len(residual) > 0 {
+		join.Details = append(join.Details, "Filter: "+conditionsString(residual))
+	}
+	nodes.join = join
+	return join, nodes
+}
+
+// String formats the plan tree, one operator per line with its inputs
+// indented below it, followed for EXPLAIN ANALYZE by how the state was built
+// and the total time
+func (e *Explanation) String() string {
+	var b strings.Builder
+	e.Plan.format(&b, 0, e.Analyzed)
+	if e.Analyzed {
+		if e.State.SnapshotEventID > 0 {
+			fmt.Fprintf(&b, "State: snapshot at event %d + %d replayed events (%s)\n",
+				e.State.SnapshotEventID, e.State.Replayed, formatDuration(e.State.ReplayTime))
+		} else {
+			fmt.Fprintf(&b, "State: %d events replayed from the start (%s)\n", e.State.Replayed, formatDuration(e.State.ReplayTime))
+		}
+		fmt.Fprintf(&b, "Execution time: %s\n", formatDuration(e.Time))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// format writes a node and its inputs at a depth
+func (n *PlanNode) format(b *strings.Builder, depth int, analyzed bool) {
+	indent := strings.Repeat("   ", depth)
+	arrow := ""
+	if depth > 0 {
+		arrow = "-> "
+	}
+	fmt.Fprintf(b, "%s%s%s (cost=%.2f rows=%.0f)", indent, arrow, n.Operator, n.Cost, n.Rows)
+	if analyzed {
+		fmt.Fprintf(b, " (actual rows=%d time=%s", n.ActualRows, formatDuration(n.Time))
+		if n.SpillFiles > 0 {
+			fmt.Fprintf(b, " spill files=%d", n.SpillFiles)
+		}
+		b.WriteString(")")
+	}
+	b.WriteString("\n")
+	for _, detail := range n.Details {
+		fmt.Fprintf(b, "%s%s  %s\n", indent, strings.Repeat(" ", len(arrow)), detail)
+	}
+	for _, child := range n.Children {
+		child.format(b, depth+1, analyzed)
+	}
+}
+
+// formatDuration formats a duration in milliseconds, e.g. "0.042ms"
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
+
+// conditionsString formats conditions as SQL joined by AND
+func conditionsString(conds []*parser.WhereClause) string {
+	parts := make([]string, len(conds))
+	for i, c := range conds {
+		single := *c
+		single.And = nil
+		parts[i] = single.String()
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// containsCondition reports whether a condition is one of conds
+func containsCondition(conds []*parser.WhereClause, c *parser.WhereClause) bool {
+	for _, cond := range conds {
+		if cond == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,411 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"rdbms/eventlog"
+	"rdbms/index"
+	"rdbms/schema"
+	"rdbms/storage"
+)
+
+// indexFileFormat is the version of the index checkpoint layout
+const indexFileFormat = 1
+
+// indexFile is a table's index checkpoint as stored on disk: the checkpoint and
+// the SHA-256 of its bytes, so a torn or edited file is detected on load
+type indexFile struct {
+	Checksum   string          `json:"checksum"`
+	Checkpoint json.RawMessage `json:"checkpoint"`
+}
+
+// indexCheckpoint is the contents of a table's indexes as of an event
+type indexCheckpoint struct {
+	Format    int          `json:"format"`
+	Table     string       `json:"table"`
+	EventID   uint64       `json:"event_id"`    // Last event the indexes reflect
+	LogRoot   string       `json:"log_root"`    // Merkle root of the log up to EventID
+	NextRowID int64        `json:"next_row_id"` // Row ID the next insert gets
+	Indexes   []savedIndex `json:"indexes"`
+}
+
+// savedIndex is one index of a checkpoint
+type savedIndex struct {
+	Name    string        `json:"name"`
+	Columns []string      `json:"columns"`
+	Unique  bool          `json:"unique"`
+	Ordered bool          `json:"ordered"`         // B-tree rather than hash
+	Where   string        `json:"where,omitempty"` // Predicate of a partial index
+	Entries []index.Entry `json:"entries"`
+}
+
+// IndexRecovery describes how the indexes were restored when the database opened
+type IndexRecovery struct {
+	Loaded         []string          // Tables whose indexes came from their checkpoint
+	Rebuilt        map[string]string // Tables rebuilt from table state, and why
+	EventsReplayed int               // Log events applied on top of checkpoints
+}
+
+// IndexRecovery reports how the indexes were restored when the database opened
+func (db *Database) IndexRecovery() IndexRecovery {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.indexRecovery
+}
+
+// CheckpointIndexes writes every table's indexes to disk, tagged with the last
+// event they reflect, and saves the statistics with them. Close does this too;
+// a checkpoint taken before a crash is brought up to date from the log on the
+// next open.
+func (db *Database) CheckpointIndexes() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	eventID, root, err := db.logPosition()
+	if err != nil {
+		return err
+	}
+	tableNames := make([]string, 0)
+	for tableName := range db.catalog.GetAllTables() {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		if err := db.checkpointTableIndexes(tableName, eventID, root); err != nil {
+			return err
+		}
+	}
+	if len(db.stats) > 0 {
+		return db.saveStatistics()
+	}
+	return nil
+}
+
+// logPosition returns the last event in the log and the log's Merkle root up
+// to it, which identifies the history a checkpoint was taken from
+func (db *Database) logPosition() (uint64, string, error) {
+	eventID := db.eventStore.GetLastEventID()
+	root, err := db.eventStore.MerkleRootAt(eventID)
+	return eventID, root, err
+}
+
+// indexFilePath is where a table's index checkpoint is kept
+func (db *Database) indexFilePath(tableName string) string {
+	return filepath.Join(db.dataDir, "indexes", tableName+".json")
+}
+
+// checkpointTableIndexes writes one table's indexes as of an event (caller
+// holds db.mu, so no row change is half applied). A table with an index still
+// building is skipped: its last checkpoint stays, and the INDEX_CREATED event
+// after it makes the next open rebuild the table.
+func (db *Database) checkpointTableIndexes(tableName string, eventID uint64, root string) error {
+	cp := indexCheckpoint{
+		Format:    indexFileFormat,
+		Table:     tableName,
+		EventID:   eventID,
+		LogRoot:   root,
+		NextRowID: db.nextRowID[tableName],
+	}
+	for _, ti := range db.sortedIndexes(tableName) {
+		if ti.building {
+			return nil
+		}
+		cp.Indexes = append(cp.Indexes, savedIndex{
+			Name:    ti.name,
+			Columns: ti.columns,
+			Unique:  ti.unique,
+			Ordered: ti.ordered(),
+			Where:   ti.where(),
+			Entries: ti.idx.Entries(),
+		})
+	}
+
+	body, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	data, err := json.Marshal(indexFile{Checksum: hex.EncodeToString(sum[:]), Checkpoint: body})
+	if err != nil {
+		return err
+	}
+
+	path := db.indexFilePath(tableName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return eventlog.WriteFileAtomic(path, data)
+}
+
+// readIndexCheckpoint loads and verifies a table's index checkpoint
+func (db *Database) readIndexCheckpoint(tableName string) (*indexCheckpoint, error) {
+	data, err := os.ReadFile(db.indexFilePath(tableName))
+	if err != nil {
+		return nil, err
+	}
+
+	var file indexFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unreadable index file: %v", err)
+	}
+	sum := sha256.Sum256(file.Checkpoint)
+	if hex.EncodeToString(sum[:]) != file.Checksum {
+		return nil, fmt.Errorf("index file checksum mismatch")
+	}
+
+	var cp indexCheckpoint
+	if err := json.Unmarshal(file.Checkpoint, &cp); err != nil {
+		return nil, fmt.Errorf("unreadable index checkpoint: %v", err)
+	}
+	if cp.Format != indexFileFormat {
+		return nil, fmt.Errorf("unsupported index file format %d", cp.Format)
+	}
+	if cp.Table != tableName {
+		return nil, fmt.Errorf("index file belongs to table '%s'", cp.Table)
+	}
+	return &cp, nil
+}
+
+// restoreIndexes installs a checkpoint's indexes for a table. The checkpoint
+// must hold exactly the indexes the catalog defines for it.
+func (db *Database) restoreIndexes(table *schema.Table, cp *indexCheckpoint) error {
+	indexes := tableIndexes(table)
+	if len(cp.Indexes) != len(indexes) {
+		return fmt.Errorf("checkpoint has %d indexes, table has %d", len(cp.Indexes), len(indexes))
+	}
+	for _, saved := range cp.Indexes {
+		ti, exists := indexes[saved.Name]
+		if !exists || ti.unique != saved.Unique || ti.ordered() != saved.Ordered ||
+			fmt.Sprint(ti.columns) != fmt.Sprint(saved.Columns) || ti.where() != saved.Where {
+			return fmt.Errorf("index '%s' does not match its definition", saved.Name)
+		}
+		if err := ti.idx.Load(saved.Entries); err != nil {
+			return err
+		}
+	}
+
+	db.indexes[table.Name] = indexes
+	db.nextRowID[table.Name] = cp.NextRowID
+	return nil
+}
+
+// loadIndexes restores every table's indexes when the database opens. Each
+// table starts from its checkpoint and replays the row events logged after it.
+// A table is rebuilt from its rows instead when its checkpoint is missing or
+// fails its checksum, is older than the compaction boundary (the events it
+// needs were folded away), was taken from a log that has since been rewritten,
+// or is followed by a schema or index change.
+func (db *Database) loadIndexes() error {
+	tables := db.catalog.GetAllTables()
+	boundary := db.eventStore.CompactionBoundary()
+	lastEventID := db.eventStore.GetLastEventID()
+	db.indexRecovery = IndexRecovery{Rebuilt: make(map[string]string)}
+
+	checkpoints := make(map[string]*indexCheckpoint)
+	oldest := lastEventID
+	for tableName, table := range tables {
+		cp, err := db.readIndexCheckpoint(tableName)
+		switch {
+		case err != nil:
+			db.indexRecovery.Rebuilt[tableName] = err.Error()
+			continue
+		case cp.EventID < boundary:
+			db.indexRecovery.Rebuilt[tableName] = fmt.Sprintf("checkpoint at event %d is behind the compaction boundary %d", cp.EventID, boundary)
+			continue
+		case cp.EventID > lastEventID:
+			db.indexRecovery.Rebuilt[tableName] = fmt.Sprintf("checkpoint at event %d is ahead of the log (last event %d)", cp.EventID, lastEventID)
+			continue
+		}
+		if root, err := db.eventStore.MerkleRootAt(cp.EventID); err != nil || root != cp.LogRoot {
+			db.indexRecovery.Rebuilt[tableName] = fmt.Sprintf("log up to event %d differs from the one checkpointed", cp.EventID)
+			continue
+		}
+		if err := db.restoreIndexes(table, cp); err != nil {
+			db.indexRecovery.Rebuilt[tableName] = err.Error()
+			continue
+		}
+		checkpoints[tableName] = cp
+		if cp.EventID < oldest {
+			oldest = cp.EventID
+		}
+	}
+
+	// Catch up on the events logged after the oldest checkpoint
+	var events []*eventlog.Event
+	if len(checkpoints) > 0 && oldest < lastEventID {
+		var err error
+		if events, err = db.eventStore.GetEventsFrom(oldest + 1); err != nil {
+			for tableName := range checkpoints {
+				db.indexRecovery.Rebuilt[tableName] = err.Error()
+			}
+			checkpoints = nil
+		}
+	}
+	for tableName, cp := range checkpoints {
+		replayed, err := db.catchUpIndexes(tableName, cp.EventID, events)
+		if err != nil {
+			db.indexRecovery.Rebuilt[tableName] = err.Error()
+			continue
+		}
+		db.indexRecovery.Loaded = append(db.indexRecovery.Loaded, tableName)
+		db.indexRecovery.EventsReplayed += replayed
+	}
+	sort.Strings(db.indexRecovery.Loaded)
+
+	for tableName := range db.indexRecovery.Rebuilt {
+		if err := db.rebuildIndexes(tableName, tables[tableName]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catchUpIndexes applies the row events after a checkpoint to a table's
+// indexes and returns how many it applied. Row events are in the current schema
+// version, since any schema change stops the catch-up. An update finds the key
+// a row was indexed under from the index itself.
+func (db *Database) catchUpIndexes(tableName string, after uint64, events []*eventlog.Event) (int, error) {
+	indexes := db.sortedIndexes(tableName)
+	rowKeys := make(map[*tableIndex]map[int64]interface{})
+	keysOf := func(ti *tableIndex) (map[int64]interface{}, error) {
+		if keys, exists := rowKeys[ti]; exists {
+			return keys, nil
+		}
+		keys := make(map[int64]interface{})
+		for _, e := range ti.idx.Entries() {
+			key, err := index.DecodeKey(string(e.Key))
+			if err != nil {
+				return nil, err
+			}
+			for _, rowID := range e.RowIDs {
+				keys[rowID] = key
+			}
+		}
+		rowKeys[ti] = keys
+		return keys, nil
+	}
+
+	replayed := 0
+	for _, e := range events {
+		if e.ID <= after || e.Type == eventlog.SnapshotCreated {
+			continue
+		}
+		payload, ok := e.Payload.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("event %d has an unreadable payload", e.ID)
+		}
+		eventTable, _ := payload["table_name"].(string)
+		if eventTable == schema.DeadLetterTable(tableName) {
+			if rowID, ok := payload["row_id"].(float64); ok && int64(rowID) >= db.nextRowID[tableName] {
+				db.nextRowID[tableName] = int64(rowID) + 1
+			}
+			continue
+		}
+		if eventTable != tableName {
+			continue
+		}
+
+		switch e.Type {
+		case eventlog.RowInserted, eventlog.RowUpdated, eventlog.RowDeleted:
+		default:
+			return 0, fmt.Errorf("%s at event %d", e.Type, e.ID)
+		}
+		rowID := int64(payload["row_id"].(float64))
+		replayed++
+
+		for _, ti := range indexes {
+			keys, err := keysOf(ti)
+			if err != nil {
+				return 0, err
+			}
+			old, indexed := keys[rowID]
+
+			switch e.Type {
+			case eventlog.RowInserted:
+				data, _ := payload["data"].(map[string]interface{})
+				if indexed {
+					ti.idx.Remove(old, rowID)
+					delete(keys, rowID)
+				}
+				if key, ok := ti.key(storage.Row(data)); ok && ti.covers(storage.Row(data)) {
+					ti.idx.Add(key, rowID)
+					keys[rowID] = key
+				}
+
+			case eventlog.RowUpdated:
+				changes, _ := payload["changes"].(map[string]interface{})
+				key, changed, err := updatedKey(ti, old, indexed, changes)
+				if err != nil {
+					return 0, fmt.Errorf("event %d: %v", e.ID, err)
+				}
+				if changed {
+					ti.idx.Remove(old, rowID)
+					ti.idx.Add(key, rowID)
+					keys[rowID] = key
+				}
+
+			case eventlog.RowDeleted:
+				if indexed {
+					ti.idx.Remove(old, rowID)
+					delete(keys, rowID)
+				}
+			}
+		}
+
+		if e.Type == eventlog.RowInserted && rowID >= db.nextRowID[tableName] {
+			db.nextRowID[tableName] = rowID + 1
+		}
+	}
+	return replayed, nil
+}
+
+// updatedKey returns a row's key after an update's changes, and whether it
+// changed. Unchanged columns come from the key the row is indexed under. An
+// update to a column of a partial index's predicate may move the row in or out
+// of the index, which takes the whole row, so it is reported as an error.
+func updatedKey(ti *tableIndex, old interface{}, indexed bool, changes map[string]interface{}) (interface{}, bool, error) {
+	for _, c := range ti.predicate.Conditions() {
+		if _, column, _ := index.SplitRef(c.Column); hasKey(changes, column) {
+			return nil, false, fmt.Errorf("update to '%s' in the predicate of index '%s'", column, ti.name)
+		}
+	}
+
+	touched := make([]bool, len(ti.columns))
+	anyTouched := false
+	for i, col := range ti.columns {
+		_, column, _ := index.SplitRef(col)
+		touched[i] = hasKey(changes, column)
+		anyTouched = anyTouched || touched[i]
+	}
+	if !anyTouched || !indexed && ti.predicate != nil {
+		return old, false, nil
+	}
+	if !indexed {
+		return nil, false, fmt.Errorf("updated row is missing from index '%s'", ti.name)
+	}
+
+	if len(ti.columns) == 1 {
+		key, _ := index.ValueOf(ti.columns[0], storage.Row(changes))
+		return key, true, nil
+	}
+	key := append(index.Key(nil), old.(index.Key)...)
+	for i, col := range ti.columns {
+		if touched[i] {
+			key[i], _ = index.ValueOf(col, storage.Row(changes))
+		}
+	}
+	return key, true, nil
+}
+
+// hasKey reports whether a map holds a key, even with a nil value
+func hasKey(m map[string]interface{}, key string) bool {
+	_, exists := m[key]
+	return exists
+}
@@ -1,16 +1,217 @@
 package database
 
 import (
+	"fmt"
+	"sort"
+
 	"rdbms/index"
+	"rdbms/parser"
 	"rdbms/schema"
+	"rdbms/storage"
 )
 
-// rebuildAllIndexes rebuilds indexes for all tables from current state
-func (db *Database) rebuildAllIndexes() error {
-	tables := db.catalog.GetAllTables()
-	for tableName, table := range tables {
-		if err := db.rebuildIndexes(tableName, table); err != nil {
-			return err
+// tableIndex is one index on a table: either implicit, backing a PRIMARY KEY or
+// UNIQUE column, or created with CREATE INDEX and recorded in the catalog
+type tableIndex struct {
+	name     string
+	columns  []string
+	unique   bool
+	implicit bool
+	idx      index.Interface
+
+	// Predicate of a partial index; nil indexes every row
+	predicate *parser.WhereClause
+
+	// A CREATE INDEX build is in progress: writes are queued in pending and
+	// applied once the index has been built from the state it started at
+	building bool
+	pending  []pendingChange
+}
+
+// pendingChange is a write made to a table while one of its indexes was building
+type pendingChange struct {
+	add   bool
+	key   interface{}
+	rowID int64
+}
+
+// key returns the value a row is indexed under: an index.Key for a composite index
+func (ti *tableIndex) key(row storage.Row) (interface{}, bool) {
+	return index.KeyOf(ti.columns, row)
+}
+
+// where returns the predicate of a partial index as SQL, or "" for a full index
+func (ti *tableIndex) where() string {
+	if ti.predicate == nil {
+		return ""
+	}
+	return ti.predicate.String()
+}
+
+// covers reports whether a row belongs in the index: a partial index holds
+// only the rows satisfying its predicate
+func (ti *tableIndex) covers(row storage.Row) bool {
+	return ti.predicate == nil || matchesWhere(row, ti.predicate)
+}
+
+// add indexes a row, or queues it while the index is building
+func (ti *tableIndex) add(row storage.Row, rowID int64) {
+	key, ok := ti.key(row)
+	if !ok || !ti.covers(row) {
+		return
+	}
+	if ti.building {
+		ti.pending = append(ti.pending, pendingChange{add: true, key: key, rowID: rowID})
+		return
+	}
+	ti.idx.Add(key, rowID)
+}
+
+// remove drops a row from the index, or queues the removal while it is building
+func (ti *tableIndex) remove(row storage.Row, rowID int64) {
+	key, ok := ti.key(row)
+	if !ok || !ti.covers(row) {
+		return
+	}
+	if ti.building {
+		ti.pending = append(ti.pending, pendingChange{key: key, rowID: rowID})
+		return
+	}
+	ti.idx.Remove(key, rowID)
+}
+
+// usableFor reports whether a query with the given conditions may read the
+// index: it must be built, and a partial index only holds the rows the query
+// needs when the conditions imply its predicate
+func (ti *tableIndex) usableFor(conds []*parser.WhereClause) bool {
+	return !ti.building && (ti.predicate == nil || implies(conds, ti.predicate))
+}
+
+// ordered reports whether the index is a B-tree
+func (ti *tableIndex) ordered() bool {
+	_, ok := ti.idx.(*index.BTree)
+	return ok
+}
+
+// fullText returns the index as a full-text index, or nil if it is another kind
+func (ti *tableIndex) fullText() *index.FullText {
+	ft, _ := ti.idx.(*index.FullText)
+	return ft
+}
+
+// primaryKeyIndexName is the name of the index backing a table's primary key
+func primaryKeyIndexName(tableName string) string {
+	return fmt.Sprintf("%s_pkey", tableName)
+}
+
+// uniqueIndexName is the name of the index backing a UNIQUE column
+func uniqueIndexName(tableName, column string) string {
+	return fmt.Sprintf("%s_%s_key", tableName, column)
+}
+
+// newPrimaryKeyIndex creates the index for a table's primary key: an ordered
+// B-tree, so range predicates and ORDER BY on the key need no full scan, and a
+// composite key serves lookups on any leftmost prefix of its columns
+func newPrimaryKeyIndex(table *schema.Table) *tableIndex {
+	key := table.KeyColumns()
+	return &tableIndex{
+		name:     primaryKeyIndexName(table.Name),
+		columns:  key,
+		unique:   true,
+		implicit: true,
+		idx:      index.NewCompositeBTree(key),
+	}
+}
+
+// newColumnIndex creates the hash index for a unique column
+func newColumnIndex(tableName string, col schema.Column) *tableIndex {
+	return &tableIndex{
+		name:     uniqueIndexName(tableName, col.Name),
+		columns:  []string{col.Name},
+		unique:   true,
+		implicit: true,
+		idx:      index.New(col.Name),
+	}
+}
+
+// newDefinedIndex creates an empty index for a CREATE INDEX definition
+func newDefinedIndex(def schema.Index) *tableIndex {
+	ti := &tableIndex{name: def.Name, columns: def.Columns, unique: def.Unique}
+	ti.idx = newIndexStructure(def)
+	if def.Where != "" {
+		// CREATE INDEX checked the predicate before recording it
+		ti.predicate, _ = parser.ParseWhere(def.Where)
+	}
+	return ti
+}
+
+// newIndexStructure creates the data structure a definition asks for
+func newIndexStructure(def schema.Index) index.Interface {
+	switch def.Method {
+	case schema.IndexHash:
+		return index.NewComposite(def.Columns)
+	case schema.IndexFullText:
+		return index.NewFullText(def.Columns[0], index.Analyzer{Stem: def.Stemming, Stopwords: def.Stopwords})
+	}
+	return index.NewCompositeBTree(def.Columns)
+}
+
+// tableIndexes creates empty indexes for a table's primary key, its unique
+// columns and the indexes defined on it
+func tableIndexes(table *schema.Table) map[string]*tableIndex {
+	indexes := make(map[string]*tableIndex)
+	if len(table.KeyColumns()) > 0 {
+		ti := newPrimaryKeyIndex(table)
+		indexes[ti.name] = ti
+	}
+	for _, col := range table.Columns {
+		if col.Unique && !(col.PrimaryKey && len(table.KeyColumns()) == 1) {
+			ti := newColumnIndex(table.Name, col)
+			indexes[ti.name] = ti
+		}
+	}
+	for _, def := range table.Indexes {
+		indexes[def.Name] = newDefinedIndex(def)
+	}
+	return indexes
+}
+
+// sortedIndexes returns a table's indexes, implicit ones first, then by name
+func (db *Database) sortedIndexes(tableName string) []*tableIndex {
+	indexes := make([]*tableIndex, 0, len(db.indexes[tableName]))
+	for _, ti := range db.indexes[tableName] {
+		indexes = append(indexes, ti)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		if indexes[i].implicit != indexes[j].implicit {
+			return indexes[i].implicit
+		}
+		return indexes[i].name < indexes[j].name
+	})
+	return indexes
+}
+
+// orderedIndexOn returns a built B-tree whose leading column is the given
+// column and that a query with the given conditions may use, or nil. Walking a
+// composite B-tree yields rows ordered by its leading column, so with composite
+// set it serves ORDER BY; range bounds apply only to single-column keys.
+func (db *Database) orderedIndexOn(tableName, column string, composite bool, conds []*parser.WhereClause) *tableIndex {
+	for _, ti := range db.sortedIndexes(tableName) {
+		if !ti.usableFor(conds) || !ti.ordered() || ti.columns[0] != column {
+			continue
+		}
+		if len(ti.columns) == 1 || composite {
+			return ti
+		}
+	}
+	return nil
+}
+
+// fullTextIndexOn returns a built FULLTEXT index on the given column, or nil
+func (db *Database) fullTextIndexOn(tableName, column string) *tableIndex {
+	for _, ti := range db.sortedIndexes(tableName) {
+		if !ti.building && ti.fullText() != nil && ti.columns[0] == column {
+			return ti
 		}
 	}
 	return nil
@@ -18,36 +219,72 @@ func (db *Database) rebuildAllIndexes() error {
 
 // rebuildIndexes rebuilds indexes for a specific table from event-derived state
 func (db *Database) rebuildIndexes(tableName string, table *schema.Table) error {
-	db.indexes[tableName] = make(map[string]*index.Index)
+	db.indexes[tableName] = tableIndexes(table)
 	db.nextRowID[tableName] = 0
 
-	// Create index structures for PK and unique columns
-	for _, col := range table.Columns {
-		if col.PrimaryKey || col.Unique {
-			db.indexes[tableName][col.Name] = index.New(col.Name)
-		}
-	}
-
 	// Populate indexes from current derived state
 	state, err := db.queryEngine.GetCurrentState()
 	if err != nil {
 		return err
 	}
 
-	rows := state.GetTableRows(tableName)
-	for _, r := range rows {
-		// Track next row ID
-		if r.ID >= db.nextRowID[tableName] {
-			db.nextRowID[tableName] = r.ID + 1
+	// Rows held back by a failed migration keep their IDs
+	for _, t := range []string{tableName, schema.DeadLetterTable(tableName)} {
+		for rowID := range state.Tables[t] {
+			if !state.DeletedRows[t][rowID] && rowID >= db.nextRowID[tableName] {
+				db.nextRowID[tableName] = rowID + 1
+			}
 		}
+	}
 
+	for _, r := range rowsByID(state, tableName) {
 		// Add to indexes
-		for colName, idx := range db.indexes[tableName] {
-			if val, exists := r.Row[colName]; exists {
-				idx.Add(val, r.ID)
-			}
+		for _, ti := range db.indexes[tableName] {
+			ti.add(r.Row, r.ID)
 		}
 	}
 
 	return nil
 }
+
+// rowsByID returns a table's rows in row ID order, so rows sharing an index key
+// are listed in the order they were inserted
+func rowsByID(state *storage.DerivedState, tableName string) []storage.RowWithID {
+	rows := state.GetTableRows(tableName)
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+	return rows
+}
+
+// checkUniqueIndexes checks a row against the table's built unique CREATE INDEX
+// indexes. old is the row being updated, which may keep its own value. A
+// partial index only constrains the rows satisfying its predicate. claimed,
+// if not nil, holds the keys rows written earlier in the same statement take,
+// which are not in the indexes yet; the row's keys are added to it.
+func (db *Database) checkUniqueIndexes(tableName string, row storage.Row, old *storage.RowWithID, claimed map[string]bool) error {
+	for _, ti := range db.sortedIndexes(tableName) {
+		if !ti.unique || ti.implicit || ti.building {
+			continue
+		}
+		key, ok := ti.key(row)
+		if !ok || !ti.covers(row) {
+			continue
+		}
+		if claimed != nil {
+			claim := ti.name + "\x00" + index.EncodeKey(key)
+			if claimed[claim] {
+				return fmt.Errorf("unique constraint violation on index '%s'", ti.name)
+			}
+			claimed[claim] = true
+		}
+		rowIDs, found := ti.idx.Lookup(key)
+		if !found {
+			continue
+		}
+		for _, rowID := range rowIDs {
+			if old == nil || rowID != old.ID {
+				return fmt.Errorf("unique constraint violation on index '%s'", ti.name)
+			}
+		}
+	}
+	return nil
+}
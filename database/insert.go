@@ -21,34 +21,30 @@ func (db *Database) Insert(tableName string, row storage.Row) (int64, error) {
 		return 0, err
 	}
 
-	// Get current state to check constraints
-	state, err := db.queryEngine.GetCurrentState()
-	if err != nil {
-		return 0, err
-	}
-
-	// Check primary key uniqueness
-	if table.PrimaryKey != "" {
-		if idx, exists := db.indexes[tableName][table.PrimaryKey]; exists {
-			pkValue := row[table.PrimaryKey]
-			if idx.Exists(pkValue) {
-				return 0, fmt.Errorf("primary key violation: duplicate value '%v'", pkValue)
-			}
+	// Check primary key uniqueness; a composite key is unique as a whole
+	if ti, exists := db.indexes[tableName][primaryKeyIndexName(tableName)]; exists {
+		if pkValue, ok := ti.key(row); ok && ti.idx.Exists(pkValue) {
+			return 0, fmt.Errorf("primary key violation: duplicate value '%v'", pkValue)
 		}
 	}
 
 	// Check unique constraints
 	for _, col := range table.Columns {
-		if col.Unique && !col.PrimaryKey {
-			if idx, exists := db.indexes[tableName][col.Name]; exists {
+		if col.Unique {
+			if ti, exists := db.indexes[tableName][uniqueIndexName(tableName, col.Name)]; exists {
 				value := row[col.Name]
-				if idx.Exists(value) {
+				if ti.idx.Exists(value) {
 					return 0, fmt.Errorf("unique constraint violation on column '%s'", col.Name)
 				}
 			}
 		}
 	}
 
+	// Check unique indexes; one still building checks its rows when it finishes
+	if err := db.checkUniqueIndexes(tableName, row, nil, nil); err != nil {
+		return 0, err
+	}
+
 	// Generate row ID
 	rowID := db.nextRowID[tableName]
 	db.nextRowID[tableName]++
@@ -61,22 +57,13 @@ func (db *Database) Insert(tableName string, row storage.Row) (int64, error) {
 	}
 
 	// Update indexes
-	for colName, idx := range db.indexes[tableName] {
-		if val, exists := row[colName]; exists {
-			idx.Add(val, rowID)
-		}
+	for _, ti := range db.indexes[tableName] {
+		ti.add(row, rowID)
 	}
+	db.noteInsert(tableName, row)
 
-	// Invalidate query cache
+	// Invalidate query cache (snapshots are taken by the scheduler)
 	db.queryEngine.InvalidateCache()
 
-	// Check if we should create a snapshot
-	lastEventID := db.eventStore.GetLastEventID()
-	if lastEventID > 0 && lastEventID%uint64(db.snapshotInterval) == 0 {
-		if state != nil {
-			db.snapshotManager.CreateSnapshot(state, lastEventID, int64(lastEventID))
-		}
-	}
-
 	return rowID, nil
 }
@@ -2,16 +2,73 @@ package database
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"rdbms/index"
 	"rdbms/parser"
 	"rdbms/storage"
 )
 
-// Join performs INNER JOIN using nested-loop algorithm
+// joinMethod is how a join pairs the rows of its two tables
+type joinMethod int
+
+const (
+	nestedLoop      joinMethod = iota // Compare every pair of rows
+	hashJoin                          // Hash the smaller side on its join column, probe with the other
+	indexNestedLoop                   // Look up each outer row's value in the inner table's index
+)
+
+// joinPlan is how an INNER JOIN reads its tables and pairs their rows
+type joinPlan struct {
+	condition *parser.JoinCondition
+	method    joinMethod
+
+	// How each side reads its rows, filtered by the WHERE conditions on its
+	// own columns; residual holds conditions on neither table alone
+	left, right *selectPlan
+	residual    *parser.WhereClause
+
+	// Hash join: the left rows are hashed rather than the right ones.
+	// Index nested loop: the left table is the inner one, probed through inner.
+	leftInner bool
+	inner     *tableIndex
+
+	// Estimated cost of the plan and rows it returns
+	cost float64
+	rows float64
+}
+
+// Join performs an INNER JOIN, choosing between a nested loop, a hash join
+// and an index nested loop by the cost model
 // Now uses state derived from event log
 func (db *Database) Join(leftTable, rightTable string, condition *parser.JoinCondition, where *parser.WhereClause) ([]storage.Row, error) {
+	rows, err := db.Query(&parser.ParsedStatement{Type: "JOIN", TableName: leftTable, JoinTable: rightTable, JoinCondition: condition, Where: where})
+	if err != nil {
+		return nil, err
+	}
+	return rows.All()
+}
+
+// PlanJoin describes how Join would pair two tables' rows, e.g.
+// "hash join on users.id = orders.user_id, build orders"
+func (db *Database) PlanJoin(leftTable, rightTable string, condition *parser.JoinCondition, where *parser.WhereClause) (string, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	plan, err := db.planJoin(leftTable, rightTable, condition, where)
+	if err != nil {
+		return "", err
+	}
+	return plan.String(), nil
+}
+
+// planJoin pushes each WHERE condition on one table's columns down to that
+// table's access path, then prices the join strategies on the rows each side
+// is estimated to yield
+func (db *Database) planJoin(leftTable, rightTable string, condition *parser.JoinCondition, where *parser.WhereClause) (*joinPlan, error) {
 	if !db.catalog.TableExists(leftTable) {
 		return nil, fmt.Errorf("table '%s' does not exist", leftTable)
 	}
@@ -19,51 +76,586 @@ func (db *Database) Join(leftTable, rightTable string, condition *parser.JoinCon
 		return nil, fmt.Errorf("table '%s' does not exist", rightTable)
 	}
 
-	// Get current state from query engine
-	state, err := db.queryEngine.GetCurrentState()
+	var leftWhere, rightWhere, residual *parser.WhereClause
+	for _, c := range where.Conditions() {
+		pushed := *c
+		pushed.And = nil
+		switch {
+		case strings.HasPrefix(c.Column, leftTable+"."):
+			pushed.Column = strings.TrimPrefix(c.Column, leftTable+".")
+			leftWhere = appendCondition(leftWhere, &pushed)
+		case strings.HasPrefix(c.Column, rightTable+"."):
+			pushed.Column = strings.TrimPrefix(c.Column, rightTable+".")
+			rightWhere = appendCondition(rightWhere, &pushed)
+		default:
+			residual = appendCondition(residual, &pushed)
+		}
+	}
+	left, err := db.planSelect(leftTable, leftWhere, nil, 0)
 	if err != nil {
 		return nil, err
 	}
+	right, err := db.planSelect(rightTable, rightWhere, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	leftEst, rightEst := db.estimator(leftTable), db.estimator(rightTable)
+	leftDistinct := db.joinDistinct(leftTable, condition.LeftColumn, leftEst)
+	rightDistinct := db.joinDistinct(rightTable, condition.RightColumn, rightEst)
+	L, R := left.rows, right.rows
+	rows := L * R / math.Max(leftDistinct, rightDistinct)
+
+	// The nested loop goes first so that it wins a tie
+	plan := &joinPlan{condition: condition, left: left, right: right, residual: residual, rows: rows}
+	plan.cost = left.cost + right.cost + L*R*joinPairCost
 
-	leftRows := state.GetTableRows(leftTable)
-	rightRows := state.GetTableRows(rightTable)
+	hash := *plan
+	hash.method, hash.leftInner = hashJoin, L < R
+	hash.cost = left.cost + right.cost + math.Min(L, R)*hashBuildCost + math.Max(L, R)*hashProbeCost
+	candidates := []*joinPlan{&hash}
+
+	// Each side may be the inner one if its join column has an index
+	for _, leftInner := range []bool{false, true} {
+		table, column, outer, est, distinct := rightTable, condition.RightColumn, left, rightEst, rightDistinct
+		if leftInner {
+			table, column, outer, est, distinct = leftTable, condition.LeftColumn, right, leftEst, leftDistinct
+		}
+		ti := db.joinIndexOn(table, column)
+		if ti == nil {
+			continue
+		}
+		nested := *plan
+		nested.method, nested.leftInner, nested.inner = indexNestedLoop, leftInner, ti
+		nested.cost = outer.cost + outer.rows*(probeCost(ti, est.rows)+est.rows/distinct*fetchRowCost)
+		candidates = append(candidates, &nested)
+	}
+
+	for _, c := range candidates {
+		if c.cost < plan.cost {
+			plan = c
+		}
+	}
+	return plan, nil
+}
 
-	var result []storage.Row
+// joinIndexOn returns a built, full index whose only column is the join
+// column, or nil
+func (db *Database) joinIndexOn(tableName, column string) *tableIndex {
+	for _, ti := range db.sortedIndexes(tableName) {
+		if !ti.building && ti.predicate == nil && ti.fullText() == nil && len(ti.columns) == 1 && ti.columns[0] == column {
+			return ti
+		}
+	}
+	return nil
+}
+
+// joinDistinct estimates the distinct values of a join column: every row's
+// value is distinct under a unique index
+func (db *Database) joinDistinct(tableName, column string, est estimator) float64 {
+	if ti := db.joinIndexOn(tableName, column); ti != nil && ti.unique {
+		return math.Max(1, est.rows)
+	}
+	return est.distinct(column)
+}
+
+// appendCondition adds a condition to the end of a conjunction
+func appendCondition(where, c *parser.WhereClause) *parser.WhereClause {
+	if where == nil {
+		return c
+	}
+	last := where
+	for last.And != nil {
+		last = last.And
+	}
+	last.And = c
+	return where
+}
 
-	// Nested-loop join
-	for _, leftRow := range leftRows {
-		for _, rightRow := range rightRows {
-			// Check join condition
-			leftVal := leftRow.Row[condition.LeftColumn]
-			rightVal := rightRow.Row[condition.RightColumn]
+// open builds the join's operators over a state. Rows come out in the order
+// the streamed side yields them: the left side for a nested loop, the probe
+// side for a hash join and the outer side for an index nested loop; each
+// row's matches follow in the order the other side yields them. They read
+// indexes through reader and record what they do in the nodes EXPLAIN ANALYZE
+// shows, if any.
+func (p *joinPlan) open(state *storage.DerivedState, reader *indexReader, mem *queryMemory, nodes joinNodes) operator {
+	leftCol, rightCol := p.condition.LeftColumn, p.condition.RightColumn
+	var op operator
 
-			if !valuesEqual(leftVal, rightVal) {
+	switch p.method {
+	case hashJoin:
+		hash := &hashJoinOp{
+			join: p, mem: mem, node: nodes.join,
+			build: p.right.open(state, reader, mem, nodes.right), buildCol: rightCol,
+			probe: p.left.open(state, reader, mem, nodes.left), probeCol: leftCol,
+		}
+		if p.leftInner {
+			hash.build, hash.probe = hash.probe, hash.build
+			hash.buildCol, hash.probeCol = leftCol, rightCol
+		}
+		op = hash
+
+	case indexNestedLoop:
+		nested := &indexNestedLoopOp{join: p, state: state, reader: reader, node: nodes.inner}
+		if p.leftInner {
+			nested.outer, nested.outerCol, nested.innerPlan = p.right.open(state, reader, mem, nodes.right), rightCol, p.left
+		} else {
+			nested.outer, nested.outerCol, nested.innerPlan = p.left.open(state, reader, mem, nodes.left), leftCol, p.right
+		}
+		op = nested
+
+	default:
+		op = &nestedLoopOp{join: p, left: p.left.open(state, reader, mem, nodes.left), right: p.right.open(state, reader, mem, nodes.right), mem: mem, node: nodes.join}
+	}
+
+	// Apply the conditions no single table could
+	if p.residual != nil {
+		op = &filterOp{child: op, keep: func(r storage.RowWithID) bool { return matchesWhere(r.Row, p.residual) }}
+	}
+	return measure(op, nodes.join)
+}
+
+// merge joins a pair of rows, prefixing each column with its table's name
+func (p *joinPlan) merge(left, right storage.RowWithID) storage.RowWithID {
+	joinedRow := make(storage.Row, len(left.Row)+len(right.Row))
+	for k, v := range left.Row {
+		joinedRow[p.left.table+"."+k] = v
+	}
+	for k, v := range right.Row {
+		joinedRow[p.right.table+"."+k] = v
+	}
+	return storage.RowWithID{Row: joinedRow}
+}
+
+// nestedLoopOp reads the right side on Open, then compares each left row
+// with every right row. A right side beyond the query's memory budget is
+// written to a spill file, which is read again for each left row.
+type nestedLoopOp struct {
+	join        *joinPlan
+	left, right operator
+	mem         *queryMemory
+	node        *PlanNode
+
+	rightRows []storage.RowWithID
+	held      int64
+	spilled   *spillFile // nil when the right side fits in memory
+	reader    *spillReader
+	current   storage.RowWithID
+	started   bool
+	pos       int
+}
+
+func (o *nestedLoopOp) Open() error {
+	if err := o.right.Open(); err != nil {
+		return err
+	}
+	o.rightRows, o.started = nil, false
+	for {
+		r, ok, err := o.right.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if o.spilled == nil {
+			size := rowSize(r.Row)
+			if o.mem.grow(size) || len(o.rightRows) == 0 {
+				o.rightRows = append(o.rightRows, r)
+				o.held += size
 				continue
 			}
+			o.mem.shrink(size)
+			if err := o.spillRight(); err != nil {
+				return err
+			}
+		}
+		if err := o.spilled.write(r); err != nil {
+			return err
+		}
+	}
+	return o.left.Open()
+}
 
-			// Merge rows with table prefix
-			joinedRow := make(storage.Row)
-			for k, v := range leftRow.Row {
-				joinedRow[leftTable+"."+k] = v
+// spillRight moves the right rows read so far to a spill file
+func (o *nestedLoopOp) spillRight() error {
+	spilled, err := o.mem.spill(o.node)
+	if err != nil {
+		return err
+	}
+	o.spilled = spilled
+	for _, r := range o.rightRows {
+		if err := spilled.write(r); err != nil {
+			return err
+		}
+	}
+	o.mem.shrink(o.held)
+	o.rightRows, o.held = nil, 0
+	return nil
+}
+
+func (o *nestedLoopOp) Next() (storage.RowWithID, bool, error) {
+	leftCol, rightCol := o.join.condition.LeftColumn, o.join.condition.RightColumn
+	for {
+		for o.started {
+			r, ok, err := o.nextRight()
+			if err != nil {
+				return storage.RowWithID{}, false, err
+			}
+			if !ok {
+				break
 			}
-			for k, v := range rightRow.Row {
-				joinedRow[rightTable+"."+k] = v
+			if valuesEqual(o.current.Row[leftCol], r.Row[rightCol]) {
+				return o.join.merge(o.current, r), true, nil
 			}
+		}
+		l, ok, err := o.left.Next()
+		if err != nil || !ok {
+			return storage.RowWithID{}, false, err
+		}
+		o.current, o.started, o.pos = l, true, 0
+		if o.spilled != nil {
+			if o.reader, err = o.spilled.reader(); err != nil {
+				return storage.RowWithID{}, false, err
+			}
+		}
+	}
+}
+
+// nextRight returns the next right row to compare with the current left row
+func (o *nestedLoopOp) nextRight() (storage.RowWithID, bool, error) {
+	if o.spilled != nil {
+		var r storage.RowWithID
+		ok, err := o.reader.next(&r)
+		return r, ok, err
+	}
+	if o.pos >= len(o.rightRows) {
+		return storage.RowWithID{}, false, nil
+	}
+	o.pos++
+	return o.rightRows[o.pos-1], true, nil
+}
 
-			// Apply WHERE filter if present
-			if where != nil {
-				if val, exists := joinedRow[where.Column]; exists {
-					if !valuesEqual(val, where.Value) {
-						continue
-					}
-				} else {
-					continue
-				}
+func (o *nestedLoopOp) Close() error {
+	var err error
+	if o.spilled != nil {
+		err = o.spilled.remove()
+	}
+	o.mem.shrink(o.held)
+	o.rightRows, o.held, o.spilled, o.reader = nil, 0, nil, nil
+	if closeErr := closeAll(o.left, o.right); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// hashJoinOp hashes the build side on its join column on Open, then looks up
+// each probe row's value.
+//
+// A build side beyond the query's memory budget turns it into a grace hash
+// join: both sides are written to spill partitions by the hash of their join
+// values, so that matching rows share a partition, and Next joins one
+// partition at a time. Rows then come out partition by partition.
+type hashJoinOp struct {
+	join               *joinPlan
+	build, probe       operator
+	buildCol, probeCol string
+	mem                *queryMemory
+	node               *PlanNode
+
+	table   map[string][]storage.RowWithID
+	held    int64
+	current storage.RowWithID
+	matches []storage.RowWithID
+
+	// Grace hash join: each side's partitions, and the probe partition being read
+	buildParts, probeParts []*spillFile
+	partition              int
+	probeReader            *spillReader
+}
+
+func (o *hashJoinOp) Open() error {
+	if err := o.build.Open(); err != nil {
+		return err
+	}
+	o.table, o.matches, o.buildParts, o.probeParts = make(map[string][]storage.RowWithID), nil, nil, nil
+	for {
+		r, ok, err := o.build.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		key := index.EncodeKey(r.Row[o.buildCol])
+		if o.buildParts == nil {
+			size := rowSize(r.Row)
+			if o.mem.grow(size) {
+				o.table[key] = append(o.table[key], r)
+				o.held += size
+				continue
+			}
+			o.mem.shrink(size)
+			if err := o.partitionBuild(); err != nil {
+				return err
+			}
+		}
+		if err := o.buildParts[partitionOf(key)].write(r); err != nil {
+			return err
+		}
+	}
+	if err := o.probe.Open(); err != nil {
+		return err
+	}
+	if o.buildParts == nil {
+		return nil
+	}
+
+	// Partition the probe side the same way, then start on the first partition
+	parts, err := o.spillPartitions()
+	if err != nil {
+		return err
+	}
+	o.probeParts = parts
+	for {
+		r, ok, err := o.probe.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if err := o.probeParts[partitionOf(index.EncodeKey(r.Row[o.probeCol]))].write(r); err != nil {
+			return err
+		}
+	}
+	o.partition = -1
+	return nil
+}
+
+// spillPartitions creates a spill file for each partition
+func (o *hashJoinOp) spillPartitions() ([]*spillFile, error) {
+	parts := make([]*spillFile, spillPartitions)
+	for i := range parts {
+		part, err := o.mem.spill(o.node)
+		if err != nil {
+			removeAll(parts)
+			return nil, err
+		}
+		parts[i] = part
+	}
+	return parts, nil
+}
+
+// partitionBuild moves the hashed build rows to their partitions
+func (o *hashJoinOp) partitionBuild() error {
+	parts, err := o.spillPartitions()
+	if err != nil {
+		return err
+	}
+	o.buildParts = parts
+	for key, rows := range o.table {
+		for _, r := range rows {
+			if err := parts[partitionOf(key)].write(r); err != nil {
+				return err
+			}
+		}
+	}
+	o.mem.shrink(o.held)
+	o.table, o.held = make(map[string][]storage.RowWithID), 0
+	return nil
+}
+
+// nextProbe returns the next probe row: from the probe side, or for a grace
+// hash join from the current probe partition, hashing the next build
+// partition when one runs out
+func (o *hashJoinOp) nextProbe() (storage.RowWithID, bool, error) {
+	if o.buildParts == nil {
+		return o.probe.Next()
+	}
+	for {
+		if o.probeReader != nil {
+			var r storage.RowWithID
+			ok, err := o.probeReader.next(&r)
+			if err != nil || ok {
+				return r, ok, err
+			}
+			// Done with this partition
+			o.buildParts[o.partition].remove()
+			o.probeParts[o.partition].remove()
+			o.buildParts[o.partition], o.probeParts[o.partition], o.probeReader = nil, nil, nil
+		}
+		o.partition++
+		if o.partition >= spillPartitions {
+			return storage.RowWithID{}, false, nil
+		}
+		if err := o.hashPartition(o.buildParts[o.partition]); err != nil {
+			return storage.RowWithID{}, false, err
+		}
+		reader, err := o.probeParts[o.partition].reader()
+		if err != nil {
+			return storage.RowWithID{}, false, err
+		}
+		o.probeReader = reader
+	}
+}
+
+// hashPartition replaces the hash table with the rows of a build partition.
+// A partition is hashed whole, even beyond the budget.
+func (o *hashJoinOp) hashPartition(part *spillFile) error {
+	o.mem.shrink(o.held)
+	o.table, o.held = make(map[string][]storage.RowWithID), 0
+	reader, err := part.reader()
+	if err != nil {
+		return err
+	}
+	for {
+		var r storage.RowWithID
+		ok, err := reader.next(&r)
+		if err != nil || !ok {
+			return err
+		}
+		size := rowSize(r.Row)
+		o.mem.grow(size)
+		o.held += size
+		key := index.EncodeKey(r.Row[o.buildCol])
+		o.table[key] = append(o.table[key], r)
+	}
+}
+
+func (o *hashJoinOp) Next() (storage.RowWithID, bool, error) {
+	for len(o.matches) == 0 {
+		r, ok, err := o.nextProbe()
+		if err != nil || !ok {
+			return storage.RowWithID{}, false, err
+		}
+		o.current, o.matches = r, o.table[index.EncodeKey(r.Row[o.probeCol])]
+	}
+	match := o.matches[0]
+	o.matches = o.matches[1:]
+	if o.join.leftInner {
+		return o.join.merge(match, o.current), true, nil
+	}
+	return o.join.merge(o.current, match), true, nil
+}
+
+func (o *hashJoinOp) Close() error {
+	err := removeAll(append(o.buildParts, o.probeParts...))
+	o.mem.shrink(o.held)
+	o.table, o.held, o.matches, o.buildParts, o.probeParts, o.probeReader = nil, 0, nil, nil, nil, nil
+	if closeErr := closeAll(o.probe, o.build); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// indexNestedLoopOp looks up each outer row's value in the inner table's
+// index, fetching and filtering the inner rows it finds in row ID order. Once
+// the index no longer matches the query's state, it looks them up in an index
+// of the state's inner rows instead.
+type indexNestedLoopOp struct {
+	join      *joinPlan
+	state     *storage.DerivedState
+	reader    *indexReader
+	outer     operator
+	outerCol  string
+	innerPlan *selectPlan
+	node      *PlanNode // Records the lookups
+
+	private *index.BTree
+	current storage.RowWithID
+	matches []storage.RowWithID
+}
+
+func (o *indexNestedLoopOp) Open() error {
+	o.matches = nil
+	return o.outer.Open()
+}
+
+func (o *indexNestedLoopOp) Next() (storage.RowWithID, bool, error) {
+	for len(o.matches) == 0 {
+		r, ok, err := o.outer.Next()
+		if err != nil || !ok {
+			return storage.RowWithID{}, false, err
+		}
+		o.current = r
+		o.matches = o.lookup(r)
+	}
+	match := o.matches[0]
+	o.matches = o.matches[1:]
+	if o.join.leftInner {
+		return o.join.merge(match, o.current), true, nil
+	}
+	return o.join.merge(o.current, match), true, nil
+}
+
+// lookup returns the inner rows matching an outer row
+func (o *indexNestedLoopOp) lookup(outer storage.RowWithID) []storage.RowWithID {
+	start := time.Now()
+	key := outer.Row[o.outerCol]
+	var rowIDs []int64
+	if o.private == nil {
+		o.reader.read(func(current bool) {
+			if current {
+				found, _ := o.join.inner.idx.Lookup(key)
+				rowIDs = append([]int64(nil), found...)
+			} else {
+				o.private = stateIndex(o.state, o.innerPlan.table, o.join.inner.columns)
 			}
+		})
+	}
+	if o.private != nil {
+		found, _ := o.private.Lookup(key)
+		rowIDs = append([]int64(nil), found...)
+	}
+	sort.Slice(rowIDs, func(i, j int) bool { return rowIDs[i] < rowIDs[j] })
 
-			result = append(result, joinedRow)
+	var matches []storage.RowWithID
+	for _, rowID := range rowIDs {
+		row, exists := o.state.GetRow(o.innerPlan.table, rowID)
+		match := storage.RowWithID{ID: rowID, Row: row}
+		if exists && o.innerPlan.matches(match) {
+			matches = append(matches, match)
 		}
 	}
+	o.node.record(len(matches), start)
+	return matches
+}
+
+func (o *indexNestedLoopOp) Close() error {
+	o.matches = nil
+	return o.outer.Close()
+}
+
+// closeAll closes operators, returning the first error
+func closeAll(ops ...operator) error {
+	var first error
+	for _, op := range ops {
+		if err := op.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
 
-	return result, nil
+// String describes the join, e.g. "nested loop join on users.id = orders.user_id"
+// or "index nested loop join on users.id = orders.user_id, probe orders.user_id (btree)"
+func (p *joinPlan) String() string {
+	on := fmt.Sprintf("%s.%s = %s.%s", p.left.table, p.condition.LeftColumn, p.right.table, p.condition.RightColumn)
+	switch p.method {
+	case hashJoin:
+		build := p.right.table
+		if p.leftInner {
+			build = p.left.table
+		}
+		return fmt.Sprintf("hash join on %s, build %s", on, build)
+	case indexNestedLoop:
+		inner := p.right
+		if p.leftInner {
+			inner = p.left
+		}
+		probe := &selectPlan{table: inner.table, index: p.inner.idx, columns: p.inner.columns}
+		return fmt.Sprintf("index nested loop join on %s, probe %s", on, probe.target())
+	}
+	return "nested loop join on " + on
 }
@@ -2,54 +2,60 @@ package database
 
 import (
 	"fmt"
+
+	"rdbms/catalog"
 	"rdbms/eventlog"
 	"rdbms/index"
 	"rdbms/schema"
 	"rdbms/storage"
 )
 
-// CreateTable creates a new table
-// Now emits SCHEMA_CREATED event in addition to catalog entry
+// CreateTable creates a new table. The SCHEMA_CREATED event is written first;
+// the catalog is a projection of it, so a failed append leaves no trace.
+// Several PRIMARY KEY columns form a composite key in column order.
 func (db *Database) CreateTable(tableName string, columns []schema.Column) error {
+	return db.CreateTableWithKey(tableName, columns, nil)
+}
+
+// CreateTableWithKey creates a table whose primary key is the given columns in
+// key order, as declared by PRIMARY KEY (a, b). A nil key takes the columns
+// marked PRIMARY KEY.
+func (db *Database) CreateTableWithKey(tableName string, columns []schema.Column, key []string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if err := db.catalog.CreateTable(tableName, columns); err != nil {
+	if err := db.catalog.ValidateCreate(tableName, columns, key); err != nil {
+		return err
+	}
+	defined, err := catalog.NewTableWithKey(tableName, columns, key)
+	if err != nil {
 		return err
 	}
 
-	// Create indexes for PK and unique columns
-	db.indexes[tableName] = make(map[string]*index.Index)
-	db.nextRowID[tableName] = 0 // Initialize next row ID
-
-	for _, col := range columns {
-		if col.PrimaryKey || col.Unique {
-			db.indexes[tableName][col.Name] = index.New(col.Name)
-		}
+	txID := fmt.Sprintf("tx_%d", db.eventStore.GetLastEventID())
+	event, err := db.eventStore.RecordSchemaCreation(&eventlog.SchemaCreatedPayload{
+		TableName:         tableName,
+		Columns:           schema.ColumnDefinitions(defined.Columns),
+		PrimaryKey:        defined.PrimaryKey,
+		PrimaryKeyColumns: defined.PrimaryKeyColumns,
+	}, txID)
+	if err != nil {
+		return err
 	}
 
-	// Record schema creation event
-	colDefs := make([]eventlog.ColumnDefinition, len(columns))
-	primaryKey := ""
-	for i, col := range columns {
-		colDefs[i] = eventlog.ColumnDefinition{
-			Name:       col.Name,
-			Type:       string(col.Type),
-			Nullable:   true, // Default to nullable
-			PrimaryKey: col.PrimaryKey,
-			Unique:     col.Unique,
-		}
-		if col.PrimaryKey {
-			primaryKey = col.Name
-		}
+	if err := db.catalog.Apply(event); err != nil {
+		return err
 	}
 
-	txID := fmt.Sprintf("tx_%d", db.eventStore.GetLastEventID())
-	_, err := db.eventStore.RecordSchemaCreated(tableName, colDefs, primaryKey, txID)
+	table, err := db.catalog.GetTable(tableName)
 	if err != nil {
 		return err
 	}
 
+	// Create indexes for PK and unique columns
+	db.indexes[tableName] = tableIndexes(table)
+	db.nextRowID[tableName] = 0 // Initialize next row ID
+
 	return nil
 }
 
@@ -94,7 +100,8 @@ func (db *Database) validateRow(table *schema.Table, row storage.Row) error {
 	return nil
 }
 
-// Helper to compare values
+// valuesEqual compares values with the same type semantics as the indexes:
+// numbers by value, and values of different types never equal
 func valuesEqual(a, b interface{}) bool {
-	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+	return index.Compare(a, b) == 0
 }
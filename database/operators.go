@@ -0,0 +1,747 @@
+package database
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"rdbms/index"
+	"rdbms/parser"
+	"rdbms/schema"
+	"rdbms/storage"
+)
+
+// operator is one step of a query's execution. A query runs as a tree of
+// operators, each pulling rows from its inputs: Open prepares it, Next returns
+// one row at a time until ok is false, and Close releases what it holds. Only
+// sorts and aggregates read all their input before returning a row.
+type operator interface {
+	Open() error
+	Next() (row storage.RowWithID, ok bool, err error)
+	Close() error
+}
+
+// btreeBatchKeys is how many keys an index walk reads before handing its row
+// IDs on, so that a LIMIT stops it after a batch rather than a whole range
+const btreeBatchKeys = 64
+
+// indexReader is how a query's operators read the database's indexes, which
+// writes change in place. Query releases the database lock once the query is
+// open, so each later read takes the read lock for itself. The query reads the
+// state as of the event it was opened at; once a write has gone past that
+// event the indexes no longer match the state, and the operators index the
+// state themselves instead. A nil indexReader is for callers that hold the
+// lock for the whole query.
+type indexReader struct {
+	db   *Database
+	at   uint64 // Last event when the query's state was built
+	held bool   // The query is still being opened under the caller's lock
+}
+
+// read runs fn under the database's read lock, reporting whether the indexes
+// still match the query's state
+func (r *indexReader) read(fn func(current bool)) {
+	if r == nil || r.held {
+		fn(true)
+		return
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	fn(r.db.eventStore.GetLastEventID() == r.at)
+}
+
+// stateIndex indexes a table's rows in the query's state, for reads that can
+// no longer use the database's own index
+func stateIndex(state *storage.DerivedState, table string, columns []string) *index.BTree {
+	btree := index.NewCompositeBTree(columns)
+	btree.Rebuild(state.GetTableRows(table))
+	return btree
+}
+
+// scanOp reads a table's rows in row ID order, one at a time
+type scanOp struct {
+	state *storage.DerivedState
+	table string
+
+	rowIDs []int64
+	rows   []storage.RowWithID // System tables, which are read whole
+	pos    int
+}
+
+func (o *scanOp) Open() error {
+	o.pos = 0
+	if schema.IsSystemTable(o.table) {
+		o.rows = o.state.GetTableRows(o.table)
+		sort.Slice(o.rows, func(i, j int) bool { return o.rows[i].ID < o.rows[j].ID })
+		return nil
+	}
+	o.rowIDs = o.state.TableRowIDs(o.table)
+	return nil
+}
+
+func (o *scanOp) Next() (storage.RowWithID, bool, error) {
+	if o.rows != nil {
+		if o.pos >= len(o.rows) {
+			return storage.RowWithID{}, false, nil
+		}
+		o.pos++
+		return o.rows[o.pos-1], true, nil
+	}
+	for o.pos < len(o.rowIDs) {
+		rowID := o.rowIDs[o.pos]
+		o.pos++
+		if row, exists := o.state.GetRow(o.table, rowID); exists {
+			return storage.RowWithID{ID: rowID, Row: row}, true, nil
+		}
+	}
+	return storage.RowWithID{}, false, nil
+}
+
+func (o *scanOp) Close() error {
+	o.rowIDs, o.rows = nil, nil
+	return nil
+}
+
+// indexScanOp fetches the rows whose IDs an index path finds, in the order it
+// finds them. source returns the next batch of row IDs, and false once done.
+type indexScanOp struct {
+	state  *storage.DerivedState
+	table  string
+	source func() ([]int64, bool)
+
+	pending []int64
+}
+
+func (o *indexScanOp) Open() error {
+	o.pending = nil
+	return nil
+}
+
+func (o *indexScanOp) Next() (storage.RowWithID, bool, error) {
+	for {
+		for len(o.pending) > 0 {
+			rowID := o.pending[0]
+			o.pending = o.pending[1:]
+			if row, exists := o.state.GetRow(o.table, rowID); exists {
+				return storage.RowWithID{ID: rowID, Row: row}, true, nil
+			}
+		}
+		batch, ok := o.source()
+		if !ok {
+			return storage.RowWithID{}, false, nil
+		}
+		o.pending = batch
+	}
+}
+
+func (o *indexScanOp) Close() error {
+	o.pending = nil
+	return nil
+}
+
+// rowIDList is a row ID source yielding one list
+func rowIDList(rowIDs []int64) func() ([]int64, bool) {
+	done := false
+	return func() ([]int64, bool) {
+		if done {
+			return nil, false
+		}
+		done = true
+		return rowIDs, true
+	}
+}
+
+// btreeBatches is a row ID source walking a B-tree between bounds, a batch of
+// keys at a time, resuming past the last key each batch read. Each batch is
+// read through reader; once the B-tree no longer matches the query's state,
+// the rest of the walk reads an index of the state's rows instead.
+func btreeBatches(reader *indexReader, state *storage.DerivedState, table string, btree *index.BTree, lo, hi index.Bound, descending bool) func() ([]int64, bool) {
+	done, private := false, false
+	return func() ([]int64, bool) {
+		if done {
+			return nil, false
+		}
+		var batch []int64
+		var last interface{}
+		keys := 0
+		visit := func(key interface{}, rowIDs []int64) bool {
+			batch = append(batch, rowIDs...)
+			last = key
+			keys++
+			return keys < btreeBatchKeys
+		}
+		walk := func(current bool) {
+			if !current && !private {
+				btree, private = stateIndex(state, table, btree.Columns), true
+			}
+			if descending {
+				btree.Descend(lo, hi, visit)
+			} else {
+				btree.Ascend(lo, hi, visit)
+			}
+		}
+		if private {
+			walk(true)
+		} else {
+			reader.read(walk)
+		}
+		switch {
+		case keys < btreeBatchKeys:
+			done = true
+		case descending:
+			hi = index.Exclusive(last)
+		default:
+			lo = index.Exclusive(last)
+		}
+		return batch, keys > 0
+	}
+}
+
+// filterOp passes on the rows keep accepts
+type filterOp struct {
+	child operator
+	keep  func(storage.RowWithID) bool
+}
+
+func (o *filterOp) Open() error { return o.child.Open() }
+
+func (o *filterOp) Next() (storage.RowWithID, bool, error) {
+	for {
+		r, ok, err := o.child.Next()
+		if err != nil || !ok || o.keep(r) {
+			return r, ok, err
+		}
+	}
+}
+
+func (o *filterOp) Close() error { return o.child.Close() }
+
+// projectOp reshapes each row, e.g. to the columns of a SELECT list
+type projectOp struct {
+	child   operator
+	project func(storage.Row) storage.Row
+}
+
+func (o *projectOp) Open() error { return o.child.Open() }
+
+func (o *projectOp) Next() (storage.RowWithID, bool, error) {
+	r, ok, err := o.child.Next()
+	if err != nil || !ok {
+		return r, ok, err
+	}
+	return storage.RowWithID{ID: r.ID, Row: o.project(r.Row)}, true, nil
+}
+
+func (o *projectOp) Close() error { return o.child.Close() }
+
+// sortOp reads all its input on Open, then returns it in ORDER BY order.
+// Input beyond the query's memory budget is sorted in runs written to spill
+// files, which Next merges with the last run, still in memory.
+type sortOp struct {
+	child operator
+	order *parser.OrderBy
+	mem   *queryMemory
+	node  *PlanNode // Counts the runs spilled
+
+	rows   []storage.RowWithID
+	held   int64
+	pos    int
+	runs   []*spillFile
+	merged *runHeap // nil when every row fit in memory
+}
+
+func (o *sortOp) Open() error {
+	if err := o.child.Open(); err != nil {
+		return err
+	}
+	o.rows, o.pos, o.merged = nil, 0, nil
+	for {
+		r, ok, err := o.child.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		size := rowSize(r.Row)
+		if !o.mem.grow(size) && len(o.rows) > 0 {
+			if err := o.spillRun(); err != nil {
+				return err
+			}
+		}
+		o.rows = append(o.rows, r)
+		o.held += size
+	}
+	sortRows(o.rows, o.order)
+	if len(o.runs) == 0 {
+		return nil
+	}
+
+	// Merge the runs, taking the smallest of their next rows each time
+	o.merged = &runHeap{order: o.order}
+	cursors := []*runCursor{{rows: o.rows}}
+	for _, run := range o.runs {
+		reader, err := run.reader()
+		if err != nil {
+			return err
+		}
+		cursors = append(cursors, &runCursor{reader: reader})
+	}
+	for _, c := range cursors {
+		ok, err := c.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			o.merged.cursors = append(o.merged.cursors, c)
+		}
+	}
+	heap.Init(o.merged)
+	return nil
+}
+
+// spillRun sorts the rows in memory and writes them to a new run
+func (o *sortOp) spillRun() error {
+	sortRows(o.rows, o.order)
+	run, err := o.mem.spill(o.node)
+	if err != nil {
+		return err
+	}
+	o.runs = append(o.runs, run)
+	for _, r := range o.rows {
+		if err := run.write(r); err != nil {
+			return err
+		}
+	}
+	o.mem.shrink(o.held)
+	o.rows, o.held = nil, 0
+	return nil
+}
+
+func (o *sortOp) Next() (storage.RowWithID, bool, error) {
+	if o.merged == nil {
+		if o.pos >= len(o.rows) {
+			return storage.RowWithID{}, false, nil
+		}
+		o.pos++
+		return o.rows[o.pos-1], true, nil
+	}
+
+	if o.merged.Len() == 0 {
+		return storage.RowWithID{}, false, nil
+	}
+	c := o.merged.cursors[0]
+	r := c.row
+	ok, err := c.advance()
+	if err != nil {
+		return storage.RowWithID{}, false, err
+	}
+	if ok {
+		heap.Fix(o.merged, 0)
+	} else {
+		heap.Pop(o.merged)
+	}
+	return r, true, nil
+}
+
+func (o *sortOp) Close() error {
+	err := removeAll(o.runs)
+	o.mem.shrink(o.held)
+	o.rows, o.held, o.runs, o.merged = nil, 0, nil, nil
+	if closeErr := o.child.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runCursor is the next row of a sorted run, read from a spill file or from
+// the rows left in memory
+type runCursor struct {
+	row    storage.RowWithID
+	reader *spillReader
+	rows   []storage.RowWithID
+}
+
+// advance moves to the run's next row, returning false at its end
+func (c *runCursor) advance() (bool, error) {
+	if c.reader == nil {
+		if len(c.rows) == 0 {
+			return false, nil
+		}
+		c.row, c.rows = c.rows[0], c.rows[1:]
+		return true, nil
+	}
+	c.row = storage.RowWithID{}
+	return c.reader.next(&c.row)
+}
+
+// runHeap orders the cursors of an external merge sort by their next rows
+type runHeap struct {
+	cursors []*runCursor
+	order   *parser.OrderBy
+}
+
+func (h *runHeap) Len() int { return len(h.cursors) }
+func (h *runHeap) Less(i, j int) bool {
+	return rowLess(h.cursors[i].row, h.cursors[j].row, h.order)
+}
+func (h *runHeap) Swap(i, j int)      { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *runHeap) Push(x interface{}) { h.cursors = append(h.cursors, x.(*runCursor)) }
+func (h *runHeap) Pop() interface{} {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
+
+// limitOp returns the first limit rows of its input, then stops pulling
+type limitOp struct {
+	child operator
+	limit int
+
+	returned int
+}
+
+func (o *limitOp) Open() error {
+	o.returned = 0
+	return o.child.Open()
+}
+
+func (o *limitOp) Next() (storage.RowWithID, bool, error) {
+	if o.returned >= o.limit {
+		return storage.RowWithID{}, false, nil
+	}
+	r, ok, err := o.child.Next()
+	if ok {
+		o.returned++
+	}
+	return r, ok, err
+}
+
+func (o *limitOp) Close() error { return o.child.Close() }
+
+// aggregateOp groups its input by the GROUP BY columns on Open and computes
+// the aggregates of the SELECT list for each group. Groups come out in the
+// order their first rows arrived; without GROUP BY there is exactly one.
+// Output rows hold the group columns and each aggregate under its name, e.g.
+// "COUNT(*)", and are numbered from 1 in that order.
+//
+// When the groups outgrow the query's memory budget, their partial
+// aggregates are written to spill partitions by the hash of the group and
+// the table starts over. Each partition then has all the partials of its
+// groups, which Next merges one partition at a time; groups then come out
+// partition by partition.
+type aggregateOp struct {
+	child      operator
+	groupBy    []string
+	aggregates []parser.SelectItem
+	mem        *queryMemory
+	node       *PlanNode // Counts the partitions spilled
+
+	groups     []*group
+	byKey      map[string]*group
+	held       int64
+	partitions []*spillFile // nil until the groups outgrow the budget
+	partition  int          // Next partition to merge
+	rows       []storage.RowWithID
+	pos        int
+	numbered   int64
+}
+
+// group is one group's values of the GROUP BY columns and its partial aggregates
+type group struct {
+	Values []interface{} `json:"values"`
+	Accs   []accumulator `json:"accs"`
+}
+
+// accumulator folds the values of one aggregate over a group
+type accumulator struct {
+	Count   int         `json:"count"` // Rows for COUNT(*), non-NULL values otherwise
+	Sum     float64     `json:"sum"`   // Of the numeric values, for SUM and AVG
+	Numeric int         `json:"numeric"`
+	Min     interface{} `json:"min"`
+	Max     interface{} `json:"max"`
+}
+
+// add folds in one row's value of the aggregate's column
+func (a *accumulator) add(val interface{}) {
+	if val == nil {
+		return
+	}
+	a.Count++
+	if n, ok := val.(float64); ok {
+		a.Sum += n
+		a.Numeric++
+	}
+	if a.Min == nil || index.Compare(val, a.Min) < 0 {
+		a.Min = val
+	}
+	if a.Max == nil || index.Compare(val, a.Max) > 0 {
+		a.Max = val
+	}
+}
+
+// merge folds in another partial aggregate of the same group
+func (a *accumulator) merge(b accumulator) {
+	a.Count += b.Count
+	a.Sum += b.Sum
+	a.Numeric += b.Numeric
+	if b.Min != nil && (a.Min == nil || index.Compare(b.Min, a.Min) < 0) {
+		a.Min = b.Min
+	}
+	if b.Max != nil && (a.Max == nil || index.Compare(b.Max, a.Max) > 0) {
+		a.Max = b.Max
+	}
+}
+
+// result returns an aggregate's value; SUM, AVG, MIN and MAX of no values are NULL
+func (a *accumulator) result(aggregate string) interface{} {
+	switch aggregate {
+	case "COUNT":
+		return float64(a.Count)
+	case "SUM":
+		if a.Numeric == 0 {
+			return nil
+		}
+		return a.Sum
+	case "AVG":
+		if a.Numeric == 0 {
+			return nil
+		}
+		return a.Sum / float64(a.Numeric)
+	case "MIN":
+		return a.Min
+	case "MAX":
+		return a.Max
+	}
+	return nil
+}
+
+// groupSize estimates the memory a group takes
+func groupSize(values []interface{}, aggregates int) int64 {
+	size := int64(64 + 64*aggregates)
+	for _, val := range values {
+		size += 16
+		if s, ok := val.(string); ok {
+			size += int64(len(s))
+		}
+	}
+	return size
+}
+
+func (o *aggregateOp) Open() error {
+	if err := o.child.Open(); err != nil {
+		return err
+	}
+	o.groups, o.byKey, o.rows, o.pos, o.numbered = nil, make(map[string]*group), nil, 0, 0
+	for {
+		r, ok, err := o.child.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		values := make([]interface{}, len(o.groupBy))
+		for i, col := range o.groupBy {
+			values[i] = r.Row[col]
+		}
+		key := index.EncodeKey(index.Key(values))
+		g, seen := o.byKey[key]
+		if !seen {
+			size := groupSize(values, len(o.aggregates))
+			if !o.mem.grow(size) && len(o.groups) > 0 {
+				if err := o.spillGroups(); err != nil {
+					return err
+				}
+			}
+			g = &group{Values: values, Accs: make([]accumulator, len(o.aggregates))}
+			o.byKey[key] = g
+			o.groups = append(o.groups, g)
+			o.held += size
+		}
+		for i, item := range o.aggregates {
+			if item.Column == "*" {
+				g.Accs[i].Count++
+			} else {
+				g.Accs[i].add(r.Row[item.Column])
+			}
+		}
+	}
+
+	if o.partitions != nil {
+		return o.spillGroups()
+	}
+	if len(o.groups) == 0 && len(o.groupBy) == 0 {
+		o.groups = append(o.groups, &group{Accs: make([]accumulator, len(o.aggregates))})
+	}
+	o.emit()
+	return nil
+}
+
+// spillGroups writes the groups in memory to their partitions and empties the table
+func (o *aggregateOp) spillGroups() error {
+	if o.partitions == nil {
+		o.partitions = make([]*spillFile, spillPartitions)
+		for i := range o.partitions {
+			part, err := o.mem.spill(o.node)
+			if err != nil {
+				return err
+			}
+			o.partitions[i] = part
+		}
+	}
+	for key, g := range o.byKey {
+		if err := o.partitions[partitionOf(key)].write(g); err != nil {
+			return err
+		}
+	}
+	o.mem.shrink(o.held)
+	o.groups, o.byKey, o.held = nil, make(map[string]*group), 0
+	return nil
+}
+
+// mergePartition reads the next spilled partition back, merging the partial
+// aggregates of each of its groups, and removes it
+func (o *aggregateOp) mergePartition() error {
+	part := o.partitions[o.partition]
+	o.partitions[o.partition] = nil
+	o.partition++
+	defer part.remove()
+
+	reader, err := part.reader()
+	if err != nil {
+		return err
+	}
+	o.mem.shrink(o.held)
+	o.groups, o.byKey, o.held = nil, make(map[string]*group), 0
+	for {
+		var partial group
+		ok, err := reader.next(&partial)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		key := index.EncodeKey(index.Key(partial.Values))
+		if g, seen := o.byKey[key]; seen {
+			for i := range g.Accs {
+				g.Accs[i].merge(partial.Accs[i])
+			}
+			continue
+		}
+		size := groupSize(partial.Values, len(o.aggregates))
+		o.mem.grow(size)
+		o.held += size
+		o.byKey[key] = &partial
+		o.groups = append(o.groups, &partial)
+	}
+	o.emit()
+	return nil
+}
+
+// emit turns the groups in memory into output rows
+func (o *aggregateOp) emit() {
+	o.rows, o.pos = make([]storage.RowWithID, len(o.groups)), 0
+	for n, g := range o.groups {
+		row := make(storage.Row, len(o.groupBy)+len(o.aggregates))
+		for i, col := range o.groupBy {
+			row[col] = g.Values[i]
+		}
+		for i, item := range o.aggregates {
+			row[item.Name()] = g.Accs[i].result(item.Aggregate)
+		}
+		o.numbered++
+		o.rows[n] = storage.RowWithID{ID: o.numbered, Row: row}
+	}
+}
+
+func (o *aggregateOp) Next() (storage.RowWithID, bool, error) {
+	for o.pos >= len(o.rows) {
+		if o.partitions == nil || o.partition >= len(o.partitions) {
+			return storage.RowWithID{}, false, nil
+		}
+		if err := o.mergePartition(); err != nil {
+			return storage.RowWithID{}, false, err
+		}
+	}
+	o.pos++
+	return o.rows[o.pos-1], true, nil
+}
+
+func (o *aggregateOp) Close() error {
+	err := removeAll(o.partitions)
+	o.mem.shrink(o.held)
+	o.groups, o.byKey, o.held, o.partitions, o.rows = nil, nil, 0, nil, nil
+	if closeErr := o.child.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// measuredOp records the rows an operator returns and the time spent in it,
+// including its inputs, into the node EXPLAIN ANALYZE shows
+type measuredOp struct {
+	child operator
+	node  *PlanNode
+}
+
+// measure wraps an operator to record into a node, or returns it as is for a nil node
+func measure(op operator, node *PlanNode) operator {
+	if node == nil {
+		return op
+	}
+	return &measuredOp{child: op, node: node}
+}
+
+func (o *measuredOp) Open() error {
+	start := time.Now()
+	err := o.child.Open()
+	o.node.record(0, start)
+	return err
+}
+
+func (o *measuredOp) Next() (storage.RowWithID, bool, error) {
+	start := time.Now()
+	r, ok, err := o.child.Next()
+	if ok {
+		o.node.record(1, start)
+	} else {
+		o.node.record(0, start)
+	}
+	return r, ok, err
+}
+
+func (o *measuredOp) Close() error {
+	start := time.Now()
+	err := o.child.Close()
+	o.node.record(0, start)
+	return err
+}
+
+// drain opens an operator and reads all its rows, leaving it open
+func drain(op operator) ([]storage.RowWithID, error) {
+	if err := op.Open(); err != nil {
+		return nil, err
+	}
+	var rows []storage.RowWithID
+	for {
+		r, ok, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return rows, nil
+		}
+		rows = append(rows, r)
+	}
+}
+
+// collect runs an operator to completion and closes it
+func collect(op operator) ([]storage.RowWithID, error) {
+	rows, err := drain(op)
+	if closeErr := op.Close(); err == nil {
+		err = closeErr
+	}
+	return rows, err
+}
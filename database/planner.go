@@ -0,0 +1,676 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"rdbms/index"
+	"rdbms/parser"
+	"rdbms/storage"
+)
+
+// accessMethod is how a SELECT reads its table's rows
+type accessMethod int
+
+const (
+	fullScan          accessMethod = iota // Read every row, then filter and sort
+	indexLookup                           // Fetch the rows for one whole key from any index
+	indexPrefix                           // Walk a composite B-tree's keys sharing leading values
+	indexRange                            // Walk a B-tree between a range condition's bounds
+	indexOrder                            // Walk a B-tree in ORDER BY order, filtering as it goes
+	fullTextSearch                        // Fetch the rows a MATCH ... AGAINST finds, best match first
+	indexIntersection                     // Fetch the rows every one of several index probes finds
+)
+
+// selectPlan is how a single-table SELECT reads, filters, orders and limits rows
+type selectPlan struct {
+	table   string
+	method  accessMethod
+	index   index.Interface // Index used, nil for a full scan
+	columns []string        // Indexed columns
+	used    int             // Leading columns an index lookup or prefix binds
+	key     interface{}     // Key looked up, or the index.Key prefix walked
+	bound   *parser.WhereClause
+	where   *parser.WhereClause
+	indexed []*parser.WhereClause // Conditions the access path answers
+	order   *parser.OrderBy
+	limit   int
+
+	// The access path already yields rows in ORDER BY order, or there is no
+	// ORDER BY, so no sort is needed and a LIMIT stops it early
+	ordered bool
+
+	// The index is partial; the query implies its predicate
+	partial bool
+
+	// Rows found by each MATCH ... AGAINST condition, and the ranked matches of
+	// the one a full-text search reads
+	matched map[*parser.WhereClause]map[int64]bool
+	search  []index.Match
+
+	// Lookups and ranges whose row IDs an index intersection intersects
+	probes []*selectPlan
+
+	// Estimated cost of the plan, and rows the access path yields before any LIMIT
+	cost float64
+	rows float64
+}
+
+// planSelect chooses the cheapest access path by the cost model: a full scan,
+// an index lookup when equality conditions bind a whole key, a walk of a
+// composite B-tree when they bind a leftmost prefix of its columns, a B-tree
+// range for <, <=, >, >= and BETWEEN, a walk of the ORDER BY column's B-tree,
+// or the intersection of the row IDs several indexes find. Estimates come from
+// the statistics ANALYZE collected, or defaults for a table it has not seen.
+// Every condition is still checked on the rows the access path yields.
+//
+// A MATCH ... AGAINST condition is answered by the column's FULLTEXT index,
+// which must exist. The first one is the access path: rows come out best match
+// first unless an ORDER BY says otherwise.
+func (db *Database) planSelect(tableName string, where *parser.WhereClause, order *parser.OrderBy, limit int) (*selectPlan, error) {
+	plan := &selectPlan{table: tableName, method: fullScan, where: where, order: order, limit: limit, ordered: order == nil}
+	conds := where.Conditions()
+
+	for _, c := range conds {
+		if c.Operator != "MATCH" {
+			continue
+		}
+		ti := db.fullTextIndexOn(tableName, c.Column)
+		if ti == nil {
+			return nil, fmt.Errorf("MATCH on '%s.%s' needs a FULLTEXT index", tableName, c.Column)
+		}
+		query, _ := c.Value.(string)
+		search := ti.fullText().Search(query)
+		if plan.matched == nil {
+			plan.matched = make(map[*parser.WhereClause]map[int64]bool)
+			plan.method, plan.index, plan.columns, plan.key = fullTextSearch, ti.idx, ti.columns, query
+			plan.search = search
+			plan.indexed = []*parser.WhereClause{c}
+		}
+		plan.matched[c] = make(map[int64]bool, len(search))
+		for _, m := range search {
+			plan.matched[c][m.RowID] = true
+		}
+	}
+	if plan.matched != nil {
+		plan.price(0, float64(len(plan.search)), float64(len(plan.search)), fetchRowCost)
+		return plan, nil
+	}
+
+	est := db.estimator(tableName)
+	out := est.rows * est.selectivity(conds...)
+	equal := equalConditions(conds)
+	for _, ti := range db.indexes[tableName] {
+		if ti.unique && ti.predicate == nil && boundColumns(ti.columns, equal) {
+			out = math.Min(out, 1)
+		}
+	}
+	plan.price(0, est.rows, out, seqRowCost)
+
+	// The full scan goes last so that an index wins a tie
+	paths := db.indexPaths(plan, est, out)
+	if intersection := db.intersectionPath(plan, est, out); intersection != nil {
+		paths = append(paths, intersection)
+	}
+	best := plan
+	for _, path := range paths {
+		if path.cost < best.cost {
+			best = path
+		}
+	}
+	return best, nil
+}
+
+// indexPaths returns the paths through each index the query may use, priced.
+// base is the full scan plan they share the query with.
+func (db *Database) indexPaths(base *selectPlan, est estimator, out float64) []*selectPlan {
+	conds := base.where.Conditions()
+	equal := equalConditions(conds)
+
+	var paths []*selectPlan
+	for _, ti := range db.sortedIndexes(base.table) {
+		if !ti.usableFor(conds) || ti.fullText() != nil {
+			continue
+		}
+		path := func(method accessMethod) *selectPlan {
+			p := *base
+			p.method, p.index, p.columns, p.partial = method, ti.idx, ti.columns, ti.predicate != nil
+			p.key, p.bound, p.indexed, p.ordered = nil, nil, nil, base.order == nil
+			return &p
+		}
+		probe := probeCost(ti, est.rows)
+
+		used := 0
+		var bound []*parser.WhereClause
+		for used < len(ti.columns) {
+			c, ok := equal[ti.columns[used]]
+			if !ok {
+				break
+			}
+			bound = append(bound, c)
+			used++
+		}
+		switch {
+		case used == len(ti.columns):
+			p := path(indexLookup)
+			p.used, p.key, p.indexed = used, lookupKey(ti.columns, equal), bound
+			p.price(probe, ti.fetched(est, bound), out, fetchRowCost)
+			paths = append(paths, p)
+		case used > 0 && ti.ordered():
+			p := path(indexPrefix)
+			prefix := make(index.Key, used)
+			for i := range prefix {
+				prefix[i] = equal[ti.columns[i]].Value
+			}
+			p.used, p.key, p.indexed = used, prefix, bound
+			p.ordered = base.order == nil || base.order.Column == ti.columns[used]
+			p.price(probe, ti.fetched(est, bound), out, fetchRowCost)
+			paths = append(paths, p)
+		}
+		if !ti.ordered() {
+			continue
+		}
+
+		if len(ti.columns) == 1 {
+			for _, c := range conds {
+				if c.Column != ti.columns[0] || !isRange(c) {
+					continue
+				}
+				p := path(indexRange)
+				p.bound, p.indexed = c, []*parser.WhereClause{c}
+				p.ordered = base.order == nil || base.order.Column == c.Column
+				p.price(probe, ti.fetched(est, []*parser.WhereClause{c}), out, fetchRowCost)
+				paths = append(paths, p)
+			}
+		}
+
+		if base.order != nil && base.order.Column == ti.columns[0] {
+			p := path(indexOrder)
+			p.ordered = true
+			p.price(probe, ti.fetched(est, nil), out, fetchRowCost)
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// intersectionPath returns the cheapest intersection of two or more index
+// probes binding different conditions, or nil. A probe is a whole-key lookup
+// or a single-column B-tree range; probes are added, smallest first, while
+// the rows they rule out are worth more than collecting their row IDs.
+func (db *Database) intersectionPath(base *selectPlan, est estimator, out float64) *selectPlan {
+	conds := base.where.Conditions()
+	equal := equalConditions(conds)
+
+	type candidate struct {
+		probe *selectPlan
+		conds []*parser.WhereClause
+		sel   float64
+		cost  float64
+	}
+	var candidates []candidate
+	for _, ti := range db.sortedIndexes(base.table) {
+		if !ti.usableFor(conds) || ti.fullText() != nil {
+			continue
+		}
+		var bound []*parser.WhereClause
+		for _, col := range ti.columns {
+			if c, ok := equal[col]; ok {
+				bound = append(bound, c)
+			}
+		}
+		probe := &selectPlan{table: base.table, index: ti.idx, columns: ti.columns, partial: ti.predicate != nil}
+		if len(bound) == len(ti.columns) {
+			probe.method, probe.used, probe.key = indexLookup, len(bound), lookupKey(ti.columns, equal)
+		} else if ti.ordered() && len(ti.columns) == 1 {
+			for _, c := range conds {
+				if c.Column == ti.columns[0] && isRange(c) {
+					probe.method, probe.bound, bound = indexRange, c, []*parser.WhereClause{c}
+					break
+				}
+			}
+		}
+		if probe.method == fullScan {
+			continue
+		}
+		k := ti.fetched(est, bound)
+		probe.indexed, probe.rows, probe.cost = bound, k, probeCost(ti, est.rows)+k*rowIDCost
+		candidates = append(candidates, candidate{probe, bound, k / est.rows, probeCost(ti, est.rows) + k*rowIDCost})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].sel < candidates[j].sel })
+
+	var chosen []candidate
+	boundBy := make(map[*parser.WhereClause]bool)
+	cost, sel := 0.0, 1.0
+	for _, c := range candidates {
+		disjoint := true
+		for _, cond := range c.conds {
+			disjoint = disjoint && !boundBy[cond]
+		}
+		if !disjoint {
+			continue
+		}
+		if len(chosen) > 0 && c.cost >= est.rows*sel*(1-c.sel)*fetchRowCost {
+			continue
+		}
+		chosen = append(chosen, c)
+		cost += c.cost
+		sel *= c.sel
+		for _, cond := range c.conds {
+			boundBy[cond] = true
+		}
+	}
+	if len(chosen) < 2 {
+		return nil
+	}
+
+	p := *base
+	p.method, p.index, p.columns, p.key, p.bound = indexIntersection, nil, nil, nil, nil
+	for _, c := range chosen {
+		p.probes = append(p.probes, c.probe)
+		p.indexed = append(p.indexed, c.conds...)
+	}
+	// Row IDs come out in ascending order
+	p.ordered = base.order == nil
+	p.price(cost, est.rows*sel, out, fetchRowCost)
+	return &p
+}
+
+// fetched estimates the rows an index yields for conditions binding its
+// columns. A partial index holds only the rows satisfying its predicate.
+func (ti *tableIndex) fetched(est estimator, bound []*parser.WhereClause) float64 {
+	rows := est.rows * est.selectivity(bound...)
+	if ti.predicate != nil {
+		for _, c := range ti.predicate.Conditions() {
+			if !boundColumn(bound, c.Column) {
+				rows *= est.conditionSelectivity(c)
+			}
+		}
+	}
+	if ti.unique && len(bound) == len(ti.columns) {
+		rows = math.Min(rows, 1)
+	}
+	return rows
+}
+
+// price sets the estimated cost and output rows of a path that pays probe to
+// start, then perRow for each row it reads of the fetched ones. An ordered
+// path under a LIMIT stops once it has found enough rows; one that is not in
+// ORDER BY order must sort what it finds.
+func (p *selectPlan) price(probe, fetched, out, perRow float64) {
+	read := fetched
+	if p.ordered && p.limit > 0 && out > float64(p.limit) {
+		read = fetched * float64(p.limit) / out
+	}
+	p.cost = probe + read*perRow
+	if p.order != nil && !p.ordered {
+		p.cost += sortCost(out)
+	}
+	p.rows = out
+}
+
+// equalConditions returns the first equality condition on each column
+func equalConditions(conds []*parser.WhereClause) map[string]*parser.WhereClause {
+	equal := make(map[string]*parser.WhereClause)
+	for _, c := range conds {
+		if _, seen := equal[c.Column]; !seen && (c.Operator == "" || c.Operator == "=") {
+			equal[c.Column] = c
+		}
+	}
+	return equal
+}
+
+// lookupKey returns the key equality conditions bind for an index's columns:
+// the value itself for one column, an index.Key for several
+func lookupKey(columns []string, equal map[string]*parser.WhereClause) interface{} {
+	if len(columns) == 1 {
+		return equal[columns[0]].Value
+	}
+	key := make(index.Key, len(columns))
+	for i, col := range columns {
+		key[i] = equal[col].Value
+	}
+	return key
+}
+
+// boundColumns reports whether equality conditions bind every one of the columns
+func boundColumns(columns []string, equal map[string]*parser.WhereClause) bool {
+	for _, col := range columns {
+		if _, ok := equal[col]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isRange reports whether a condition is a comparison a B-tree range serves
+func isRange(c *parser.WhereClause) bool {
+	switch c.Operator {
+	case "<", "<=", ">", ">=", "BETWEEN":
+		return true
+	}
+	return false
+}
+
+// boundColumn reports whether one of the conditions is on a column
+func boundColumn(conds []*parser.WhereClause, column string) bool {
+	for _, c := range conds {
+		if c.Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// open builds the plan's operators over a state: the access path, a filter
+// checking every condition on the rows it yields, a sort unless the path
+// yields ORDER BY order, then a limit. The access path reads indexes through
+// reader, and a sort holds rows within the query's memory budget. They record
+// what they do in the nodes EXPLAIN ANALYZE shows, if any.
+func (p *selectPlan) open(state *storage.DerivedState, reader *indexReader, mem *queryMemory, nodes selectNodes) operator {
+	var op operator = &scanOp{state: state, table: p.table}
+	if p.method != fullScan {
+		op = &indexScanOp{state: state, table: p.table, source: p.rowIDSource(state, reader, nodes.probes)}
+	}
+	if p.where != nil {
+		op = &filterOp{child: op, keep: p.matches}
+	}
+	op = measure(op, nodes.access)
+
+	if p.order != nil && !p.ordered {
+		op = measure(&sortOp{child: op, order: p.order, mem: mem, node: nodes.sort}, nodes.sort)
+	}
+	if p.limit > 0 {
+		op = measure(&limitOp{child: op, limit: p.limit}, nodes.limit)
+	}
+	return op
+}
+
+// rowIDSource returns the row IDs an index path finds, in the order it reads
+// them. A lookup copies its row IDs while the query is opened; B-tree walks go
+// a batch at a time; an index intersection collects its probes' row IDs on the
+// first call, recording each probe in its node, or falls back to the state's
+// row IDs once the indexes have moved past it.
+func (p *selectPlan) rowIDSource(state *storage.DerivedState, reader *indexReader, probes []*PlanNode) func() ([]int64, bool) {
+	descending := p.order != nil && p.order.Desc && p.ordered
+
+	switch p.method {
+	case indexLookup:
+		rowIDs, _ := p.index.Lookup(p.key)
+		return rowIDList(append([]int64(nil), rowIDs...))
+
+	case indexPrefix:
+		lo, hi := index.KeyPrefixBounds(p.key.(index.Key))
+		return btreeBatches(reader, state, p.table, p.index.(*index.BTree), lo, hi, descending)
+
+	case indexRange, indexOrder:
+		lo, hi := index.Unbounded(), index.Unbounded()
+		if p.method == indexRange {
+			lo, hi = whereBounds(p.bound)
+		}
+		return btreeBatches(reader, state, p.table, p.index.(*index.BTree), lo, hi, descending)
+
+	case fullTextSearch:
+		rowIDs := make([]int64, len(p.search))
+		for i, m := range p.search {
+			rowIDs[i] = m.RowID
+		}
+		return rowIDList(rowIDs)
+	}
+
+	var intersected func() ([]int64, bool)
+	return func() ([]int64, bool) {
+		if intersected == nil {
+			reader.read(func(current bool) {
+				if current {
+					intersected = rowIDList(p.intersect(probes))
+				} else {
+					intersected = rowIDList(state.TableRowIDs(p.table))
+				}
+			})
+		}
+		return intersected()
+	}
+}
+
+// intersect returns the row IDs every probe of an index intersection finds,
+// in ascending order
+func (p *selectPlan) intersect(nodes []*PlanNode) []int64 {
+	var found map[int64]bool
+	for i, probe := range p.probes {
+		start := time.Now()
+		ids := probe.rowIDs()
+		if i < len(nodes) {
+			nodes[i].record(len(ids), start)
+		}
+		if found != nil {
+			for rowID := range found {
+				if !ids[rowID] {
+					delete(found, rowID)
+				}
+			}
+		} else {
+			found = ids
+		}
+	}
+	rowIDs := make([]int64, 0, len(found))
+	for rowID := range found {
+		rowIDs = append(rowIDs, rowID)
+	}
+	sort.Slice(rowIDs, func(i, j int) bool { return rowIDs[i] < rowIDs[j] })
+	return rowIDs
+}
+
+// rowIDs returns the row IDs an index lookup or range finds, without reading the rows
+func (p *selectPlan) rowIDs() map[int64]bool {
+	found := make(map[int64]bool)
+	if p.method == indexLookup {
+		rowIDs, _ := p.index.Lookup(p.key)
+		for _, rowID := range rowIDs {
+			found[rowID] = true
+		}
+		return found
+	}
+	lo, hi := whereBounds(p.bound)
+	p.index.(*index.BTree).Ascend(lo, hi, func(key interface{}, rowIDs []int64) bool {
+		for _, rowID := range rowIDs {
+			found[rowID] = true
+		}
+		return true
+	})
+	return found
+}
+
+// String describes the plan, e.g. "index range on users.id (btree)",
+// "index prefix on members.(user_id, group_id) (btree), 1 of 2 columns" or
+// "index intersection of users.status (hash) and users.age (btree)"
+func (p *selectPlan) String() string {
+	var s string
+	switch p.method {
+	case indexLookup:
+		s = "index lookup on " + p.target()
+	case indexPrefix:
+		s = fmt.Sprintf("index prefix on %s, %d of %d columns", p.target(), p.used, len(p.columns))
+	case indexRange:
+		s = "index range on " + p.target()
+	case indexOrder:
+		s = "index order on " + p.target()
+	case fullTextSearch:
+		s = "full-text search on " + p.target()
+	case indexIntersection:
+		targets := make([]string, len(p.probes))
+		for i, probe := range p.probes {
+			targets[i] = probe.target()
+		}
+		last := len(targets) - 1
+		s = fmt.Sprintf("index intersection of %s and %s", strings.Join(targets[:last], ", "), targets[last])
+	default:
+		s = fmt.Sprintf("full scan on %s", p.table)
+	}
+	if p.order != nil && !p.ordered {
+		s += fmt.Sprintf(", sort by %s", p.order.Column)
+	}
+	if p.limit > 0 {
+		s += fmt.Sprintf(", limit %d", p.limit)
+	}
+	return s
+}
+
+// target describes the index a plan reads, e.g. "users.id (btree)"
+func (p *selectPlan) target() string {
+	kind := "hash"
+	switch p.index.(type) {
+	case *index.BTree:
+		kind = "btree"
+	case *index.FullText:
+		kind = "fulltext"
+	}
+	if p.partial {
+		kind += ", partial"
+	}
+	if len(p.columns) == 1 {
+		return fmt.Sprintf("%s.%s (%s)", p.table, p.columns[0], kind)
+	}
+	return fmt.Sprintf("%s.(%s) (%s)", p.table, strings.Join(p.columns, ", "), kind)
+}
+
+// whereBounds converts a comparison to B-tree bounds
+func whereBounds(where *parser.WhereClause) (lo, hi index.Bound) {
+	lo, hi = index.Unbounded(), index.Unbounded()
+	switch where.Operator {
+	case "", "=":
+		lo, hi = index.Inclusive(where.Value), index.Inclusive(where.Value)
+	case "<":
+		hi = index.Exclusive(where.Value)
+	case "<=":
+		hi = index.Inclusive(where.Value)
+	case ">":
+		lo = index.Exclusive(where.Value)
+	case ">=":
+		lo = index.Inclusive(where.Value)
+	case "BETWEEN":
+		lo, hi = index.Inclusive(where.Value), index.Inclusive(where.High)
+	}
+	return lo, hi
+}
+
+// implies reports whether every row satisfying the query's conditions
+// satisfies a partial index's predicate: each condition of the predicate needs
+// a query condition on the same column whose values lie within its own
+func implies(conds []*parser.WhereClause, predicate *parser.WhereClause) bool {
+	for _, p := range predicate.Conditions() {
+		implied := false
+		for _, c := range conds {
+			if c.Column == p.Column && c.Operator != "MATCH" && rangeWithin(c, p) {
+				implied = true
+				break
+			}
+		}
+		if !implied {
+			return false
+		}
+	}
+	return true
+}
+
+// rangeWithin reports whether the values one comparison accepts are all
+// accepted by another, e.g. age > 30 within age >= 18
+func rangeWithin(inner, outer *parser.WhereClause) bool {
+	innerLo, innerHi := whereBounds(inner)
+	outerLo, outerHi := whereBounds(outer)
+	return boundWithin(innerLo, outerLo, 1) && boundWithin(innerHi, outerHi, -1)
+}
+
+// boundWithin reports whether a bound is at least as tight as another. dir is
+// 1 for lower bounds, which tighten upwards, and -1 for upper bounds.
+func boundWithin(inner, outer index.Bound, dir int) bool {
+	if outer.Infinite {
+		return true
+	}
+	if inner.Infinite {
+		return false
+	}
+	c := index.Compare(inner.Value, outer.Value) * dir
+	return c > 0 || c == 0 && (outer.Inclusive || !inner.Inclusive)
+}
+
+// matches reports whether a row satisfies the plan's WHERE clause, looking up
+// MATCH conditions in the rows their FULLTEXT index found
+func (p *selectPlan) matches(r storage.RowWithID) bool {
+	for _, c := range p.where.Conditions() {
+		if matched, isMatch := p.matched[c]; isMatch {
+			if !matched[r.ID] {
+				return false
+			}
+		} else if !matchesCondition(r.Row, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesWhere reports whether a row satisfies every condition of an optional
+// WHERE clause. MATCH conditions need a plan's FULLTEXT lookups and never match.
+func matchesWhere(row storage.Row, where *parser.WhereClause) bool {
+	for _, c := range where.Conditions() {
+		if !matchesCondition(row, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesCondition reports whether a row satisfies one condition. Equality
+// compares values by type, like the indexes; range operators use the typed
+// ordering of index.Compare, and NULL never satisfies them.
+func matchesCondition(row storage.Row, where *parser.WhereClause) bool {
+	val, exists := index.ValueOf(where.Column, row)
+	if !exists {
+		return false
+	}
+
+	switch where.Operator {
+	case "", "=":
+		return valuesEqual(val, where.Value)
+	}
+	if val == nil {
+		return false
+	}
+	switch where.Operator {
+	case "<":
+		return index.Compare(val, where.Value) < 0
+	case "<=":
+		return index.Compare(val, where.Value) <= 0
+	case ">":
+		return index.Compare(val, where.Value) > 0
+	case ">=":
+		return index.Compare(val, where.Value) >= 0
+	case "BETWEEN":
+		return index.Compare(val, where.Value) >= 0 && index.Compare(val, where.High) <= 0
+	}
+	return false
+}
+
+// sortRows sorts rows by an optional ORDER BY column, breaking ties by row ID
+func sortRows(rows []storage.RowWithID, order *parser.OrderBy) {
+	if order == nil {
+		return
+	}
+	sort.Slice(rows, func(i, j int) bool { return rowLess(rows[i], rows[j], order) })
+}
+
+// rowLess reports whether a row sorts before another by an ORDER BY column,
+// breaking ties by row ID
+func rowLess(a, b storage.RowWithID, order *parser.OrderBy) bool {
+	c := index.Compare(a.Row[order.Column], b.Row[order.Column])
+	if c == 0 {
+		return a.ID < b.ID
+	}
+	if order.Desc {
+		return c > 0
+	}
+	return c < 0
+}
@@ -0,0 +1,349 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"rdbms/parser"
+	"rdbms/schema"
+	"rdbms/storage"
+)
+
+// Rows is the result of a query, read one row at a time with Next:
+//
+//	rows, err := db.Query(stmt)
+//	if err != nil { ... }
+//	defer rows.Close()
+//	for rows.Next() {
+//	    fmt.Println(rows.Row())
+//	}
+//	if err := rows.Err(); err != nil { ... }
+//
+// Rows reads the state as of the moment the query was opened: writes made
+// while it is read are not seen, and do not wait for it, so a caller may block
+// between rows, e.g. on writes to a network client. Always Close it, so that
+// the operators release what they hold.
+type Rows struct {
+	op      operator
+	columns []string
+
+	row    storage.Row
+	err    error
+	closed bool
+}
+
+// Next advances to the next row, returning false at the end or on an error
+func (r *Rows) Next() bool {
+	if r.closed {
+		return false
+	}
+	row, ok, err := r.op.Next()
+	if err != nil || !ok {
+		r.err = err
+		if closeErr := r.Close(); r.err == nil {
+			r.err = closeErr
+		}
+		return false
+	}
+	r.row = row.Row
+	return true
+}
+
+// Row returns the current row
+func (r *Rows) Row() storage.Row {
+	return r.row
+}
+
+// Err returns the error that ended the rows early, if any
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Columns returns the names of the result's columns in SELECT list order:
+// a table's columns for SELECT *, "table.column" for a join. It is nil for
+// system tables, whose columns are not in the catalog.
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// Close stops reading and releases what the operators hold, such as spill
+// files. It is safe to call more than once.
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.op.Close()
+}
+
+// All reads the remaining rows and closes the result
+func (r *Rows) All() ([]storage.Row, error) {
+	defer r.Close()
+	var rows []storage.Row
+	for r.Next() {
+		rows = append(rows, r.Row())
+	}
+	return rows, r.Err()
+}
+
+// Query runs a SELECT, including AS OF, a SELECT list, aggregates and GROUP
+// BY, or a JOIN, returning its rows as the operators produce them. A LIMIT
+// stops reading the table once enough rows have come out, unless a sort or
+// an aggregate has to see them all first. The read lock is held while the
+// query is planned and opened, then released; the rows are read from the state
+// built for the query, and index walks take the lock again for each batch.
+func (db *Database) Query(stmt *parser.ParsedStatement) (*Rows, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.query(stmt)
+}
+
+// query plans and opens a query (caller holds db.mu, and releases it once
+// the query is open)
+func (db *Database) query(stmt *parser.ParsedStatement) (*Rows, error) {
+	// A read before the compaction horizon fails before the schema is projected
+	var state *storage.DerivedState
+	var err error
+	if stmt.AsOf > 0 {
+		state, err = db.queryEngine.GetStateAsOf(stmt.AsOf)
+	} else {
+		// Get current state from query engine (uses snapshots + events)
+		state, err = db.queryEngine.GetCurrentState()
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan, err := db.planQuery(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &indexReader{db: db, at: db.eventStore.GetLastEventID(), held: true}
+	op := plan.open(state, reader, db.newQueryMemory(), queryNodes{})
+	if err := op.Open(); err != nil {
+		op.Close()
+		return nil, err
+	}
+	reader.held = false
+	return &Rows{op: op, columns: plan.columns}, nil
+}
+
+// queryPlan is how a query reads its rows, then aggregates, orders, limits
+// and projects them
+type queryPlan struct {
+	sel  *selectPlan
+	join *joinPlan
+
+	// AS OF: the event read as of, and the table's schema at that point
+	asOf  uint64
+	table *schema.Table
+
+	// SELECT list, nil for SELECT *
+	items []parser.SelectItem
+
+	// GROUP BY or aggregates: the ORDER BY and LIMIT apply to the groups
+	aggregated bool
+	groupBy    []string
+	groups     float64 // Estimated
+	order      *parser.OrderBy
+	limit      int
+
+	columns []string
+}
+
+// queryNodes are the nodes a query plan records into; nil ones record nothing
+type queryNodes struct {
+	sel                             selectNodes
+	join                            joinNodes
+	aggregate, sort, limit, project *PlanNode
+}
+
+// planQuery plans a SELECT or JOIN statement (caller holds db.mu)
+func (db *Database) planQuery(stmt *parser.ParsedStatement) (*queryPlan, error) {
+	switch stmt.Type {
+	case "JOIN":
+		join, err := db.planJoin(stmt.TableName, stmt.JoinTable, stmt.JoinCondition, stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		q := &queryPlan{join: join}
+		for _, tableName := range []string{stmt.TableName, stmt.JoinTable} {
+			table, _ := db.catalog.GetTable(tableName)
+			for _, col := range table.Columns {
+				q.columns = append(q.columns, tableName+"."+col.Name)
+			}
+		}
+		return q, nil
+	case "SELECT":
+	default:
+		return nil, fmt.Errorf("%s is not a query", stmt.Type)
+	}
+
+	q := &queryPlan{asOf: stmt.AsOf, items: stmt.Select, groupBy: stmt.GroupBy}
+	for _, item := range stmt.Select {
+		q.aggregated = q.aggregated || item.Aggregate != ""
+	}
+	q.aggregated = q.aggregated || len(stmt.GroupBy) > 0
+	order, limit := stmt.OrderBy, stmt.Limit
+	if q.aggregated {
+		q.order, q.limit, order, limit = order, limit, nil, 0
+	}
+
+	var err error
+	if stmt.AsOf > 0 {
+		for _, c := range stmt.Where.Conditions() {
+			if c.Operator == "MATCH" {
+				return nil, fmt.Errorf("MATCH ... AGAINST reads the current FULLTEXT index and cannot be used with AS OF")
+			}
+		}
+		if q.table, err = db.schemaAsOf(stmt.TableName, stmt.AsOf); err != nil {
+			return nil, err
+		}
+		// Indexes reflect the current state, so historical reads always scan
+		q.sel = &selectPlan{table: stmt.TableName, method: fullScan, where: stmt.Where, order: order, limit: limit, ordered: order == nil}
+	} else {
+		if !db.catalog.TableExists(stmt.TableName) && !schema.IsSystemTable(stmt.TableName) {
+			return nil, fmt.Errorf("table '%s' does not exist", stmt.TableName)
+		}
+		q.table, _ = db.catalog.GetTable(stmt.TableName)
+		if q.sel, err = db.planSelect(stmt.TableName, stmt.Where, order, limit); err != nil {
+			return nil, err
+		}
+	}
+	if err := q.validate(stmt); err != nil {
+		return nil, err
+	}
+
+	// A GROUP BY forms a group per combination of its columns' distinct
+	// values, at most one per row grouped
+	if q.aggregated {
+		est, distinct := db.estimator(stmt.TableName), 1.0
+		for _, col := range q.groupBy {
+			distinct *= est.distinct(col)
+		}
+		q.groups = math.Max(1, math.Min(distinct, q.sel.rows))
+	}
+
+	switch {
+	case q.items != nil:
+		for _, item := range q.items {
+			q.columns = append(q.columns, item.Name())
+		}
+	case q.table != nil:
+		for _, col := range q.table.Columns {
+			q.columns = append(q.columns, col.Name)
+		}
+	}
+	return q, nil
+}
+
+// validate checks the SELECT list and GROUP BY against the table's columns:
+// with aggregates, every plain column must be grouped by, and ORDER BY must
+// name a group column. SUM and AVG need INT columns.
+func (q *queryPlan) validate(stmt *parser.ParsedStatement) error {
+	columns := make(map[string]*schema.Column)
+	if q.table != nil {
+		for i := range q.table.Columns {
+			columns[q.table.Columns[i].Name] = &q.table.Columns[i]
+		}
+	}
+	exists := func(col string) error {
+		if _, ok := columns[col]; !ok && q.table != nil {
+			return fmt.Errorf("column '%s' does not exist in table '%s'", col, stmt.TableName)
+		}
+		return nil
+	}
+
+	for _, col := range q.groupBy {
+		if err := exists(col); err != nil {
+			return err
+		}
+	}
+	if q.aggregated && q.items == nil {
+		return fmt.Errorf("SELECT * cannot be used with GROUP BY")
+	}
+	for _, item := range q.items {
+		if item.Column == "*" {
+			continue
+		}
+		if err := exists(item.Column); err != nil {
+			return err
+		}
+		switch {
+		case item.Aggregate == "" && q.aggregated && !containsString(q.groupBy, item.Column):
+			return fmt.Errorf("column '%s' must appear in GROUP BY or be used in an aggregate", item.Column)
+		case (item.Aggregate == "SUM" || item.Aggregate == "AVG") && columns[item.Column] != nil && columns[item.Column].Type != schema.TypeInt:
+			return fmt.Errorf("%s needs an INT column, '%s' is %s", item.Aggregate, item.Column, columns[item.Column].Type)
+		}
+	}
+	if q.aggregated && q.order != nil && !containsString(q.groupBy, q.order.Column) {
+		return fmt.Errorf("ORDER BY column '%s' must appear in GROUP BY", q.order.Column)
+	}
+	return nil
+}
+
+// open builds the query's operators over a state, reading indexes through
+// reader, holding rows within a memory budget and recording into nodes
+func (q *queryPlan) open(state *storage.DerivedState, reader *indexReader, mem *queryMemory, nodes queryNodes) operator {
+	if q.join != nil {
+		return q.join.open(state, reader, mem, nodes.join)
+	}
+
+	op := q.sel.open(state, reader, mem, nodes.sel)
+	if q.asOf > 0 {
+		// Shape rows by the schema in effect at the event
+		table := q.table
+		op = &projectOp{child: op, project: func(row storage.Row) storage.Row { return projectRow(table, row) }}
+	}
+	if q.aggregated {
+		var aggregates []parser.SelectItem
+		for _, item := range q.items {
+			if item.Aggregate != "" {
+				aggregates = append(aggregates, item)
+			}
+		}
+		op = measure(&aggregateOp{child: op, groupBy: q.groupBy, aggregates: aggregates, mem: mem, node: nodes.aggregate}, nodes.aggregate)
+		if q.order != nil {
+			op = measure(&sortOp{child: op, order: q.order, mem: mem, node: nodes.sort}, nodes.sort)
+		}
+		if q.limit > 0 {
+			op = measure(&limitOp{child: op, limit: q.limit}, nodes.limit)
+		}
+	}
+	if q.items != nil {
+		op = measure(&projectOp{child: op, project: q.project}, nodes.project)
+	}
+	return op
+}
+
+// project keeps the columns and aggregates of the SELECT list
+func (q *queryPlan) project(row storage.Row) storage.Row {
+	projected := make(storage.Row, len(q.items))
+	for _, item := range q.items {
+		name := item.Name()
+		if val, exists := row[name]; exists {
+			projected[name] = val
+		}
+	}
+	return projected
+}
+
+// containsString reports whether a string is one of values
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// itemsString formats a SELECT list, e.g. "status, COUNT(*)"
+func itemsString(items []parser.SelectItem) string {
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item.Name()
+	}
+	return strings.Join(names, ", ")
+}
@@ -2,59 +2,85 @@ package database
 
 import (
 	"fmt"
+	"rdbms/catalog"
 	"rdbms/parser"
+	"rdbms/schema"
 	"rdbms/storage"
 )
 
 // Select selects rows from a table with optional WHERE clause (uses index if available)
 // Now derives state from event log via query engine
 func (db *Database) Select(tableName string, where *parser.WhereClause) ([]storage.Row, error) {
+	return db.SelectOrdered(tableName, where, nil, 0)
+}
+
+// SelectOrdered selects rows matching an optional WHERE clause, sorted by an
+// optional ORDER BY and cut to limit rows if limit > 0. The planner reads
+// through an index when one serves the WHERE clause or the ORDER BY.
+func (db *Database) SelectOrdered(tableName string, where *parser.WhereClause, order *parser.OrderBy, limit int) ([]storage.Row, error) {
+	rows, err := db.Query(&parser.ParsedStatement{Type: "SELECT", TableName: tableName, Where: where, OrderBy: order, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return rows.All()
+}
+
+// PlanSelect describes how SelectOrdered would read a table, e.g.
+// "index range on users.id (btree), limit 10"
+func (db *Database) PlanSelect(tableName string, where *parser.WhereClause, order *parser.OrderBy, limit int) (string, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	if !db.catalog.TableExists(tableName) {
-		return nil, fmt.Errorf("table '%s' does not exist", tableName)
+	if !db.catalog.TableExists(tableName) && !schema.IsSystemTable(tableName) {
+		return "", fmt.Errorf("table '%s' does not exist", tableName)
+	}
+	plan, err := db.planSelect(tableName, where, order, limit)
+	if err != nil {
+		return "", err
 	}
+	return plan.String(), nil
+}
+
+// SelectAsOf selects rows as they were right after the given event, shaped by
+// the schema in effect at that point. Indexes reflect the current state, so
+// historical reads always scan, and MATCH ... AGAINST is not available.
+func (db *Database) SelectAsOf(tableName string, where *parser.WhereClause, eventID uint64) ([]storage.Row, error) {
+	return db.SelectAsOfOrdered(tableName, where, nil, 0, eventID)
+}
 
-	// Get current state from query engine (uses snapshots + events)
-	state, err := db.queryEngine.GetCurrentState()
+// SelectAsOfOrdered is SelectAsOf with an optional ORDER BY and LIMIT
+func (db *Database) SelectAsOfOrdered(tableName string, where *parser.WhereClause, order *parser.OrderBy, limit int, eventID uint64) ([]storage.Row, error) {
+	rows, err := db.Query(&parser.ParsedStatement{Type: "SELECT", TableName: tableName, Where: where, OrderBy: order, Limit: limit, AsOf: eventID})
 	if err != nil {
 		return nil, err
 	}
+	return rows.All()
+}
 
-	var rowsWithID []storage.RowWithID
-
-	// Try to use index for WHERE clause
-	if where != nil {
-		if idx, hasIndex := db.indexes[tableName][where.Column]; hasIndex {
-			// Index hit! Fetch only matching row IDs from index
-			if rowIDs, found := idx.Lookup(where.Value); found {
-				for _, rowID := range rowIDs {
-					if row, exists := state.GetRow(tableName, rowID); exists {
-						rowsWithID = append(rowsWithID, storage.RowWithID{ID: rowID, Row: row})
-					}
-				}
-			}
-		} else {
-			// No index, fall back to full scan with WHERE filter
-			allRows := state.GetTableRows(tableName)
-			for _, r := range allRows {
-				if val, exists := r.Row[where.Column]; exists {
-					if valuesEqual(val, where.Value) {
-						rowsWithID = append(rowsWithID, r)
-					}
-				}
-			}
-		}
-	} else {
-		// No WHERE clause, return all rows
-		rowsWithID = state.GetTableRows(tableName)
+// schemaAsOf returns a table's schema as projected from the schema events up to eventID
+func (db *Database) schemaAsOf(tableName string, eventID uint64) (*schema.Table, error) {
+	events, err := db.eventStore.ReadAllEvents()
+	if err != nil {
+		return nil, err
 	}
-
-	var rows []storage.Row
-	for _, r := range rowsWithID {
-		rows = append(rows, r.Row)
+	schemas, err := catalog.Project(events, eventID)
+	if err != nil {
+		return nil, err
+	}
+	table, exists := schemas[tableName]
+	if !exists {
+		return nil, fmt.Errorf("table '%s' does not exist as of event %d", tableName, eventID)
 	}
+	return table, nil
+}
 
-	return rows, nil
+// projectRow keeps only the columns defined by the given schema
+func projectRow(table *schema.Table, row storage.Row) storage.Row {
+	projected := make(storage.Row, len(table.Columns))
+	for _, col := range table.Columns {
+		if val, exists := row[col.Name]; exists {
+			projected[col.Name] = val
+		}
+	}
+	return projected
 }
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+
+	"rdbms/storage"
+)
+
+// DefaultQueryMemory is the memory a query may hold in sorts, hash tables and
+// groups before it spills to disk
+const DefaultQueryMemory = 64 << 20
+
+// spillDir is the directory under the data directory that holds spill files,
+// named by spillPattern. None outlives its query, so New removes any it finds.
+const spillDir = "spill"
+
+// spillPattern matches the names of spill files
+const spillPattern = "spill-*.ndjson"
+
+// spillPartitions is how many partitions a grace hash join or a spilled
+// aggregate splits its input into
+const spillPartitions = 16
+
+// SetQueryMemory sets the memory in bytes each query may hold in sorts, hash
+// tables and groups before spilling to temporary files; 0 restores the default
+func (db *Database) SetQueryMemory(bytes int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if bytes <= 0 {
+		bytes = DefaultQueryMemory
+	}
+	db.queryMemory = bytes
+}
+
+// QueryMemory returns the memory each query may hold before spilling
+func (db *Database) QueryMemory() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.queryMemory
+}
+
+// removeSpillFiles deletes spill files a crash left behind, leaving anything
+// else in the spill directory alone
+func removeSpillFiles(dataDir string) error {
+	files, err := filepath.Glob(filepath.Join(dataDir, spillDir, spillPattern))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryMemory is the memory budget of one query, shared by the operators
+// that hold rows: sorts, hash joins, nested loops and aggregates. Sizes are
+// estimates; see rowSize.
+type queryMemory struct {
+	limit int64
+	used  int64
+	dir   string // Where spill files go
+}
+
+// newQueryMemory returns a budget for one query (caller holds db.mu)
+func (db *Database) newQueryMemory() *queryMemory {
+	return &queryMemory{limit: db.queryMemory, dir: filepath.Join(db.dataDir, spillDir)}
+}
+
+// grow adds bytes an operator now holds and reports whether the query is
+// still within its budget. An operator over budget spills what it holds.
+func (m *queryMemory) grow(bytes int64) bool {
+	m.used += bytes
+	return m.used <= m.limit
+}
+
+// shrink gives back bytes an operator no longer holds
+func (m *queryMemory) shrink(bytes int64) {
+	m.used -= bytes
+}
+
+// spill creates a spill file, counting it in an operator's node, if any
+func (m *queryMemory) spill(node *PlanNode) (*spillFile, error) {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(m.dir, spillPattern)
+	if err != nil {
+		return nil, err
+	}
+	if node != nil {
+		node.SpillFiles++
+	}
+	w := bufio.NewWriter(file)
+	return &spillFile{file: file, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// spillFile holds values an operator moved out of memory, one JSON document
+// per line, until it is removed
+type spillFile struct {
+	file *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+// write appends a value
+func (s *spillFile) write(v interface{}) error {
+	return s.enc.Encode(v)
+}
+
+// reader flushes what was written and returns a reader from the start of the
+// file; each call starts over
+func (s *spillFile) reader() (*spillReader, error) {
+	if err := s.w.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &spillReader{dec: json.NewDecoder(bufio.NewReader(s.file))}, nil
+}
+
+// remove closes and deletes the file
+func (s *spillFile) remove() error {
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// spillReader reads back the values of a spill file in the order written
+type spillReader struct {
+	dec *json.Decoder
+}
+
+// next decodes the next value into v, returning false at the end of the file
+func (r *spillReader) next(v interface{}) (bool, error) {
+	err := r.dec.Decode(v)
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// removeAll deletes spill files, returning the first error
+func removeAll(files []*spillFile) error {
+	var first error
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		if err := f.remove(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// rowSize estimates the memory a row takes: the map, and for each column an
+// entry, its name and, for text, its characters
+func rowSize(row storage.Row) int64 {
+	size := int64(48)
+	for col, val := range row {
+		size += 32 + int64(len(col))
+		if s, ok := val.(string); ok {
+			size += int64(len(s))
+		}
+	}
+	return size
+}
+
+// partitionOf assigns an encoded key (see index.EncodeKey) to one of the
+// spill partitions by its hash, so that equal values of any type land in the
+// same one
+func partitionOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % spillPartitions)
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"rdbms/eventlog"
+	"rdbms/index"
+	"rdbms/storage"
+)
+
+// statisticsFile holds the statistics ANALYZE collected, keyed by table
+const statisticsFile = "statistics.json"
+
+// histogramBuckets is how many buckets ANALYZE divides a column's values into
+const histogramBuckets = 10
+
+// TableStats summarizes a table for the planner's cost model. ANALYZE collects
+// it from the table's rows; writes keep the row count current and widen the
+// column ranges, while distinct counts and histograms stay as ANALYZE found
+// them until it runs again.
+type TableStats struct {
+	RowCount   int64                   `json:"row_count"`
+	AnalyzedAt uint64                  `json:"analyzed_at"` // Last event ANALYZE saw
+	Changes    int64                   `json:"changes"`     // Rows written since
+	Columns    map[string]*ColumnStats `json:"columns"`
+}
+
+// ColumnStats summarizes one column's values
+type ColumnStats struct {
+	// Distinct non-NULL values. A negative number is a fraction of the row
+	// count instead, for columns whose values grow with the table, so the
+	// estimate follows the row count between ANALYZEs.
+	Distinct     float64 `json:"distinct"`
+	NullFraction float64 `json:"null_fraction"`
+
+	Min interface{} `json:"min,omitempty"`
+	Max interface{} `json:"max,omitempty"`
+
+	// Bounds of equal-depth buckets: each of the len-1 buckets holds about as
+	// many rows as the next, so dense ranges of values get narrow buckets
+	Histogram []interface{} `json:"histogram,omitempty"`
+}
+
+// Analyze collects statistics for a table, or for every table when tableName
+// is empty, and saves them to disk
+func (db *Database) Analyze(tableName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tables := []string{tableName}
+	if tableName == "" {
+		tables = tables[:0]
+		for name := range db.catalog.GetAllTables() {
+			tables = append(tables, name)
+		}
+		sort.Strings(tables)
+	} else if !db.catalog.TableExists(tableName) {
+		return fmt.Errorf("table '%s' does not exist", tableName)
+	}
+
+	state, err := db.queryEngine.GetCurrentState()
+	if err != nil {
+		return err
+	}
+	for _, name := range tables {
+		table, err := db.catalog.GetTable(name)
+		if err != nil {
+			return err
+		}
+		rows := state.GetTableRows(name)
+		ts := &TableStats{
+			RowCount:   int64(len(rows)),
+			AnalyzedAt: db.eventStore.GetLastEventID(),
+			Columns:    make(map[string]*ColumnStats, len(table.Columns)),
+		}
+		for _, col := range table.Columns {
+			ts.Columns[col.Name] = analyzeColumn(rows, col.Name)
+		}
+		db.stats[name] = ts
+	}
+	return db.saveStatistics()
+}
+
+// analyzeColumn computes the statistics of one column
+func analyzeColumn(rows []storage.RowWithID, column string) *ColumnStats {
+	cs := &ColumnStats{}
+	var values []interface{}
+	distinct := make(map[string]bool)
+	for _, r := range rows {
+		val := r.Row[column]
+		if val == nil {
+			continue
+		}
+		values = append(values, val)
+		distinct[index.EncodeKey(val)] = true
+	}
+	if len(rows) == 0 {
+		return cs
+	}
+	cs.NullFraction = float64(len(rows)-len(values)) / float64(len(rows))
+	if len(values) == 0 {
+		return cs
+	}
+
+	sort.Slice(values, func(i, j int) bool { return index.Compare(values[i], values[j]) < 0 })
+	cs.Min, cs.Max = values[0], values[len(values)-1]
+
+	// Like PostgreSQL, assume a column with many distinct values gains more
+	// as rows are added
+	cs.Distinct = float64(len(distinct))
+	if cs.Distinct > 0.1*float64(len(rows)) {
+		cs.Distinct = -cs.Distinct / float64(len(rows))
+	}
+
+	buckets := histogramBuckets
+	if len(values)-1 < buckets {
+		buckets = len(values) - 1
+	}
+	for i := 0; i <= buckets && buckets > 0; i++ {
+		cs.Histogram = append(cs.Histogram, values[i*(len(values)-1)/buckets])
+	}
+	return cs
+}
+
+// Statistics returns a copy of the statistics ANALYZE collected for a table
+func (db *Database) Statistics(tableName string) (TableStats, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ts, exists := db.stats[tableName]
+	if !exists {
+		return TableStats{}, false
+	}
+	copied := *ts
+	copied.Columns = make(map[string]*ColumnStats, len(ts.Columns))
+	for name, cs := range ts.Columns {
+		c := *cs
+		copied.Columns[name] = &c
+	}
+	return copied, true
+}
+
+// noteInsert counts an inserted row in its table's statistics (caller holds db.mu)
+func (db *Database) noteInsert(tableName string, row storage.Row) {
+	if ts, exists := db.stats[tableName]; exists {
+		ts.RowCount++
+		ts.Changes++
+		ts.widen(row)
+	}
+}
+
+// noteUpdate counts an updated row in its table's statistics (caller holds db.mu)
+func (db *Database) noteUpdate(tableName string, row storage.Row) {
+	if ts, exists := db.stats[tableName]; exists {
+		ts.Changes++
+		ts.widen(row)
+	}
+}
+
+// noteDelete counts a deleted row in its table's statistics (caller holds db.mu)
+func (db *Database) noteDelete(tableName string) {
+	if ts, exists := db.stats[tableName]; exists && ts.RowCount > 0 {
+		ts.RowCount--
+		ts.Changes++
+	}
+}
+
+// forgetColumnStats drops a table's column statistics after a schema change
+// that may have renamed or converted its columns; the row count stays
+func (db *Database) forgetColumnStats(tableName string) {
+	if ts, exists := db.stats[tableName]; exists {
+		ts.Columns = make(map[string]*ColumnStats)
+	}
+}
+
+// widen extends the column ranges to cover a written row
+func (ts *TableStats) widen(row storage.Row) {
+	for name, val := range row {
+		cs, exists := ts.Columns[name]
+		if !exists || val == nil || cs.Min == nil {
+			continue
+		}
+		if index.Compare(val, cs.Min) < 0 {
+			cs.Min = val
+		}
+		if index.Compare(val, cs.Max) > 0 {
+			cs.Max = val
+		}
+	}
+}
+
+// loadStatistics reads the saved statistics. They only guide the planner, so
+// a missing or unreadable file leaves every table without statistics. Writes
+// after the file was saved are lost if the process crashed, so the row counts
+// are taken from the state the log replays to instead.
+func (db *Database) loadStatistics() {
+	db.stats = make(map[string]*TableStats)
+	data, err := os.ReadFile(filepath.Join(db.dataDir, statisticsFile))
+	if err != nil {
+		return
+	}
+	var stats map[string]*TableStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return
+	}
+	for name, ts := range stats {
+		if db.catalog.TableExists(name) && ts.Columns != nil {
+			db.stats[name] = ts
+		}
+	}
+	if len(db.stats) == 0 {
+		return
+	}
+	state, err := db.queryEngine.GetCurrentState()
+	if err != nil {
+		return
+	}
+	for name, ts := range db.stats {
+		ts.RowCount = int64(len(state.TableRowIDs(name)))
+	}
+}
+
+// saveStatistics writes the statistics to disk (caller holds db.mu)
+func (db *Database) saveStatistics() error {
+	data, err := json.MarshalIndent(db.stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return eventlog.WriteFileAtomic(filepath.Join(db.dataDir, statisticsFile), data)
+}
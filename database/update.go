@@ -28,51 +28,61 @@ func (db *Database) Update(tableName string, setColumn string, setValue interfac
 	}
 
 	// Find rows matching WHERE clause
+	plan, err := db.planSelect(tableName, where, nil, 0)
+	if err != nil {
+		return 0, err
+	}
 	rows := state.GetTableRows(tableName)
 
-	count := 0
-	txID := fmt.Sprintf("tx_%d", db.eventStore.GetLastEventID())
-
+	// Check every matched row before writing any, so that a row that fails
+	// leaves the table and its indexes as they were
+	type rowUpdate struct {
+		old    storage.RowWithID
+		newRow storage.Row
+	}
+	var updates []rowUpdate
+	claimed := make(map[string]bool)
 	for _, r := range rows {
-		if val, exists := r.Row[where.Column]; exists && valuesEqual(val, where.Value) {
-			// Remove old from indexes
-			for colName, idx := range db.indexes[tableName] {
-				if colVal, exists := r.Row[colName]; exists {
-					idx.Remove(colVal, r.ID)
-				}
-			}
-
-			// Create new row with updated column
-			newRow := make(storage.Row)
-			for k, v := range r.Row {
-				newRow[k] = v
-			}
-
-			oldValue := r.Row[setColumn]
-			newRow[setColumn] = setValue
+		if !plan.matches(r) {
+			continue
+		}
+		// Create new row with updated column
+		newRow := make(storage.Row)
+		for k, v := range r.Row {
+			newRow[k] = v
+		}
+		newRow[setColumn] = setValue
 
-			if err := db.validateRow(table, newRow); err != nil {
-				return count, err
-			}
+		if err := db.validateRow(table, newRow); err != nil {
+			return 0, err
+		}
+		if err := db.checkUniqueIndexes(tableName, newRow, &r, claimed); err != nil {
+			return 0, err
+		}
+		updates = append(updates, rowUpdate{old: r, newRow: newRow})
+	}
 
-			// Record the update event
-			changes := map[string]interface{}{setColumn: setValue}
-			oldValues := map[string]interface{}{setColumn: oldValue}
+	count := 0
+	txID := fmt.Sprintf("tx_%d", db.eventStore.GetLastEventID())
 
-			_, err := db.eventStore.RecordRowUpdated(tableName, r.ID, changes, oldValues, txID)
-			if err != nil {
-				return count, err
-			}
+	for _, u := range updates {
+		// Record the update event
+		changes := map[string]interface{}{setColumn: setValue}
+		oldValues := map[string]interface{}{setColumn: u.old.Row[setColumn]}
 
-			// Add new to indexes
-			for colName, idx := range db.indexes[tableName] {
-				if colVal, exists := newRow[colName]; exists {
-					idx.Add(colVal, r.ID)
-				}
-			}
+		_, err := db.eventStore.RecordRowUpdated(tableName, u.old.ID, changes, oldValues, txID)
+		if err != nil {
+			return count, err
+		}
 
-			count++
+		// Move the row from its old keys to its new ones
+		for _, ti := range db.indexes[tableName] {
+			ti.remove(u.old.Row, u.old.ID)
+			ti.add(u.newRow, u.old.ID)
 		}
+		db.noteUpdate(tableName, u.newRow)
+
+		count++
 	}
 
 	// Invalidate query cache
@@ -0,0 +1,187 @@
+package eventlog
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultCheckpointInterval is how many events are appended between signed checkpoints
+const DefaultCheckpointInterval uint64 = 100
+
+// CheckpointVersion is the format new checkpoints are written in. Version 1
+// signs only the chain head; version 2 adds the Merkle tree size and root.
+// Checkpoints written before the format was recorded carry no version and are
+// told apart by whether they have a tree size.
+const CheckpointVersion = 2
+
+// Checkpoint is a signed statement of the chain head at a given event.
+// Because each event's checksum covers its predecessor's, a valid checkpoint
+// vouches for every event up to and including EventID. The Merkle root lets
+// auditors check inclusion and consistency proofs against signed state.
+type Checkpoint struct {
+	Version    int       `json:"version,omitempty"` // Format, see CheckpointVersion
+	EventID    uint64    `json:"event_id"`          // Last event covered by the checkpoint
+	ChainHead  string    `json:"chain_head"`        // Checksum of event EventID
+	TreeSize   uint64    `json:"tree_size"`         // Number of leaves in the Merkle tree (version 2)
+	MerkleRoot string    `json:"merkle_root"`       // Root over the first TreeSize event checksums (version 2)
+	CreatedAt  time.Time `json:"created_at"`
+	Signature  string    `json:"signature"` // Hex ed25519 signature over the fields above
+}
+
+// FormatVersion returns the checkpoint's format, inferring it for checkpoints
+// written before the version was recorded
+func (cp *Checkpoint) FormatVersion() int {
+	switch {
+	case cp.Version > 0:
+		return cp.Version
+	case cp.TreeSize > 0:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// signingMessage returns the bytes covered by a checkpoint signature
+func (cp *Checkpoint) signingMessage() []byte {
+	createdAt := cp.CreatedAt.UTC().Format(time.RFC3339Nano)
+	if cp.FormatVersion() == 1 {
+		return []byte(fmt.Sprintf("%d:%s:%s", cp.EventID, cp.ChainHead, createdAt))
+	}
+	return []byte(fmt.Sprintf("%d:%s:%d:%s:%s", cp.EventID, cp.ChainHead, cp.TreeSize, cp.MerkleRoot, createdAt))
+}
+
+// VerifyCheckpoint checks a checkpoint signature against a public key, in
+// whichever format the checkpoint was written
+func VerifyCheckpoint(cp *Checkpoint, publicKey ed25519.PublicKey) error {
+	sig, err := hex.DecodeString(cp.Signature)
+	if err != nil {
+		return fmt.Errorf("checkpoint %d: malformed signature: %v", cp.EventID, err)
+	}
+	if version := cp.FormatVersion(); version > CheckpointVersion {
+		return fmt.Errorf("checkpoint %d: unsupported format version %d", cp.EventID, version)
+	}
+	if !ed25519.Verify(publicKey, cp.signingMessage(), sig) {
+		return fmt.Errorf("checkpoint %d: signature verification failed", cp.EventID)
+	}
+	return nil
+}
+
+// loadOrCreateSigningKey reads the local ed25519 key, generating one on first use.
+// The file holds the hex-encoded private key seed and is readable only by the owner.
+func loadOrCreateSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(string(data))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid signing key in %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SetCheckpointInterval changes how often checkpoints are written (0 disables automatic checkpoints)
+func (l *Log) SetCheckpointInterval(interval uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.checkpointInterval = interval
+}
+
+// PublicKey returns the key used to verify this log's checkpoints
+func (l *Log) PublicKey() ed25519.PublicKey {
+	return l.signingKey.Public().(ed25519.PublicKey)
+}
+
+// Checkpoint signs and records the current chain head
+func (l *Log) Checkpoint() (*Checkpoint, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writeCheckpointLocked()
+}
+
+// writeCheckpointLocked appends a checkpoint for the current head (caller holds l.mu)
+func (l *Log) writeCheckpointLocked() (*Checkpoint, error) {
+	if l.currentID <= 1 {
+		return nil, fmt.Errorf("cannot checkpoint an empty log")
+	}
+
+	cp := &Checkpoint{
+		Version:    CheckpointVersion,
+		EventID:    l.currentID - 1,
+		ChainHead:  l.lastChecksum,
+		TreeSize:   uint64(len(l.leaves)),
+		MerkleRoot: l.currentRootLocked(),
+		CreatedAt:  time.Now().UTC(),
+	}
+	cp.Signature = hex.EncodeToString(ed25519.Sign(l.signingKey, cp.signingMessage()))
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(l.checkpointPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+// Checkpoints returns all recorded checkpoints in the order they were written
+func (l *Log) Checkpoints() ([]Checkpoint, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.readCheckpointsLocked()
+}
+
+// readCheckpointsLocked reads the checkpoint file (caller holds l.mu)
+func (l *Log) readCheckpointsLocked() ([]Checkpoint, error) {
+	f, err := os.Open(l.checkpointPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var checkpoints []Checkpoint
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var cp Checkpoint
+		if err := json.Unmarshal(scanner.Bytes(), &cp); err != nil {
+			return checkpoints, fmt.Errorf("malformed checkpoint: %v", err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, scanner.Err()
+}
@@ -0,0 +1,223 @@
+package eventlog
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrCompacted is returned when a read needs events that were folded into the base segment
+var ErrCompacted = errors.New("events have been compacted")
+
+// BaseSegment holds the consolidated form of all events up to Boundary.
+//
+// Compaction replaces the prefix of the log with synthetic events that
+// reproduce the state at Boundary (schema events plus one insert per
+// surviving row). The checksums of the folded events are kept so the hash
+// chain, checkpoints and Merkle proofs remain verifiable across the boundary.
+// The synthetic events are outside the chain, so the segment is signed with
+// the log key instead.
+type BaseSegment struct {
+	Boundary        uint64    `json:"boundary"`      // Last event folded into the segment
+	BoundaryHash    string    `json:"boundary_hash"` // Checksum of event Boundary (anchors the retained chain)
+	CreatedAt       time.Time `json:"created_at"`
+	FoldedChecksums []string  `json:"folded_checksums"`    // Checksums of events 1..Boundary, in order
+	Events          []*Event  `json:"events"`              // Synthetic events reproducing the state at Boundary
+	Signature       string    `json:"signature,omitempty"` // Hex ed25519 signature over digest (empty before segments were signed)
+}
+
+// digest hashes everything the segment vouches for: the boundary, the folded
+// checksums and the checksums of the synthetic events, in order
+func (b *BaseSegment) digest() []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s:%s\n", b.Boundary, b.BoundaryHash, b.CreatedAt.UTC().Format(time.RFC3339Nano))
+	for _, checksum := range b.FoldedChecksums {
+		fmt.Fprintf(h, "%s\n", checksum)
+	}
+	fmt.Fprintf(h, "%d\n", len(b.Events))
+	for _, e := range b.Events {
+		fmt.Fprintf(h, "%s\n", e.Checksum)
+	}
+	return h.Sum(nil)
+}
+
+// verify checks every synthetic event's checksum and the segment signature
+func (b *BaseSegment) verify(publicKey ed25519.PublicKey) []ChainIssue {
+	report := &ChainReport{}
+	for _, e := range b.Events {
+		if valid, _ := validateEventChecksum(e); !valid {
+			report.add(b.Boundary, ChainChecksumMismatch, "synthetic event for compaction boundary %d checksum validation failed", b.Boundary)
+		}
+	}
+
+	if b.Signature == "" {
+		report.add(b.Boundary, ChainUnsignedBase, "base segment at boundary %d is not signed", b.Boundary)
+		return report.Issues
+	}
+	sig, err := hex.DecodeString(b.Signature)
+	if err != nil || !ed25519.Verify(publicKey, b.digest(), sig) {
+		report.add(b.Boundary, ChainBadBase, "base segment at boundary %d does not match its signature", b.Boundary)
+	}
+	return report.Issues
+}
+
+// loadBaseSegment reads the base segment, returning nil if the log was never compacted
+func loadBaseSegment(path string) (*BaseSegment, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var base BaseSegment
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("corrupt base segment %s: %v", path, err)
+	}
+	if uint64(len(base.FoldedChecksums)) != base.Boundary {
+		return nil, fmt.Errorf("corrupt base segment %s: %d checksums for boundary %d", path, len(base.FoldedChecksums), base.Boundary)
+	}
+	return &base, nil
+}
+
+// WriteFileAtomic replaces a file by writing and syncing a temporary file and
+// renaming it over the original, so a crash leaves either version whole
+func WriteFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// CompactionBoundary returns the last event folded into the base segment (0 if none)
+func (l *Log) CompactionBoundary() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.base == nil {
+		return 0
+	}
+	return l.base.Boundary
+}
+
+// VerifyBaseSegment checks the base segment's signature and synthetic events,
+// returning nothing if they verify or the log was never compacted
+func (l *Log) VerifyBaseSegment() []ChainIssue {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.base == nil {
+		return nil
+	}
+	return l.base.verify(l.PublicKey())
+}
+
+// Compact folds every event up to boundary into a base segment made of the given
+// synthetic events, and rewrites the log so that it only holds later events.
+//
+// Synthetic events are stamped with the boundary ID and marked Synthetic; they
+// are not part of the hash chain or the Merkle tree. Events after the boundary
+// are left untouched, so their IDs, checksums and chain links do not change.
+//
+// The base segment is written before the log is rewritten. If the process dies
+// in between, the next open ignores log events already covered by the segment.
+func (l *Log) Compact(boundary uint64, synthetic []*Event) (*BaseSegment, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if boundary == 0 || boundary >= l.currentID {
+		return nil, fmt.Errorf("compaction boundary %d outside log (last event %d)", boundary, l.currentID-1)
+	}
+	if l.base != nil && boundary <= l.base.Boundary {
+		return nil, fmt.Errorf("events up to %d are already compacted", l.base.Boundary)
+	}
+
+	events, decodeErr, err := l.readRawLocked()
+	if err != nil {
+		return nil, err
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("refusing to compact a damaged log: %v", decodeErr)
+	}
+
+	base := &BaseSegment{
+		Boundary:        boundary,
+		BoundaryHash:    l.leaves[boundary-1],
+		CreatedAt:       time.Now().UTC(),
+		FoldedChecksums: append([]string(nil), l.leaves[:boundary]...),
+		Events:          make([]*Event, 0, len(synthetic)),
+	}
+	for _, e := range synthetic {
+		folded := *e
+		folded.ID = boundary
+		folded.Synthetic = true
+		folded.PrevHash = ""
+		folded.Checksum, err = ComputeChecksum(&folded)
+		if err != nil {
+			return nil, err
+		}
+		base.Events = append(base.Events, &folded)
+	}
+	base.Signature = hex.EncodeToString(ed25519.Sign(l.signingKey, base.digest()))
+
+	data, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteFileAtomic(l.basePath, data); err != nil {
+		return nil, err
+	}
+
+	// Rewrite the log with only the retained events
+	var retained []byte
+	for _, e := range events {
+		if e.ID <= boundary {
+			continue
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		retained = append(retained, line...)
+		retained = append(retained, '\n')
+	}
+
+	if err := l.file.Close(); err != nil {
+		return nil, err
+	}
+	if err := WriteFileAtomic(l.filePath, retained); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(l.filePath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+
+	// Keep the in-memory segment identical to what a later open would load
+	var loaded BaseSegment
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, err
+	}
+	l.base = &loaded
+	l.baseIssues = loaded.verify(l.PublicKey())
+
+	return l.base, nil
+}
@@ -7,6 +7,17 @@
 // Key Features:
 //   - Append-Only: Events are never modified, only appended
 //   - Integrity Checking: SHA256 checksums verify event integrity
+//   - Hash Chain: Each event's checksum covers the previous event's checksum,
+//     so rewriting, deleting or reordering events breaks the chain
+//   - Signed Checkpoints: The chain head is periodically signed with a local
+//     ed25519 key (<log>.key) and recorded in <log>.checkpoints; version 2
+//     checkpoints also sign the Merkle root, and version 1 ones still verify
+//   - Merkle Proofs: An RFC 6962 Merkle tree over event checksums supports
+//     inclusion proofs for single events and consistency proofs between roots
+//   - Compaction: Events up to a boundary can be folded into a base segment
+//     (<log>.base) of synthetic events; the folded checksums are kept so the
+//     chain, checkpoints and proofs still verify across the boundary, and the
+//     segment is signed with the log key
 //   - Atomic Writes: Events are written atomically with fsync for durability
 //   - Batch Operations: Supports batch appends for transaction grouping
 //   - Corruption Detection: Can detect and report corrupted events
@@ -25,6 +36,7 @@
 //   - Validating event checksums on read
 //   - Managing event IDs (monotonic, sequential)
 //   - Detecting and reporting corruption
+//   - Verifying the hash chain and checkpoints (VerifyChain)
 //
 // Usage Example:
 //
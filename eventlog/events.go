@@ -20,6 +20,10 @@ const (
 	SchemaEvolved EventType = "SCHEMA_EVOLVED"
 	// SnapshotCreated: A snapshot of current state was created
 	SnapshotCreated EventType = "SNAPSHOT_CREATED"
+	// IndexCreated: A secondary index was defined on a table
+	IndexCreated EventType = "INDEX_CREATED"
+	// IndexDropped: A secondary index was removed
+	IndexDropped EventType = "INDEX_DROPPED"
 )
 
 // Event represents an immutable database event
@@ -28,16 +32,20 @@ type Event struct {
 	ID        uint64    `json:"id"`        // Sequential event ID (monotonic, 1-indexed)
 	Type      EventType `json:"type"`      // Event type
 	Timestamp time.Time `json:"timestamp"` // When event occurred
-	Version   int       `json:"version"`   // Schema version this event targets
+	Version   int       `json:"version"`   // Schema version of the event's table (0 if not tied to a table)
 
 	// Transaction metadata for grouping related operations
 	TxID string `json:"tx_id,omitempty"` // Transaction ID (UUID)
 
+	// Synthetic events are produced by compaction to stand in for folded history
+	Synthetic bool `json:"synthetic,omitempty"`
+
 	// Payload - varies by event type
 	Payload EventPayload `json:"payload"`
 
 	// Data integrity
-	Checksum string `json:"checksum"` // SHA256 of the event (excluding checksum field)
+	PrevHash string `json:"prev_hash,omitempty"` // Checksum of the preceding event (hash chain link)
+	Checksum string `json:"checksum"`            // SHA256 of the event (excluding checksum field)
 }
 
 // EventPayload is a generic container for event-specific data
@@ -48,6 +56,9 @@ type SchemaCreatedPayload struct {
 	TableName  string             `json:"table_name"`
 	Columns    []ColumnDefinition `json:"columns"`
 	PrimaryKey string             `json:"primary_key,omitempty"`
+
+	// Columns of a composite primary key, in key order
+	PrimaryKeyColumns []string `json:"primary_key_columns,omitempty"`
 }
 
 // ColumnDefinition represents a column in a table
@@ -89,14 +100,23 @@ type SchemaEvolvedPayload struct {
 	Evolution SchemaEvolution    `json:"evolution"`
 	OldSchema []ColumnDefinition `json:"old_schema"`
 	NewSchema []ColumnDefinition `json:"new_schema"`
+
+	// Set when the evolution was applied from a migration file
+	Migration string `json:"migration,omitempty"` // Migration ID, e.g. "0002_add_email"
+	Direction string `json:"direction,omitempty"` // "up" or "down"
 }
 
-// SchemaEvolution describes what changed in the schema
+// SchemaEvolution describes what changed in the schema. Replaying it as a
+// migration applies renames, then modifications, then removals, then additions.
 type SchemaEvolution struct {
 	AddedColumns    []ColumnDefinition   `json:"added_columns,omitempty"`
 	RemovedColumns  []string             `json:"removed_columns,omitempty"` // Column names
 	ModifiedColumns []ColumnModification `json:"modified_columns,omitempty"`
 	RenamedColumns  map[string]string    `json:"renamed_columns,omitempty"` // old name -> new name
+
+	// How values that fail a type conversion are handled ("error", "null", "default", "dead_letter")
+	OnConversionFailure string      `json:"on_conversion_failure,omitempty"`
+	ConversionDefault   interface{} `json:"conversion_default,omitempty"`
 }
 
 // ColumnModification describes changes to a column
@@ -106,6 +126,24 @@ type ColumnModification struct {
 	NewDef ColumnDefinition `json:"new_definition"`
 }
 
+// IndexCreatedPayload - when INDEX_CREATED event occurs
+type IndexCreatedPayload struct {
+	TableName string   `json:"table_name"`
+	IndexName string   `json:"index_name"`
+	Columns   []string `json:"columns"`
+	Unique    bool     `json:"unique,omitempty"`
+	Method    string   `json:"method"` // HASH, BTREE or FULLTEXT
+	Stemming  bool     `json:"stemming,omitempty"`
+	Stopwords bool     `json:"stopwords,omitempty"`
+	Where     string   `json:"where,omitempty"` // Predicate of a partial index
+}
+
+// IndexDroppedPayload - when INDEX_DROPPED event occurs
+type IndexDroppedPayload struct {
+	TableName string `json:"table_name"`
+	IndexName string `json:"index_name"`
+}
+
 // SnapshotCreatedPayload - when SNAPSHOT_CREATED event occurs
 type SnapshotCreatedPayload struct {
 	SnapshotID     string    `json:"snapshot_id"`   // UUID
@@ -114,6 +152,15 @@ type SnapshotCreatedPayload struct {
 	SnapshotPath   string    `json:"snapshot_path"`   // Relative path to snapshot file
 	DataHash       string    `json:"data_hash"`       // Hash of entire snapshot state
 	EventsIncluded int64     `json:"events_included"` // Number of events replayed
+
+	Kind             string `json:"kind,omitempty"`        // "full" or "delta"
+	ParentID         string `json:"parent_id,omitempty"`   // Snapshot a delta applies to
+	ChainDepth       int    `json:"chain_depth,omitempty"` // Deltas since the last full snapshot
+	MerkleRoot       string `json:"merkle_root,omitempty"` // Root over events 1..BaseEventID
+	Format           string `json:"format,omitempty"`
+	Compression      string `json:"compression,omitempty"`
+	CompressedSize   int64  `json:"compressed_size,omitempty"`
+	UncompressedSize int64  `json:"uncompressed_size,omitempty"`
 }
 
 // EventError wraps an event that failed to process
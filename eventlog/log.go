@@ -1,23 +1,40 @@
 package eventlog
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
 // Log manages the append-only event log
 type Log struct {
-	mu          sync.RWMutex
-	filePath    string
-	currentID   uint64 // Next event ID to assign
-	file        *os.File
-	initialized bool
+	mu           sync.RWMutex
+	filePath     string
+	currentID    uint64 // Next event ID to assign
+	lastChecksum string // Checksum of the last appended event (chain head)
+	file         *os.File
+	initialized  bool
+
+	// Compacted prefix of the log (nil if never compacted)
+	basePath   string
+	base       *BaseSegment
+	baseIssues []ChainIssue // Problems found verifying base when it was loaded
+
+	// Merkle tree over event checksums
+	leaves   []string // Event checksums in log order
+	frontier [][]byte // Roots of the perfect subtrees covering all leaves
+
+	// Signed checkpoints of the chain head
+	checkpointPath     string
+	checkpointInterval uint64 // Write a checkpoint every N events (0 disables)
+	signingKey         ed25519.PrivateKey
 }
 
 // NewLog creates a new event log
@@ -27,7 +44,20 @@ func NewLog(dataDir string, filename string) (*Log, error) {
 	}
 
 	filePath := filepath.Join(dataDir, filename)
-	l := &Log{filePath: filePath}
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	key, err := loadOrCreateSigningKey(filepath.Join(dataDir, base+".key"))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		filePath:           filePath,
+		basePath:           filepath.Join(dataDir, base+".base"),
+		checkpointPath:     filepath.Join(dataDir, base+".checkpoints"),
+		checkpointInterval: DefaultCheckpointInterval,
+		signingKey:         key,
+	}
 
 	// Try to open existing log or create new one
 	if err := l.initialize(); err != nil {
@@ -42,8 +72,24 @@ func (l *Log) initialize() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	// Load the compacted base segment; its folded events precede the log file
+	baseSeg, err := loadBaseSegment(l.basePath)
+	if err != nil {
+		return err
+	}
+	l.base = baseSeg
+	lastID := uint64(0)
+	if baseSeg != nil {
+		l.baseIssues = baseSeg.verify(l.PublicKey())
+		lastID = baseSeg.Boundary
+		l.lastChecksum = baseSeg.BoundaryHash
+		for _, checksum := range baseSeg.FoldedChecksums {
+			l.addLeafLocked(checksum)
+		}
+	}
+
 	// Check if log file exists
-	_, err := os.Stat(l.filePath)
+	_, err = os.Stat(l.filePath)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
@@ -55,7 +101,7 @@ func (l *Log) initialize() error {
 			return err
 		}
 		l.file = f
-		l.currentID = 1
+		l.currentID = lastID + 1
 		l.initialized = true
 		return nil
 	}
@@ -75,17 +121,22 @@ func (l *Log) initialize() error {
 	defer readFile.Close()
 
 	decoder := json.NewDecoder(readFile)
-	count := uint64(0)
 	for decoder.More() {
 		var e Event
 		if err := decoder.Decode(&e); err != nil {
 			// Stop at first decode error (corrupted entry)
 			break
 		}
-		count++
+		if e.ID <= lastID {
+			// Already folded into the base segment (compaction interrupted before the rewrite)
+			continue
+		}
+		lastID = e.ID
+		l.lastChecksum = e.Checksum
+		l.addLeafLocked(e.Checksum)
 	}
 
-	l.currentID = count + 1
+	l.currentID = lastID + 1
 	l.initialized = true
 	return nil
 }
@@ -108,9 +159,10 @@ func (l *Log) Append(eventType EventType, payload EventPayload, txID string, ver
 		TxID:      txID,
 		Version:   version,
 		Payload:   payload,
+		PrevHash:  l.lastChecksum,
 	}
 
-	// Compute checksum
+	// Compute checksum (covers PrevHash, linking this event to its predecessor)
 	checksum, err := computeEventChecksum(event)
 	if err != nil {
 		return nil, err
@@ -139,6 +191,13 @@ func (l *Log) Append(eventType EventType, payload EventPayload, txID string, ver
 
 	// Increment ID for next event
 	l.currentID++
+	l.lastChecksum = event.Checksum
+	l.addLeafLocked(event.Checksum)
+
+	// A failed checkpoint does not undo a durable append; the next one retries
+	if l.checkpointInterval > 0 && event.ID%l.checkpointInterval == 0 {
+		_, _ = l.writeCheckpointLocked()
+	}
 
 	return event, nil
 }
@@ -159,8 +218,10 @@ func (l *Log) AppendBatch(events []*Event) error {
 
 	// Prepare all events
 	data := make([][]byte, len(events))
+	prevHash := l.lastChecksum
 	for i, event := range events {
 		event.ID = l.currentID + uint64(i)
+		event.PrevHash = prevHash
 
 		// Compute checksum
 		checksum, err := computeEventChecksum(event)
@@ -176,6 +237,7 @@ func (l *Log) AppendBatch(events []*Event) error {
 		}
 
 		data[i] = append(jsonData, '\n')
+		prevHash = checksum
 	}
 
 	// Write all at once
@@ -191,7 +253,18 @@ func (l *Log) AppendBatch(events []*Event) error {
 		return err
 	}
 
+	for _, event := range events {
+		l.addLeafLocked(event.Checksum)
+	}
+
+	firstID := l.currentID
 	l.currentID += uint64(len(events))
+	l.lastChecksum = prevHash
+
+	// Checkpoint if the batch crossed an interval boundary
+	if l.checkpointInterval > 0 && (l.currentID-1)/l.checkpointInterval > (firstID-1)/l.checkpointInterval {
+		_, _ = l.writeCheckpointLocked()
+	}
 	return nil
 }
 
@@ -210,6 +283,28 @@ func (l *Log) Read() ([]*Event, []EventError) {
 	var events []*Event
 	var errors []EventError
 
+	// Synthetic events from the base segment stand in for the compacted prefix.
+	// A tampered segment is reported like a corrupted event; one written before
+	// segments were signed is still read.
+	folded := uint64(0)
+	if l.base != nil {
+		folded = l.base.Boundary
+		for _, issue := range l.baseIssues {
+			if issue.Kind != ChainUnsignedBase {
+				errors = append(errors, EventError{
+					EventID:   issue.EventID,
+					Error:     issue.Message,
+					Timestamp: time.Now(),
+				})
+			}
+		}
+		for _, e := range l.base.Events {
+			if valid, _ := validateEventChecksum(e); valid {
+				events = append(events, e)
+			}
+		}
+	}
+
 	decoder := json.NewDecoder(readFile)
 	for decoder.More() {
 		var e Event
@@ -220,6 +315,9 @@ func (l *Log) Read() ([]*Event, []EventError) {
 			})
 			break
 		}
+		if e.ID <= folded {
+			continue
+		}
 
 		// Validate checksum
 		if valid, err := validateEventChecksum(&e); !valid {
@@ -240,18 +338,33 @@ func (l *Log) Read() ([]*Event, []EventError) {
 }
 
 // ReadFrom returns events starting from eventID
-// Useful for reading after a snapshot
+// Useful for reading after a snapshot. Reading from the start of a compacted
+// log yields the base segment's synthetic events first; starting anywhere else
+// inside the compacted range fails with ErrCompacted, since the individual
+// events there no longer exist.
 func (l *Log) ReadFrom(startEventID uint64) ([]*Event, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
+	var events []*Event
+	folded := uint64(0)
+	if l.base != nil {
+		folded = l.base.Boundary
+		if startEventID > 1 && startEventID <= folded {
+			return nil, fmt.Errorf("%w: cannot read from event %d, events up to %d were folded into the base segment",
+				ErrCompacted, startEventID, folded)
+		}
+		if startEventID <= 1 {
+			events = append(events, l.base.Events...)
+		}
+	}
+
 	readFile, err := os.Open(l.filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer readFile.Close()
 
-	var events []*Event
 	decoder := json.NewDecoder(readFile)
 
 	for decoder.More() {
@@ -260,7 +373,7 @@ func (l *Log) ReadFrom(startEventID uint64) ([]*Event, error) {
 			break // Stop at corruption
 		}
 
-		if e.ID >= startEventID {
+		if e.ID >= startEventID && e.ID > folded {
 			events = append(events, &e)
 		}
 	}
@@ -286,14 +399,14 @@ func (l *Log) Close() error {
 	return nil
 }
 
-// computeEventChecksum computes SHA256 checksum of event (with checksum field temporarily cleared)
-func computeEventChecksum(event *Event) (string, error) {
-	// Temporarily clear checksum
-	originalChecksum := event.Checksum
-	event.Checksum = ""
-	defer func() { event.Checksum = originalChecksum }()
+// ComputeChecksum computes the SHA256 checksum of an event as written by Append.
+// The checksum field itself is excluded; PrevHash is included, so the checksum
+// of each event commits to the entire chain before it.
+func ComputeChecksum(event *Event) (string, error) {
+	unsigned := *event
+	unsigned.Checksum = ""
 
-	data, err := json.Marshal(event)
+	data, err := json.Marshal(&unsigned)
 	if err != nil {
 		return "", err
 	}
@@ -302,6 +415,11 @@ func computeEventChecksum(event *Event) (string, error) {
 	return hex.EncodeToString(hash[:]), nil
 }
 
+// computeEventChecksum computes SHA256 checksum of event (excluding checksum field)
+func computeEventChecksum(event *Event) (string, error) {
+	return ComputeChecksum(event)
+}
+
 // validateEventChecksum verifies event integrity
 func validateEventChecksum(event *Event) (bool, error) {
 	expected, err := computeEventChecksum(event)
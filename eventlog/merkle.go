@@ -0,0 +1,338 @@
+package eventlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// The Merkle tree follows RFC 6962: leaves are event checksums in log order,
+// leaf hashes are prefixed with 0x00 and interior nodes with 0x01 so that a
+// leaf can never be passed off as a subtree.
+
+// InclusionProof proves that an event is part of the log summarized by Root
+type InclusionProof struct {
+	EventID   uint64   `json:"event_id"`
+	Checksum  string   `json:"checksum"`   // The event's checksum (the leaf)
+	LeafIndex uint64   `json:"leaf_index"` // Zero-based position of the leaf
+	TreeSize  uint64   `json:"tree_size"`
+	Root      string   `json:"root"`
+	Path      []string `json:"path"` // Sibling hashes from the leaf up to the root
+}
+
+// ConsistencyProof proves that the log summarized by NewRoot is an append-only
+// extension of the log summarized by OldRoot
+type ConsistencyProof struct {
+	OldSize uint64   `json:"old_size"`
+	NewSize uint64   `json:"new_size"`
+	OldRoot string   `json:"old_root"`
+	NewRoot string   `json:"new_root"`
+	Path    []string `json:"path"`
+}
+
+// leafHash hashes an event checksum into a tree leaf
+func leafHash(checksum string) []byte {
+	data, err := hex.DecodeString(checksum)
+	if err != nil {
+		data = []byte(checksum)
+	}
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// nodeHash combines two subtree hashes
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of two smaller than n (n > 1)
+func splitPoint(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// treeHash computes the Merkle tree hash of a list of leaves
+func treeHash(leaves []string) []byte {
+	switch len(leaves) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leafHash(leaves[0])
+	}
+	k := splitPoint(uint64(len(leaves)))
+	return nodeHash(treeHash(leaves[:k]), treeHash(leaves[k:]))
+}
+
+// inclusionPath computes the audit path for leaf m
+func inclusionPath(m uint64, leaves []string) [][]byte {
+	n := uint64(len(leaves))
+	if n <= 1 {
+		return nil
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(inclusionPath(m, leaves[:k]), treeHash(leaves[k:]))
+	}
+	return append(inclusionPath(m-k, leaves[k:]), treeHash(leaves[:k]))
+}
+
+// consistencyPath computes the consistency proof between the first m leaves and all leaves
+func consistencyPath(m uint64, leaves []string, complete bool) [][]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{treeHash(leaves)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(consistencyPath(m, leaves[:k], complete), treeHash(leaves[k:]))
+	}
+	return append(consistencyPath(m-k, leaves[k:], false), treeHash(leaves[:k]))
+}
+
+// MerkleRoot returns the hex-encoded Merkle root over a list of event checksums
+func MerkleRoot(checksums []string) string {
+	return hex.EncodeToString(treeHash(checksums))
+}
+
+func encodeHashes(hashes [][]byte) []string {
+	out := make([]string, len(hashes))
+	for i, h := range hashes {
+		out[i] = hex.EncodeToString(h)
+	}
+	return out
+}
+
+func decodeHashes(hashes []string) ([][]byte, error) {
+	out := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("malformed proof hash %q", h)
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// VerifyInclusion checks an inclusion proof without access to the log
+func VerifyInclusion(proof *InclusionProof) error {
+	if proof.LeafIndex >= proof.TreeSize {
+		return fmt.Errorf("leaf index %d outside tree of size %d", proof.LeafIndex, proof.TreeSize)
+	}
+	path, err := decodeHashes(proof.Path)
+	if err != nil {
+		return err
+	}
+	root, err := hex.DecodeString(proof.Root)
+	if err != nil {
+		return fmt.Errorf("malformed root")
+	}
+
+	fn, sn := proof.LeafIndex, proof.TreeSize-1
+	r := leafHash(proof.Checksum)
+	for _, p := range path {
+		if sn == 0 {
+			return fmt.Errorf("inclusion proof is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return fmt.Errorf("event %d is not included in tree %s", proof.EventID, proof.Root)
+	}
+	return nil
+}
+
+// VerifyConsistency checks a consistency proof without access to the log
+func VerifyConsistency(proof *ConsistencyProof) error {
+	if proof.OldSize > proof.NewSize {
+		return fmt.Errorf("old tree size %d exceeds new tree size %d", proof.OldSize, proof.NewSize)
+	}
+	if proof.OldSize == proof.NewSize {
+		if len(proof.Path) != 0 || proof.OldRoot != proof.NewRoot {
+			return fmt.Errorf("trees of equal size must have equal roots and an empty proof")
+		}
+		return nil
+	}
+	if proof.OldSize == 0 {
+		// The empty tree is a prefix of every tree
+		return nil
+	}
+
+	path, err := decodeHashes(proof.Path)
+	if err != nil {
+		return err
+	}
+	oldRoot, err1 := hex.DecodeString(proof.OldRoot)
+	newRoot, err2 := hex.DecodeString(proof.NewRoot)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("malformed root")
+	}
+
+	// When the old tree is a complete subtree its root is implied
+	if proof.OldSize&(proof.OldSize-1) == 0 {
+		path = append([][]byte{oldRoot}, path...)
+	}
+	if len(path) == 0 {
+		return fmt.Errorf("consistency proof is empty")
+	}
+
+	fn, sn := proof.OldSize-1, proof.NewSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := path[0], path[0]
+	for _, c := range path[1:] {
+		if sn == 0 {
+			return fmt.Errorf("consistency proof is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, oldRoot) || !bytes.Equal(sr, newRoot) {
+		return fmt.Errorf("tree %s is not a prefix of tree %s", proof.OldRoot, proof.NewRoot)
+	}
+	return nil
+}
+
+// addLeafLocked records an appended event's checksum as a new leaf (caller holds l.mu).
+// The frontier keeps the roots of the perfect subtrees that make up the tree, so the
+// current root is available without rehashing the whole log.
+func (l *Log) addLeafLocked(checksum string) {
+	n := uint64(len(l.leaves))
+	l.leaves = append(l.leaves, checksum)
+	l.frontier = append(l.frontier, leafHash(checksum))
+	for ; n&1 == 1; n >>= 1 {
+		last := len(l.frontier) - 1
+		l.frontier = append(l.frontier[:last-1], nodeHash(l.frontier[last-1], l.frontier[last]))
+	}
+}
+
+// currentRootLocked folds the frontier into the root of the whole log (caller holds l.mu)
+func (l *Log) currentRootLocked() string {
+	if len(l.frontier) == 0 {
+		return MerkleRoot(nil)
+	}
+	root := l.frontier[len(l.frontier)-1]
+	for i := len(l.frontier) - 2; i >= 0; i-- {
+		root = nodeHash(l.frontier[i], root)
+	}
+	return hex.EncodeToString(root)
+}
+
+// MerkleRoot returns the root over the whole log and the number of leaves it covers
+func (l *Log) MerkleRoot() (string, uint64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.currentRootLocked(), uint64(len(l.leaves))
+}
+
+// MerkleRootAt returns the root over the first treeSize events
+func (l *Log) MerkleRootAt(treeSize uint64) (string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if treeSize > uint64(len(l.leaves)) {
+		return "", fmt.Errorf("tree size %d exceeds log size %d", treeSize, len(l.leaves))
+	}
+	return MerkleRoot(l.leaves[:treeSize]), nil
+}
+
+// InclusionProof proves that an event is part of the current log
+func (l *Log) InclusionProof(eventID uint64) (*InclusionProof, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// Event IDs are contiguous from 1, so the leaf index follows from the ID
+	if eventID == 0 || eventID > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("event %d not found in log", eventID)
+	}
+	index := eventID - 1
+
+	return &InclusionProof{
+		EventID:   eventID,
+		Checksum:  l.leaves[index],
+		LeafIndex: index,
+		TreeSize:  uint64(len(l.leaves)),
+		Root:      l.currentRootLocked(),
+		Path:      encodeHashes(inclusionPath(index, l.leaves)),
+	}, nil
+}
+
+// ConsistencyProof proves that the tree with root newRoot extends the tree with root oldRoot.
+// Both roots must be known to the log: either recorded in a checkpoint or the current root.
+func (l *Log) ConsistencyProof(oldRoot, newRoot string) (*ConsistencyProof, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	sizes := make(map[string]uint64)
+	checkpoints, _ := l.readCheckpointsLocked()
+	for _, cp := range checkpoints {
+		if cp.FormatVersion() >= 2 && cp.TreeSize <= uint64(len(l.leaves)) {
+			sizes[cp.MerkleRoot] = cp.TreeSize
+		}
+	}
+	sizes[l.currentRootLocked()] = uint64(len(l.leaves))
+
+	oldSize, ok := sizes[oldRoot]
+	if !ok {
+		return nil, fmt.Errorf("unknown Merkle root %s", oldRoot)
+	}
+	newSize, ok := sizes[newRoot]
+	if !ok {
+		return nil, fmt.Errorf("unknown Merkle root %s", newRoot)
+	}
+	if oldSize > newSize {
+		return nil, fmt.Errorf("root %s covers more events than %s", oldRoot, newRoot)
+	}
+
+	leaves := l.leaves[:newSize]
+	proof := &ConsistencyProof{
+		OldSize: oldSize,
+		NewSize: newSize,
+		OldRoot: MerkleRoot(leaves[:oldSize]),
+		NewRoot: MerkleRoot(leaves),
+		Path:    []string{},
+	}
+	if oldSize > 0 && oldSize < newSize {
+		proof.Path = encodeHashes(consistencyPath(oldSize, leaves, true))
+	}
+	return proof, nil
+}
@@ -2,6 +2,7 @@ package executor
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
 	"rdbms/database"
@@ -30,30 +31,86 @@ func New(db *database.Database) *Executor {
 // Execute executes a parsed statement
 func (e *Executor) Execute(stmt *parser.ParsedStatement) (string, error) {
 	switch stmt.Type {
+	case "SELECT", "JOIN":
+		var result strings.Builder
+		if err := e.executeQuery(&result, stmt); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(result.String()), nil
 	case "CREATE_TABLE":
 		return e.executeCreateTable(stmt)
+	case "CREATE_INDEX":
+		return e.executeCreateIndex(stmt)
+	case "DROP_INDEX":
+		return e.executeDropIndex(stmt)
 	case "INSERT":
 		return e.executeInsert(stmt)
-	case "SELECT":
-		return e.executeSelect(stmt)
 	case "DELETE":
 		return e.executeDelete(stmt)
 	case "UPDATE":
 		return e.executeUpdate(stmt)
-	case "JOIN":
-		return e.executeJoin(stmt)
+	case "ANALYZE":
+		return e.executeAnalyze(stmt)
+	case "EXPLAIN":
+		return e.executeExplain(stmt)
 	default:
 		return "", fmt.Errorf("unknown statement type: %s", stmt.Type)
 	}
 }
 
+// ExecuteTo executes a parsed statement and writes its result to w, one line
+// per row for a SELECT or JOIN as the rows are produced
+func (e *Executor) ExecuteTo(w io.Writer, stmt *parser.ParsedStatement) error {
+	if stmt.Type == "SELECT" || stmt.Type == "JOIN" {
+		return e.executeQuery(w, stmt)
+	}
+	result, err := e.Execute(stmt)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, result)
+	return err
+}
+
 func (e *Executor) executeCreateTable(stmt *parser.ParsedStatement) (string, error) {
-	if err := e.db.CreateTable(stmt.TableName, stmt.Columns); err != nil {
+	if err := e.db.CreateTableWithKey(stmt.TableName, stmt.Columns, stmt.PrimaryKey); err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("Table '%s' created", stmt.TableName), nil
 }
 
+func (e *Executor) executeCreateIndex(stmt *parser.ParsedStatement) (string, error) {
+	if err := e.db.CreateIndex(stmt.TableName, *stmt.Index); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Index '%s' created on '%s'", stmt.Index.Name, stmt.TableName), nil
+}
+
+func (e *Executor) executeDropIndex(stmt *parser.ParsedStatement) (string, error) {
+	if err := e.db.DropIndex(stmt.Index.Name); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Index '%s' dropped", stmt.Index.Name), nil
+}
+
+func (e *Executor) executeAnalyze(stmt *parser.ParsedStatement) (string, error) {
+	if err := e.db.Analyze(stmt.TableName); err != nil {
+		return "", err
+	}
+	if stmt.TableName == "" {
+		return "Analyzed all tables", nil
+	}
+	return fmt.Sprintf("Analyzed '%s'", stmt.TableName), nil
+}
+
+func (e *Executor) executeExplain(stmt *parser.ParsedStatement) (string, error) {
+	explanation, err := e.db.Explain(stmt.Explain, stmt.ExplainAnalyze)
+	if err != nil {
+		return "", err
+	}
+	return explanation.String(), nil
+}
+
 func (e *Executor) executeInsert(stmt *parser.ParsedStatement) (string, error) {
 	// Get raw values from parser
 	rawValues := stmt.Values["_raw_values"].([]interface{})
@@ -82,14 +139,6 @@ func (e *Executor) executeInsert(stmt *parser.ParsedStatement) (string, error) {
 	return fmt.Sprintf("Inserted row with ID %d", rowID), nil
 }
 
-func (e *Executor) executeSelect(stmt *parser.ParsedStatement) (string, error) {
-	rows, err := e.db.Select(stmt.TableName, stmt.Where)
-	if err != nil {
-		return "", err
-	}
-	return formatRows(rows), nil
-}
-
 func (e *Executor) executeDelete(stmt *parser.ParsedStatement) (string, error) {
 	count, err := e.db.Delete(stmt.TableName, stmt.Where)
 	if err != nil {
@@ -106,25 +155,28 @@ func (e *Executor) executeUpdate(stmt *parser.ParsedStatement) (string, error) {
 	return fmt.Sprintf("Updated %d row(s)", count), nil
 }
 
-// Format rows for display
-func formatRows(rows []storage.Row) string {
-	if len(rows) == 0 {
-		return "No rows returned"
+// executeQuery streams the rows of a SELECT or JOIN to w, one per line
+func (e *Executor) executeQuery(w io.Writer, stmt *parser.ParsedStatement) error {
+	rows, err := e.db.Query(stmt)
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
-	var result strings.Builder
-	for _, row := range rows {
-		result.WriteString(fmt.Sprintf("%v\n", row))
+	count := 0
+	for rows.Next() {
+		if _, err := fmt.Fprintf(w, "%v\n", rows.Row()); err != nil {
+			return err
+		}
+		count++
 	}
-	return strings.TrimSpace(result.String())
-}
-
-func (e *Executor) executeJoin(stmt *parser.ParsedStatement) (string, error) {
-	rows, err := e.db.Join(stmt.TableName, stmt.JoinTable, stmt.JoinCondition, stmt.Where)
-	if err != nil {
-		return "", err
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err = fmt.Fprintln(w, "No rows returned")
 	}
-	return formatRows(rows), nil
+	return err
 }
 
 // SetMigrationHandler sets the migration handler for schema transformations
@@ -132,8 +184,9 @@ func (e *Executor) SetMigrationHandler(mh *storage.MigrationHandler) {
 	e.migrationHandler = mh
 }
 
-// Replay replays all events deterministically from the event store
-func (e *Executor) Replay(targetSchemaVersion int) (*storage.ReplayResult, error) {
+// Replay replays all events deterministically from the event store, migrating
+// each table to its target version (its latest registered version if not listed)
+func (e *Executor) Replay(targets storage.SchemaTargets) (*storage.ReplayResult, error) {
 	// Get the event store from the database
 	eventStore := e.db.GetEventStore()
 	if eventStore == nil {
@@ -148,10 +201,10 @@ func (e *Executor) Replay(targetSchemaVersion int) (*storage.ReplayResult, error
 
 	// Perform deterministic replay
 	opts := &storage.DeterministicReplayOptions{
-		TargetSchemaVersion: targetSchemaVersion,
-		SkipCorrupted:       false,
-		CollectErrors:       true,
-		MigrationHandler:    e.migrationHandler,
+		TargetSchemaVersions: targets,
+		SkipCorrupted:        false,
+		CollectErrors:        true,
+		MigrationHandler:     e.migrationHandler,
 	}
 
 	result := storage.ReplayEventsDeterministic(events, opts, e.migrationHandler)
@@ -161,7 +214,7 @@ func (e *Executor) Replay(targetSchemaVersion int) (*storage.ReplayResult, error
 }
 
 // ReplayWithRecovery replays events with partial recovery for corrupted events
-func (e *Executor) ReplayWithRecovery(targetSchemaVersion int) (*storage.ReplayResult, error) {
+func (e *Executor) ReplayWithRecovery(targets storage.SchemaTargets) (*storage.ReplayResult, error) {
 	// Get the event store from the database
 	eventStore := e.db.GetEventStore()
 	if eventStore == nil {
@@ -181,10 +234,10 @@ func (e *Executor) ReplayWithRecovery(targetSchemaVersion int) (*storage.ReplayR
 	// If there's corruption and we can partially recover, do so
 	if corruptionReport.CorruptedEvents > 0 && corruptionReport.CanPartialReplay {
 		opts := &storage.DeterministicReplayOptions{
-			TargetSchemaVersion: targetSchemaVersion,
-			SkipCorrupted:       true, // Skip corrupted events
-			CollectErrors:       true,
-			MigrationHandler:    e.migrationHandler,
+			TargetSchemaVersions: targets,
+			SkipCorrupted:        true, // Skip corrupted events
+			CollectErrors:        true,
+			MigrationHandler:     e.migrationHandler,
 		}
 
 		result := storage.ReplayEventsDeterministic(events, opts, e.migrationHandler)
@@ -194,10 +247,10 @@ func (e *Executor) ReplayWithRecovery(targetSchemaVersion int) (*storage.ReplayR
 
 	// No corruption or can't partially recover, do full deterministic replay
 	opts := &storage.DeterministicReplayOptions{
-		TargetSchemaVersion: targetSchemaVersion,
-		SkipCorrupted:       false,
-		CollectErrors:       true,
-		MigrationHandler:    e.migrationHandler,
+		TargetSchemaVersions: targets,
+		SkipCorrupted:        false,
+		CollectErrors:        true,
+		MigrationHandler:     e.migrationHandler,
 	}
 
 	result := storage.ReplayEventsDeterministic(events, opts, e.migrationHandler)
@@ -233,10 +286,5 @@ func (e *Executor) ValidateEventIntegrity() *storage.CorruptionReport {
 		return nil
 	}
 
-	events, err := eventStore.ReadAllEvents()
-	if err != nil {
-		return nil
-	}
-
-	return storage.DetectCorruption(events, nil)
+	return eventStore.DetectCorruption()
 }
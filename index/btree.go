@@ -0,0 +1,455 @@
+package index
+
+import (
+	"sort"
+	"strings"
+
+	"rdbms/storage"
+)
+
+// degree is the B-tree's minimum degree: every node but the root holds between
+// degree-1 and 2*degree-1 keys
+const degree = 32
+
+// BTree is an ordered index. Keys are column values ordered by Compare, so
+// numbers sort numerically rather than as text. Besides point lookups it
+// supports range scans, prefix scans and iteration in either direction.
+type BTree struct {
+	Column  string   // indexed column name (the first, for a composite index)
+	Columns []string // indexed columns, in key order
+	root    *node
+	size    int
+}
+
+// entry is one distinct key and the rows holding it
+type entry struct {
+	key    interface{}
+	rowIDs []int64
+}
+
+// node is a B-tree node; leaves have no children
+type node struct {
+	entries  []entry
+	children []*node
+}
+
+// Bound limits one end of a range scan
+type Bound struct {
+	Value     interface{}
+	Inclusive bool // Whether Value itself is in the range
+	Infinite  bool // No limit: the range runs to the end of the index
+}
+
+// Unbounded returns a bound that does not limit the range
+func Unbounded() Bound {
+	return Bound{Infinite: true}
+}
+
+// Inclusive returns a bound that includes value
+func Inclusive(value interface{}) Bound {
+	return Bound{Value: value, Inclusive: true}
+}
+
+// Exclusive returns a bound that stops just short of value
+func Exclusive(value interface{}) Bound {
+	return Bound{Value: value}
+}
+
+// Visitor receives the keys of a scan in order with the rows holding each;
+// returning false stops the scan
+type Visitor func(key interface{}, rowIDs []int64) bool
+
+// NewBTree creates an empty ordered index
+func NewBTree(column string) *BTree {
+	return NewCompositeBTree([]string{column})
+}
+
+// NewCompositeBTree creates an empty ordered index over several columns. Its
+// values are Keys; besides whole keys, any leftmost prefix of a key can be
+// looked up with AscendKeyPrefix.
+func NewCompositeBTree(columns []string) *BTree {
+	return &BTree{Column: columns[0], Columns: columns}
+}
+
+// Len returns the number of distinct keys in the index
+func (t *BTree) Len() int {
+	return t.size
+}
+
+// Add adds a row to the index
+func (t *BTree) Add(value interface{}, rowID int64) {
+	if e := t.find(value); e != nil {
+		e.rowIDs = append(e.rowIDs, rowID)
+		return
+	}
+
+	if t.root == nil {
+		t.root = &node{}
+	}
+	if len(t.root.entries) == 2*degree-1 {
+		t.root = &node{children: []*node{t.root}}
+		t.root.splitChild(0)
+	}
+	t.root.insert(entry{key: value, rowIDs: []int64{rowID}})
+	t.size++
+}
+
+// Remove removes a row from the index
+func (t *BTree) Remove(value interface{}, rowID int64) {
+	e := t.find(value)
+	if e == nil {
+		return
+	}
+
+	var remaining []int64
+	for _, id := range e.rowIDs {
+		if id != rowID {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) > 0 {
+		e.rowIDs = remaining
+		return
+	}
+
+	t.root.remove(value)
+	t.size--
+	if len(t.root.entries) == 0 {
+		if t.root.leaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+}
+
+// Lookup finds row IDs for a value
+func (t *BTree) Lookup(value interface{}) ([]int64, bool) {
+	if e := t.find(value); e != nil {
+		return e.rowIDs, true
+	}
+	return nil, false
+}
+
+// Exists checks if a value exists in the index
+func (t *BTree) Exists(value interface{}) bool {
+	return t.find(value) != nil
+}
+
+// Rebuild rebuilds the index from scratch
+func (t *BTree) Rebuild(rows []storage.RowWithID) {
+	t.root = nil
+	t.size = 0
+	for _, r := range rows {
+		if val, exists := KeyOf(t.Columns, r.Row); exists {
+			t.Add(val, r.ID)
+		}
+	}
+}
+
+// Ascend visits the keys between lo and hi in ascending order
+func (t *BTree) Ascend(lo, hi Bound, visit Visitor) {
+	if t.root != nil {
+		t.root.ascend(lo, hi, visit)
+	}
+}
+
+// Descend visits the keys between lo and hi in descending order
+func (t *BTree) Descend(lo, hi Bound, visit Visitor) {
+	if t.root != nil {
+		t.root.descend(lo, hi, visit)
+	}
+}
+
+// AscendPrefix visits the string keys starting with prefix in ascending order
+func (t *BTree) AscendPrefix(prefix string, visit Visitor) {
+	t.Ascend(Inclusive(prefix), prefixEnd(prefix), withPrefix(prefix, visit))
+}
+
+// DescendPrefix visits the string keys starting with prefix in descending order
+func (t *BTree) DescendPrefix(prefix string, visit Visitor) {
+	t.Descend(Inclusive(prefix), prefixEnd(prefix), withPrefix(prefix, visit))
+}
+
+// AscendKeyPrefix visits the composite keys whose leading values equal prefix
+// in ascending order
+func (t *BTree) AscendKeyPrefix(prefix Key, visit Visitor) {
+	t.Ascend(Inclusive(prefix), keyPrefixEnd(prefix), visit)
+}
+
+// DescendKeyPrefix visits the composite keys whose leading values equal prefix
+// in descending order
+func (t *BTree) DescendKeyPrefix(prefix Key, visit Visitor) {
+	t.Descend(Inclusive(prefix), keyPrefixEnd(prefix), visit)
+}
+
+// KeyPrefixBounds returns the bounds of the composite keys whose leading
+// values equal prefix, for walking them with Ascend or Descend
+func KeyPrefixBounds(prefix Key) (lo, hi Bound) {
+	return Inclusive(prefix), keyPrefixEnd(prefix)
+}
+
+// keyPrefixEnd returns the bound just past every key starting with prefix
+func keyPrefixEnd(prefix Key) Bound {
+	end := make(Key, len(prefix), len(prefix)+1)
+	copy(end, prefix)
+	return Exclusive(append(end, keyEnd{}))
+}
+
+// prefixEnd returns the bound just past every string starting with prefix
+func prefixEnd(prefix string) Bound {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			return Exclusive(prefix[:i] + string([]byte{prefix[i] + 1}))
+		}
+	}
+	return Unbounded()
+}
+
+// withPrefix skips keys that are not strings starting with prefix, which an
+// unbounded prefix range can reach
+func withPrefix(prefix string, visit Visitor) Visitor {
+	return func(key interface{}, rowIDs []int64) bool {
+		if s, ok := key.(string); !ok || !strings.HasPrefix(s, prefix) {
+			return true
+		}
+		return visit(key, rowIDs)
+	}
+}
+
+// find returns the entry for a key, or nil
+func (t *BTree) find(key interface{}) *entry {
+	n := t.root
+	for n != nil {
+		i, found := n.search(key)
+		if found {
+			return &n.entries[i]
+		}
+		if n.leaf() {
+			return nil
+		}
+		n = n.children[i]
+	}
+	return nil
+}
+
+func (n *node) leaf() bool {
+	return len(n.children) == 0
+}
+
+// search returns the index of the first entry not below key, and whether it equals key
+func (n *node) search(key interface{}) (int, bool) {
+	i := sort.Search(len(n.entries), func(i int) bool {
+		return Compare(n.entries[i].key, key) >= 0
+	})
+	return i, i < len(n.entries) && Compare(n.entries[i].key, key) == 0
+}
+
+// splitChild splits the full child i around its median entry, which moves up into n
+func (n *node) splitChild(i int) {
+	child := n.children[i]
+	median := child.entries[degree-1]
+
+	right := &node{entries: append([]entry(nil), child.entries[degree:]...)}
+	if !child.leaf() {
+		right.children = append([]*node(nil), child.children[degree:]...)
+		child.children = child.children[:degree:degree]
+	}
+	child.entries = child.entries[: degree-1 : degree-1]
+
+	n.entries = append(n.entries, entry{})
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = median
+
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+}
+
+// insert adds a new key to the subtree rooted at n, which is not full
+func (n *node) insert(e entry) {
+	i, _ := n.search(e.key)
+	if n.leaf() {
+		n.entries = append(n.entries, entry{})
+		copy(n.entries[i+1:], n.entries[i:])
+		n.entries[i] = e
+		return
+	}
+
+	if len(n.children[i].entries) == 2*degree-1 {
+		n.splitChild(i)
+		if Compare(e.key, n.entries[i].key) > 0 {
+			i++
+		}
+	}
+	n.children[i].insert(e)
+}
+
+// remove deletes a key from the subtree rooted at n. Every node it descends
+// into is first given at least degree entries, so removal never underflows.
+func (n *node) remove(key interface{}) {
+	i, found := n.search(key)
+
+	if found {
+		if n.leaf() {
+			n.entries = append(n.entries[:i], n.entries[i+1:]...)
+			return
+		}
+		switch {
+		case len(n.children[i].entries) >= degree:
+			pred := n.children[i].max()
+			n.entries[i] = pred
+			n.children[i].remove(pred.key)
+		case len(n.children[i+1].entries) >= degree:
+			succ := n.children[i+1].min()
+			n.entries[i] = succ
+			n.children[i+1].remove(succ.key)
+		default:
+			n.merge(i)
+			n.children[i].remove(key)
+		}
+		return
+	}
+
+	if n.leaf() {
+		return
+	}
+	if len(n.children[i].entries) < degree {
+		switch {
+		case i > 0 && len(n.children[i-1].entries) >= degree:
+			n.borrowFromLeft(i)
+		case i < len(n.children)-1 && len(n.children[i+1].entries) >= degree:
+			n.borrowFromRight(i)
+		case i < len(n.children)-1:
+			n.merge(i)
+		default:
+			n.merge(i - 1)
+			i--
+		}
+	}
+	n.children[i].remove(key)
+}
+
+// min returns the smallest entry in the subtree rooted at n
+func (n *node) min() entry {
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return n.entries[0]
+}
+
+// max returns the largest entry in the subtree rooted at n
+func (n *node) max() entry {
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.entries[len(n.entries)-1]
+}
+
+// borrowFromLeft moves an entry from child i-1 through n into child i
+func (n *node) borrowFromLeft(i int) {
+	child, left := n.children[i], n.children[i-1]
+
+	child.entries = append([]entry{n.entries[i-1]}, child.entries...)
+	n.entries[i-1] = left.entries[len(left.entries)-1]
+	left.entries = left.entries[:len(left.entries)-1]
+
+	if !left.leaf() {
+		child.children = append([]*node{left.children[len(left.children)-1]}, child.children...)
+		left.children = left.children[:len(left.children)-1]
+	}
+}
+
+// borrowFromRight moves an entry from child i+1 through n into child i
+func (n *node) borrowFromRight(i int) {
+	child, right := n.children[i], n.children[i+1]
+
+	child.entries = append(child.entries, n.entries[i])
+	n.entries[i] = right.entries[0]
+	right.entries = append([]entry(nil), right.entries[1:]...)
+
+	if !right.leaf() {
+		child.children = append(child.children, right.children[0])
+		right.children = append([]*node(nil), right.children[1:]...)
+	}
+}
+
+// merge joins child i, entry i and child i+1 into child i
+func (n *node) merge(i int) {
+	child, right := n.children[i], n.children[i+1]
+
+	child.entries = append(child.entries, n.entries[i])
+	child.entries = append(child.entries, right.entries...)
+	child.children = append(child.children, right.children...)
+
+	n.entries = append(n.entries[:i], n.entries[i+1:]...)
+	n.children = append(n.children[:i+1], n.children[i+2:]...)
+}
+
+// aboveLow reports whether key is inside the range's lower bound
+func aboveLow(key interface{}, lo Bound) bool {
+	if lo.Infinite {
+		return true
+	}
+	c := Compare(key, lo.Value)
+	return c > 0 || (c == 0 && lo.Inclusive)
+}
+
+// belowHigh reports whether key is inside the range's upper bound
+func belowHigh(key interface{}, hi Bound) bool {
+	if hi.Infinite {
+		return true
+	}
+	c := Compare(key, hi.Value)
+	return c < 0 || (c == 0 && hi.Inclusive)
+}
+
+// ascend visits the subtree's keys in [lo, hi] in order; false means the scan stopped
+func (n *node) ascend(lo, hi Bound, visit Visitor) bool {
+	i := 0
+	if !lo.Infinite {
+		i, _ = n.search(lo.Value)
+	}
+	for ; i < len(n.entries); i++ {
+		if !n.leaf() && !n.children[i].ascend(lo, hi, visit) {
+			return false
+		}
+		e := n.entries[i]
+		if !belowHigh(e.key, hi) {
+			return false
+		}
+		if aboveLow(e.key, lo) && !visit(e.key, e.rowIDs) {
+			return false
+		}
+	}
+	if !n.leaf() {
+		return n.children[len(n.entries)].ascend(lo, hi, visit)
+	}
+	return true
+}
+
+// descend visits the subtree's keys in [lo, hi] in reverse order; false means the scan stopped
+func (n *node) descend(lo, hi Bound, visit Visitor) bool {
+	j := len(n.entries)
+	if !hi.Infinite {
+		j = sort.Search(len(n.entries), func(i int) bool {
+			return Compare(n.entries[i].key, hi.Value) > 0
+		})
+	}
+	if !n.leaf() && !n.children[j].descend(lo, hi, visit) {
+		return false
+	}
+	for i := j - 1; i >= 0; i-- {
+		e := n.entries[i]
+		if !aboveLow(e.key, lo) {
+			return false
+		}
+		if belowHigh(e.key, hi) && !visit(e.key, e.rowIDs) {
+			return false
+		}
+		if !n.leaf() && !n.children[i].descend(lo, hi, visit) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Compare orders two column values by type, then by value: NULL sorts first,
+// then booleans (false before true), then numbers compared numerically, then
+// strings compared bytewise, then composite Keys column by column. Values of
+// any other type sort last by their text. It returns -1, 0 or 1.
+func Compare(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ra {
+	case rankNull:
+		return 0
+	case rankBool:
+		ba, bb := a.(bool), b.(bool)
+		switch {
+		case ba == bb:
+			return 0
+		case !ba:
+			return -1
+		}
+		return 1
+	case rankNumber:
+		na, nb := toFloat(a), toFloat(b)
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	case rankString:
+		return strings.Compare(a.(string), b.(string))
+	case rankKey:
+		return compareKeys(a.(Key), b.(Key))
+	case rankEnd:
+		return 0
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+const (
+	rankNull = iota
+	rankBool
+	rankNumber
+	rankString
+	rankKey
+	rankOther
+	rankEnd
+)
+
+// keyEnd sorts after every value; a Key ending in it bounds a prefix scan
+type keyEnd struct{}
+
+// typeRank places a value's type in the order Compare sorts types in
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return rankNull
+	case bool:
+		return rankBool
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return rankNumber
+	case string:
+		return rankString
+	case Key:
+		return rankKey
+	case keyEnd:
+		return rankEnd
+	}
+	return rankOther
+}
+
+// toFloat converts any numeric value to float64, like decoded JSON numbers
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int8:
+		return float64(n)
+	case int16:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint:
+		return float64(n)
+	case uint8:
+		return float64(n)
+	case uint16:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	}
+	return 0
+}
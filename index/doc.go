@@ -1,4 +1,4 @@
-// Package index provides hash-based indexing for fast column value lookups.
+// Package index provides hash, B-tree and full-text indexes for fast column value lookups.
 //
 // The index package implements in-memory hash indexes that map column values to
 // lists of row IDs. This enables O(1) lookups for WHERE clauses on indexed columns,
@@ -7,8 +7,12 @@
 // Key Features:
 //   - Hash-Based: Uses hash maps for O(1) value lookups
 //   - Multi-Value Support: Maps values to lists of row IDs (handles duplicates)
+//   - Ordered: BTree keeps keys in typed order for range, prefix and reverse scans
+//   - Full-Text: FullText maps words to rows and ranks searches with BM25
+//   - Expressions: columns such as LOWER(email) index a function of a column
+//   - Typed: keys are encoded with their type, so "1" and 1 never collide
 //   - Rebuildable: Can rebuild indexes from scratch from row data
-//   - In-Memory: Fast access but requires rebuilding on restart
+//   - In-Memory: Fast access; Entries and Load save and restore an index's contents
 //
 // Key Responsibilities:
 //   - Maintaining hash maps of column values to row IDs
@@ -41,5 +45,5 @@
 //
 // The index package is used by the database package to maintain indexes on
 // frequently queried columns. Indexes are automatically maintained as data
-// changes, but must be rebuilt from storage on database restart.
+// changes, and checkpointed by the database so a restart need not rebuild them.
 package index
@@ -0,0 +1,180 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Type tags of encoded keys, in the order Compare sorts types. Tag 0 ends a
+// composite key, so a key sorts before every longer key it is a prefix of.
+const (
+	tagKeyEnd byte = iota
+	tagNull
+	tagBool
+	tagNumber
+	tagString
+	tagKey
+	tagOther
+	tagEnd byte = 0xff
+)
+
+// EncodeKey encodes a column value or composite Key as a string whose bytewise
+// order matches Compare. The encoding starts with a type tag, so values of
+// different types never collide: the TEXT value "1" and the INT value 1, or
+// "true" and true, get different encodings. Numbers are encoded by value, so 1
+// and 1.0 share an encoding whatever Go type holds them.
+func EncodeKey(value interface{}) string {
+	var b strings.Builder
+	encodeValue(&b, value)
+	return b.String()
+}
+
+// encodeValue appends one value's encoding
+func encodeValue(b *strings.Builder, value interface{}) {
+	switch typeRank(value) {
+	case rankNull:
+		b.WriteByte(tagNull)
+	case rankBool:
+		b.WriteByte(tagBool)
+		if value.(bool) {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case rankNumber:
+		b.WriteByte(tagNumber)
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], orderedBits(toFloat(value)))
+		b.Write(buf[:])
+	case rankString:
+		b.WriteByte(tagString)
+		encodeString(b, value.(string))
+	case rankKey:
+		b.WriteByte(tagKey)
+		for _, v := range value.(Key) {
+			encodeValue(b, v)
+		}
+		b.WriteByte(tagKeyEnd)
+	case rankEnd:
+		b.WriteByte(tagEnd)
+	default:
+		b.WriteByte(tagOther)
+		encodeString(b, fmt.Sprintf("%v", value))
+	}
+}
+
+// orderedBits maps a float64 to a uint64 with the same order: the sign bit is
+// flipped for positive numbers and every bit for negative ones. Negative zero
+// is treated as zero.
+func orderedBits(f float64) uint64 {
+	if f == 0 {
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		return ^bits
+	}
+	return bits | 1<<63
+}
+
+// encodeString appends a string terminated by 0x00 0x01, escaping each 0x00
+// byte in it as 0x00 0xff, so a string sorts before every longer string it is
+// a prefix of and a value inside a composite key cannot run into the next one
+func encodeString(b *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			b.WriteString("\x00\xff")
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteString("\x00\x01")
+}
+
+// DecodeKey reverses EncodeKey. Numbers decode as float64, like JSON numbers,
+// and values of unknown types as their text.
+func DecodeKey(encoded string) (interface{}, error) {
+	value, rest, err := decodeValue(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("%d trailing bytes after encoded key", len(rest))
+	}
+	return value, nil
+}
+
+// decodeValue decodes one value and returns the bytes after it
+func decodeValue(s string) (interface{}, string, error) {
+	if s == "" {
+		return nil, "", fmt.Errorf("truncated key")
+	}
+	tag, s := s[0], s[1:]
+	switch tag {
+	case tagNull:
+		return nil, s, nil
+	case tagBool:
+		if s == "" {
+			return nil, "", fmt.Errorf("truncated boolean")
+		}
+		return s[0] == 1, s[1:], nil
+	case tagNumber:
+		if len(s) < 8 {
+			return nil, "", fmt.Errorf("truncated number")
+		}
+		bits := binary.BigEndian.Uint64([]byte(s[:8]))
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return math.Float64frombits(bits), s[8:], nil
+	case tagString, tagOther:
+		return decodeString(s)
+	case tagKey:
+		key := Key{}
+		for {
+			if s == "" {
+				return nil, "", fmt.Errorf("unterminated composite key")
+			}
+			if s[0] == tagKeyEnd {
+				return key, s[1:], nil
+			}
+			value, rest, err := decodeValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			key = append(key, value)
+			s = rest
+		}
+	case tagEnd:
+		return keyEnd{}, s, nil
+	}
+	return nil, "", fmt.Errorf("unknown key type tag %d", tag)
+}
+
+// decodeString reads a string written by encodeString
+func decodeString(s string) (interface{}, string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != 0 {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			break
+		}
+		switch s[i+1] {
+		case 0xff:
+			b.WriteByte(0)
+			i++
+		case 0x01:
+			return b.String(), s[i+2:], nil
+		default:
+			return nil, "", fmt.Errorf("invalid escape in encoded string")
+		}
+	}
+	return nil, "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,49 @@
+package index
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"rdbms/storage"
+)
+
+// refRe matches a function applied to a column, e.g. LOWER(email)
+var refRe = regexp.MustCompile(`^(\w+)\((\w+)\)$`)
+
+// functions are the functions an index column or WHERE condition can apply to
+// a column. They change text only; other values pass through unchanged.
+var functions = map[string]func(string) string{
+	"LOWER": strings.ToLower,
+	"UPPER": strings.ToUpper,
+}
+
+// SplitRef splits a column reference into its function and column: a plain
+// column has no function, and LOWER(email) is LOWER applied to email
+func SplitRef(ref string) (fn, column string, err error) {
+	m := refRe.FindStringSubmatch(ref)
+	if m == nil {
+		return "", ref, nil
+	}
+	if _, known := functions[m[1]]; !known {
+		return "", "", fmt.Errorf("unknown function '%s' (expected LOWER or UPPER)", m[1])
+	}
+	return m[1], m[2], nil
+}
+
+// ValueOf returns what a row holds for a column reference: the column's value,
+// or the function applied to it. It reports false if the row lacks the column.
+func ValueOf(ref string, row storage.Row) (interface{}, bool) {
+	fn, column, err := SplitRef(ref)
+	if err != nil {
+		return nil, false
+	}
+	val, exists := row[column]
+	if !exists || fn == "" {
+		return val, exists
+	}
+	if s, ok := val.(string); ok {
+		return functions[fn](s), true
+	}
+	return val, true
+}
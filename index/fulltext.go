@@ -0,0 +1,288 @@
+package index
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"rdbms/storage"
+)
+
+// BM25 parameters: k1 limits how much repeating a term raises a row's score,
+// b how much long texts are penalized
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopwords are common English words left out of the index when Analyzer.Stopwords is set
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"but": true, "by": true, "for": true, "if": true, "in": true, "into": true, "is": true,
+	"it": true, "no": true, "not": true, "of": true, "on": true, "or": true, "such": true,
+	"that": true, "the": true, "their": true, "then": true, "there": true, "these": true,
+	"they": true, "this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// Analyzer turns text into index terms: it splits on anything but letters and
+// digits and lowercases each word
+type Analyzer struct {
+	Stem      bool // Reduce words to a stem, so "crashes" and "crashed" match "crash"
+	Stopwords bool // Leave out common words such as "the" and "of"
+}
+
+// Terms returns the terms of a text in order, repeated as often as they occur
+func (a Analyzer) Terms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, w := range words {
+		if a.Stopwords && stopwords[w] {
+			continue
+		}
+		if a.Stem {
+			w = stem(w)
+		}
+		terms = append(terms, w)
+	}
+	return terms
+}
+
+// stem strips common English inflections: plurals, -ing, -ed and -ly. It is a
+// light stemmer; words of three letters or fewer are left alone.
+func stem(w string) string {
+	if len(w) <= 3 {
+		return w
+	}
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies"):
+		w = w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "shes"), strings.HasSuffix(w, "ches"),
+		strings.HasSuffix(w, "xes"), strings.HasSuffix(w, "zes"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") &&
+		!strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is"):
+		w = w[:len(w)-1]
+	}
+
+	for _, suffix := range []string{"ing", "ed"} {
+		base := strings.TrimSuffix(w, suffix)
+		if base == w || len(base) < 3 || !strings.ContainsAny(base, "aeiouy") {
+			continue
+		}
+		// running -> run, stopped -> stop
+		if n := len(base); base[n-1] == base[n-2] && !strings.ContainsRune("lsz", rune(base[n-1])) {
+			base = base[:n-1]
+		}
+		return base
+	}
+	if base := strings.TrimSuffix(w, "ly"); base != w && len(base) >= 3 {
+		return base
+	}
+	return w
+}
+
+// FullText is an inverted index over a TEXT column: it maps each term to the
+// rows containing it and how often, and ranks searches with BM25. Values are
+// the column's text; Lookup and Search take a query instead of a value.
+type FullText struct {
+	Column   string   // indexed column name
+	Columns  []string // the indexed column alone
+	Analyzer Analyzer
+
+	postings map[string]map[int64]int // term -> row -> occurrences
+	rowTerms map[int64][]string       // row -> its distinct terms
+	lengths  map[int64]int            // row -> number of terms
+	total    int                      // sum of lengths
+}
+
+// Match is a row found by a full-text search and its BM25 score
+type Match struct {
+	RowID int64
+	Score float64
+}
+
+// NewFullText creates an empty full-text index
+func NewFullText(column string, analyzer Analyzer) *FullText {
+	ft := &FullText{Column: column, Columns: []string{column}, Analyzer: analyzer}
+	ft.reset()
+	return ft
+}
+
+// reset empties the index
+func (ft *FullText) reset() {
+	ft.postings = make(map[string]map[int64]int)
+	ft.rowTerms = make(map[int64][]string)
+	ft.lengths = make(map[int64]int)
+	ft.total = 0
+}
+
+// Len returns the number of rows with at least one term
+func (ft *FullText) Len() int {
+	return len(ft.lengths)
+}
+
+// Add indexes a row's text, replacing what the row held before. Values other
+// than strings are ignored.
+func (ft *FullText) Add(value interface{}, rowID int64) {
+	ft.Remove(value, rowID)
+	text, ok := value.(string)
+	if !ok {
+		return
+	}
+	counts := make(map[string]int)
+	for _, term := range ft.Analyzer.Terms(text) {
+		counts[term]++
+	}
+	for term, n := range counts {
+		ft.addTerm(term, rowID, n)
+	}
+}
+
+// addTerm records n occurrences of a term in a row
+func (ft *FullText) addTerm(term string, rowID int64, n int) {
+	rows, exists := ft.postings[term]
+	if !exists {
+		rows = make(map[int64]int)
+		ft.postings[term] = rows
+	}
+	if rows[rowID] == 0 {
+		ft.rowTerms[rowID] = append(ft.rowTerms[rowID], term)
+	}
+	rows[rowID] += n
+	ft.lengths[rowID] += n
+	ft.total += n
+}
+
+// Remove drops a row from the index. The index remembers each row's terms, so
+// the value is not needed.
+func (ft *FullText) Remove(value interface{}, rowID int64) {
+	for _, term := range ft.rowTerms[rowID] {
+		delete(ft.postings[term], rowID)
+		if len(ft.postings[term]) == 0 {
+			delete(ft.postings, term)
+		}
+	}
+	ft.total -= ft.lengths[rowID]
+	delete(ft.rowTerms, rowID)
+	delete(ft.lengths, rowID)
+}
+
+// Search returns the rows containing any term of a query, best match first.
+// Each query term adds its BM25 weight: rarer terms weigh more, and a term
+// counts for less the more often it repeats and the longer the row's text.
+func (ft *FullText) Search(query string) []Match {
+	n := float64(len(ft.lengths))
+	if n == 0 {
+		return nil
+	}
+	avgLength := float64(ft.total) / n
+
+	scores := make(map[int64]float64)
+	seen := make(map[string]bool)
+	for _, term := range ft.Analyzer.Terms(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		rows := ft.postings[term]
+		df := float64(len(rows))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for rowID, occurrences := range rows {
+			tf := float64(occurrences)
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(ft.lengths[rowID])/avgLength)
+			scores[rowID] += idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+
+	matches := make([]Match, 0, len(scores))
+	for rowID, score := range scores {
+		matches = append(matches, Match{RowID: rowID, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].RowID < matches[j].RowID
+	})
+	return matches
+}
+
+// Lookup returns the rows matching a query, best match first
+func (ft *FullText) Lookup(value interface{}) ([]int64, bool) {
+	query, _ := value.(string)
+	matches := ft.Search(query)
+	rowIDs := make([]int64, len(matches))
+	for i, m := range matches {
+		rowIDs[i] = m.RowID
+	}
+	return rowIDs, len(rowIDs) > 0
+}
+
+// Exists reports whether any row matches a query
+func (ft *FullText) Exists(value interface{}) bool {
+	query, _ := value.(string)
+	for _, term := range ft.Analyzer.Terms(query) {
+		if len(ft.postings[term]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Rebuild rebuilds the index from scratch
+func (ft *FullText) Rebuild(rows []storage.RowWithID) {
+	ft.reset()
+	for _, r := range rows {
+		if val, exists := r.Row[ft.Column]; exists {
+			ft.Add(val, r.ID)
+		}
+	}
+}
+
+// Entries returns one entry per term in order. A row is listed once for each
+// time the term occurs in it, so term frequencies survive a save and Load.
+func (ft *FullText) Entries() []Entry {
+	terms := make([]string, 0, len(ft.postings))
+	for term := range ft.postings {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	entries := make([]Entry, 0, len(terms))
+	for _, term := range terms {
+		rowIDs := make([]int64, 0, len(ft.postings[term]))
+		for rowID, n := range ft.postings[term] {
+			for i := 0; i < n; i++ {
+				rowIDs = append(rowIDs, rowID)
+			}
+		}
+		sort.Slice(rowIDs, func(i, j int) bool { return rowIDs[i] < rowIDs[j] })
+		entries = append(entries, Entry{Key: []byte(EncodeKey(term)), RowIDs: rowIDs})
+	}
+	return entries
+}
+
+// Load replaces the index's contents with saved entries
+func (ft *FullText) Load(entries []Entry) error {
+	ft.reset()
+	for _, e := range entries {
+		key, err := DecodeKey(string(e.Key))
+		if err != nil {
+			return err
+		}
+		term, ok := key.(string)
+		if !ok {
+			return fmt.Errorf("index %s: term %v is not text", ft.Column, key)
+		}
+		for _, rowID := range e.RowIDs {
+			ft.addTerm(term, rowID, 1)
+		}
+	}
+	return nil
+}
@@ -1,33 +1,51 @@
 package index
 
 import (
-	"fmt"
 	"rdbms/storage"
 )
 
+// Interface is what the database needs from every index type
+type Interface interface {
+	Add(value interface{}, rowID int64)
+	Remove(value interface{}, rowID int64)
+	Lookup(value interface{}) ([]int64, bool)
+	Exists(value interface{}) bool
+	Rebuild(rows []storage.RowWithID)
+	Entries() []Entry
+	Load(entries []Entry) error
+}
+
 // Index is a hash-based index for fast lookups
 type Index struct {
-	Column string              // indexed column name
-	Data   map[string][]int64  // value -> [row_ids]
+	Column  string             // indexed column name (the first, for a composite index)
+	Columns []string           // indexed columns, in key order
+	Data    map[string][]int64 // EncodeKey(value) -> [row_ids]
 }
 
 // New creates a new index
 func New(column string) *Index {
+	return NewComposite([]string{column})
+}
+
+// NewComposite creates a hash index over several columns. Its values are Keys
+// and only whole keys can be looked up.
+func NewComposite(columns []string) *Index {
 	return &Index{
-		Column: column,
-		Data:   make(map[string][]int64),
+		Column:  columns[0],
+		Columns: columns,
+		Data:    make(map[string][]int64),
 	}
 }
 
 // Add adds a row to the index
 func (idx *Index) Add(value interface{}, rowID int64) {
-	key := fmt.Sprintf("%v", value)
+	key := EncodeKey(value)
 	idx.Data[key] = append(idx.Data[key], rowID)
 }
 
 // Remove removes a row from the index
 func (idx *Index) Remove(value interface{}, rowID int64) {
-	key := fmt.Sprintf("%v", value)
+	key := EncodeKey(value)
 	if ids, found := idx.Data[key]; found {
 		newIDs := []int64{}
 		for _, id := range ids {
@@ -45,14 +63,14 @@ func (idx *Index) Remove(value interface{}, rowID int64) {
 
 // Lookup finds row IDs for a value
 func (idx *Index) Lookup(value interface{}) ([]int64, bool) {
-	key := fmt.Sprintf("%v", value)
+	key := EncodeKey(value)
 	ids, found := idx.Data[key]
 	return ids, found
 }
 
 // Exists checks if a value exists in the index
 func (idx *Index) Exists(value interface{}) bool {
-	key := fmt.Sprintf("%v", value)
+	key := EncodeKey(value)
 	_, found := idx.Data[key]
 	return found
 }
@@ -61,7 +79,7 @@ func (idx *Index) Exists(value interface{}) bool {
 func (idx *Index) Rebuild(rows []storage.RowWithID) {
 	idx.Data = make(map[string][]int64)
 	for _, r := range rows {
-		if val, exists := r.Row[idx.Column]; exists {
+		if val, exists := KeyOf(idx.Columns, r.Row); exists {
 			idx.Add(val, r.ID)
 		}
 	}
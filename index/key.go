@@ -0,0 +1,75 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+
+	"rdbms/storage"
+)
+
+// Key is the value of a composite index key: one value per indexed column, in
+// index order. Keys compare column by column, and a key sorts before every
+// longer key it is a prefix of, so a B-tree keeps all keys sharing leading
+// values together.
+type Key []interface{}
+
+// String formats the key as a tuple, e.g. (1, 'a')
+func (k Key) String() string {
+	parts := make([]string, len(k))
+	for i, v := range k {
+		if s, ok := v.(string); ok {
+			parts[i] = fmt.Sprintf("'%s'", s)
+		} else {
+			parts[i] = fmt.Sprintf("%v", v)
+		}
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// HasPrefix reports whether the key's leading values equal prefix
+func (k Key) HasPrefix(prefix Key) bool {
+	if len(prefix) > len(k) {
+		return false
+	}
+	for i, v := range prefix {
+		if Compare(k[i], v) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// KeyOf returns the value a row is indexed under for the given columns: the
+// column's value for a single column, otherwise a Key. Columns may be
+// expressions such as LOWER(email), evaluated with ValueOf. It reports false if
+// the row lacks any of the columns.
+func KeyOf(columns []string, row storage.Row) (interface{}, bool) {
+	if len(columns) == 1 {
+		return ValueOf(columns[0], row)
+	}
+	key := make(Key, len(columns))
+	for i, col := range columns {
+		val, exists := ValueOf(col, row)
+		if !exists {
+			return nil, false
+		}
+		key[i] = val
+	}
+	return key, true
+}
+
+// compareKeys orders composite keys column by column
+func compareKeys(a, b Key) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
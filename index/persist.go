@@ -0,0 +1,62 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Entry is one distinct key of an index and the rows stored under it, in the
+// form indexes are saved in: the key encoded with EncodeKey
+type Entry struct {
+	Key    []byte  `json:"key"`
+	RowIDs []int64 `json:"row_ids"`
+}
+
+// Entries returns the index's keys in encoded order
+func (idx *Index) Entries() []Entry {
+	entries := make([]Entry, 0, len(idx.Data))
+	for key, rowIDs := range idx.Data {
+		entries = append(entries, Entry{Key: []byte(key), RowIDs: append([]int64(nil), rowIDs...)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return string(entries[i].Key) < string(entries[j].Key) })
+	return entries
+}
+
+// Load replaces the index's contents with saved entries
+func (idx *Index) Load(entries []Entry) error {
+	data := make(map[string][]int64, len(entries))
+	for _, e := range entries {
+		if _, err := DecodeKey(string(e.Key)); err != nil {
+			return fmt.Errorf("index %s: %v", idx.Column, err)
+		}
+		data[string(e.Key)] = append([]int64(nil), e.RowIDs...)
+	}
+	idx.Data = data
+	return nil
+}
+
+// Entries returns the index's keys in order
+func (t *BTree) Entries() []Entry {
+	entries := make([]Entry, 0, t.size)
+	t.Ascend(Unbounded(), Unbounded(), func(key interface{}, rowIDs []int64) bool {
+		entries = append(entries, Entry{Key: []byte(EncodeKey(key)), RowIDs: append([]int64(nil), rowIDs...)})
+		return true
+	})
+	return entries
+}
+
+// Load replaces the index's contents with saved entries
+func (t *BTree) Load(entries []Entry) error {
+	t.root = nil
+	t.size = 0
+	for _, e := range entries {
+		key, err := DecodeKey(string(e.Key))
+		if err != nil {
+			return fmt.Errorf("index %s: %v", t.Column, err)
+		}
+		for _, rowID := range e.RowIDs {
+			t.Add(key, rowID)
+		}
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"rdbms/cmd/web"
 	"rdbms/database"
 	"rdbms/executor"
+	"rdbms/migrate"
 	"rdbms/parser"
 )
 
@@ -44,11 +45,9 @@ func runREPL(db *database.Database) {
 			continue
 		}
 
-		result, err := exec.Execute(stmt)
-		if err != nil {
+		// Rows print as the query produces them
+		if err := exec.ExecuteTo(os.Stdout, stmt); err != nil {
 			fmt.Printf("Error: %v\n", err)
-		} else {
-			fmt.Println(result)
 		}
 		fmt.Println()
 	}
@@ -68,7 +67,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	if len(os.Args) > 1 && os.Args[1] == "web" {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := migrate.Run(db, os.Args[2:], os.Stdout)
+		db.Close()
+		if err != nil {
+			fmt.Printf("Migration error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if len(os.Args) > 1 && os.Args[1] == "web" {
 		port := "8080"
 		if len(os.Args) > 2 {
 			port = os.Args[2]
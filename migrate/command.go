@@ -0,0 +1,173 @@
+package migrate
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"rdbms/database"
+	"rdbms/schema"
+)
+
+// DefaultDir is where the migrate command looks for migration files
+const DefaultDir = "./migrations"
+
+// Run executes a migrate subcommand and writes its report to out:
+//
+//	migrate up [-dir DIR] [N]     apply the next N pending migrations (all by default)
+//	migrate down [-dir DIR] [N]   roll back the last N applied migrations (one by default)
+//	migrate status [-dir DIR]     list migrations and whether they are applied
+//	migrate plan [-dir DIR] [N]   show what up would do, without applying anything
+//
+// -compat none|backward|forward|full refuses steps whose column changes break
+// that compatibility, FULL by default; -compat none only reports them.
+func Run(db *database.Database, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down|status|plan [-dir DIR] [-compat MODE] [N]")
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("dir", DefaultDir, "directory containing migration files")
+	compat := fs.String("compat", string(DefaultCompatibility), "compatibility to enforce: none, backward, forward or full")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	mode, err := schema.ParseCompatibilityMode(*compat)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	if fs.NArg() > 0 {
+		if n, err = strconv.Atoi(fs.Arg(0)); err != nil || n < 1 {
+			return fmt.Errorf("invalid migration count: %s", fs.Arg(0))
+		}
+	}
+
+	m, err := New(db, *dir)
+	if err != nil {
+		return err
+	}
+	m.SetCompatibilityMode(mode)
+
+	switch args[0] {
+	case "status":
+		entries, err := m.Status()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Fprintf(out, "No migrations in %s\n", *dir)
+		}
+		for _, e := range entries {
+			state := "pending"
+			if e.Applied {
+				state = fmt.Sprintf("applied (event %d)", e.EventID)
+			}
+			if e.FileMissing {
+				state += ", file missing"
+			}
+			fmt.Fprintf(out, "%-30s %-15s %s\n", e.ID, e.Table, state)
+		}
+		return nil
+
+	case "plan":
+		steps, err := m.Plan(n)
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			fmt.Fprintln(out, "No pending migrations")
+		}
+		for _, step := range steps {
+			writeStep(out, step)
+		}
+		return nil
+
+	case "up", "down":
+		var steps []*Step
+		if args[0] == "up" {
+			steps, err = m.Up(n)
+		} else {
+			steps, err = m.Down(n)
+		}
+		for _, step := range steps {
+			fmt.Fprintf(out, "%s %s: %s v%d -> v%d\n", step.Direction, step.ID, step.Table, step.FromVersion, step.ToVersion)
+		}
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			fmt.Fprintf(out, "Nothing to migrate %s\n", args[0])
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command: %s", args[0])
+}
+
+// writeStep prints a planned step with its operations and checks
+func writeStep(out io.Writer, step *Step) {
+	fmt.Fprintf(out, "%s %s: %s v%d -> v%d\n", step.Direction, step.ID, step.Table, step.FromVersion, step.ToVersion)
+	for _, op := range step.Ops {
+		fmt.Fprintf(out, "  %s\n", describeOp(op))
+	}
+	if step.Policy.OnFailure != "" && step.Policy.OnFailure != schema.FailOnError {
+		fmt.Fprintf(out, "  on conversion failure: %s\n", step.Policy.OnFailure)
+	}
+	fmt.Fprintf(out, "  compatibility: %s (%s)\n", step.Compatibility.Status, step.Compatibility.Message)
+	if r := step.Compatibility.Report; r != nil {
+		for _, c := range r.Breaking(schema.CompatibilityFull) {
+			fmt.Fprintf(out, "    breaks %s: %s\n", breaksWhat(c), c.Reason)
+		}
+	}
+	if r := step.DryRun; r != nil {
+		fmt.Fprintf(out, "  dry run: %d rows, %d migrated, %d failed (%d nulled, %d defaulted, %d dead-lettered, %d aborted)\n",
+			r.Rows, r.Migrated, r.Failed, r.Nulled, r.Defaulted, r.DeadLettered, r.Aborted)
+		for _, sample := range r.Samples {
+			fmt.Fprintf(out, "    %s\n", sample)
+		}
+	}
+	if err := step.Refused(); err != nil {
+		fmt.Fprintf(out, "  REFUSED: %v\n", err)
+	}
+}
+
+// breaksWhat names the compatibility modes a change breaks
+func breaksWhat(c schema.ColumnChange) string {
+	switch {
+	case c.BreaksBackward && c.BreaksForward:
+		return "backward and forward"
+	case c.BreaksBackward:
+		return "backward"
+	}
+	return "forward"
+}
+
+// describeOp renders a migration operation as the ALTER TABLE action it performs
+func describeOp(op schema.MigrationOp) string {
+	switch o := op.(type) {
+	case *schema.AddColumnOp:
+		s := fmt.Sprintf("ADD COLUMN %s %s", o.Column.Name, o.Column.Type)
+		if o.Column.Unique {
+			s += " UNIQUE"
+		}
+		if o.Column.NotNull {
+			s += " NOT NULL"
+		}
+		if o.Default != nil {
+			s += fmt.Sprintf(" DEFAULT %v", o.Default)
+		}
+		return s
+	case *schema.RemoveColumnOp:
+		return "DROP COLUMN " + o.ColumnName
+	case *schema.RenameColumnOp:
+		return fmt.Sprintf("RENAME COLUMN %s TO %s", o.OldName, o.NewName)
+	case *schema.ModifyColumnOp:
+		return fmt.Sprintf("ALTER COLUMN %s TYPE %s (was %s)", o.ColumnName, o.NewDef.Type, o.OldDef.Type)
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", op), "*schema.")
+}
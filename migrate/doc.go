@@ -0,0 +1,29 @@
+// Package migrate applies declarative migration files to a database.
+//
+// A migrations directory holds versioned files named <version>_<name>.sql or
+// <version>_<name>.json. Each file evolves one table by one schema version, either
+// as ALTER TABLE statements or as a JSON op list, with an optional down section and
+// a conversion failure policy.
+//
+// Applying a migration records a SCHEMA_EVOLVED event carrying the migration's ID
+// and direction, so which migrations are applied is itself derived from the log.
+// Rolling one back records another SCHEMA_EVOLVED event with the inverse operations;
+// schema versions only ever increase.
+//
+// Before anything is applied, every step is checked with CheckCompatibility and a
+// dry run over the table's rows. If any step is incompatible or would fail for a
+// row under its conversion policy, the whole batch is refused. The compatibility
+// check lists breaking column changes; SetCompatibilityMode makes them refuse the
+// step too.
+//
+// Usage Example:
+//
+//	m, err := migrate.New(db, "./migrations")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	steps, err := m.Plan(0) // Inspect what would happen
+//	steps, err = m.Up(0)    // Apply all pending migrations
+//	steps, err = m.Down(1)  // Roll back the last one
+package migrate
@@ -0,0 +1,270 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"rdbms/parser"
+	"rdbms/schema"
+)
+
+// fileNameRe matches migration files: <version>_<name>.sql or <version>_<name>.json
+var fileNameRe = regexp.MustCompile(`^(\d+)_(\w+)\.(sql|json)$`)
+
+// File is one versioned migration loaded from the migrations directory. Each file
+// evolves exactly one table by one schema version.
+type File struct {
+	ID      string // File name without extension, e.g. "0002_add_email"
+	Version int    // Numeric prefix; files apply in this order
+	Path    string
+	Table   string
+	Up      []schema.MigrationOp
+	Down    []schema.MigrationOp // nil means derive the inverse of Up
+	Policy  schema.ConversionPolicy
+}
+
+// Load reads every migration file in dir, ordered by version. Files that do not
+// follow the naming scheme are ignored; a missing directory has no migrations.
+func Load(dir string) ([]*File, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		m := fileNameRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		if other, exists := seen[version]; exists {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		f := &File{
+			ID:      strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+			Version: version,
+			Path:    path,
+			Policy:  schema.ConversionPolicy{OnFailure: schema.FailOnError},
+		}
+		if m[3] == "sql" {
+			err = parseSQLFile(f, string(data))
+		} else {
+			err = parseJSONFile(f, data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		files = append(files, f)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+	return files, nil
+}
+
+// parseSQLFile reads ALTER TABLE statements. Directive comments split the file
+// into sections and set the conversion policy:
+//
+//	-- +up
+//	ALTER TABLE users ALTER COLUMN age TYPE INT;
+//	-- +down
+//	ALTER TABLE users ALTER COLUMN age TYPE TEXT;
+//	-- +on_failure default 0
+//
+// Without directives the whole file is the up section.
+func parseSQLFile(f *File, content string) error {
+	sections := map[string]*strings.Builder{"up": {}, "down": {}}
+	current := "up"
+	hasDown := false
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "-- +") {
+			fields := strings.Fields(strings.TrimPrefix(trimmed, "-- +"))
+			if len(fields) == 0 {
+				continue
+			}
+			switch strings.ToLower(fields[0]) {
+			case "up":
+				current = "up"
+			case "down":
+				current = "down"
+				hasDown = true
+			case "on_failure":
+				if len(fields) < 2 {
+					return fmt.Errorf("on_failure needs an action")
+				}
+				f.Policy.OnFailure = schema.FailureAction(strings.ToLower(fields[1]))
+				if len(fields) > 2 {
+					f.Policy.Default = parser.ParseLiteral(strings.Join(fields[2:], " "))
+				}
+			default:
+				return fmt.Errorf("unknown directive: %s", trimmed)
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		sections[current].WriteString(line)
+		sections[current].WriteString("\n")
+	}
+
+	p := parser.New()
+	parse := func(section string) ([]schema.MigrationOp, error) {
+		var ops []schema.MigrationOp
+		for _, stmt := range strings.Split(section, ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			alter, err := p.ParseAlter(stmt)
+			if err != nil {
+				return nil, err
+			}
+			if f.Table == "" {
+				f.Table = alter.TableName
+			} else if f.Table != alter.TableName {
+				return nil, fmt.Errorf("a migration may only alter one table, found %s and %s", f.Table, alter.TableName)
+			}
+			ops = append(ops, alter.Operations...)
+		}
+		return ops, nil
+	}
+
+	var err error
+	if f.Up, err = parse(sections["up"].String()); err != nil {
+		return err
+	}
+	if len(f.Up) == 0 {
+		return fmt.Errorf("no ALTER TABLE statements")
+	}
+	if hasDown {
+		if f.Down, err = parse(sections["down"].String()); err != nil {
+			return err
+		}
+		if f.Down == nil {
+			f.Down = []schema.MigrationOp{}
+		}
+	}
+	return validatePolicy(f.Policy)
+}
+
+// jsonFile is the JSON migration format
+type jsonFile struct {
+	Table     string      `json:"table"`
+	Up        []jsonOp    `json:"up"`
+	Down      []jsonOp    `json:"down,omitempty"`
+	OnFailure string      `json:"on_failure,omitempty"`
+	Default   interface{} `json:"default,omitempty"`
+}
+
+// jsonOp is one operation in a JSON migration
+type jsonOp struct {
+	Op      string      `json:"op"`               // add_column, drop_column, rename_column, modify_column
+	Column  string      `json:"column,omitempty"` // Column added, dropped or modified
+	Type    string      `json:"type,omitempty"`   // Type of an added or modified column
+	Unique  bool        `json:"unique,omitempty"`
+	NotNull bool        `json:"not_null,omitempty"`
+	Default interface{} `json:"default,omitempty"` // Value for existing rows (add) or restored rows (drop)
+	From    string      `json:"from,omitempty"`    // rename_column
+	To      string      `json:"to,omitempty"`
+}
+
+// parseJSONFile reads a JSON op list:
+//
+//	{"table": "users", "up": [{"op": "add_column", "column": "email", "type": "TEXT", "default": "none"}]}
+func parseJSONFile(f *File, data []byte) error {
+	var jf jsonFile
+	if err := json.Unmarshal(data, &jf); err != nil {
+		return err
+	}
+	if jf.Table == "" {
+		return fmt.Errorf("missing table")
+	}
+	f.Table = jf.Table
+	if jf.OnFailure != "" {
+		f.Policy = schema.ConversionPolicy{OnFailure: schema.FailureAction(jf.OnFailure), Default: jf.Default}
+	}
+
+	var err error
+	if f.Up, err = jsonOps(jf.Up); err != nil {
+		return err
+	}
+	if len(f.Up) == 0 {
+		return fmt.Errorf("no operations in up")
+	}
+	if jf.Down != nil {
+		if f.Down, err = jsonOps(jf.Down); err != nil {
+			return err
+		}
+	}
+	return validatePolicy(f.Policy)
+}
+
+// jsonOps converts JSON operations to migration operations
+func jsonOps(in []jsonOp) ([]schema.MigrationOp, error) {
+	ops := make([]schema.MigrationOp, 0, len(in))
+	for _, o := range in {
+		switch o.Op {
+		case "add_column":
+			colType, err := columnType(o.Type)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, &schema.AddColumnOp{
+				Column:  schema.Column{Name: o.Column, Type: colType, Unique: o.Unique, NotNull: o.NotNull},
+				Default: o.Default,
+			})
+		case "drop_column":
+			ops = append(ops, &schema.RemoveColumnOp{ColumnName: o.Column, Default: o.Default})
+		case "rename_column":
+			ops = append(ops, &schema.RenameColumnOp{OldName: o.From, NewName: o.To})
+		case "modify_column":
+			colType, err := columnType(o.Type)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, &schema.ModifyColumnOp{ColumnName: o.Column, NewDef: schema.Column{Name: o.Column, Type: colType}})
+		default:
+			return nil, fmt.Errorf("unknown op %q", o.Op)
+		}
+	}
+	return ops, nil
+}
+
+// columnType validates a column type name
+func columnType(name string) (schema.ColumnType, error) {
+	colType := schema.ColumnType(strings.ToUpper(name))
+	switch colType {
+	case schema.TypeInt, schema.TypeText, schema.TypeBool:
+		return colType, nil
+	}
+	return "", fmt.Errorf("unsupported column type: %q", name)
+}
+
+// validatePolicy rejects unknown failure actions
+func validatePolicy(policy schema.ConversionPolicy) error {
+	switch policy.OnFailure {
+	case schema.FailOnError, schema.SetNull, schema.UseDefault, schema.DeadLetterRow:
+		return nil
+	}
+	return fmt.Errorf("unknown on_failure action %q", policy.OnFailure)
+}
@@ -0,0 +1,393 @@
+package migrate
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"rdbms/database"
+	"rdbms/eventlog"
+	"rdbms/schema"
+	"rdbms/storage"
+)
+
+// Directions recorded on SCHEMA_EVOLVED events produced by migration files
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+// Migrator applies migration files to a database. Which files have been applied
+// is derived from the SCHEMA_EVOLVED events they recorded, so it needs no state
+// of its own.
+type Migrator struct {
+	db    *database.Database
+	files []*File
+	mode  schema.CompatibilityMode
+}
+
+// Applied describes a migration whose up step is in effect
+type Applied struct {
+	ID      string
+	EventID uint64 // SCHEMA_EVOLVED event of the up step
+	Payload *eventlog.SchemaEvolvedPayload
+}
+
+// StatusEntry is one line of the migration status
+type StatusEntry struct {
+	ID          string
+	Table       string
+	Applied     bool
+	EventID     uint64 // SCHEMA_EVOLVED event of the up step, if applied
+	FileMissing bool   // Applied, but no longer in the migrations directory
+}
+
+// Step is one planned schema change: a migration file applied up or rolled back
+type Step struct {
+	ID            string
+	Direction     string
+	Table         string
+	FromVersion   int
+	ToVersion     int
+	Before        []schema.Column
+	After         []schema.Column
+	Ops           []schema.MigrationOp
+	Policy        schema.ConversionPolicy
+	Compatibility schema.SchemaCompatibilityCheck
+	DryRun        *schema.DryRunReport
+}
+
+// Refused returns why a step may not be applied, or nil if it can be
+func (s *Step) Refused() error {
+	if s.Compatibility.Status == schema.Incompatible {
+		return fmt.Errorf("migration %s (%s) is incompatible: %s", s.ID, s.Direction, s.Compatibility.Message)
+	}
+	if s.DryRun != nil && s.DryRun.Aborted > 0 {
+		return fmt.Errorf("migration %s (%s) would fail for %d of %d rows in %s", s.ID, s.Direction, s.DryRun.Aborted, s.DryRun.Rows, s.Table)
+	}
+	return nil
+}
+
+// DefaultCompatibility is the compatibility a migrator enforces unless told
+// otherwise: a step is refused if rows written before it could not be read
+// after it, or rows written after it could not be read by the old schema,
+// e.g. a dropped column without a default, a new NOT NULL column without one,
+// or a narrowed or widened type
+const DefaultCompatibility = schema.CompatibilityFull
+
+// New creates a migrator for the migration files in dir
+func New(db *database.Database, dir string) (*Migrator, error) {
+	files, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, files: files, mode: DefaultCompatibility}, nil
+}
+
+// SetCompatibilityMode makes planning refuse steps whose column changes break
+// mode instead of DefaultCompatibility. With CompatibilityNone breaking
+// changes are only reported.
+func (m *Migrator) SetCompatibilityMode(mode schema.CompatibilityMode) {
+	m.mode = mode
+}
+
+// Files returns the loaded migration files in version order
+func (m *Migrator) Files() []*File {
+	return m.files
+}
+
+// Applied returns the migrations currently in effect, oldest first
+func (m *Migrator) Applied() ([]*Applied, error) {
+	events, err := m.db.GetEventStore().ReadAllEvents()
+	if err != nil {
+		return nil, err
+	}
+	return appliedFromEvents(events)
+}
+
+// appliedFromEvents folds migration SCHEMA_EVOLVED events: an up step marks a
+// migration applied and a later down step unmarks it
+func appliedFromEvents(events []*eventlog.Event) ([]*Applied, error) {
+	applied := make(map[string]*Applied)
+
+	for _, e := range events {
+		if e.Type != eventlog.SchemaEvolved {
+			continue
+		}
+		payloadMap, ok := e.Payload.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var payload eventlog.SchemaEvolvedPayload
+		if err := storage.ConvertPayload(payloadMap, &payload); err != nil {
+			return nil, fmt.Errorf("event %d: %w", e.ID, err)
+		}
+		if payload.Migration == "" {
+			continue
+		}
+
+		switch payload.Direction {
+		case DirectionUp:
+			applied[payload.Migration] = &Applied{ID: payload.Migration, EventID: e.ID, Payload: &payload}
+		case DirectionDown:
+			delete(applied, payload.Migration)
+		}
+	}
+
+	result := make([]*Applied, 0, len(applied))
+	for _, a := range applied {
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].EventID < result[j].EventID })
+	return result, nil
+}
+
+// Status lists every migration file and whether it is applied, followed by
+// applied migrations whose files have been removed
+func (m *Migrator) Status() ([]StatusEntry, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Applied, len(applied))
+	for _, a := range applied {
+		byID[a.ID] = a
+	}
+
+	var entries []StatusEntry
+	for _, f := range m.files {
+		entry := StatusEntry{ID: f.ID, Table: f.Table}
+		if a, ok := byID[f.ID]; ok {
+			entry.Applied = true
+			entry.EventID = a.EventID
+			delete(byID, f.ID)
+		}
+		entries = append(entries, entry)
+	}
+	for _, a := range applied {
+		if _, missing := byID[a.ID]; missing {
+			entries = append(entries, StatusEntry{ID: a.ID, Table: a.Payload.TableName, Applied: true, EventID: a.EventID, FileMissing: true})
+		}
+	}
+
+	return entries, nil
+}
+
+// Pending returns the migration files that have not been applied, in version order
+func (m *Migrator) Pending() ([]*File, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[string]bool, len(applied))
+	for _, a := range applied {
+		done[a.ID] = true
+	}
+
+	var pending []*File
+	for _, f := range m.files {
+		if !done[f.ID] {
+			pending = append(pending, f)
+		}
+	}
+	return pending, nil
+}
+
+// Plan describes the steps Up would take for the next n pending migrations
+// (all of them when n <= 0), including their compatibility and dry-run results
+func (m *Migrator) Plan(n int) ([]*Step, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && n < len(pending) {
+		pending = pending[:n]
+	}
+
+	p, err := m.newPlanner()
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]*Step, 0, len(pending))
+	for _, f := range pending {
+		step, err := p.plan(f.ID, DirectionUp, f.Table, f.Up, f.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", f.ID, err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// PlanDown describes the steps Down would take to roll back the last n applied
+// migrations (one when n <= 0), newest first
+func (m *Migrator) PlanDown(n int) ([]*Step, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		n = 1
+	}
+	if n > len(applied) {
+		n = len(applied)
+	}
+
+	files := make(map[string]*File, len(m.files))
+	for _, f := range m.files {
+		files[f.ID] = f
+	}
+
+	p, err := m.newPlanner()
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]*Step, 0, n)
+	for i := len(applied) - 1; i >= len(applied)-n; i-- {
+		a := applied[i]
+		upOps, policy := schema.OpsFromEvolution(a.Payload.Evolution)
+
+		// An explicit down section wins; otherwise invert what the up step recorded
+		var ops []schema.MigrationOp
+		if f, ok := files[a.ID]; ok && f.Down != nil {
+			ops, policy = f.Down, f.Policy
+		} else {
+			ops, err = schema.InverseOps(upOps, schema.ColumnsFromDefinitions(a.Payload.OldSchema))
+			if err != nil {
+				return nil, fmt.Errorf("migration %s cannot be reversed: %w", a.ID, err)
+			}
+		}
+
+		step, err := p.plan(a.ID, DirectionDown, a.Payload.TableName, ops, policy)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", a.ID, err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Up applies the next n pending migrations (all of them when n <= 0). Every step
+// is planned and checked first; if any is refused, nothing is applied.
+func (m *Migrator) Up(n int) ([]*Step, error) {
+	steps, err := m.Plan(n)
+	if err != nil {
+		return nil, err
+	}
+	return m.apply(steps)
+}
+
+// Down rolls back the last n applied migrations (one when n <= 0), with the
+// same checks as Up
+func (m *Migrator) Down(n int) ([]*Step, error) {
+	steps, err := m.PlanDown(n)
+	if err != nil {
+		return nil, err
+	}
+	return m.apply(steps)
+}
+
+// apply records a SCHEMA_EVOLVED event for each step once all of them pass their checks
+func (m *Migrator) apply(steps []*Step) ([]*Step, error) {
+	for _, step := range steps {
+		if err := step.Refused(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, step := range steps {
+		evolution := schema.EvolutionFromOps(step.Ops, step.Policy)
+		if _, err := m.db.EvolveTable(step.Table, step.After, evolution, step.ID, step.Direction); err != nil {
+			return steps[:i], fmt.Errorf("migration %s (%s): %w", step.ID, step.Direction, err)
+		}
+	}
+	return steps, nil
+}
+
+// planner simulates a sequence of steps against the current schema, so that later
+// steps in a batch are checked against the columns and versions earlier ones produce
+type planner struct {
+	events   []*eventlog.Event
+	registry *schema.SchemaRegistry
+	handler  *storage.MigrationHandler
+	db       *database.Database
+	columns  map[string][]schema.Column
+	versions map[string]int
+}
+
+func (m *Migrator) newPlanner() (*planner, error) {
+	es := m.db.GetEventStore()
+	events, err := es.ReadAllEvents()
+	if err != nil {
+		return nil, err
+	}
+	registry, err := storage.RegistryFromEvents(events)
+	if err != nil {
+		return nil, err
+	}
+	registry.SetCompatibilityMode(m.mode)
+	return &planner{
+		events:   events,
+		registry: registry,
+		handler:  storage.NewMigrationHandler(registry),
+		db:       m.db,
+		columns:  make(map[string][]schema.Column),
+		versions: es.GetSchemaVersions(),
+	}, nil
+}
+
+// plan checks one step and advances the simulated schema past it
+func (p *planner) plan(id, direction, tableName string, ops []schema.MigrationOp, policy schema.ConversionPolicy) (*Step, error) {
+	before, ok := p.columns[tableName]
+	if !ok {
+		table, err := p.db.GetTable(tableName)
+		if err != nil {
+			return nil, err
+		}
+		before = table.Columns
+	}
+
+	after, resolved, err := schema.ApplyOpsToColumns(before, ops)
+	if err != nil {
+		return nil, err
+	}
+	if len(after) == 0 {
+		return nil, fmt.Errorf("table '%s' must keep at least one column", tableName)
+	}
+
+	// The event records the change as a schema evolution, which replays in a
+	// fixed order; the step must mean the same thing in that order
+	canonical, _ := schema.OpsFromEvolution(schema.EvolutionFromOps(resolved, policy))
+	replayed, _, err := schema.ApplyOpsToColumns(before, canonical)
+	if err != nil || !reflect.DeepEqual(replayed, after) {
+		return nil, fmt.Errorf("operations depend on their order; split them into separate migrations")
+	}
+
+	from := p.versions[tableName]
+	if from == 0 {
+		from = 1
+	}
+	to := from + 1
+
+	p.registry.RegisterSchema(tableName, from, before)
+	p.registry.RegisterSchema(tableName, to, after)
+	p.registry.RegisterMigrationWithPolicy(tableName, from, to, resolved, policy)
+
+	step := &Step{
+		ID:            id,
+		Direction:     direction,
+		Table:         tableName,
+		FromVersion:   from,
+		ToVersion:     to,
+		Before:        before,
+		After:         after,
+		Ops:           resolved,
+		Policy:        policy,
+		Compatibility: p.registry.CheckCompatibility(tableName, from, to),
+		DryRun:        p.handler.DryRun(p.events, tableName, to),
+	}
+
+	p.columns[tableName] = after
+	p.versions[tableName] = to
+	return step, nil
+}
@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"rdbms/schema"
+)
+
+// AlterStatement is a parsed ALTER TABLE statement
+type AlterStatement struct {
+	TableName  string
+	Operations []schema.MigrationOp
+}
+
+var (
+	alterTableRe = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(\w+)\s+(.+?)\s*;?\s*$`)
+	addColumnRe  = regexp.MustCompile(`(?is)^ADD\s+(?:COLUMN\s+)?(\w+)\s+(\w+)(.*)$`)
+	dropColumnRe = regexp.MustCompile(`(?is)^DROP\s+(?:COLUMN\s+)?(\w+)$`)
+	renameRe     = regexp.MustCompile(`(?is)^RENAME\s+(?:COLUMN\s+)?(\w+)\s+TO\s+(\w+)$`)
+	alterTypeRe  = regexp.MustCompile(`(?is)^(?:ALTER\s+(?:COLUMN\s+)?(\w+)\s+(?:SET\s+DATA\s+)?TYPE|MODIFY\s+(?:COLUMN\s+)?(\w+))\s+(\w+)$`)
+	defaultRe    = regexp.MustCompile(`(?is)\bDEFAULT\s+('(?:[^']*)'|"(?:[^"]*)"|\S+)`)
+)
+
+// ParseAlter parses an ALTER TABLE statement with one or more comma-separated actions:
+//
+//	ALTER TABLE users ADD [COLUMN] email TEXT [UNIQUE] [NOT NULL] [DEFAULT 'none']
+//	ALTER TABLE users DROP [COLUMN] legacy
+//	ALTER TABLE users RENAME [COLUMN] nick TO name
+//	ALTER TABLE users ALTER [COLUMN] age [SET DATA] TYPE INT
+//	ALTER TABLE users MODIFY [COLUMN] age INT
+func (p *Parser) ParseAlter(sql string) (*AlterStatement, error) {
+	matches := alterTableRe.FindStringSubmatch(strings.TrimSpace(sql))
+	if matches == nil {
+		return nil, fmt.Errorf("invalid ALTER TABLE syntax")
+	}
+
+	stmt := &AlterStatement{TableName: matches[1]}
+	for _, action := range splitOutsideQuotes(matches[2], ',') {
+		op, err := parseAlterAction(strings.TrimSpace(action))
+		if err != nil {
+			return nil, err
+		}
+		stmt.Operations = append(stmt.Operations, op)
+	}
+
+	return stmt, nil
+}
+
+// parseAlterAction parses a single ALTER TABLE action into a migration operation
+func parseAlterAction(action string) (schema.MigrationOp, error) {
+	if m := addColumnRe.FindStringSubmatch(action); m != nil {
+		colType, err := parseColumnType(m[2])
+		if err != nil {
+			return nil, err
+		}
+		op := &schema.AddColumnOp{Column: schema.Column{Name: m[1], Type: colType}}
+
+		rest := m[3]
+		if d := defaultRe.FindStringSubmatch(rest); d != nil {
+			op.Default = parseValue(d[1])
+			rest = strings.Replace(rest, d[0], "", 1)
+		}
+		words := strings.Fields(rest)
+		for i := 0; i < len(words); i++ {
+			switch strings.ToUpper(words[i]) {
+			case "UNIQUE":
+				op.Column.Unique = true
+			case "NOT":
+				if i+1 >= len(words) || strings.ToUpper(words[i+1]) != "NULL" {
+					return nil, fmt.Errorf("expected NULL after NOT in ADD")
+				}
+				op.Column.NotNull = true
+				i++
+			case "NULL":
+			default:
+				return nil, fmt.Errorf("unsupported column option in ADD: %s", words[i])
+			}
+		}
+		return op, nil
+	}
+
+	if m := dropColumnRe.FindStringSubmatch(action); m != nil {
+		return &schema.RemoveColumnOp{ColumnName: m[1]}, nil
+	}
+
+	if m := renameRe.FindStringSubmatch(action); m != nil {
+		return &schema.RenameColumnOp{OldName: m[1], NewName: m[2]}, nil
+	}
+
+	if m := alterTypeRe.FindStringSubmatch(action); m != nil {
+		name := m[1]
+		if name == "" {
+			name = m[2]
+		}
+		colType, err := parseColumnType(m[3])
+		if err != nil {
+			return nil, err
+		}
+		return &schema.ModifyColumnOp{ColumnName: name, NewDef: schema.Column{Name: name, Type: colType}}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported ALTER TABLE action: %s", action)
+}
+
+// parseColumnType validates a column type name
+func parseColumnType(name string) (schema.ColumnType, error) {
+	colType := schema.ColumnType(strings.ToUpper(name))
+	switch colType {
+	case schema.TypeInt, schema.TypeText, schema.TypeBool:
+		return colType, nil
+	}
+	return "", fmt.Errorf("unsupported column type: %s", name)
+}
+
+// splitOutsideQuotes splits s on sep, ignoring separators inside quoted strings
+func splitOutsideQuotes(s string, sep rune) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
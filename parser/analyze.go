@@ -0,0 +1,20 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+)
+
+func (p *Parser) parseAnalyze(sql string) (*ParsedStatement, error) {
+	// ANALYZE users, or ANALYZE for every table
+	re := regexp.MustCompile(`(?i)^ANALYZE(?:\s+(\w+))?\s*;?$`)
+	matches := re.FindStringSubmatch(sql)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid ANALYZE syntax")
+	}
+
+	return &ParsedStatement{
+		Type:      "ANALYZE",
+		TableName: matches[1],
+	}, nil
+}
@@ -8,8 +8,11 @@ import (
 	"rdbms/schema"
 )
 
+var primaryKeyRe = regexp.MustCompile(`(?i)^PRIMARY\s+KEY\s*\(([^)]*)\)$`)
+
 func (p *Parser) parseCreateTable(sql string) (*ParsedStatement, error) {
-	// CREATE TABLE users (id INT PRIMARY KEY, name TEXT UNIQUE, active BOOL)
+	// CREATE TABLE users (id INT PRIMARY KEY, name TEXT UNIQUE NOT NULL, active BOOL DEFAULT true)
+	// CREATE TABLE members (user_id INT, group_id INT, PRIMARY KEY (user_id, group_id))
 	re := regexp.MustCompile(`(?i)CREATE TABLE\s+(\w+)\s*\((.*)\)`)
 	matches := re.FindStringSubmatch(sql)
 	if len(matches) != 3 {
@@ -20,7 +23,21 @@ func (p *Parser) parseCreateTable(sql string) (*ParsedStatement, error) {
 	columnsStr := matches[2]
 
 	var columns []schema.Column
-	for _, colDef := range strings.Split(columnsStr, ",") {
+	var primaryKey []string
+	for _, colDef := range splitOutsideParens(columnsStr) {
+		if m := primaryKeyRe.FindStringSubmatch(strings.TrimSpace(colDef)); m != nil {
+			if primaryKey != nil {
+				return nil, fmt.Errorf("multiple PRIMARY KEY clauses")
+			}
+			for _, name := range strings.Split(m[1], ",") {
+				if name = strings.TrimSpace(name); name == "" {
+					return nil, fmt.Errorf("invalid PRIMARY KEY clause: %s", colDef)
+				}
+				primaryKey = append(primaryKey, name)
+			}
+			continue
+		}
+
 		parts := strings.Fields(strings.TrimSpace(colDef))
 		if len(parts) < 2 {
 			return nil, fmt.Errorf("invalid column definition: %s", colDef)
@@ -31,7 +48,7 @@ func (p *Parser) parseCreateTable(sql string) (*ParsedStatement, error) {
 			Type: schema.ColumnType(strings.ToUpper(parts[1])),
 		}
 
-		// Check for PRIMARY KEY or UNIQUE
+		// Check for PRIMARY KEY, UNIQUE, NOT NULL or DEFAULT
 		for i := 2; i < len(parts); i++ {
 			switch strings.ToUpper(parts[i]) {
 			case "PRIMARY":
@@ -41,6 +58,16 @@ func (p *Parser) parseCreateTable(sql string) (*ParsedStatement, error) {
 				}
 			case "UNIQUE":
 				col.Unique = true
+			case "NOT":
+				if i+1 < len(parts) && strings.ToUpper(parts[i+1]) == "NULL" {
+					col.NotNull = true
+					i++
+				}
+			case "DEFAULT":
+				if i+1 < len(parts) {
+					col.Default = parseValue(parts[i+1])
+					i++
+				}
 			}
 		}
 
@@ -48,8 +75,30 @@ func (p *Parser) parseCreateTable(sql string) (*ParsedStatement, error) {
 	}
 
 	return &ParsedStatement{
-		Type:      "CREATE_TABLE",
-		TableName: tableName,
-		Columns:   columns,
+		Type:       "CREATE_TABLE",
+		TableName:  tableName,
+		Columns:    columns,
+		PrimaryKey: primaryKey,
 	}, nil
 }
+
+// splitOutsideParens splits a comma-separated list, leaving commas inside
+// parentheses in place
+func splitOutsideParens(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -3,25 +3,26 @@ package parser
 import (
 	"fmt"
 	"regexp"
-	"strings"
 )
 
 func (p *Parser) parseDelete(sql string) (*ParsedStatement, error) {
 	// DELETE FROM users WHERE id = 1
-	re := regexp.MustCompile(`(?i)DELETE FROM\s+(\w+)\s+WHERE\s+(\w+)\s*=\s*(.+)`)
+	// DELETE FROM members WHERE user_id = 1 AND group_id = 2
+	re := regexp.MustCompile(`(?is)DELETE FROM\s+(\w+)\s+WHERE\s+(.+?)\s*;?\s*$`)
 	matches := re.FindStringSubmatch(sql)
-	if len(matches) != 4 {
+	if len(matches) != 3 {
 		return nil, fmt.Errorf("invalid DELETE syntax (WHERE required)")
 	}
 
 	tableName := matches[1]
-	column := matches[2]
-	valueStr := strings.TrimSpace(matches[3])
-	value := parseValue(valueStr)
+	where, err := parseWhere(matches[2])
+	if err != nil {
+		return nil, err
+	}
 
 	return &ParsedStatement{
 		Type:      "DELETE",
 		TableName: tableName,
-		Where:     &WhereClause{Column: column, Value: value},
+		Where:     where,
 	}, nil
 }
@@ -7,10 +7,13 @@
 // Supported SQL Operations:
 //   - CREATE TABLE: Define table schemas with columns and types
 //   - INSERT INTO: Insert rows with explicit values
-//   - SELECT: Query rows with optional WHERE clauses
+//   - SELECT: Query rows with optional WHERE clauses, a list of columns or
+//     aggregates (COUNT, SUM, AVG, MIN, MAX) and GROUP BY
 //   - UPDATE: Update rows with SET and WHERE clauses
 //   - DELETE FROM: Delete rows with WHERE clauses
 //   - JOIN: INNER JOIN with ON conditions
+//   - ANALYZE: Collect planner statistics for one table or all of them
+//   - EXPLAIN [ANALYZE]: Show a SELECT or JOIN's plan, optionally running it
 //
 // Key Responsibilities:
 //   - Tokenizing and parsing SQL strings
@@ -0,0 +1,31 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+)
+
+func (p *Parser) parseExplain(sql string) (*ParsedStatement, error) {
+	// EXPLAIN SELECT * FROM users WHERE age > 30
+	// EXPLAIN ANALYZE SELECT * FROM users JOIN orders ON users.id = orders.user_id
+	re := regexp.MustCompile(`(?is)^EXPLAIN\s+(ANALYZE\s+)?(.+)$`)
+	matches := re.FindStringSubmatch(sql)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid EXPLAIN syntax")
+	}
+
+	stmt, err := p.Parse(matches[2])
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Type != "SELECT" && stmt.Type != "JOIN" {
+		return nil, fmt.Errorf("EXPLAIN supports SELECT and JOIN, not %s", stmt.Type)
+	}
+
+	return &ParsedStatement{
+		Type:           "EXPLAIN",
+		TableName:      stmt.TableName,
+		Explain:        stmt,
+		ExplainAnalyze: matches[1] != "",
+	}, nil
+}
@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"rdbms/schema"
+)
+
+// indexColumnRe matches one column of CREATE INDEX
+var indexColumnRe = regexp.MustCompile(`^(?:` + columnRefPattern + `)$`)
+
+func (p *Parser) parseCreateIndex(sql string) (*ParsedStatement, error) {
+	if regexp.MustCompile(`(?i)^CREATE\s+FULLTEXT\b`).MatchString(sql) {
+		return p.parseCreateFullTextIndex(sql)
+	}
+
+	// CREATE UNIQUE INDEX users_email ON users (email) USING HASH
+	// CREATE UNIQUE INDEX ON users (LOWER(email))
+	// CREATE INDEX ON tasks (owner) WHERE completed = false
+	re := regexp.MustCompile(`(?is)^CREATE\s+(UNIQUE\s+)?INDEX(?:\s+(\w+))?\s+ON\s+(\w+)\s*\(((?:[^()]|\([^()]*\))*)\)(?:\s+USING\s+(\w+))?(?:\s+WHERE\s+(.+?))?\s*;?$`)
+	matches := re.FindStringSubmatch(sql)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid CREATE INDEX syntax")
+	}
+
+	var columns, nameParts []string
+	for _, col := range strings.Split(matches[4], ",") {
+		col = strings.TrimSpace(col)
+		if col == "" {
+			return nil, fmt.Errorf("invalid CREATE INDEX syntax: empty column name")
+		}
+		if !indexColumnRe.MatchString(col) {
+			return nil, fmt.Errorf("invalid index column '%s' (expected a column, LOWER(column) or UPPER(column))", col)
+		}
+		col = columnRef(col)
+		columns = append(columns, col)
+		nameParts = append(nameParts, strings.ToLower(strings.Trim(strings.NewReplacer("(", "_", ")", "").Replace(col), "_")))
+	}
+
+	def := &schema.Index{
+		Name:    matches[2],
+		Columns: columns,
+		Unique:  matches[1] != "",
+	}
+	if def.Name == "" {
+		def.Name = fmt.Sprintf("%s_%s_idx", matches[3], strings.Join(nameParts, "_"))
+	}
+	if matches[6] != "" {
+		where, err := parseWhere(matches[6])
+		if err != nil {
+			return nil, fmt.Errorf("invalid partial index predicate: %v", err)
+		}
+		def.Where = where.String()
+	}
+	switch method := strings.ToUpper(matches[5]); method {
+	case "":
+	case string(schema.IndexHash), string(schema.IndexBTree):
+		def.Method = schema.IndexMethod(method)
+	default:
+		return nil, fmt.Errorf("unknown index method '%s' (expected HASH or BTREE)", matches[5])
+	}
+
+	return &ParsedStatement{
+		Type:      "CREATE_INDEX",
+		TableName: matches[3],
+		Index:     def,
+	}, nil
+}
+
+func (p *Parser) parseCreateFullTextIndex(sql string) (*ParsedStatement, error) {
+	// CREATE FULLTEXT INDEX ON tickets (description)
+	// CREATE FULLTEXT INDEX tickets_search ON tickets (description) WITH (stemming, stopwords)
+	re := regexp.MustCompile(`(?i)^CREATE\s+FULLTEXT\s+INDEX(?:\s+(\w+))?\s+ON\s+(\w+)\s*\(\s*(\w+)\s*\)(?:\s+WITH\s*\(([^)]*)\))?\s*;?$`)
+	matches := re.FindStringSubmatch(sql)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid CREATE FULLTEXT INDEX syntax")
+	}
+
+	def := &schema.Index{
+		Name:    matches[1],
+		Columns: []string{matches[3]},
+		Method:  schema.IndexFullText,
+	}
+	if def.Name == "" {
+		def.Name = fmt.Sprintf("%s_%s_fulltext", matches[2], matches[3])
+	}
+	if matches[4] != "" {
+		for _, option := range strings.Split(matches[4], ",") {
+			switch strings.ToLower(strings.TrimSpace(option)) {
+			case "stemming":
+				def.Stemming = true
+			case "stopwords":
+				def.Stopwords = true
+			default:
+				return nil, fmt.Errorf("unknown FULLTEXT option '%s' (expected stemming or stopwords)", strings.TrimSpace(option))
+			}
+		}
+	}
+
+	return &ParsedStatement{
+		Type:      "CREATE_INDEX",
+		TableName: matches[2],
+		Index:     def,
+	}, nil
+}
+
+func (p *Parser) parseDropIndex(sql string) (*ParsedStatement, error) {
+	// DROP INDEX users_email
+	re := regexp.MustCompile(`(?i)^DROP\s+INDEX\s+(\w+)\s*;?$`)
+	matches := re.FindStringSubmatch(sql)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid DROP INDEX syntax")
+	}
+
+	return &ParsedStatement{
+		Type:  "DROP_INDEX",
+		Index: &schema.Index{Name: matches[1]},
+	}, nil
+}
@@ -2,28 +2,92 @@ package parser
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"rdbms/schema"
 )
 
-// WhereClause represents a simple WHERE condition
+// WhereClause represents a WHERE condition: a column compared with a value,
+// optionally ANDed with further conditions
 type WhereClause struct {
+	Column   string // A column, or a function applied to one: LOWER(email)
+	Operator string // =, <, <=, >, >=, BETWEEN or MATCH (full-text); empty means =
+	Value    interface{}
+	High     interface{}  // Upper bound of BETWEEN; Value is the lower bound
+	And      *WhereClause // Next condition of a conjunction, nil for the last
+}
+
+// Conditions returns the conditions of a conjunction in order
+func (w *WhereClause) Conditions() []*WhereClause {
+	var conds []*WhereClause
+	for c := w; c != nil; c = c.And {
+		conds = append(conds, c)
+	}
+	return conds
+}
+
+// String formats the conditions as SQL, e.g. "owner = 'ann' AND age BETWEEN 20 AND 30"
+func (w *WhereClause) String() string {
+	var parts []string
+	for _, c := range w.Conditions() {
+		switch c.Operator {
+		case "MATCH":
+			parts = append(parts, fmt.Sprintf("MATCH(%s) AGAINST(%s)", c.Column, FormatLiteral(c.Value)))
+		case "BETWEEN":
+			parts = append(parts, fmt.Sprintf("%s BETWEEN %s AND %s", c.Column, FormatLiteral(c.Value), FormatLiteral(c.High)))
+		case "":
+			parts = append(parts, fmt.Sprintf("%s = %s", c.Column, FormatLiteral(c.Value)))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %s %s", c.Column, c.Operator, FormatLiteral(c.Value)))
+		}
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// OrderBy represents an ORDER BY clause on one column
+type OrderBy struct {
 	Column string
-	Value  interface{}
+	Desc   bool
+}
+
+// SelectItem is one entry of a SELECT list: a column, or an aggregate over one
+type SelectItem struct {
+	Aggregate string // COUNT, SUM, AVG, MIN or MAX; empty for a plain column
+	Column    string // "*" for COUNT(*)
+}
+
+// Name is the column a SELECT list entry appears as in result rows, e.g.
+// "name" or "COUNT(*)"
+func (s SelectItem) Name() string {
+	if s.Aggregate == "" {
+		return s.Column
+	}
+	return fmt.Sprintf("%s(%s)", s.Aggregate, s.Column)
 }
 
 // ParsedStatement represents a parsed SQL statement
 type ParsedStatement struct {
-	Type          string // CREATE_TABLE, INSERT, SELECT, UPDATE, DELETE, JOIN
+	Type          string // CREATE_TABLE, CREATE_INDEX, DROP_INDEX, INSERT, SELECT, UPDATE, DELETE, JOIN, ANALYZE, EXPLAIN
 	TableName     string
 	Columns       []schema.Column
+	PrimaryKey    []string // CREATE TABLE ... PRIMARY KEY (a, b); nil when columns declare the key
 	Values        map[string]interface{}
 	Where         *WhereClause
 	SetColumn     string
 	SetValue      interface{}
 	JoinTable     string
 	JoinCondition *JoinCondition
+	Select        []SelectItem // SELECT list; nil for SELECT *
+	GroupBy       []string
+	AsOf          uint64 // SELECT ... AS OF <event ID>; 0 means current state
+	OrderBy       *OrderBy
+	Limit         int           // SELECT ... LIMIT n; 0 means no limit
+	Index         *schema.Index // CREATE INDEX definition; DROP INDEX sets only the name
+
+	// EXPLAIN [ANALYZE]: the SELECT or JOIN explained, and whether to run it
+	Explain        *ParsedStatement
+	ExplainAnalyze bool
 }
 
 // JoinCondition represents ON clause
@@ -49,6 +113,10 @@ func (p *Parser) Parse(sql string) (*ParsedStatement, error) {
 
 	if strings.HasPrefix(sqlUpper, "CREATE TABLE") {
 		return p.parseCreateTable(sql)
+	} else if regexp.MustCompile(`^CREATE\s+(UNIQUE\s+|FULLTEXT\s+)?INDEX\b`).MatchString(sqlUpper) {
+		return p.parseCreateIndex(sql)
+	} else if strings.HasPrefix(sqlUpper, "DROP INDEX") {
+		return p.parseDropIndex(sql)
 	} else if strings.HasPrefix(sqlUpper, "INSERT INTO") {
 		return p.parseInsert(sql)
 	} else if strings.HasPrefix(sqlUpper, "SELECT") {
@@ -61,6 +129,10 @@ func (p *Parser) Parse(sql string) (*ParsedStatement, error) {
 		return p.parseDelete(sql)
 	} else if strings.HasPrefix(sqlUpper, "UPDATE") {
 		return p.parseUpdate(sql)
+	} else if regexp.MustCompile(`^EXPLAIN\b`).MatchString(sqlUpper) {
+		return p.parseExplain(sql)
+	} else if regexp.MustCompile(`^ANALYZE\b`).MatchString(sqlUpper) {
+		return p.parseAnalyze(sql)
 	}
 
 	return nil, fmt.Errorf("unsupported SQL command")
@@ -3,31 +3,189 @@ package parser
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
+var (
+	selectRe  = regexp.MustCompile(`(?is)^SELECT\s+(.+?)\s+FROM\s+(\w+)(?:\s+AS\s+OF\s+(\d+))?(?:\s+WHERE\s+(.+?))?(?:\s+GROUP\s+BY\s+(\w+(?:\s*,\s*\w+)*))?(?:\s+ORDER\s+BY\s+(\w+)(?:\s+(ASC|DESC))?)?(?:\s+LIMIT\s+(\d+))?\s*;?\s*$`)
+	compareRe = regexp.MustCompile(`(?s)^(` + columnRefPattern + `)\s*(<=|>=|=|<|>)\s*(.+)$`)
+	betweenRe = regexp.MustCompile(`(?is)^(` + columnRefPattern + `)\s+BETWEEN\s+(.+?)\s+AND\s+(.+)$`)
+	itemRe    = regexp.MustCompile(`(?i)^(?:(COUNT|SUM|AVG|MIN|MAX)\s*\(\s*(\*|\w+)\s*\)|(\w+))$`)
+	matchRe   = regexp.MustCompile(`(?is)^MATCH\s*\(\s*(\w+)\s*\)\s*AGAINST\s*\(\s*('[^']*'|"[^"]*")\s*\)$`)
+
+	andRe          = regexp.MustCompile(`(?i)\s+AND\s+`)
+	betweenStartRe = regexp.MustCompile(`(?is)^(?:` + columnRefPattern + `)\s+BETWEEN\s`)
+
+	// A column, or a function applied to one: LOWER(email)
+	columnRefPattern = `(?i:LOWER|UPPER)\s*\(\s*\w+\s*\)|\w+`
+	functionRefRe    = regexp.MustCompile(`^(\w+)\s*\(\s*(\w+)\s*\)$`)
+)
+
 func (p *Parser) parseSelect(sql string) (*ParsedStatement, error) {
 	// SELECT * FROM users
 	// SELECT * FROM users WHERE name = 'Alice'
-	re := regexp.MustCompile(`(?i)SELECT\s+\*\s+FROM\s+(\w+)(?:\s+WHERE\s+(\w+)\s*=\s*(.+))?`)
-	matches := re.FindStringSubmatch(sql)
-	if len(matches) < 2 {
+	// SELECT * FROM users AS OF 42 WHERE name = 'Alice'
+	// SELECT * FROM users WHERE age BETWEEN 20 AND 30 ORDER BY age DESC LIMIT 10
+	// SELECT name, email FROM users WHERE age > 30
+	// SELECT status, COUNT(*), AVG(age) FROM users GROUP BY status ORDER BY status
+	matches := selectRe.FindStringSubmatch(strings.TrimSpace(sql))
+	if matches == nil {
+		if regexp.MustCompile(`(?i)\sAS\s+OF\b`).MatchString(sql) && !regexp.MustCompile(`(?i)\sAS\s+OF\s+\d+`).MatchString(sql) {
+			return nil, fmt.Errorf("invalid AS OF clause: expected an event ID")
+		}
 		return nil, fmt.Errorf("invalid SELECT syntax")
 	}
 
-	tableName := matches[1]
-	var where *WhereClause
+	stmt := &ParsedStatement{
+		Type:      "SELECT",
+		TableName: matches[2],
+	}
 
-	if len(matches) == 4 && matches[2] != "" {
-		column := matches[2]
-		valueStr := strings.TrimSpace(matches[3])
-		value := parseValue(valueStr)
-		where = &WhereClause{Column: column, Value: value}
+	if list := strings.TrimSpace(matches[1]); list != "*" {
+		items, err := parseSelectList(list)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Select = items
 	}
 
-	return &ParsedStatement{
-		Type:      "SELECT",
-		TableName: tableName,
-		Where:     where,
-	}, nil
+	if matches[3] != "" {
+		id, err := strconv.ParseUint(matches[3], 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid AS OF event ID: %s", matches[3])
+		}
+		stmt.AsOf = id
+	}
+
+	if matches[4] != "" {
+		where, err := parseWhere(matches[4])
+		if err != nil {
+			return nil, err
+		}
+		stmt.Where = where
+	}
+
+	if matches[5] != "" {
+		for _, col := range strings.Split(matches[5], ",") {
+			stmt.GroupBy = append(stmt.GroupBy, strings.TrimSpace(col))
+		}
+	}
+
+	if matches[6] != "" {
+		stmt.OrderBy = &OrderBy{Column: matches[6], Desc: strings.EqualFold(matches[7], "DESC")}
+	}
+
+	if matches[8] != "" {
+		limit, err := strconv.Atoi(matches[8])
+		if err != nil || limit == 0 {
+			return nil, fmt.Errorf("invalid LIMIT: %s", matches[8])
+		}
+		stmt.Limit = limit
+	}
+
+	return stmt, nil
+}
+
+// parseSelectList parses the columns and aggregates of a SELECT list, e.g.
+// "status, COUNT(*), AVG(age)"
+func parseSelectList(list string) ([]SelectItem, error) {
+	var items []SelectItem
+	for _, entry := range strings.Split(list, ",") {
+		m := itemRe.FindStringSubmatch(strings.TrimSpace(entry))
+		if m == nil {
+			return nil, fmt.Errorf("invalid SELECT list entry: %s", strings.TrimSpace(entry))
+		}
+		if m[3] != "" {
+			items = append(items, SelectItem{Column: m[3]})
+			continue
+		}
+		aggregate := strings.ToUpper(m[1])
+		if m[2] == "*" && aggregate != "COUNT" {
+			return nil, fmt.Errorf("invalid SELECT list entry: %s(*)", aggregate)
+		}
+		items = append(items, SelectItem{Aggregate: aggregate, Column: m[2]})
+	}
+	return items, nil
+}
+
+// ParseWhere parses the conditions of a WHERE clause, without the WHERE
+// keyword, such as the predicate of a partial index
+func ParseWhere(clause string) (*WhereClause, error) {
+	return parseWhere(clause)
+}
+
+// parseWhere parses a WHERE clause: one or more conditions joined by AND
+func parseWhere(clause string) (*WhereClause, error) {
+	var first, last *WhereClause
+	for _, cond := range splitConjunction(strings.TrimSpace(clause)) {
+		where, err := parseCondition(cond)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = where
+		} else {
+			last.And = where
+		}
+		last = where
+	}
+	return first, nil
+}
+
+// splitConjunction splits a WHERE clause on the ANDs that join conditions,
+// leaving the AND of a BETWEEN and any inside quoted strings in place
+func splitConjunction(clause string) []string {
+	var conds []string
+	start := 0
+	for _, loc := range andRe.FindAllStringIndex(clause, -1) {
+		cond := clause[start:loc[0]]
+		if strings.Count(clause[:loc[0]], "'")%2 == 1 || strings.Count(clause[:loc[0]], `"`)%2 == 1 {
+			continue // Inside a quoted string
+		}
+		if betweenStartRe.MatchString(cond) && !betweenRe.MatchString(cond) {
+			continue // This AND separates the bounds of a BETWEEN
+		}
+		conds = append(conds, strings.TrimSpace(cond))
+		start = loc[1]
+	}
+	return append(conds, strings.TrimSpace(clause[start:]))
+}
+
+// parseCondition parses a WHERE condition: col = v, col < v, col <= v, col > v,
+// col >= v, col BETWEEN low AND high or MATCH(col) AGAINST('query'). The
+// column of a comparison may be wrapped in LOWER or UPPER.
+func parseCondition(cond string) (*WhereClause, error) {
+	if m := matchRe.FindStringSubmatch(cond); m != nil {
+		return &WhereClause{
+			Column:   m[1],
+			Operator: "MATCH",
+			Value:    parseValue(m[2]),
+		}, nil
+	}
+	if m := betweenRe.FindStringSubmatch(cond); m != nil {
+		return &WhereClause{
+			Column:   columnRef(m[1]),
+			Operator: "BETWEEN",
+			Value:    parseValue(strings.TrimSpace(m[2])),
+			High:     parseValue(strings.TrimSpace(m[3])),
+		}, nil
+	}
+	if m := compareRe.FindStringSubmatch(cond); m != nil {
+		return &WhereClause{
+			Column:   columnRef(m[1]),
+			Operator: m[2],
+			Value:    parseValue(strings.TrimSpace(m[3])),
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid WHERE clause: %s", cond)
+}
+
+// columnRef returns a column reference in canonical form: a function applied
+// to a column is written in upper case without spaces, e.g. LOWER(email)
+func columnRef(ref string) string {
+	if m := functionRefRe.FindStringSubmatch(ref); m != nil {
+		return fmt.Sprintf("%s(%s)", strings.ToUpper(m[1]), m[2])
+	}
+	return ref
 }
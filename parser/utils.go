@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -41,3 +42,24 @@ func parseValue(str string) interface{} {
 	// Default: string
 	return str
 }
+
+// FormatLiteral formats a value as the SQL literal ParseLiteral reads back
+func FormatLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if strings.Contains(v, "'") {
+			return `"` + v + `"`
+		}
+		return "'" + v + "'"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return "NULL"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// ParseLiteral parses a single SQL literal: a quoted string, TRUE/FALSE or a number
+func ParseLiteral(str string) interface{} {
+	return parseValue(str)
+}
@@ -1,6 +1,9 @@
 package schema
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // SchemaCompatibilityCheck verifies if a row from an old schema can be read by new schema
 type SchemaCompatibilityCheck struct {
@@ -8,6 +11,7 @@ type SchemaCompatibilityCheck struct {
 	NewSchema *SchemaVersion
 	Status    CompatibilityStatus
 	Message   string
+	Report    *CompatibilityReport // Column-level analysis, nil if either version is unknown
 }
 
 type CompatibilityStatus string
@@ -18,7 +22,109 @@ const (
 	MigrationNeeded CompatibilityStatus = "MIGRATION_NEEDED"
 )
 
-// CheckCompatibility checks if two schemas are compatible
+// CompatibilityMode says which readers must be able to read which rows, as in
+// Avro and Protobuf schema registries
+type CompatibilityMode string
+
+const (
+	CompatibilityNone     CompatibilityMode = "NONE"     // Changes are reported, none are refused
+	CompatibilityBackward CompatibilityMode = "BACKWARD" // The new schema reads rows written in the old one
+	CompatibilityForward  CompatibilityMode = "FORWARD"  // The old schema reads rows written in the new one
+	CompatibilityFull     CompatibilityMode = "FULL"     // Both backward and forward
+)
+
+// ParseCompatibilityMode parses a compatibility mode name, case-insensitively
+func ParseCompatibilityMode(name string) (CompatibilityMode, error) {
+	mode := CompatibilityMode(strings.ToUpper(strings.TrimSpace(name)))
+	switch mode {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown compatibility mode: %s (expected none, backward, forward or full)", name)
+}
+
+// ChangeKind classifies one column-level difference between two schema versions
+type ChangeKind string
+
+const (
+	ColumnAdded       ChangeKind = "COLUMN_ADDED"
+	ColumnRemoved     ChangeKind = "COLUMN_REMOVED"
+	ColumnRenamed     ChangeKind = "COLUMN_RENAMED"
+	TypeWidened       ChangeKind = "TYPE_WIDENED"
+	TypeNarrowed      ChangeKind = "TYPE_NARROWED"
+	NotNullAdded      ChangeKind = "NOT_NULL_ADDED"
+	NotNullRemoved    ChangeKind = "NOT_NULL_REMOVED"
+	PrimaryKeyChanged ChangeKind = "PRIMARY_KEY_CHANGED"
+	UniqueAdded       ChangeKind = "UNIQUE_ADDED"
+	UniqueRemoved     ChangeKind = "UNIQUE_REMOVED"
+)
+
+// ColumnChange is one difference between two versions of a table's columns
+type ColumnChange struct {
+	Kind           ChangeKind
+	Column         string  // Name in the old schema, or in the new one for added columns
+	Old            *Column // nil for added columns
+	New            *Column // nil for removed columns
+	BreaksBackward bool    // Rows written in the old schema may not be readable in the new one
+	BreaksForward  bool    // Rows written in the new schema may not be readable in the old one
+	Reason         string
+}
+
+// Breaks reports whether the change violates mode
+func (c ColumnChange) Breaks(mode CompatibilityMode) bool {
+	switch mode {
+	case CompatibilityBackward:
+		return c.BreaksBackward
+	case CompatibilityForward:
+		return c.BreaksForward
+	case CompatibilityFull:
+		return c.BreaksBackward || c.BreaksForward
+	}
+	return false
+}
+
+// CompatibilityReport lists every column-level change between two versions of a
+// table and which compatibility modes each one breaks
+type CompatibilityReport struct {
+	TableName   string
+	FromVersion int
+	ToVersion   int
+	Changes     []ColumnChange
+}
+
+// Breaking returns the changes that violate mode
+func (r *CompatibilityReport) Breaking(mode CompatibilityMode) []ColumnChange {
+	var breaking []ColumnChange
+	for _, c := range r.Changes {
+		if c.Breaks(mode) {
+			breaking = append(breaking, c)
+		}
+	}
+	return breaking
+}
+
+// Compatible reports whether no change violates mode
+func (r *CompatibilityReport) Compatible(mode CompatibilityMode) bool {
+	return len(r.Breaking(mode)) == 0
+}
+
+// Level returns the strongest mode the change satisfies: FULL, BACKWARD, FORWARD or NONE
+func (r *CompatibilityReport) Level() CompatibilityMode {
+	switch {
+	case r.Compatible(CompatibilityFull):
+		return CompatibilityFull
+	case r.Compatible(CompatibilityBackward):
+		return CompatibilityBackward
+	case r.Compatible(CompatibilityForward):
+		return CompatibilityForward
+	}
+	return CompatibilityNone
+}
+
+// CheckCompatibility checks if rows written in one version of a table can be read
+// in another version of the same table. Versions are per table. The columns of the
+// two versions are compared, and the check fails if a change breaks the registry's
+// compatibility mode.
 func (sr *SchemaRegistry) CheckCompatibility(tableName string, oldVersion, newVersion int) SchemaCompatibilityCheck {
 	check := SchemaCompatibilityCheck{
 		Status:  Compatible,
@@ -41,21 +147,266 @@ func (sr *SchemaRegistry) CheckCompatibility(tableName string, oldVersion, newVe
 
 	check.OldSchema = oldSchema
 	check.NewSchema = newSchema
+	check.Report = sr.compareVersions(tableName, oldSchema, newSchema)
 
-	// Check if migration exists
-	key := fmt.Sprintf("%s_%d_to_%d", tableName, oldVersion, newVersion)
-	_, migrationExists := sr.migrations[key]
+	// Every step of the table's own version chain needs a migration; going
+	// backwards, each one also needs an inverse
+	if from, to, ok := sr.MissingMigration(tableName, oldVersion, newVersion); !ok {
+		check.Status = Incompatible
+		check.Message = fmt.Sprintf("No migration registered from v%d to v%d", from, to)
+		return check
+	}
 
-	if oldVersion < newVersion && !migrationExists {
+	if breaking := check.Report.Breaking(sr.mode); len(breaking) > 0 {
+		reasons := make([]string, len(breaking))
+		for i, c := range breaking {
+			reasons[i] = c.Reason
+		}
 		check.Status = Incompatible
-		check.Message = fmt.Sprintf("No migration registered from v%d to v%d", oldVersion, newVersion)
+		check.Message = fmt.Sprintf("Not %s compatible: %s", sr.mode, strings.Join(reasons, "; "))
 		return check
 	}
 
-	if oldVersion < newVersion {
+	if oldVersion != newVersion {
 		check.Status = MigrationNeeded
-		check.Message = fmt.Sprintf("Migration available from v%d to v%d", oldVersion, newVersion)
+		check.Message = fmt.Sprintf("Migration available from v%d to v%d (%s compatible)", oldVersion, newVersion, check.Report.Level())
 	}
 
 	return check
 }
+
+// compareVersions diffs two versions of a table, using the migrations between
+// them for what the columns alone cannot tell: which columns were renamed, and
+// the defaults given to added and removed columns
+func (sr *SchemaRegistry) compareVersions(tableName string, oldSchema, newSchema *SchemaVersion) *CompatibilityReport {
+	lo, hi := oldSchema.Version, newSchema.Version
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+
+	// Follow each column of the lower version through the up migrations
+	origin := make(map[string]string) // name in the current step -> name in lo
+	addDefaults := make(map[string]interface{})
+	removeDefaults := make(map[string]interface{})
+	for version := lo; version < hi; version++ {
+		migration, exists := sr.migrations[migrationKey(tableName, version, version+1)]
+		if !exists {
+			continue
+		}
+		for _, op := range migration.Operations {
+			switch o := op.(type) {
+			case *RenameColumnOp:
+				name, ok := origin[o.OldName]
+				if !ok {
+					name = o.OldName
+				}
+				delete(origin, o.OldName)
+				origin[o.NewName] = name
+			case *AddColumnOp:
+				if v := o.Value(); v != nil {
+					addDefaults[o.Column.Name] = v
+				}
+			case *RemoveColumnOp:
+				name, ok := origin[o.ColumnName]
+				if !ok {
+					name = o.ColumnName
+				}
+				if o.Default != nil {
+					removeDefaults[name] = o.Default
+				}
+			}
+		}
+	}
+
+	renamed := make(map[string]string)
+	for current, original := range origin {
+		if current != original {
+			renamed[original] = current
+		}
+	}
+
+	oldCols, newCols := oldSchema.Columns, newSchema.Columns
+	if oldSchema.Version > newSchema.Version {
+		// Going down, the up migration's additions are removals and vice versa
+		inverted := make(map[string]string, len(renamed))
+		for from, to := range renamed {
+			inverted[to] = from
+		}
+		renamed = inverted
+		addDefaults, removeDefaults = removeDefaults, addDefaults
+	}
+
+	report := CompareColumns(withDefaults(oldCols, removeDefaults), withDefaults(newCols, addDefaults), renamed)
+	report.TableName = tableName
+	report.FromVersion = oldSchema.Version
+	report.ToVersion = newSchema.Version
+	return report
+}
+
+// withDefaults returns columns with the given defaults filled in where they have none
+func withDefaults(columns []Column, defaults map[string]interface{}) []Column {
+	result := make([]Column, len(columns))
+	copy(result, columns)
+	for i, col := range result {
+		if col.Default == nil {
+			result[i].Default = defaults[col.Name]
+		}
+	}
+	return result
+}
+
+// CompareColumns diffs two versions of a table's columns. renamed maps old column
+// names to new ones; other columns are matched by name.
+func CompareColumns(oldCols, newCols []Column, renamed map[string]string) *CompatibilityReport {
+	report := &CompatibilityReport{}
+	add := func(c ColumnChange) {
+		report.Changes = append(report.Changes, c)
+	}
+
+	newByName := make(map[string]int, len(newCols))
+	for i, col := range newCols {
+		newByName[col.Name] = i
+	}
+	matched := make(map[string]bool, len(newCols))
+
+	for i := range oldCols {
+		oldCol := &oldCols[i]
+		name := oldCol.Name
+		if to, ok := renamed[name]; ok {
+			name = to
+		}
+		j, exists := newByName[name]
+		if !exists {
+			add(ColumnChange{
+				Kind:          ColumnRemoved,
+				Column:        oldCol.Name,
+				Old:           oldCol,
+				BreaksForward: oldCol.Default == nil,
+				Reason:        removedReason(oldCol),
+			})
+			continue
+		}
+		matched[name] = true
+		newCol := &newCols[j]
+
+		if name != oldCol.Name {
+			add(ColumnChange{
+				Kind:   ColumnRenamed,
+				Column: oldCol.Name,
+				Old:    oldCol,
+				New:    newCol,
+				Reason: fmt.Sprintf("column '%s' renamed to '%s'", oldCol.Name, name),
+			})
+		}
+
+		if oldCol.Type != newCol.Type {
+			change := ColumnChange{Column: oldCol.Name, Old: oldCol, New: newCol}
+			if widens(oldCol.Type, newCol.Type) {
+				change.Kind = TypeWidened
+				change.BreaksForward = true
+				change.Reason = fmt.Sprintf("column '%s' widened from %s to %s; new values may not fit the old type", oldCol.Name, oldCol.Type, newCol.Type)
+			} else {
+				change.Kind = TypeNarrowed
+				change.BreaksBackward = true
+				change.Reason = fmt.Sprintf("column '%s' narrowed from %s to %s; old values may not fit the new type", oldCol.Name, oldCol.Type, newCol.Type)
+			}
+			add(change)
+		}
+
+		if oldCol.NotNull != newCol.NotNull {
+			if newCol.NotNull {
+				add(ColumnChange{
+					Kind: NotNullAdded, Column: oldCol.Name, Old: oldCol, New: newCol, BreaksBackward: true,
+					Reason: fmt.Sprintf("column '%s' became NOT NULL; old rows may hold NULL", oldCol.Name),
+				})
+			} else {
+				add(ColumnChange{
+					Kind: NotNullRemoved, Column: oldCol.Name, Old: oldCol, New: newCol, BreaksForward: true,
+					Reason: fmt.Sprintf("column '%s' is no longer NOT NULL; new rows may hold NULL", oldCol.Name),
+				})
+			}
+		}
+
+		if oldCol.PrimaryKey != newCol.PrimaryKey {
+			add(ColumnChange{
+				Kind: PrimaryKeyChanged, Column: oldCol.Name, Old: oldCol, New: newCol, BreaksBackward: true, BreaksForward: true,
+				Reason: fmt.Sprintf("column '%s' %s the primary key; rows are identified differently", oldCol.Name, joinedOrLeft(newCol.PrimaryKey)),
+			})
+		}
+
+		if oldCol.Unique != newCol.Unique {
+			if newCol.Unique {
+				add(ColumnChange{
+					Kind: UniqueAdded, Column: oldCol.Name, Old: oldCol, New: newCol, BreaksBackward: true,
+					Reason: fmt.Sprintf("column '%s' became UNIQUE; old rows may hold duplicates", oldCol.Name),
+				})
+			} else {
+				add(ColumnChange{
+					Kind: UniqueRemoved, Column: oldCol.Name, Old: oldCol, New: newCol, BreaksForward: true,
+					Reason: fmt.Sprintf("column '%s' is no longer UNIQUE; new rows may hold duplicates", oldCol.Name),
+				})
+			}
+		}
+	}
+
+	for i := range newCols {
+		newCol := &newCols[i]
+		if matched[newCol.Name] {
+			continue
+		}
+		add(ColumnChange{
+			Kind:           ColumnAdded,
+			Column:         newCol.Name,
+			New:            newCol,
+			BreaksBackward: newCol.NotNull && newCol.Default == nil,
+			Reason:         addedReason(newCol),
+		})
+		if newCol.PrimaryKey || newCol.Unique {
+			kind := UniqueAdded
+			if newCol.PrimaryKey {
+				kind = PrimaryKeyChanged
+			}
+			add(ColumnChange{
+				Kind: kind, Column: newCol.Name, New: newCol, BreaksBackward: true,
+				Reason: fmt.Sprintf("new column '%s' must be unique, but old rows all get the same value", newCol.Name),
+			})
+		}
+	}
+
+	return report
+}
+
+// widens reports whether every value of type from has a value of type to
+func widens(from, to ColumnType) bool {
+	switch from {
+	case TypeBool:
+		return to == TypeInt || to == TypeText
+	case TypeInt:
+		return to == TypeText
+	}
+	return false
+}
+
+func removedReason(col *Column) string {
+	if col.Default == nil {
+		return fmt.Sprintf("column '%s' removed without a default; old readers get no value", col.Name)
+	}
+	return fmt.Sprintf("column '%s' removed; old readers get its default %v", col.Name, col.Default)
+}
+
+func addedReason(col *Column) string {
+	switch {
+	case col.NotNull && col.Default == nil:
+		return fmt.Sprintf("NOT NULL column '%s' added without a default; old rows have no value", col.Name)
+	case col.Default == nil:
+		return fmt.Sprintf("column '%s' added; old rows read it as NULL", col.Name)
+	}
+	return fmt.Sprintf("column '%s' added; old rows read its default %v", col.Name, col.Default)
+}
+
+func joinedOrLeft(primaryKey bool) string {
+	if primaryKey {
+		return "joined"
+	}
+	return "left"
+}
@@ -0,0 +1,140 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FailureAction says what a migration does with a value it cannot convert
+type FailureAction string
+
+const (
+	FailOnError   FailureAction = "error"       // Abort the row's migration (the default)
+	SetNull       FailureAction = "null"        // Store NULL instead
+	UseDefault    FailureAction = "default"     // Store the policy's default instead
+	DeadLetterRow FailureAction = "dead_letter" // Move the whole row to the dead-letter table
+)
+
+// ConversionPolicy configures how a migration handles failed type conversions
+type ConversionPolicy struct {
+	OnFailure FailureAction
+	Default   interface{} // Value stored when OnFailure is UseDefault
+}
+
+// ConversionError describes a value that could not be converted between column types
+type ConversionError struct {
+	Table  string
+	Column string
+	Value  interface{}
+	From   ColumnType
+	To     ColumnType
+	Reason string
+	Action FailureAction // How the migration's policy handled the failure
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("cannot convert %s.%s value %v from %s to %s: %s", e.Table, e.Column, e.Value, e.From, e.To, e.Reason)
+}
+
+// IsDeadLetter reports whether err asks for the row to be routed to the dead-letter table
+func IsDeadLetter(err error) bool {
+	var convErr *ConversionError
+	return errors.As(err, &convErr) && convErr.Action == DeadLetterRow
+}
+
+// DeadLetterTable returns the name of the table that collects rows of tableName
+// whose migration failed under a dead-letter policy
+func DeadLetterTable(tableName string) string {
+	return tableName + deadLetterSuffix
+}
+
+// deadLetterSuffix ends the name of every dead-letter table
+const deadLetterSuffix = "_dead_letter"
+
+// MigrationErrorTable is the derived table listing rows that could not be
+// migrated to their table's schema version and were left unmigrated
+const MigrationErrorTable = "_migration_errors"
+
+// IsSystemTable reports whether tableName is a derived table that collects rows
+// whose migration failed, rather than a table in the catalog. These names are
+// reserved, so no table in the catalog can take one.
+func IsSystemTable(tableName string) bool {
+	return tableName == MigrationErrorTable || strings.HasSuffix(tableName, deadLetterSuffix)
+}
+
+// ConvertValue converts a value stored in a column of type from to type to.
+// NULL converts to NULL; INT values are produced as float64, like decoded JSON.
+func ConvertValue(val interface{}, from, to ColumnType) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+
+	switch to {
+	case TypeInt:
+		switch v := val.(type) {
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, fmt.Errorf("%v is not a whole number", v)
+			}
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case bool:
+			if v {
+				return float64(1), nil
+			}
+			return float64(0), nil
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || n != math.Trunc(n) {
+				return nil, fmt.Errorf("%q is not an integer", v)
+			}
+			return n, nil
+		}
+
+	case TypeText:
+		switch v := val.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case int:
+			return strconv.Itoa(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+
+	case TypeBool:
+		switch v := val.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a boolean", v)
+			}
+			return b, nil
+		case float64, int, int64:
+			n, _ := ConvertValue(v, from, TypeInt)
+			switch n {
+			case float64(0):
+				return false, nil
+			case float64(1):
+				return true, nil
+			}
+			return nil, fmt.Errorf("%v is not 0 or 1", v)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported column type %s", to)
+	}
+
+	return nil, fmt.Errorf("unexpected %T value for %s column", val, from)
+}
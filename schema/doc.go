@@ -24,6 +24,10 @@
 //   - Structuring schema metadata for serialization
 //   - Supporting schema evolution and migration
 //
+// Schema versions are numbered per table: a table starts at version 1 and each
+// SCHEMA_EVOLVED event for it bumps only its own version. Migrations are keyed by
+// table and version, so evolving one table never affects another's migrations.
+//
 // Usage Example:
 //
 //	// Define columns
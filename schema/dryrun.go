@@ -0,0 +1,81 @@
+package schema
+
+import "errors"
+
+// maxDryRunSamples caps the failures kept in a dry-run report
+const maxDryRunSamples = 10
+
+// VersionedRow is a row together with the schema version it was written in
+type VersionedRow struct {
+	Data    map[string]interface{}
+	Version int
+}
+
+// DryRunReport summarizes what migrating a table's rows would do, without changing them
+type DryRunReport struct {
+	Table        string
+	ToVersion    int
+	Rows         int // Rows examined
+	Migrated     int // Rows that would migrate, including those whose failures the policy absorbs
+	Failed       int // Rows with at least one failed conversion or another migration error
+	Nulled       int // Failed values that would be set to NULL
+	Defaulted    int // Failed values that would be replaced by the policy default
+	DeadLettered int // Rows that would be moved to the dead-letter table
+	Aborted      int // Rows whose migration would fail outright
+	Samples      []string
+}
+
+// DryRunMigration reports how migrating rows of a table to toVersion would go,
+// so failures can be reviewed before the migration is committed
+func (sr *SchemaRegistry) DryRunMigration(tableName string, rows []VersionedRow, toVersion int) *DryRunReport {
+	report := &DryRunReport{Table: tableName, ToVersion: toVersion}
+
+	for _, row := range rows {
+		report.Rows++
+		_, failures, err := sr.migrateRow(tableName, row.Data, row.Version, toVersion)
+
+		for _, f := range failures {
+			switch f.Action {
+			case SetNull:
+				report.Nulled++
+			case UseDefault:
+				report.Defaulted++
+			}
+			report.sample(f)
+		}
+
+		if err != nil {
+			if IsDeadLetter(err) {
+				report.DeadLettered++
+			} else {
+				report.Aborted++
+			}
+			report.sample(err)
+		} else {
+			report.Migrated++
+		}
+
+		if err != nil || len(failures) > 0 {
+			report.Failed++
+		}
+	}
+
+	return report
+}
+
+// HasFailures reports whether any row would lose data or fail to migrate
+func (r *DryRunReport) HasFailures() bool {
+	return r.Failed > 0
+}
+
+func (r *DryRunReport) sample(err error) {
+	if len(r.Samples) >= maxDryRunSamples {
+		return
+	}
+	var convErr *ConversionError
+	if errors.As(err, &convErr) {
+		r.Samples = append(r.Samples, convErr.Error()+" ("+string(convErr.Action)+")")
+		return
+	}
+	r.Samples = append(r.Samples, err.Error())
+}
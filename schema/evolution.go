@@ -0,0 +1,197 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+
+	"rdbms/eventlog"
+)
+
+// ColumnsFromDefinitions converts event log column definitions to schema columns
+func ColumnsFromDefinitions(defs []eventlog.ColumnDefinition) []Column {
+	columns := make([]Column, len(defs))
+	for i, def := range defs {
+		columns[i] = Column{
+			Name:       def.Name,
+			Type:       ColumnType(def.Type),
+			PrimaryKey: def.PrimaryKey,
+			Unique:     def.Unique,
+			NotNull:    !def.Nullable,
+			Default:    def.Default,
+		}
+	}
+	return columns
+}
+
+// ApplyOpsToColumns applies migration operations to a column list, returning the
+// new columns and the operations with each ModifyColumnOp's old definition filled in
+func ApplyOpsToColumns(columns []Column, ops []MigrationOp) ([]Column, []MigrationOp, error) {
+	result := make([]Column, len(columns))
+	copy(result, columns)
+	resolved := make([]MigrationOp, 0, len(ops))
+
+	find := func(name string) int {
+		for i, col := range result {
+			if col.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for _, op := range ops {
+		switch o := op.(type) {
+		case *AddColumnOp:
+			if find(o.Column.Name) >= 0 {
+				return nil, nil, fmt.Errorf("column '%s' already exists", o.Column.Name)
+			}
+			col := o.Column
+			col.Default = o.Value()
+			result = append(result, col)
+			resolved = append(resolved, o)
+
+		case *RemoveColumnOp:
+			i := find(o.ColumnName)
+			if i < 0 {
+				return nil, nil, fmt.Errorf("column '%s' does not exist", o.ColumnName)
+			}
+			if result[i].PrimaryKey {
+				return nil, nil, fmt.Errorf("cannot drop primary key column '%s'", o.ColumnName)
+			}
+			result = append(result[:i], result[i+1:]...)
+			resolved = append(resolved, o)
+
+		case *ModifyColumnOp:
+			i := find(o.ColumnName)
+			if i < 0 {
+				return nil, nil, fmt.Errorf("column '%s' does not exist", o.ColumnName)
+			}
+			newDef := o.NewDef
+			if newDef.Name == "" {
+				newDef.Name = o.ColumnName
+			}
+			if newDef.Type == "" {
+				newDef.Type = result[i].Type
+			}
+			resolved = append(resolved, &ModifyColumnOp{ColumnName: o.ColumnName, OldDef: result[i], NewDef: newDef})
+			result[i] = newDef
+
+		case *RenameColumnOp:
+			i := find(o.OldName)
+			if i < 0 {
+				return nil, nil, fmt.Errorf("column '%s' does not exist", o.OldName)
+			}
+			if find(o.NewName) >= 0 {
+				return nil, nil, fmt.Errorf("column '%s' already exists", o.NewName)
+			}
+			result[i].Name = o.NewName
+			resolved = append(resolved, o)
+
+		default:
+			return nil, nil, fmt.Errorf("unknown migration operation type: %T", op)
+		}
+	}
+
+	return result, resolved, nil
+}
+
+// EvolutionFromOps describes resolved migration operations as a schema evolution
+func EvolutionFromOps(ops []MigrationOp, policy ConversionPolicy) eventlog.SchemaEvolution {
+	var evolution eventlog.SchemaEvolution
+	for _, op := range ops {
+		switch o := op.(type) {
+		case *AddColumnOp:
+			evolution.AddedColumns = append(evolution.AddedColumns, eventlog.ColumnDefinition{
+				Name:       o.Column.Name,
+				Type:       string(o.Column.Type),
+				Nullable:   !o.Column.NotNull,
+				PrimaryKey: o.Column.PrimaryKey,
+				Unique:     o.Column.Unique,
+				Default:    o.Value(),
+			})
+
+		case *RemoveColumnOp:
+			evolution.RemovedColumns = append(evolution.RemovedColumns, o.ColumnName)
+
+		case *ModifyColumnOp:
+			evolution.ModifiedColumns = append(evolution.ModifiedColumns, eventlog.ColumnModification{
+				Name:   o.ColumnName,
+				OldDef: columnDefinition(o.OldDef),
+				NewDef: columnDefinition(o.NewDef),
+			})
+
+		case *RenameColumnOp:
+			if evolution.RenamedColumns == nil {
+				evolution.RenamedColumns = make(map[string]string)
+			}
+			evolution.RenamedColumns[o.OldName] = o.NewName
+		}
+	}
+
+	if policy.OnFailure != "" && policy.OnFailure != FailOnError {
+		evolution.OnConversionFailure = string(policy.OnFailure)
+		evolution.ConversionDefault = policy.Default
+	}
+	return evolution
+}
+
+// OpsFromEvolution rebuilds migration operations from a recorded schema evolution:
+// renames first, then modifications, removals and additions
+func OpsFromEvolution(evolution eventlog.SchemaEvolution) ([]MigrationOp, ConversionPolicy) {
+	var ops []MigrationOp
+
+	oldNames := make([]string, 0, len(evolution.RenamedColumns))
+	for oldName := range evolution.RenamedColumns {
+		oldNames = append(oldNames, oldName)
+	}
+	sort.Strings(oldNames)
+	for _, oldName := range oldNames {
+		ops = append(ops, &RenameColumnOp{OldName: oldName, NewName: evolution.RenamedColumns[oldName]})
+	}
+
+	for _, m := range evolution.ModifiedColumns {
+		ops = append(ops, &ModifyColumnOp{
+			ColumnName: m.Name,
+			OldDef:     ColumnsFromDefinitions([]eventlog.ColumnDefinition{m.OldDef})[0],
+			NewDef:     ColumnsFromDefinitions([]eventlog.ColumnDefinition{m.NewDef})[0],
+		})
+	}
+
+	for _, name := range evolution.RemovedColumns {
+		ops = append(ops, &RemoveColumnOp{ColumnName: name})
+	}
+
+	for _, def := range evolution.AddedColumns {
+		ops = append(ops, &AddColumnOp{
+			Column:  ColumnsFromDefinitions([]eventlog.ColumnDefinition{def})[0],
+			Default: def.Default,
+		})
+	}
+
+	policy := ConversionPolicy{OnFailure: FailOnError}
+	if evolution.OnConversionFailure != "" {
+		policy = ConversionPolicy{OnFailure: FailureAction(evolution.OnConversionFailure), Default: evolution.ConversionDefault}
+	}
+	return ops, policy
+}
+
+// ColumnDefinitions converts schema columns to event log column definitions
+func ColumnDefinitions(columns []Column) []eventlog.ColumnDefinition {
+	defs := make([]eventlog.ColumnDefinition, len(columns))
+	for i, col := range columns {
+		defs[i] = columnDefinition(col)
+	}
+	return defs
+}
+
+// columnDefinition converts a schema column to an event log column definition
+func columnDefinition(col Column) eventlog.ColumnDefinition {
+	return eventlog.ColumnDefinition{
+		Name:       col.Name,
+		Type:       string(col.Type),
+		Nullable:   !col.NotNull,
+		PrimaryKey: col.PrimaryKey,
+		Unique:     col.Unique,
+		Default:    col.Default,
+	}
+}
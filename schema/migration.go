@@ -8,6 +8,10 @@ type Migration struct {
 	ToVersion   int
 	// Operations applied in order
 	Operations []MigrationOp
+	// Down undoes Operations when migrating backwards; nil means derive it
+	Down []MigrationOp
+	// Policy handles values a ModifyColumnOp cannot convert
+	Policy ConversionPolicy
 }
 
 // MigrationOp represents a single schema change operation
@@ -19,12 +23,23 @@ type AddColumnOp struct {
 	Default interface{} // Value for existing rows
 }
 
+// Value returns what existing rows get for the new column: the operation's
+// default, or else the column's own
+func (o *AddColumnOp) Value() interface{} {
+	if o.Default != nil {
+		return o.Default
+	}
+	return o.Column.Default
+}
+
 // RemoveColumnOp removes a column
 type RemoveColumnOp struct {
 	ColumnName string
+	Default    interface{} // Value restored when the migration is reversed
 }
 
-// ModifyColumnOp changes column properties
+// ModifyColumnOp changes column properties. Changing the type converts existing
+// values with ConvertValue; failures are handled by the migration's policy.
 type ModifyColumnOp struct {
 	ColumnName string
 	OldDef     Column
@@ -37,19 +52,54 @@ type RenameColumnOp struct {
 	NewName string
 }
 
-// applyMigration applies a single migration to a row
-func applyMigration(row map[string]interface{}, migration *Migration) (map[string]interface{}, error) {
+// InverseOps derives the operations that undo ops, in reverse order. Adds,
+// removes, renames and type changes are invertible; removed columns come back
+// with their RemoveColumnOp default, typed from the column's definition in
+// before when it is known.
+func InverseOps(ops []MigrationOp, before []Column) ([]MigrationOp, error) {
+	inverse := make([]MigrationOp, 0, len(ops))
+	for i := len(ops) - 1; i >= 0; i-- {
+		switch o := ops[i].(type) {
+		case *AddColumnOp:
+			inverse = append(inverse, &RemoveColumnOp{ColumnName: o.Column.Name, Default: o.Value()})
+
+		case *RemoveColumnOp:
+			col := Column{Name: o.ColumnName}
+			for _, c := range before {
+				if c.Name == o.ColumnName {
+					col = c
+				}
+			}
+			inverse = append(inverse, &AddColumnOp{Column: col, Default: o.Default})
+
+		case *ModifyColumnOp:
+			inverse = append(inverse, &ModifyColumnOp{ColumnName: o.ColumnName, OldDef: o.NewDef, NewDef: o.OldDef})
+
+		case *RenameColumnOp:
+			inverse = append(inverse, &RenameColumnOp{OldName: o.NewName, NewName: o.OldName})
+
+		default:
+			return nil, fmt.Errorf("cannot derive inverse of migration operation %T", ops[i])
+		}
+	}
+	return inverse, nil
+}
+
+// applyMigration applies ops, the operations of a migration in either direction,
+// to a row. Conversion failures the migration's policy absorbed are returned alongside the row; any other failure is the error.
+func applyMigration(tableName string, row map[string]interface{}, migration *Migration, ops []MigrationOp) (map[string]interface{}, []*ConversionError, error) {
 	result := make(map[string]interface{})
 	for k, v := range row {
 		result[k] = v
 	}
 
-	for _, op := range migration.Operations {
+	var failures []*ConversionError
+	for _, op := range ops {
 		switch o := op.(type) {
 		case *AddColumnOp:
 			// Add new column with default value if not present
 			if _, exists := result[o.Column.Name]; !exists {
-				result[o.Column.Name] = o.Default
+				result[o.Column.Name] = o.Value()
 			}
 
 		case *RemoveColumnOp:
@@ -57,11 +107,38 @@ func applyMigration(row map[string]interface{}, migration *Migration) (map[strin
 			delete(result, o.ColumnName)
 
 		case *ModifyColumnOp:
-			// For now, just keep the value as-is
-			// In a real system, might need type conversion
-			if val, exists := result[o.ColumnName]; exists {
-				result[o.ColumnName] = val
+			val, exists := result[o.ColumnName]
+			if !exists || o.OldDef.Type == o.NewDef.Type || o.NewDef.Type == "" {
+				continue
+			}
+
+			converted, err := ConvertValue(val, o.OldDef.Type, o.NewDef.Type)
+			if err == nil {
+				result[o.ColumnName] = converted
+				continue
+			}
+
+			failure := &ConversionError{
+				Table:  tableName,
+				Column: o.ColumnName,
+				Value:  val,
+				From:   o.OldDef.Type,
+				To:     o.NewDef.Type,
+				Reason: err.Error(),
+				Action: migration.Policy.OnFailure,
+			}
+			switch failure.Action {
+			case SetNull:
+				result[o.ColumnName] = nil
+			case UseDefault:
+				result[o.ColumnName] = migration.Policy.Default
+			case DeadLetterRow:
+				return nil, failures, failure
+			default:
+				failure.Action = FailOnError
+				return nil, failures, failure
 			}
+			failures = append(failures, failure)
 
 		case *RenameColumnOp:
 			// Rename column
@@ -71,9 +148,9 @@ func applyMigration(row map[string]interface{}, migration *Migration) (map[strin
 			}
 
 		default:
-			return nil, fmt.Errorf("unknown migration operation type: %T", op)
+			return nil, failures, fmt.Errorf("unknown migration operation type: %T", op)
 		}
 	}
 
-	return result, nil
+	return result, failures, nil
 }
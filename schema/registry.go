@@ -4,12 +4,20 @@ import (
 	"fmt"
 )
 
-// SchemaRegistry tracks all schema versions for all tables
+// SchemaRegistry tracks all schema versions for all tables. Versions are
+// numbered per table: evolving one table never changes another's version.
 type SchemaRegistry struct {
 	// tableName -> version -> SchemaVersion
 	schemas map[string]map[int]*SchemaVersion
 	// Registered migrations: (tableName, fromVer, toVer) -> Migration
 	migrations map[string]*Migration
+	// Which compatibility CheckCompatibility enforces
+	mode CompatibilityMode
+}
+
+// migrationKey identifies a migration between two versions of one table
+func migrationKey(tableName string, fromVer, toVer int) string {
+	return fmt.Sprintf("%s_%d_to_%d", tableName, fromVer, toVer)
 }
 
 // NewSchemaRegistry creates a new schema registry
@@ -17,9 +25,21 @@ func NewSchemaRegistry() *SchemaRegistry {
 	return &SchemaRegistry{
 		schemas:    make(map[string]map[int]*SchemaVersion),
 		migrations: make(map[string]*Migration),
+		mode:       CompatibilityNone,
 	}
 }
 
+// SetCompatibilityMode sets which compatibility CheckCompatibility enforces.
+// With CompatibilityNone, breaking changes are reported but not refused.
+func (sr *SchemaRegistry) SetCompatibilityMode(mode CompatibilityMode) {
+	sr.mode = mode
+}
+
+// CompatibilityMode returns the compatibility CheckCompatibility enforces
+func (sr *SchemaRegistry) CompatibilityMode() CompatibilityMode {
+	return sr.mode
+}
+
 // RegisterSchema registers a schema version
 func (sr *SchemaRegistry) RegisterSchema(tableName string, version int, columns []Column) {
 	if _, exists := sr.schemas[tableName]; !exists {
@@ -33,16 +53,39 @@ func (sr *SchemaRegistry) RegisterSchema(tableName string, version int, columns
 	}
 }
 
-// RegisterMigration registers a migration between two schema versions
+// RegisterMigration registers a migration between two schema versions.
+// Failed type conversions abort the row's migration.
 func (sr *SchemaRegistry) RegisterMigration(tableName string, fromVer, toVer int, ops []MigrationOp) {
-	migration := &Migration{
+	sr.RegisterMigrationWithPolicy(tableName, fromVer, toVer, ops, ConversionPolicy{OnFailure: FailOnError})
+}
+
+// RegisterMigrationWithPolicy registers a migration whose failed type conversions
+// are handled by policy
+func (sr *SchemaRegistry) RegisterMigrationWithPolicy(tableName string, fromVer, toVer int, ops []MigrationOp, policy ConversionPolicy) {
+	sr.registerMigration(tableName, &Migration{
 		FromVersion: fromVer,
 		ToVersion:   toVer,
 		Operations:  ops,
-	}
+		Policy:      policy,
+	})
+}
 
-	key := fmt.Sprintf("%s_%d_to_%d", tableName, fromVer, toVer)
-	sr.migrations[key] = migration
+// RegisterReversibleMigration registers a migration with explicit down operations,
+// for changes whose inverse cannot be derived
+func (sr *SchemaRegistry) RegisterReversibleMigration(tableName string, fromVer, toVer int, up, down []MigrationOp) {
+	sr.registerMigration(tableName, &Migration{
+		FromVersion: fromVer,
+		ToVersion:   toVer,
+		Operations:  up,
+		Down:        down,
+		Policy:      ConversionPolicy{OnFailure: FailOnError},
+	})
+}
+
+func (sr *SchemaRegistry) registerMigration(tableName string, migration *Migration) {
+	fromVer, toVer := migration.FromVersion, migration.ToVersion
+
+	sr.migrations[migrationKey(tableName, fromVer, toVer)] = migration
 
 	// Also register in schema version for reference
 	if schema, exists := sr.schemas[tableName][toVer]; exists {
@@ -74,15 +117,56 @@ func (sr *SchemaRegistry) GetLatestSchemaVersion(tableName string) int {
 	return 0
 }
 
-// MigrateRow applies a series of migrations to a row
+// MissingMigration returns the first step on a table's path from one version to
+// another, in either direction, that has no usable migration, or ok=true if the
+// path is complete. Backward steps also need an explicit or derivable inverse.
+func (sr *SchemaRegistry) MissingMigration(tableName string, fromVersion, toVersion int) (from, to int, ok bool) {
+	for version := fromVersion; version < toVersion; version++ {
+		if _, exists := sr.migrations[migrationKey(tableName, version, version+1)]; !exists {
+			return version, version + 1, false
+		}
+	}
+	for version := fromVersion; version > toVersion; version-- {
+		if _, err := sr.downOps(tableName, version-1, version); err != nil {
+			return version, version - 1, false
+		}
+	}
+	return 0, 0, true
+}
+
+// downOps returns the operations that take a row from toVer back to fromVer
+func (sr *SchemaRegistry) downOps(tableName string, fromVer, toVer int) ([]MigrationOp, error) {
+	migration, exists := sr.migrations[migrationKey(tableName, fromVer, toVer)]
+	if !exists {
+		return nil, fmt.Errorf("no migration path from %s v%d to v%d", tableName, toVer, fromVer)
+	}
+	if migration.Down != nil {
+		return migration.Down, nil
+	}
+
+	var before []Column
+	if sv, exists := sr.schemas[tableName][fromVer]; exists {
+		before = sv.Columns
+	}
+	down, err := InverseOps(migration.Operations, before)
+	if err != nil {
+		return nil, fmt.Errorf("%s v%d to v%d is not reversible: %w", tableName, toVer, fromVer, err)
+	}
+	return down, nil
+}
+
+// MigrateRow applies a series of migrations to a row, forwards or backwards.
+// Backward steps use each migration's down operations, derived if not declared.
 // Returns the migrated row or error if migration path doesn't exist
 func (sr *SchemaRegistry) MigrateRow(tableName string, row map[string]interface{}, fromVersion, toVersion int) (map[string]interface{}, error) {
-	if fromVersion == toVersion {
-		return row, nil
-	}
+	migratedRow, _, err := sr.migrateRow(tableName, row, fromVersion, toVersion)
+	return migratedRow, err
+}
 
-	if fromVersion > toVersion {
-		return nil, fmt.Errorf("cannot migrate backwards from version %d to %d", fromVersion, toVersion)
+// migrateRow is MigrateRow that also returns the conversion failures absorbed by policies
+func (sr *SchemaRegistry) migrateRow(tableName string, row map[string]interface{}, fromVersion, toVersion int) (map[string]interface{}, []*ConversionError, error) {
+	if fromVersion == toVersion {
+		return row, nil, nil
 	}
 
 	migratedRow := make(map[string]interface{})
@@ -91,20 +175,37 @@ func (sr *SchemaRegistry) MigrateRow(tableName string, row map[string]interface{
 	}
 
 	// Apply migrations sequentially
+	var failures []*ConversionError
+
+	// Walk back down the chain, undoing one migration at a time
+	for version := fromVersion; version > toVersion; version-- {
+		down, err := sr.downOps(tableName, version-1, version)
+		if err != nil {
+			return nil, failures, err
+		}
+
+		var stepFailures []*ConversionError
+		migratedRow, stepFailures, err = applyMigration(tableName, migratedRow, sr.migrations[migrationKey(tableName, version-1, version)], down)
+		failures = append(failures, stepFailures...)
+		if err != nil {
+			return nil, failures, err
+		}
+	}
 	for version := fromVersion; version < toVersion; version++ {
-		key := fmt.Sprintf("%s_%d_to_%d", tableName, version, version+1)
-		migration, exists := sr.migrations[key]
+		migration, exists := sr.migrations[migrationKey(tableName, version, version+1)]
 		if !exists {
-			return nil, fmt.Errorf("no migration path from %s v%d to v%d", tableName, version, version+1)
+			return nil, failures, fmt.Errorf("no migration path from %s v%d to v%d", tableName, version, version+1)
 		}
 
 		// Apply each migration operation sequentially
+		var stepFailures []*ConversionError
 		var err error
-		migratedRow, err = applyMigration(migratedRow, migration)
+		migratedRow, stepFailures, err = applyMigration(tableName, migratedRow, migration, migration.Operations)
+		failures = append(failures, stepFailures...)
 		if err != nil {
-			return nil, err
+			return nil, failures, err
 		}
 	}
 
-	return migratedRow, nil
+	return migratedRow, failures, nil
 }
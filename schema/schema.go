@@ -11,15 +11,68 @@ const (
 
 // Column defines a table column
 type Column struct {
-	Name       string     `json:"name"`
-	Type       ColumnType `json:"type"`
-	PrimaryKey bool       `json:"primary_key"`
-	Unique     bool       `json:"unique"`
+	Name       string      `json:"name"`
+	Type       ColumnType  `json:"type"`
+	PrimaryKey bool        `json:"primary_key"`
+	Unique     bool        `json:"unique"`
+	NotNull    bool        `json:"not_null,omitempty"`
+	Default    interface{} `json:"default,omitempty"` // Value for rows that have none
+}
+
+// IndexMethod is the data structure behind an index
+type IndexMethod string
+
+const (
+	IndexHash     IndexMethod = "HASH"     // Equality lookups only
+	IndexBTree    IndexMethod = "BTREE"    // Ordered: equality, ranges and ORDER BY
+	IndexFullText IndexMethod = "FULLTEXT" // Inverted index over a TEXT column for MATCH ... AGAINST
+)
+
+// Index defines a user-created secondary index
+type Index struct {
+	Name    string      `json:"name"`
+	Columns []string    `json:"columns"` // Column names, or expressions such as LOWER(email)
+	Unique  bool        `json:"unique,omitempty"`
+	Method  IndexMethod `json:"method"`
+
+	// Predicate of a partial index, in WHERE clause syntax: only rows
+	// satisfying it are indexed
+	Where string `json:"where,omitempty"`
+
+	// Analysis options of a FULLTEXT index
+	Stemming  bool `json:"stemming,omitempty"`  // Index word stems, so "crashed" matches "crash"
+	Stopwords bool `json:"stopwords,omitempty"` // Leave common words such as "the" out
 }
 
 // Table holds table metadata
 type Table struct {
 	Name       string   `json:"name"`
 	Columns    []Column `json:"columns"`
-	PrimaryKey string   `json:"primary_key"` // column name
+	PrimaryKey string   `json:"primary_key"`       // column name; empty for a composite key
+	Indexes    []Index  `json:"indexes,omitempty"` // Indexes created with CREATE INDEX
+
+	// Columns of a composite primary key, in key order
+	PrimaryKeyColumns []string `json:"primary_key_columns,omitempty"`
+}
+
+// KeyColumns returns the primary key's columns in key order, or nil if the
+// table has no primary key
+func (t *Table) KeyColumns() []string {
+	if len(t.PrimaryKeyColumns) > 0 {
+		return t.PrimaryKeyColumns
+	}
+	if t.PrimaryKey != "" {
+		return []string{t.PrimaryKey}
+	}
+	return nil
+}
+
+// GetIndex returns the table's index with the given name
+func (t *Table) GetIndex(name string) (Index, bool) {
+	for _, idx := range t.Indexes {
+		if idx.Name == name {
+			return idx, true
+		}
+	}
+	return Index{}, false
 }
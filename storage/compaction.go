@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"rdbms/eventlog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBeforeCompaction is returned when a temporal query asks for state that
+// was folded into the base segment and can no longer be reconstructed
+var ErrBeforeCompaction = errors.New("requested point is before the compaction boundary")
+
+// compactionTxID marks the synthetic events written by compaction
+const compactionTxID = "compaction"
+
+// CompactionPolicy decides how much history is kept as individual events.
+// Both limits apply; the most conservative one wins. A zero value keeps nothing back.
+type CompactionPolicy struct {
+	RetainEvents uint64        // Keep at least this many of the most recent events
+	RetainFor    time.Duration // Keep every event newer than this
+}
+
+// CompactionResult describes a completed compaction
+type CompactionResult struct {
+	Boundary        uint64 // Last event folded into the base segment
+	FoldedEvents    int    // Events removed from the log by this run
+	SyntheticEvents int    // Events written to the base segment
+	SurvivingRows   int    // Rows live at the boundary
+}
+
+// CompactionBoundary returns the last event folded into the base segment (0 if none)
+func (es *EventStore) CompactionBoundary() uint64 {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.log.CompactionBoundary()
+}
+
+// CompactionHorizon returns the newest event the policy allows to be folded (0 if none)
+func (es *EventStore) CompactionHorizon(policy CompactionPolicy) (uint64, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+
+	lastID := es.log.LastID()
+	if lastID <= policy.RetainEvents {
+		return 0, nil
+	}
+	horizon := lastID - policy.RetainEvents
+
+	if policy.RetainFor > 0 {
+		events, errs := es.log.Read()
+		if len(errs) > 0 {
+			return 0, fmt.Errorf("cannot compute compaction horizon: %v", errs[0].Error)
+		}
+
+		cutoff := time.Now().Add(-policy.RetainFor)
+		var byAge uint64
+		for _, e := range events {
+			if e.Synthetic || !e.Timestamp.Before(cutoff) {
+				continue
+			}
+			if e.ID > byAge {
+				byAge = e.ID
+			}
+		}
+		if byAge < horizon {
+			horizon = byAge
+		}
+	}
+
+	return horizon, nil
+}
+
+// Compact folds every event up to and including horizon into the base segment.
+//
+// The segment keeps schema events verbatim and replaces row history with a
+// single synthetic insert per row that is live at the horizon, so replaying the
+// compacted log yields the same state. Events after the horizon are not touched.
+// Writers are blocked while compaction runs.
+func (es *EventStore) Compact(horizon uint64) (*CompactionResult, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	previous := es.log.CompactionBoundary()
+	if horizon <= previous {
+		return nil, fmt.Errorf("events up to %d are already compacted", previous)
+	}
+
+	events, errs := es.log.Read()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("refusing to compact a log with %d unreadable events: %v", len(errs), errs[0].Error)
+	}
+
+	folded := make([]*eventlog.Event, 0)
+	for _, e := range events {
+		if e.ID > horizon {
+			break
+		}
+		folded = append(folded, e)
+	}
+
+	state, err := ReplayEventsUpTo(folded, horizon)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each synthetic insert keeps the schema version its row was written in,
+	// so migrations still apply to it on replay
+	rowSchemaVersions := make(map[string]map[int64]int)
+	synthetic := make([]*eventlog.Event, 0)
+	for _, e := range folded {
+		switch e.Type {
+		case eventlog.RowInserted:
+			payload := e.Payload.(map[string]interface{})
+			tableName := payload["table_name"].(string)
+			if _, exists := rowSchemaVersions[tableName]; !exists {
+				rowSchemaVersions[tableName] = make(map[int64]int)
+			}
+			rowSchemaVersions[tableName][int64(payload["row_id"].(float64))] = e.Version
+		case eventlog.RowUpdated, eventlog.RowDeleted:
+			// Row history is replaced by the surviving rows below
+		default:
+			synthetic = append(synthetic, e)
+		}
+	}
+
+	surviving := 0
+	tableNames := make([]string, 0, len(state.Tables))
+	for name := range state.Tables {
+		tableNames = append(tableNames, name)
+	}
+	sort.Strings(tableNames)
+
+	now := time.Now().UTC()
+	for _, name := range tableNames {
+		rows := state.GetTableRows(name)
+		sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+		for _, r := range rows {
+			version, exists := rowSchemaVersions[name][r.ID]
+			if !exists {
+				version = es.tableVersionLocked(name)
+			}
+			synthetic = append(synthetic, &eventlog.Event{
+				Type:      eventlog.RowInserted,
+				Timestamp: now,
+				Version:   version,
+				TxID:      compactionTxID,
+				Payload: map[string]interface{}{
+					"table_name": name,
+					"row_id":     float64(r.ID),
+					"data":       map[string]interface{}(r.Row),
+				},
+			})
+			surviving++
+		}
+	}
+
+	base, err := es.log.Compact(horizon, synthetic)
+	if err != nil {
+		return nil, err
+	}
+
+	foldedNow := 0
+	for _, e := range folded {
+		if !e.Synthetic {
+			foldedNow++
+		}
+	}
+
+	return &CompactionResult{
+		Boundary:        base.Boundary,
+		FoldedEvents:    foldedNow,
+		SyntheticEvents: len(base.Events),
+		SurvivingRows:   surviving,
+	}, nil
+}
+
+// Compactor periodically compacts the event log according to a policy
+type Compactor struct {
+	mu         sync.Mutex
+	eventStore *EventStore
+	policy     CompactionPolicy
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewCompactor creates a compactor for an event store
+func NewCompactor(eventStore *EventStore, policy CompactionPolicy) *Compactor {
+	return &Compactor{
+		eventStore: eventStore,
+		policy:     policy,
+	}
+}
+
+// RunOnce compacts up to the policy horizon. It returns nil when there is nothing to fold.
+func (c *Compactor) RunOnce() (*CompactionResult, error) {
+	horizon, err := c.eventStore.CompactionHorizon(c.policy)
+	if err != nil {
+		return nil, err
+	}
+	if horizon <= c.eventStore.CompactionBoundary() {
+		return nil, nil
+	}
+	return c.eventStore.Compact(horizon)
+}
+
+// Start runs compaction in the background every interval until Stop is called
+func (c *Compactor) Start(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stop != nil {
+		return
+	}
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// Failures are retried on the next tick; the log is left unchanged
+				c.RunOnce()
+			}
+		}
+	}(c.stop, c.done)
+}
+
+// Stop halts background compaction and waits for a running pass to finish
+func (c *Compactor) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stop == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+	c.stop = nil
+	c.done = nil
+}
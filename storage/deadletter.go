@@ -0,0 +1,35 @@
+package storage
+
+import "rdbms/schema"
+
+// routeDeadLetter moves a row whose migration failed under a dead-letter policy
+// into the table's dead-letter table, keeping its original data and the reason
+func routeDeadLetter(state *DerivedState, tableName string, rowID int64, row Row, fromVersion, toVersion int, err error) {
+	deadTable := schema.DeadLetterTable(tableName)
+	if _, exists := state.Tables[deadTable]; !exists {
+		state.Tables[deadTable] = make(map[int64]Row)
+		state.DeletedRows[deadTable] = make(map[int64]bool)
+	}
+
+	dead := make(Row, len(row)+3)
+	for k, v := range row {
+		dead[k] = v
+	}
+	dead["_error"] = err.Error()
+	dead["_from_version"] = float64(fromVersion)
+	dead["_to_version"] = float64(toVersion)
+
+	state.Tables[deadTable][rowID] = dead
+	delete(state.DeletedRows[deadTable], rowID)
+	delete(state.Tables[tableName], rowID)
+}
+
+// deadLetterRow returns the dead-letter copy of a row, if its migration failed
+// under a dead-letter policy. Later updates and deletes follow it there.
+func deadLetterRow(state *DerivedState, tableName string, rowID int64) (Row, bool) {
+	if _, live := state.Tables[tableName][rowID]; live {
+		return nil, false
+	}
+	row, exists := state.Tables[schema.DeadLetterTable(tableName)][rowID]
+	return row, exists
+}
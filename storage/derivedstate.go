@@ -3,6 +3,8 @@ package storage
 import (
 	"encoding/json"
 	"rdbms/eventlog"
+	"rdbms/schema"
+	"sort"
 )
 
 // DerivedState represents the current state of the database derived from events
@@ -12,6 +14,15 @@ type DerivedState struct {
 
 	// DeletedRows: tableName -> set of deleted rowIDs
 	DeletedRows map[string]map[int64]bool
+
+	// RowVersions: tableName -> rowID -> schema version the row's data is in (0 if unknown)
+	RowVersions map[string]map[int64]int `json:"row_versions,omitempty"`
+
+	// Rows that failed to migrate (tableName -> rowID), recomputed rather than persisted
+	migrationErrors map[string]map[int64]*MigrationError
+
+	// Set when rows are upgraded on read
+	lazy *lazyMigration
 }
 
 // ReplayEvents derives the current state by replaying all events
@@ -25,6 +36,7 @@ func ReplayEventsUpTo(events []*eventlog.Event, upToEventID uint64) (*DerivedSta
 		Tables:      make(map[string]map[int64]Row),
 		DeletedRows: make(map[string]map[int64]bool),
 	}
+	tableSchemaVersions := make(map[string]int)
 
 	for _, e := range events {
 		// If upToEventID specified, stop after that event
@@ -42,6 +54,7 @@ func ReplayEventsUpTo(events []*eventlog.Event, upToEventID uint64) (*DerivedSta
 				state.Tables[tableName] = make(map[int64]Row)
 				state.DeletedRows[tableName] = make(map[int64]bool)
 			}
+			tableSchemaVersions[tableName] = e.Version
 
 		case eventlog.RowInserted:
 			payload := e.Payload.(map[string]interface{})
@@ -60,6 +73,7 @@ func ReplayEventsUpTo(events []*eventlog.Event, upToEventID uint64) (*DerivedSta
 
 			// Insert row
 			state.Tables[tableName][rowID] = row
+			state.setRowVersion(tableName, rowID, rowSchemaVersion(e, tableName, tableSchemaVersions))
 			delete(state.DeletedRows[tableName], rowID) // Mark as not deleted
 
 		case eventlog.RowUpdated:
@@ -98,22 +112,44 @@ func ReplayEventsUpTo(events []*eventlog.Event, upToEventID uint64) (*DerivedSta
 
 			// Mark as deleted
 			state.DeletedRows[tableName][rowID] = true
+
+		case eventlog.SchemaEvolved:
+			payload := e.Payload.(map[string]interface{})
+			tableName := payload["table_name"].(string)
+			tableSchemaVersions[tableName] = e.Version
+
+		case eventlog.SnapshotCreated:
+			// Snapshots record state, they never change it
 		}
 	}
 
 	return state, nil
 }
 
-// GetTableRows returns only non-deleted rows for a table
+// GetTableRows returns only non-deleted rows for a table. With lazy migration
+// enabled, rows are upgraded first and rows that fail to migrate are left out;
+// schema.MigrationErrorTable lists them.
 func (s *DerivedState) GetTableRows(tableName string) []RowWithID {
+	if tableName == schema.MigrationErrorTable {
+		return s.migrationErrorRows()
+	}
+	if s.lazy != nil {
+		if schema.IsSystemTable(tableName) {
+			// Dead-letter tables fill up as their source tables are upgraded
+			s.UpgradeAll()
+		} else {
+			s.upgradeTable(tableName)
+		}
+	}
+
 	var result []RowWithID
 
 	if tableRows, exists := s.Tables[tableName]; exists {
 		deletedSet := s.DeletedRows[tableName]
 
 		for rowID, row := range tableRows {
-			// Skip deleted rows
-			if deletedSet[rowID] {
+			// Skip deleted rows and rows held back by a failed migration
+			if deletedSet[rowID] || s.failedMigration(tableName, rowID) {
 				continue
 			}
 			result = append(result, RowWithID{ID: rowID, Row: row})
@@ -123,12 +159,30 @@ func (s *DerivedState) GetTableRows(tableName string) []RowWithID {
 	return result
 }
 
+// TableRowIDs returns the IDs of a table's non-deleted rows in ascending
+// order, without reading or migrating the rows; GetRow reads each one
+func (s *DerivedState) TableRowIDs(tableName string) []int64 {
+	tableRows := s.Tables[tableName]
+	deletedSet := s.DeletedRows[tableName]
+	rowIDs := make([]int64, 0, len(tableRows))
+	for rowID := range tableRows {
+		if !deletedSet[rowID] {
+			rowIDs = append(rowIDs, rowID)
+		}
+	}
+	sort.Slice(rowIDs, func(i, j int) bool { return rowIDs[i] < rowIDs[j] })
+	return rowIDs
+}
+
 // GetRow returns a single row if it exists and is not deleted
 func (s *DerivedState) GetRow(tableName string, rowID int64) (Row, bool) {
+	if s.lazy != nil && !s.DeletedRows[tableName][rowID] {
+		s.upgradeRow(tableName, rowID)
+	}
 	if tableRows, exists := s.Tables[tableName]; exists {
 		if row, exists := tableRows[rowID]; exists {
-			// Check if deleted
-			if !s.DeletedRows[tableName][rowID] {
+			// Check if deleted or held back by a failed migration
+			if !s.DeletedRows[tableName][rowID] && !s.failedMigration(tableName, rowID) {
 				return row, true
 			}
 		}
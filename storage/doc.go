@@ -8,6 +8,8 @@
 //   - EventStore: Wraps the event log with database-aware operations
 //   - QueryEngine: Executes queries using snapshots and event replay
 //   - SnapshotManager: Creates and manages database state snapshots
+//   - SnapshotScheduler: Takes snapshots in the background and applies retention
+//   - Compactor: Folds old history into a base segment according to a retention policy
 //   - Engine: Legacy row-based storage (used for snapshots)
 //
 // Architecture:
@@ -18,16 +20,19 @@
 //
 // Key Responsibilities:
 //   - Storing and retrieving events from the event log
-//   - Creating and loading snapshots for performance
+//   - Creating and loading snapshots for performance, recording each one as a
+//     SNAPSHOT_CREATED event from which the snapshot index can be rebuilt
 //   - Replaying events to reconstruct database state
 //   - Executing queries with snapshot + replay strategy
 //   - Managing schema versions and migrations
 //   - Detecting and recovering from event corruption
+//   - Compacting history older than the retention window
 //   - Providing row-based storage for snapshots
 //
 // Storage Format:
 //   - Events: Newline-delimited JSON in events.log
-//   - Snapshots: JSON files with complete table state
+//   - Snapshots: Gzip-compressed streams of JSON records (header, tables, rows, hash trailer),
+//     holding either the complete state or the rows changed since a parent snapshot
 //   - Rows: Binary format with deleted flags and JSON data
 //
 // Usage Example:
@@ -12,8 +12,11 @@ type EventStore struct {
 	mu  sync.RWMutex
 	log *eventlog.Log
 
-	// Current schema version (incremented on schema changes)
-	schemaVersion int
+	// Current schema version per table (1 on creation, incremented on each evolution)
+	schemaVersions map[string]int
+
+	// ID of the latest SCHEMA_CREATED or SCHEMA_EVOLVED event
+	lastSchemaEventID uint64
 
 	// Track row versions for optimistic concurrency (rowID -> latestEventID)
 	rowVersions map[string]map[int64]uint64
@@ -27,9 +30,9 @@ func NewEventStore(dataDir string) (*EventStore, error) {
 	}
 
 	es := &EventStore{
-		log:           log,
-		schemaVersion: 1, // Start at version 1
-		rowVersions:   make(map[string]map[int64]uint64),
+		log:            log,
+		schemaVersions: make(map[string]int),
+		rowVersions:    make(map[string]map[int64]uint64),
 	}
 
 	// Load existing row versions from log
@@ -44,7 +47,11 @@ func (es *EventStore) rebuildRowVersions(events []*eventlog.Event) {
 	for _, e := range events {
 		switch e.Type {
 		case eventlog.SchemaCreated:
-			// New table
+			payload := e.Payload.(map[string]interface{})
+			tableName := payload["table_name"].(string)
+			es.schemaVersions[tableName] = 1
+			es.lastSchemaEventID = e.ID
+
 		case eventlog.RowInserted:
 			payload := e.Payload.(map[string]interface{})
 			tableName := payload["table_name"].(string)
@@ -76,31 +83,45 @@ func (es *EventStore) rebuildRowVersions(events []*eventlog.Event) {
 			es.rowVersions[tableName][rowID] = e.ID
 
 		case eventlog.SchemaEvolved:
-			es.schemaVersion++
+			payload := e.Payload.(map[string]interface{})
+			tableName := payload["table_name"].(string)
+			es.schemaVersions[tableName] = es.tableVersionLocked(tableName) + 1
+			es.lastSchemaEventID = e.ID
+
+		case eventlog.SnapshotCreated:
+			// Bookkeeping only
 		}
 	}
 }
 
 // RecordSchemaCreated logs a table creation event
 func (es *EventStore) RecordSchemaCreated(tableName string, columns []eventlog.ColumnDefinition, primaryKey string, txID string) (*eventlog.Event, error) {
-	es.mu.Lock()
-	defer es.mu.Unlock()
-
-	payload := &eventlog.SchemaCreatedPayload{
+	return es.RecordSchemaCreation(&eventlog.SchemaCreatedPayload{
 		TableName:  tableName,
 		Columns:    columns,
 		PrimaryKey: primaryKey,
-	}
+	}, txID)
+}
+
+// RecordSchemaCreation logs a table creation event from a full payload,
+// including the key order of a composite primary key
+func (es *EventStore) RecordSchemaCreation(payload *eventlog.SchemaCreatedPayload, txID string) (*eventlog.Event, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	tableName := payload.TableName
 
 	// Marshal payload to JSON (eventlog.Append expects JSON-serializable payload)
 	payloadJSON, _ := json.Marshal(payload)
 	var payloadData map[string]interface{}
 	json.Unmarshal(payloadJSON, &payloadData)
 
-	event, err := es.log.Append(eventlog.SchemaCreated, payloadData, txID, es.schemaVersion)
+	event, err := es.log.Append(eventlog.SchemaCreated, payloadData, txID, 1)
 	if err != nil {
 		return nil, err
 	}
+	es.schemaVersions[tableName] = 1
+	es.lastSchemaEventID = event.ID
 
 	// Initialize row version tracking for this table
 	if _, exists := es.rowVersions[tableName]; !exists {
@@ -125,7 +146,7 @@ func (es *EventStore) RecordRowInserted(tableName string, rowID int64, data Row,
 	var payloadData map[string]interface{}
 	json.Unmarshal(payloadJSON, &payloadData)
 
-	event, err := es.log.Append(eventlog.RowInserted, payloadData, txID, es.schemaVersion)
+	event, err := es.log.Append(eventlog.RowInserted, payloadData, txID, es.tableVersionLocked(tableName))
 	if err != nil {
 		return nil, err
 	}
@@ -155,7 +176,7 @@ func (es *EventStore) RecordRowUpdated(tableName string, rowID int64, changes ma
 	var payloadData map[string]interface{}
 	json.Unmarshal(payloadJSON, &payloadData)
 
-	event, err := es.log.Append(eventlog.RowUpdated, payloadData, txID, es.schemaVersion)
+	event, err := es.log.Append(eventlog.RowUpdated, payloadData, txID, es.tableVersionLocked(tableName))
 	if err != nil {
 		return nil, err
 	}
@@ -184,7 +205,7 @@ func (es *EventStore) RecordRowDeleted(tableName string, rowID int64, deletedDat
 	var payloadData map[string]interface{}
 	json.Unmarshal(payloadJSON, &payloadData)
 
-	event, err := es.log.Append(eventlog.RowDeleted, payloadData, txID, es.schemaVersion)
+	event, err := es.log.Append(eventlog.RowDeleted, payloadData, txID, es.tableVersionLocked(tableName))
 	if err != nil {
 		return nil, err
 	}
@@ -200,30 +221,80 @@ func (es *EventStore) RecordRowDeleted(tableName string, rowID int64, deletedDat
 
 // RecordSchemaEvolved logs a schema evolution event
 func (es *EventStore) RecordSchemaEvolved(tableName string, oldSchema []eventlog.ColumnDefinition, newSchema []eventlog.ColumnDefinition, evolution eventlog.SchemaEvolution, txID string) (*eventlog.Event, error) {
-	es.mu.Lock()
-	defer es.mu.Unlock()
-
-	payload := &eventlog.SchemaEvolvedPayload{
+	return es.RecordSchemaEvolution(&eventlog.SchemaEvolvedPayload{
 		TableName: tableName,
 		Evolution: evolution,
 		OldSchema: oldSchema,
 		NewSchema: newSchema,
-	}
+	}, txID)
+}
+
+// RecordSchemaEvolution logs a schema evolution event from a full payload,
+// including the migration that produced it
+func (es *EventStore) RecordSchemaEvolution(payload *eventlog.SchemaEvolvedPayload, txID string) (*eventlog.Event, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
 
 	payloadJSON, _ := json.Marshal(payload)
 	var payloadData map[string]interface{}
 	json.Unmarshal(payloadJSON, &payloadData)
 
-	event, err := es.log.Append(eventlog.SchemaEvolved, payloadData, txID, es.schemaVersion)
+	// The evolution event carries the version it produces
+	newVersion := es.tableVersionLocked(payload.TableName) + 1
+	event, err := es.log.Append(eventlog.SchemaEvolved, payloadData, txID, newVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	es.schemaVersion++
+	es.schemaVersions[payload.TableName] = newVersion
+	es.lastSchemaEventID = event.ID
 
 	return event, nil
 }
 
+// RecordIndexCreated logs the definition of a secondary index
+func (es *EventStore) RecordIndexCreated(payload *eventlog.IndexCreatedPayload, txID string) (*eventlog.Event, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	payloadJSON, _ := json.Marshal(payload)
+	var payloadData map[string]interface{}
+	json.Unmarshal(payloadJSON, &payloadData)
+
+	return es.log.Append(eventlog.IndexCreated, payloadData, txID, es.tableVersionLocked(payload.TableName))
+}
+
+// RecordIndexDropped logs the removal of a secondary index
+func (es *EventStore) RecordIndexDropped(tableName, indexName string, txID string) (*eventlog.Event, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	payload := &eventlog.IndexDroppedPayload{
+		TableName: tableName,
+		IndexName: indexName,
+	}
+
+	payloadJSON, _ := json.Marshal(payload)
+	var payloadData map[string]interface{}
+	json.Unmarshal(payloadJSON, &payloadData)
+
+	return es.log.Append(eventlog.IndexDropped, payloadData, txID, es.tableVersionLocked(tableName))
+}
+
+// RecordSnapshotCreated logs that a snapshot was written. The event is bookkeeping
+// only: replay ignores it, but it lets the snapshot index be rebuilt from the log.
+func (es *EventStore) RecordSnapshotCreated(payload *eventlog.SnapshotCreatedPayload, txID string) (*eventlog.Event, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	payloadJSON, _ := json.Marshal(payload)
+	var payloadData map[string]interface{}
+	json.Unmarshal(payloadJSON, &payloadData)
+
+	// Not tied to any table, so it carries no schema version
+	return es.log.Append(eventlog.SnapshotCreated, payloadData, txID, 0)
+}
+
 // GetAllEvents returns all events from the log
 func (es *EventStore) GetAllEvents() ([]*eventlog.Event, []eventlog.EventError) {
 	es.mu.RLock()
@@ -245,11 +316,39 @@ func (es *EventStore) GetLastEventID() uint64 {
 	return es.log.LastID()
 }
 
-// GetSchemaVersion returns the current schema version
-func (es *EventStore) GetSchemaVersion() int {
+// GetSchemaVersion returns the current schema version of a table
+func (es *EventStore) GetSchemaVersion(tableName string) int {
 	es.mu.RLock()
 	defer es.mu.RUnlock()
-	return es.schemaVersion
+	return es.tableVersionLocked(tableName)
+}
+
+// GetSchemaVersions returns the current schema version of every table
+func (es *EventStore) GetSchemaVersions() map[string]int {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+
+	versions := make(map[string]int, len(es.schemaVersions))
+	for tableName, version := range es.schemaVersions {
+		versions[tableName] = version
+	}
+	return versions
+}
+
+// LastSchemaEventID returns the ID of the latest SCHEMA_CREATED or SCHEMA_EVOLVED
+// event, so callers can tell when schema-derived caches are stale
+func (es *EventStore) LastSchemaEventID() uint64 {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.lastSchemaEventID
+}
+
+// tableVersionLocked returns a table's schema version; tables start at version 1
+func (es *EventStore) tableVersionLocked(tableName string) int {
+	if version, exists := es.schemaVersions[tableName]; exists {
+		return version
+	}
+	return 1
 }
 
 // GetRowVersion returns the latest event ID for a specific row
@@ -278,6 +377,48 @@ func (es *EventStore) ReadAllEvents() ([]*eventlog.Event, error) {
 	return events, nil
 }
 
+// VerifyChain verifies the hash chain and signed checkpoints of the event log
+func (es *EventStore) VerifyChain() (*eventlog.ChainReport, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.log.VerifyChain()
+}
+
+// Checkpoint writes a signed checkpoint of the current chain head
+func (es *EventStore) Checkpoint() (*eventlog.Checkpoint, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.log.Checkpoint()
+}
+
+// MerkleRoot returns the Merkle root over all event checksums and the tree size
+func (es *EventStore) MerkleRoot() (string, uint64) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.log.MerkleRoot()
+}
+
+// MerkleRootAt returns the Merkle root over the events up to and including eventID
+func (es *EventStore) MerkleRootAt(eventID uint64) (string, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.log.MerkleRootAt(eventID)
+}
+
+// InclusionProof proves that an event is part of the log
+func (es *EventStore) InclusionProof(eventID uint64) (*eventlog.InclusionProof, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.log.InclusionProof(eventID)
+}
+
+// ConsistencyProof proves that the log with root newRoot extends the log with root oldRoot
+func (es *EventStore) ConsistencyProof(oldRoot, newRoot string) (*eventlog.ConsistencyProof, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.log.ConsistencyProof(oldRoot, newRoot)
+}
+
 // Close closes the event store
 func (es *EventStore) Close() error {
 	es.mu.Lock()
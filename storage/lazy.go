@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"sort"
+
+	"rdbms/schema"
+)
+
+// MigrationError describes a row that could not be migrated to its table's
+// target schema version. The row stays in the state in its original version
+// and is left out of reads until a later migration succeeds.
+type MigrationError struct {
+	Table       string
+	RowID       int64
+	FromVersion int
+	ToVersion   int
+	Error       string
+	Row         Row
+}
+
+// lazyMigration is how a state upgrades rows when they are read
+type lazyMigration struct {
+	handler *MigrationHandler
+	targets SchemaTargets
+}
+
+// EnableLazyMigration makes reads of the state upgrade rows to their table's
+// target version on first access, instead of migrating every row up front.
+// Tables not listed in targets are upgraded to their latest registered version.
+func (s *DerivedState) EnableLazyMigration(handler *MigrationHandler, targets SchemaTargets) {
+	if handler == nil {
+		s.lazy = nil
+		return
+	}
+	s.lazy = &lazyMigration{handler: handler, targets: targets}
+}
+
+// RowVersion returns the schema version a row's data is in, or 0 if unknown
+func (s *DerivedState) RowVersion(tableName string, rowID int64) int {
+	return s.RowVersions[tableName][rowID]
+}
+
+// setRowVersion records the schema version a row's data is in
+func (s *DerivedState) setRowVersion(tableName string, rowID int64, version int) {
+	if s.RowVersions == nil {
+		s.RowVersions = make(map[string]map[int64]int)
+	}
+	if s.RowVersions[tableName] == nil {
+		s.RowVersions[tableName] = make(map[int64]int)
+	}
+	s.RowVersions[tableName][rowID] = version
+}
+
+// PendingMigrations counts live rows whose data is not yet in its table's target version
+func (s *DerivedState) PendingMigrations() int {
+	if s.lazy == nil {
+		return 0
+	}
+	pending := 0
+	for tableName, rows := range s.Tables {
+		target := s.lazy.handler.targetFor(tableName, s.lazy.targets)
+		for rowID := range rows {
+			if s.DeletedRows[tableName][rowID] {
+				continue
+			}
+			if version := s.RowVersion(tableName, rowID); version > 0 && target > 0 && version != target {
+				pending++
+			}
+		}
+	}
+	return pending
+}
+
+// UpgradeAll migrates every live row that is behind its table's target version
+// and returns how many were migrated. Failures are recorded as migration errors.
+func (s *DerivedState) UpgradeAll() int {
+	if s.lazy == nil {
+		return 0
+	}
+	tableNames := make([]string, 0, len(s.Tables))
+	for tableName := range s.Tables {
+		if !schema.IsSystemTable(tableName) {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+
+	migrated := 0
+	for _, tableName := range tableNames {
+		migrated += s.upgradeTable(tableName)
+	}
+	return migrated
+}
+
+// upgradeTable migrates the live rows of one table that are behind its target version
+func (s *DerivedState) upgradeTable(tableName string) int {
+	target := s.lazy.handler.targetFor(tableName, s.lazy.targets)
+	if target == 0 {
+		return 0
+	}
+
+	// Rows may move to the dead-letter table while upgrading, so collect IDs first
+	var behind []int64
+	for rowID := range s.Tables[tableName] {
+		if version := s.RowVersion(tableName, rowID); version > 0 && version != target && !s.DeletedRows[tableName][rowID] {
+			behind = append(behind, rowID)
+		}
+	}
+	sort.Slice(behind, func(i, j int) bool { return behind[i] < behind[j] })
+
+	migrated := 0
+	for _, rowID := range behind {
+		if s.migrateStoredRow(tableName, rowID, target, s.lazy.handler) {
+			migrated++
+		}
+	}
+	return migrated
+}
+
+// upgradeRow migrates one row if it is behind its table's target version
+func (s *DerivedState) upgradeRow(tableName string, rowID int64) {
+	target := s.lazy.handler.targetFor(tableName, s.lazy.targets)
+	if version := s.RowVersion(tableName, rowID); version > 0 && target > 0 && version != target {
+		s.migrateStoredRow(tableName, rowID, target, s.lazy.handler)
+	}
+}
+
+// migrateStoredRow migrates a row in the state to toVersion in place. Rows that
+// fail under a dead-letter policy move to the dead-letter table; other failures
+// leave the row in its original version and record a migration error.
+func (s *DerivedState) migrateStoredRow(tableName string, rowID int64, toVersion int, handler *MigrationHandler) bool {
+	row, exists := s.Tables[tableName][rowID]
+	if !exists {
+		return false
+	}
+	fromVersion := s.RowVersion(tableName, rowID)
+
+	migrated, err := handler.MigrateRowIfNeeded(tableName, row, fromVersion, toVersion)
+	if schema.IsDeadLetter(err) {
+		routeDeadLetter(s, tableName, rowID, row, fromVersion, toVersion, err)
+		s.clearMigrationError(tableName, rowID)
+		return false
+	}
+	if err != nil {
+		s.recordMigrationError(tableName, rowID, row, fromVersion, toVersion, err)
+		return false
+	}
+
+	s.Tables[tableName][rowID] = migrated
+	s.setRowVersion(tableName, rowID, toVersion)
+	s.clearMigrationError(tableName, rowID)
+	return true
+}
+
+// recordMigrationError notes that a row could not be migrated
+func (s *DerivedState) recordMigrationError(tableName string, rowID int64, row Row, fromVersion, toVersion int, err error) {
+	if s.migrationErrors == nil {
+		s.migrationErrors = make(map[string]map[int64]*MigrationError)
+	}
+	if s.migrationErrors[tableName] == nil {
+		s.migrationErrors[tableName] = make(map[int64]*MigrationError)
+	}
+	s.migrationErrors[tableName][rowID] = &MigrationError{
+		Table:       tableName,
+		RowID:       rowID,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Error:       err.Error(),
+		Row:         row,
+	}
+}
+
+// clearMigrationError forgets a row's migration error, e.g. once it is deleted or migrates
+func (s *DerivedState) clearMigrationError(tableName string, rowID int64) {
+	delete(s.migrationErrors[tableName], rowID)
+}
+
+// failedMigration reports whether a row is held back because it failed to migrate
+func (s *DerivedState) failedMigration(tableName string, rowID int64) bool {
+	return s.migrationErrors[tableName][rowID] != nil
+}
+
+// MigrationErrors returns the rows that failed to migrate, ordered by table and row ID.
+// With lazy migration enabled, every table is upgraded first so the list is complete.
+func (s *DerivedState) MigrationErrors() []MigrationError {
+	if s.lazy != nil {
+		s.UpgradeAll()
+	}
+
+	var errs []MigrationError
+	for tableName, rows := range s.migrationErrors {
+		for rowID, migErr := range rows {
+			if s.DeletedRows[tableName][rowID] {
+				continue
+			}
+			errs = append(errs, *migErr)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Table != errs[j].Table {
+			return errs[i].Table < errs[j].Table
+		}
+		return errs[i].RowID < errs[j].RowID
+	})
+	return errs
+}
+
+// migrationErrorRows presents the migration errors as the rows of schema.MigrationErrorTable
+func (s *DerivedState) migrationErrorRows() []RowWithID {
+	errs := s.MigrationErrors()
+	rows := make([]RowWithID, len(errs))
+	for i, e := range errs {
+		data := make(map[string]interface{}, len(e.Row))
+		for k, v := range e.Row {
+			data[k] = v
+		}
+		rows[i] = RowWithID{
+			ID: int64(i + 1),
+			Row: Row{
+				"table":        e.Table,
+				"row_id":       float64(e.RowID),
+				"from_version": float64(e.FromVersion),
+				"to_version":   float64(e.ToVersion),
+				"error":        e.Error,
+				"data":         data,
+			},
+		}
+	}
+	return rows
+}
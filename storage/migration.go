@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"rdbms/eventlog"
@@ -9,7 +10,7 @@ import (
 )
 
 // MigrationHandler manages schema migrations during state derivation
-// Transparently applies migrations when replaying rows from older schema versions
+// Transparently applies migrations when replaying rows from older or newer schema versions
 type MigrationHandler struct {
 	mu       sync.RWMutex
 	registry *schema.SchemaRegistry
@@ -22,7 +23,24 @@ func NewMigrationHandler(registry *schema.SchemaRegistry) *MigrationHandler {
 	}
 }
 
-// MigrateRowIfNeeded applies schema migrations to a row if needed
+// SchemaTargets maps table names to the schema version replay should migrate
+// rows to. Tables not listed are migrated to their latest registered version.
+type SchemaTargets map[string]int
+
+// TargetVersion resolves the version rows of a table should be migrated to.
+// It returns 0 when neither the targets nor the registry know the table.
+func (mh *MigrationHandler) TargetVersion(tableName string, targets SchemaTargets) int {
+	if version, exists := targets[tableName]; exists {
+		return version
+	}
+
+	mh.mu.RLock()
+	defer mh.mu.RUnlock()
+	return mh.registry.GetLatestSchemaVersion(tableName)
+}
+
+// MigrateRowIfNeeded applies schema migrations to a row if needed.
+// Versions are those of tableName alone.
 func (mh *MigrationHandler) MigrateRowIfNeeded(tableName string, row Row, fromVersion, toVersion int) (Row, error) {
 	mh.mu.RLock()
 	defer mh.mu.RUnlock()
@@ -48,8 +66,12 @@ func (mh *MigrationHandler) MigrateRowIfNeeded(tableName string, row Row, fromVe
 	return result, nil
 }
 
-// ReplayEventsWithMigrations replays events and applies migrations for target schema version
-func ReplayEventsWithMigrations(events []*eventlog.Event, targetSchemaVersion int, migrationHandler *MigrationHandler) (*DerivedState, error) {
+// ReplayEventsWithMigrations replays events and eagerly migrates each inserted row
+// from the schema version of its own table at the time it was written to that
+// table's target. Targets older than a row's version migrate it backwards, e.g. to
+// roll back a deploy. For large tables, prefer a lazily migrating state (see
+// QueryEngine.SetLazyMigration), which only migrates rows as they are read.
+func ReplayEventsWithMigrations(events []*eventlog.Event, targets SchemaTargets, migrationHandler *MigrationHandler) (*DerivedState, error) {
 	state := &DerivedState{
 		Tables:      make(map[string]map[int64]Row),
 		DeletedRows: make(map[string]map[int64]bool),
@@ -85,20 +107,16 @@ func ReplayEventsWithMigrations(events []*eventlog.Event, targetSchemaVersion in
 				state.DeletedRows[tableName] = make(map[int64]bool)
 			}
 
-			// Apply migration if needed
-			if eventSchemaVer := e.Version; eventSchemaVer < targetSchemaVersion && migrationHandler != nil {
-				migratedRow, err := migrationHandler.MigrateRowIfNeeded(tableName, row, eventSchemaVer, targetSchemaVersion)
-				if err != nil {
-					// Log but continue - don't fail entire replay.
-					// CRITICAL: Do not add the row to the state if migration fails.
-					fmt.Printf("Warning: migration failed for row %d in table %s, skipping row: %v\n", rowID, tableName, err)
-					continue // Skip to the next event
-				}
-				row = migratedRow
-			}
-
 			state.Tables[tableName][rowID] = row
+			state.setRowVersion(tableName, rowID, rowSchemaVersion(e, tableName, tableSchemaVersions))
 			delete(state.DeletedRows[tableName], rowID)
+			state.clearMigrationError(tableName, rowID)
+
+			// Apply migration if needed. A row that fails stays in its original
+			// version, out of reads, and is listed in schema.MigrationErrorTable.
+			if target := migrationHandler.targetFor(tableName, targets); target > 0 && state.RowVersion(tableName, rowID) != target {
+				state.migrateStoredRow(tableName, rowID, target, migrationHandler)
+			}
 
 		case eventlog.RowUpdated:
 			payload := e.Payload.(map[string]interface{})
@@ -112,6 +130,13 @@ func ReplayEventsWithMigrations(events []*eventlog.Event, targetSchemaVersion in
 				state.DeletedRows[tableName] = make(map[int64]bool)
 			}
 
+			if dead, exists := deadLetterRow(state, tableName, rowID); exists {
+				for k, v := range changesRaw {
+					dead[k] = v
+				}
+				continue
+			}
+
 			if _, exists := state.Tables[tableName][rowID]; !exists {
 				state.Tables[tableName][rowID] = make(Row)
 			}
@@ -132,18 +157,136 @@ func ReplayEventsWithMigrations(events []*eventlog.Event, targetSchemaVersion in
 				state.DeletedRows[tableName] = make(map[int64]bool)
 			}
 
+			if _, exists := deadLetterRow(state, tableName, rowID); exists {
+				state.DeletedRows[schema.DeadLetterTable(tableName)][rowID] = true
+				continue
+			}
+
 			state.DeletedRows[tableName][rowID] = true
+			state.clearMigrationError(tableName, rowID)
 
 		case eventlog.SchemaEvolved:
 			payload := e.Payload.(map[string]interface{})
 			tableName := payload["table_name"].(string)
 			tableSchemaVersions[tableName] = e.Version
+
+		case eventlog.SnapshotCreated:
+			// Snapshots record state, they never change it
 		}
 	}
 
 	return state, nil
 }
 
+// DryRun reports how migrating the live rows of a table to toVersion would go,
+// using the conversion policies of the registered migrations. Nothing is changed.
+func (mh *MigrationHandler) DryRun(events []*eventlog.Event, tableName string, toVersion int) *schema.DryRunReport {
+	rows := make(map[int64]*schema.VersionedRow)
+	tableSchemaVersions := make(map[string]int)
+
+	for _, e := range events {
+		payload, ok := e.Payload.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		eventTable, _ := payload["table_name"].(string)
+
+		switch e.Type {
+		case eventlog.SchemaCreated, eventlog.SchemaEvolved:
+			tableSchemaVersions[eventTable] = e.Version
+		}
+		if eventTable != tableName {
+			continue
+		}
+
+		rowIDFloat, _ := payload["row_id"].(float64)
+		rowID := int64(rowIDFloat)
+		switch e.Type {
+		case eventlog.RowInserted:
+			data, _ := payload["data"].(map[string]interface{})
+			copied := make(map[string]interface{}, len(data))
+			for k, v := range data {
+				copied[k] = v
+			}
+			rows[rowID] = &schema.VersionedRow{Data: copied, Version: rowSchemaVersion(e, tableName, tableSchemaVersions)}
+
+		case eventlog.RowUpdated:
+			changes, _ := payload["changes"].(map[string]interface{})
+			if row, exists := rows[rowID]; exists {
+				for k, v := range changes {
+					row.Data[k] = v
+				}
+			}
+
+		case eventlog.RowDeleted:
+			delete(rows, rowID)
+		}
+	}
+
+	ids := make([]int64, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	versioned := make([]schema.VersionedRow, len(ids))
+	for i, id := range ids {
+		versioned[i] = *rows[id]
+	}
+
+	mh.mu.RLock()
+	defer mh.mu.RUnlock()
+	return mh.registry.DryRunMigration(tableName, versioned, toVersion)
+}
+
+// targetFor is TargetVersion that tolerates a nil handler, which never migrates
+func (mh *MigrationHandler) targetFor(tableName string, targets SchemaTargets) int {
+	if mh == nil {
+		return 0
+	}
+	return mh.TargetVersion(tableName, targets)
+}
+
+// rowSchemaVersion returns the schema version a row event was written in. Events
+// without a version fall back to the table's version at that point in the log.
+func rowSchemaVersion(e *eventlog.Event, tableName string, tableSchemaVersions map[string]int) int {
+	if e.Version > 0 {
+		return e.Version
+	}
+	if version, exists := tableSchemaVersions[tableName]; exists {
+		return version
+	}
+	return 1
+}
+
+// RegistryFromEvents rebuilds a schema registry from the log: every table
+// version with its columns, and a migration for every SCHEMA_EVOLVED event
+func RegistryFromEvents(events []*eventlog.Event) (*schema.SchemaRegistry, error) {
+	registry := schema.NewSchemaRegistry()
+
+	for _, e := range events {
+		switch e.Type {
+		case eventlog.SchemaCreated:
+			var payload eventlog.SchemaCreatedPayload
+			if err := ConvertPayload(e.Payload.(map[string]interface{}), &payload); err != nil {
+				return nil, fmt.Errorf("event %d: %w", e.ID, err)
+			}
+			registry.RegisterSchema(payload.TableName, e.Version, schema.ColumnsFromDefinitions(payload.Columns))
+
+		case eventlog.SchemaEvolved:
+			var payload eventlog.SchemaEvolvedPayload
+			if err := ConvertPayload(e.Payload.(map[string]interface{}), &payload); err != nil {
+				return nil, fmt.Errorf("event %d: %w", e.ID, err)
+			}
+			registry.RegisterSchema(payload.TableName, e.Version, schema.ColumnsFromDefinitions(payload.NewSchema))
+			ops, policy := schema.OpsFromEvolution(payload.Evolution)
+			registry.RegisterMigrationWithPolicy(payload.TableName, e.Version-1, e.Version, ops, policy)
+		}
+	}
+
+	return registry, nil
+}
+
 // GetSchemaVersionHistory returns all schema versions encountered in events
 func GetSchemaVersionHistory(events []*eventlog.Event) map[string][]int {
 	history := make(map[string][]int) // table -> versions
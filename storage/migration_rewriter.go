@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RewriteStats summarizes one pass of the migration rewriter
+type RewriteStats struct {
+	Pending  int           // Rows behind their table's schema version before the pass
+	Migrated int           // Rows rewritten in the current version
+	Failed   int           // Rows left in their old version, see schema.MigrationErrorTable
+	Snapshot *SnapshotMeta // Snapshot holding the rewritten rows, nil if nothing was rewritten
+}
+
+// MigrationRewriter upgrades rows that lazy migration has not reached yet, in
+// the background. The log is never touched: rewritten rows are persisted as a
+// snapshot, so later reads start from rows that are already migrated.
+type MigrationRewriter struct {
+	mu              sync.Mutex
+	queryEngine     *QueryEngine
+	snapshotManager *SnapshotManager
+	interval        time.Duration
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewMigrationRewriter creates a rewriter; call Start to run it in the background
+func NewMigrationRewriter(queryEngine *QueryEngine, snapshotManager *SnapshotManager, interval time.Duration) *MigrationRewriter {
+	return &MigrationRewriter{
+		queryEngine:     queryEngine,
+		snapshotManager: snapshotManager,
+		interval:        interval,
+	}
+}
+
+// RunOnce migrates every row that is behind its table's schema version and
+// snapshots the result. It does nothing when no row is behind.
+func (r *MigrationRewriter) RunOnce() (*RewriteStats, error) {
+	state, eventID, err := r.queryEngine.PinnedState()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RewriteStats{Pending: state.PendingMigrations()}
+	if stats.Pending == 0 {
+		return stats, nil
+	}
+
+	stats.Migrated = state.UpgradeAll()
+	stats.Failed = len(state.MigrationErrors())
+	if stats.Migrated == 0 {
+		return stats, nil
+	}
+
+	meta, err := r.snapshotManager.CreateSnapshot(state, eventID, int64(eventID))
+	if err != nil {
+		return stats, fmt.Errorf("rewriting %d migrated rows: %w", stats.Migrated, err)
+	}
+	stats.Snapshot = meta
+	return stats, nil
+}
+
+// Start runs the rewriter in the background until Stop is called
+func (r *MigrationRewriter) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		return
+	}
+	interval := r.interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// Failures are retried on the next tick; reads still migrate lazily
+				r.RunOnce()
+			}
+		}
+	}(r.stop, r.done)
+}
+
+// Stop halts the rewriter and waits for a running pass to finish
+func (r *MigrationRewriter) Stop() {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
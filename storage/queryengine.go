@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"rdbms/eventlog"
 	"sync"
+	"time"
 )
 
 // QueryEngine provides efficient querying of the database state
@@ -13,6 +17,23 @@ type QueryEngine struct {
 	cachedState       *DerivedState
 	cachedUpToEventID uint64
 	enableSnapshots   bool
+
+	// Lazy migration: rows written in older schema versions are upgraded as they
+	// are read, using migrations rebuilt from the log whenever the schema changes
+	lazyMigration    bool
+	migrationHandler *MigrationHandler
+	migrationsAt     uint64 // Last schema event the handler reflects
+
+	// How the most recent state was built
+	lastSource StateSource
+}
+
+// StateSource describes how a state was built: the snapshot it was restored
+// from, if any, and the events replayed on top of it
+type StateSource struct {
+	SnapshotEventID uint64        // Last event the snapshot reflects; 0 when replayed from the start
+	Replayed        int           // Events replayed on top of the snapshot
+	ReplayTime      time.Duration // Time spent replaying them
 }
 
 // NewQueryEngine creates a new query engine
@@ -33,12 +54,68 @@ func (qe *QueryEngine) GetCurrentState() (*DerivedState, error) {
 	qe.mu.Lock()
 	defer qe.mu.Unlock()
 
+	lastEventID := qe.eventStore.GetLastEventID()
+	state, err := qe.buildStateLocked(lastEventID)
+	if err != nil {
+		return nil, err
+	}
+
+	qe.cachedState = state
+	qe.cachedUpToEventID = lastEventID
+
+	return state, nil
+}
+
+// GetCurrentStateWithSource is GetCurrentState, also reporting how the state
+// was built
+func (qe *QueryEngine) GetCurrentStateWithSource() (*DerivedState, StateSource, error) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+
+	lastEventID := qe.eventStore.GetLastEventID()
+	state, err := qe.buildStateLocked(lastEventID)
+	if err != nil {
+		return nil, StateSource{}, err
+	}
+
+	qe.cachedState = state
+	qe.cachedUpToEventID = lastEventID
+
+	return state, qe.lastSource, nil
+}
+
+// PinnedState returns the state as of the current last event together with that event ID.
+// Events appended while the state is being built are not included, so the result is a
+// consistent point-in-time view suitable for snapshotting.
+func (qe *QueryEngine) PinnedState() (*DerivedState, uint64, error) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+
+	lastEventID := qe.eventStore.GetLastEventID()
+	state, err := qe.buildStateLocked(lastEventID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return state, lastEventID, nil
+}
+
+// LastReplay returns how many events the most recent state build replayed on top
+// of its snapshot and how long that took
+func (qe *QueryEngine) LastReplay() (int, time.Duration) {
+	qe.mu.RLock()
+	defer qe.mu.RUnlock()
+	return qe.lastSource.Replayed, qe.lastSource.ReplayTime
+}
+
+// buildStateLocked restores the latest usable snapshot and replays events up to
+// and including upTo on top of it (caller holds qe.mu)
+func (qe *QueryEngine) buildStateLocked(upTo uint64) (*DerivedState, error) {
 	var baseState *DerivedState
 	var baseEventID uint64
 
 	// Try to restore from latest snapshot
 	if qe.enableSnapshots {
-		if snap, meta, err := qe.snapshotManager.RestoreLatestSnapshot(); err == nil {
+		if snap, meta, err := qe.snapshotManager.RestoreLatestSnapshot(); err == nil && meta.BaseEventID <= upTo {
 			baseState = snap
 			baseEventID = meta.BaseEventID
 		}
@@ -55,26 +132,140 @@ func (qe *QueryEngine) GetCurrentState() (*DerivedState, error) {
 
 	// Get events since snapshot
 	events, err := qe.eventStore.GetEventsFrom(baseEventID + 1)
+	if errors.Is(err, eventlog.ErrCompacted) {
+		// The snapshot predates the compaction boundary; the base segment replaces it
+		baseState = &DerivedState{
+			Tables:      make(map[string]map[int64]Row),
+			DeletedRows: make(map[string]map[int64]bool),
+		}
+		baseEventID = 0
+		events, err = qe.eventStore.GetEventsFrom(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Ignore anything appended after the pinned event
+	for i, e := range events {
+		if e.ID > upTo {
+			events = events[:i]
+			break
+		}
+	}
+
+	handler, err := qe.migrationHandlerLocked()
 	if err != nil {
 		return nil, err
 	}
 
+	start := time.Now()
+
 	// Replay events onto base state
 	if len(events) > 0 {
 		// Replay events onto base state to merge snapshot with new events
-		replayedState, err := replayEventsOntoState(baseState, events)
+		replayedState, err := replayEventsOntoState(baseState, events, handler)
 		if err != nil {
 			return nil, err
 		}
 		baseState = replayedState
 	}
 
-	qe.cachedState = baseState
-	qe.cachedUpToEventID = qe.eventStore.GetLastEventID()
+	qe.lastSource = StateSource{SnapshotEventID: baseEventID, Replayed: len(events), ReplayTime: time.Since(start)}
 
+	baseState.EnableLazyMigration(handler, nil)
 	return baseState, nil
 }
 
+// SetLazyMigration enables or disables lazy migration. When enabled, states
+// migrate a row to its table's latest schema version when it is read, or when
+// an update written in a newer version is replayed onto it.
+func (qe *QueryEngine) SetLazyMigration(enabled bool) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+	qe.lazyMigration = enabled
+	qe.migrationHandler = nil
+	qe.migrationsAt = 0
+}
+
+// migrationHandlerLocked returns the handler for lazy migration, rebuilding it
+// from the log if a schema event arrived since it was built (caller holds qe.mu)
+func (qe *QueryEngine) migrationHandlerLocked() (*MigrationHandler, error) {
+	if !qe.lazyMigration {
+		return nil, nil
+	}
+
+	schemaEventID := qe.eventStore.LastSchemaEventID()
+	if qe.migrationHandler != nil && qe.migrationsAt == schemaEventID {
+		return qe.migrationHandler, nil
+	}
+
+	events, err := qe.eventStore.ReadAllEvents()
+	if err != nil {
+		return nil, err
+	}
+	registry, err := RegistryFromEvents(events)
+	if err != nil {
+		return nil, err
+	}
+	qe.migrationHandler = NewMigrationHandler(registry)
+	qe.migrationsAt = schemaEventID
+	return qe.migrationHandler, nil
+}
+
+// GetStateAsOf returns the database state as it was right after eventID.
+// History folded by compaction is gone, so points before the compaction
+// boundary fail with ErrBeforeCompaction.
+func (qe *QueryEngine) GetStateAsOf(eventID uint64) (*DerivedState, error) {
+	if eventID == 0 {
+		return nil, fmt.Errorf("invalid event ID 0")
+	}
+	if last := qe.eventStore.GetLastEventID(); eventID > last {
+		return nil, fmt.Errorf("event %d is beyond the end of the log (last event %d)", eventID, last)
+	}
+	if boundary := qe.eventStore.CompactionBoundary(); eventID < boundary {
+		return nil, fmt.Errorf("%w: state as of event %d is unavailable, events up to %d were compacted",
+			ErrBeforeCompaction, eventID, boundary)
+	}
+
+	events, err := qe.eventStore.ReadAllEvents()
+	if err != nil {
+		return nil, err
+	}
+	state, err := ReplayEventsUpTo(events, eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows are read in the schema versions in effect at that point
+	qe.mu.Lock()
+	handler, err := qe.migrationHandlerLocked()
+	qe.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	state.EnableLazyMigration(handler, schemaVersionsAt(events, eventID))
+	return state, nil
+}
+
+// schemaVersionsAt returns every table's schema version right after eventID
+func schemaVersionsAt(events []*eventlog.Event, eventID uint64) SchemaTargets {
+	targets := make(SchemaTargets)
+	for _, e := range events {
+		if e.ID > eventID {
+			break
+		}
+		if e.Type != eventlog.SchemaCreated && e.Type != eventlog.SchemaEvolved {
+			continue
+		}
+		if payload, ok := e.Payload.(map[string]interface{}); ok {
+			if tableName, ok := payload["table_name"].(string); ok {
+				targets[tableName] = e.Version
+			}
+		}
+	}
+	return targets
+}
+
 // GetTableRows returns all active rows for a table
 func (qe *QueryEngine) GetTableRows(tableName string) ([]RowWithID, error) {
 	state, err := qe.GetCurrentState()
@@ -1,17 +1,15 @@
 package storage
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"rdbms/eventlog"
+	"rdbms/schema"
 )
 
 // DeterministicReplayOptions configures deterministic replay behavior
 type DeterministicReplayOptions struct {
-	// TargetSchemaVersion is the schema version to replay events to (current version if not set)
-	TargetSchemaVersion int
+	// TargetSchemaVersions is the schema version to replay each table to (latest registered version if not set)
+	TargetSchemaVersions SchemaTargets
 	// SkipCorrupted determines if corrupted events should be skipped (partial recovery)
 	SkipCorrupted bool
 	// CollectErrors determines if errors should be collected (for reporting)
@@ -24,7 +22,7 @@ type DeterministicReplayOptions struct {
 type CorruptionIssue struct {
 	EventID   uint64 `json:"event_id"`
 	EventType string `json:"event_type"`
-	IssueType string `json:"issue_type"` // "checksum_mismatch", "invalid_payload", "schema_violation"
+	IssueType string `json:"issue_type"` // "checksum_mismatch", "invalid_payload", "chain_break", "event_gap", "out_of_order", "base_segment_mismatch", "unsigned_base_segment"
 	Message   string `json:"message"`
 	Position  int64  `json:"position"` // Byte offset in log file
 	Timestamp string `json:"timestamp"`
@@ -50,25 +48,14 @@ type ReplayResult struct {
 	ReplayDeterministic bool // True if replay is guaranteed to produce same result
 }
 
-// ComputeEventChecksum computes the SHA256 checksum of an event (excluding the checksum field)
+// ComputeEventChecksum computes the SHA256 checksum of an event (excluding the checksum field).
+// It matches the checksum written by the event log, including the hash chain link.
 func ComputeEventChecksum(e *eventlog.Event) (string, error) {
-	// Create a copy of the event without the checksum field
-	eventCopy := map[string]interface{}{
-		"id":        e.ID,
-		"type":      e.Type,
-		"timestamp": e.Timestamp,
-		"version":   e.Version,
-		"tx_id":     e.TxID,
-		"payload":   e.Payload,
-	}
-
-	jsonBytes, err := json.Marshal(eventCopy)
+	checksum, err := eventlog.ComputeChecksum(e)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal event for checksum: %w", err)
 	}
-
-	hash := sha256.Sum256(jsonBytes)
-	return hex.EncodeToString(hash[:]), nil
+	return checksum, nil
 }
 
 // ValidateEventChecksum verifies that an event's checksum is valid
@@ -141,10 +128,77 @@ func DetectCorruption(events []*eventlog.Event, schemaRegistry interface{}) *Cor
 		}
 	}
 
+	detectChainIssues(events, report)
+
+	report.CanPartialReplay = report.CorruptedEvents < len(events)
+	return report
+}
+
+// DetectCorruption analyzes the stored log. Besides the checks on the events
+// themselves it verifies the compacted base segment, whose signature cannot be
+// checked from the events alone.
+func (es *EventStore) DetectCorruption() *CorruptionReport {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+
+	events, _ := es.log.Read()
+	report := DetectCorruption(events, nil)
+
+	for _, ci := range es.log.VerifyBaseSegment() {
+		report.Issues = append(report.Issues, CorruptionIssue{
+			EventID:   ci.EventID,
+			IssueType: string(ci.Kind),
+			Message:   ci.Message,
+		})
+		report.CorruptedEvents++
+		if report.FirstIssueAt == 0 || ci.EventID < report.FirstIssueAt {
+			report.FirstIssueAt = ci.EventID
+		}
+	}
+
 	report.CanPartialReplay = report.CorruptedEvents < len(events)
 	return report
 }
 
+// detectChainIssues adds hash chain breaks, ID gaps and reordering to a corruption report.
+// Checksum failures are already reported per event, so they are not repeated here.
+func detectChainIssues(events []*eventlog.Event, report *CorruptionReport) {
+	flagged := make(map[uint64]bool, len(report.Issues))
+	for _, issue := range report.Issues {
+		flagged[issue.EventID] = true
+	}
+
+	timestamps := make(map[uint64]string, len(events))
+	types := make(map[uint64]string, len(events))
+	for _, e := range events {
+		timestamps[e.ID] = e.Timestamp.String()
+		types[e.ID] = string(e.Type)
+	}
+
+	chain := eventlog.VerifyChain(events)
+	for _, ci := range chain.Issues {
+		if ci.Kind == eventlog.ChainChecksumMismatch {
+			continue
+		}
+
+		report.Issues = append(report.Issues, CorruptionIssue{
+			EventID:   ci.EventID,
+			EventType: types[ci.EventID],
+			IssueType: string(ci.Kind),
+			Message:   ci.Message,
+			Timestamp: timestamps[ci.EventID],
+		})
+
+		if !flagged[ci.EventID] {
+			flagged[ci.EventID] = true
+			report.CorruptedEvents++
+		}
+		if report.FirstIssueAt == 0 || ci.EventID < report.FirstIssueAt {
+			report.FirstIssueAt = ci.EventID
+		}
+	}
+}
+
 // validatePayloadStructure verifies that an event's payload has the required fields
 func validatePayloadStructure(e *eventlog.Event) bool {
 	payload, ok := e.Payload.(map[string]interface{})
@@ -180,6 +234,22 @@ func validatePayloadStructure(e *eventlog.Event) bool {
 		_, hasEvolution := payload["evolution"]
 		return hasTable && hasEvolution
 
+	case eventlog.SnapshotCreated:
+		_, hasID := payload["snapshot_id"]
+		_, hasBase := payload["base_event_id"]
+		return hasID && hasBase
+
+	case eventlog.IndexCreated:
+		_, hasTable := payload["table_name"]
+		_, hasName := payload["index_name"]
+		_, hasCols := payload["columns"]
+		return hasTable && hasName && hasCols
+
+	case eventlog.IndexDropped:
+		_, hasTable := payload["table_name"]
+		_, hasName := payload["index_name"]
+		return hasTable && hasName
+
 	default:
 		return true // Unknown types are not considered corrupt
 	}
@@ -296,19 +366,15 @@ func applyEventDeterministic(e *eventlog.Event, state *DerivedState, tableVersio
 			state.DeletedRows[tableName] = make(map[int64]bool)
 		}
 
-		// Apply migration if needed
-		if migrationHandler != nil && opts.TargetSchemaVersion > 0 {
-			eventVersion := e.Version
-			if eventVersion != opts.TargetSchemaVersion {
-				migratedRow, err := migrationHandler.MigrateRowIfNeeded(tableName, row, eventVersion, opts.TargetSchemaVersion)
-				if err == nil {
-					row = migratedRow
-				}
-			}
-		}
-
 		state.Tables[tableName][rowID] = row
+		state.setRowVersion(tableName, rowID, rowSchemaVersion(e, tableName, tableVersions))
 		delete(state.DeletedRows[tableName], rowID)
+		state.clearMigrationError(tableName, rowID)
+
+		// Apply migration if needed; failures are listed in schema.MigrationErrorTable
+		if target := migrationHandler.targetFor(tableName, opts.TargetSchemaVersions); target > 0 && state.RowVersion(tableName, rowID) != target {
+			state.migrateStoredRow(tableName, rowID, target, migrationHandler)
+		}
 
 	case eventlog.RowUpdated:
 		tableName, _ := payload["table_name"].(string)
@@ -327,6 +393,13 @@ func applyEventDeterministic(e *eventlog.Event, state *DerivedState, tableVersio
 			state.DeletedRows[tableName] = make(map[int64]bool)
 		}
 
+		if dead, exists := deadLetterRow(state, tableName, rowID); exists {
+			for k, v := range changesRaw {
+				dead[k] = v
+			}
+			return
+		}
+
 		if _, exists := state.Tables[tableName][rowID]; !exists {
 			state.Tables[tableName][rowID] = make(Row)
 		}
@@ -350,11 +423,20 @@ func applyEventDeterministic(e *eventlog.Event, state *DerivedState, tableVersio
 			state.DeletedRows[tableName] = make(map[int64]bool)
 		}
 
+		if _, exists := deadLetterRow(state, tableName, rowID); exists {
+			state.DeletedRows[schema.DeadLetterTable(tableName)][rowID] = true
+			return
+		}
+
 		state.DeletedRows[tableName][rowID] = true
+		state.clearMigrationError(tableName, rowID)
 
 	case eventlog.SchemaEvolved:
 		tableName, _ := payload["table_name"].(string)
 		tableVersions[tableName] = e.Version
+
+	case eventlog.SnapshotCreated:
+		// Snapshots record state, they never change it
 	}
 }
 
@@ -1,9 +1,14 @@
 package storage
 
-import "rdbms/eventlog"
+import (
+	"rdbms/eventlog"
+	"rdbms/schema"
+)
 
-// replayEventsOntoState merges new events onto an existing state
-func replayEventsOntoState(baseState *DerivedState, events []*eventlog.Event) (*DerivedState, error) {
+// replayEventsOntoState merges new events onto an existing state. Rows keep the
+// schema version they were written in; with a migration handler, a row written
+// in an older version is migrated before an update in a newer version applies.
+func replayEventsOntoState(baseState *DerivedState, events []*eventlog.Event, handler *MigrationHandler) (*DerivedState, error) {
 	// Deep copy base state to avoid mutating it
 	newTables := make(map[string]map[int64]Row, len(baseState.Tables))
 	for tbl, rows := range baseState.Tables {
@@ -21,10 +26,20 @@ func replayEventsOntoState(baseState *DerivedState, events []*eventlog.Event) (*
 		}
 		newDeleted[tbl] = delCopy
 	}
+	newVersions := make(map[string]map[int64]int, len(baseState.RowVersions))
+	for tbl, versions := range baseState.RowVersions {
+		versionCopy := make(map[int64]int, len(versions))
+		for id, version := range versions {
+			versionCopy[id] = version
+		}
+		newVersions[tbl] = versionCopy
+	}
 	state := &DerivedState{
 		Tables:      newTables,
 		DeletedRows: newDeleted,
+		RowVersions: newVersions,
 	}
+	tableSchemaVersions := make(map[string]int)
 
 	for _, e := range events {
 		switch e.Type {
@@ -36,6 +51,7 @@ func replayEventsOntoState(baseState *DerivedState, events []*eventlog.Event) (*
 				state.Tables[tableName] = make(map[int64]Row)
 				state.DeletedRows[tableName] = make(map[int64]bool)
 			}
+			tableSchemaVersions[tableName] = e.Version
 
 		case eventlog.RowInserted:
 			payload := e.Payload.(map[string]interface{})
@@ -49,6 +65,7 @@ func replayEventsOntoState(baseState *DerivedState, events []*eventlog.Event) (*
 			}
 
 			state.Tables[tableName][rowID] = Row(dataRaw)
+			state.setRowVersion(tableName, rowID, rowSchemaVersion(e, tableName, tableSchemaVersions))
 			delete(state.DeletedRows[tableName], rowID)
 
 		case eventlog.RowUpdated:
@@ -62,6 +79,21 @@ func replayEventsOntoState(baseState *DerivedState, events []*eventlog.Event) (*
 				state.DeletedRows[tableName] = make(map[int64]bool)
 			}
 
+			// The changes are in the update's schema version; bring the row there first
+			if _, exists := state.Tables[tableName][rowID]; exists && handler != nil {
+				current := state.RowVersion(tableName, rowID)
+				if version := rowSchemaVersion(e, tableName, tableSchemaVersions); current > 0 && version > current {
+					state.migrateStoredRow(tableName, rowID, version, handler)
+				}
+			}
+
+			if dead, exists := deadLetterRow(state, tableName, rowID); exists {
+				for k, v := range changesRaw {
+					dead[k] = v
+				}
+				continue
+			}
+
 			if _, exists := state.Tables[tableName][rowID]; !exists {
 				state.Tables[tableName][rowID] = make(Row)
 			}
@@ -80,7 +112,21 @@ func replayEventsOntoState(baseState *DerivedState, events []*eventlog.Event) (*
 				state.DeletedRows[tableName] = make(map[int64]bool)
 			}
 
+			if _, exists := deadLetterRow(state, tableName, rowID); exists {
+				state.DeletedRows[schema.DeadLetterTable(tableName)][rowID] = true
+				continue
+			}
+
 			state.DeletedRows[tableName][rowID] = true
+			state.clearMigrationError(tableName, rowID)
+
+		case eventlog.SchemaEvolved:
+			payload := e.Payload.(map[string]interface{})
+			tableName := payload["table_name"].(string)
+			tableSchemaVersions[tableName] = e.Version
+
+		case eventlog.SnapshotCreated:
+			// Snapshots record state, they never change it
 		}
 	}
 
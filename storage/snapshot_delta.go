@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// diffStates returns the changes that turn parent into child: rows that are new or
+// different (or now in another schema version), rows that became deleted, rows that
+// are no longer deleted, and rows or tables that are gone altogether (compaction
+// drops deleted rows, dead-lettering moves rows to another table).
+// Tables that do not exist in parent are carried whole so that empty tables survive.
+func diffStates(parent, child *DerivedState) SnapshotData {
+	delta := SnapshotData{
+		Tables:      make(map[string]map[int64]Row),
+		DeletedRows: make(map[string]map[int64]bool),
+		Undeleted:   make(map[string][]int64),
+	}
+
+	for tableName, rows := range child.Tables {
+		parentRows, existed := parent.Tables[tableName]
+		if !existed {
+			delta.Tables[tableName] = rows
+			for rowID := range rows {
+				setDeltaRowVersion(&delta, child, tableName, rowID)
+			}
+			continue
+		}
+
+		for rowID, row := range rows {
+			if old, ok := parentRows[rowID]; ok && rowsEqual(old, row) &&
+				parent.RowVersion(tableName, rowID) == child.RowVersion(tableName, rowID) {
+				continue
+			}
+			if delta.Tables[tableName] == nil {
+				delta.Tables[tableName] = make(map[int64]Row)
+			}
+			delta.Tables[tableName][rowID] = row
+			setDeltaRowVersion(&delta, child, tableName, rowID)
+		}
+	}
+
+	for tableName, deleted := range child.DeletedRows {
+		for rowID := range deleted {
+			if parent.DeletedRows[tableName][rowID] {
+				continue
+			}
+			if delta.DeletedRows[tableName] == nil {
+				delta.DeletedRows[tableName] = make(map[int64]bool)
+			}
+			delta.DeletedRows[tableName][rowID] = true
+		}
+	}
+
+	for tableName, deleted := range parent.DeletedRows {
+		for rowID := range deleted {
+			if _, present := child.Tables[tableName][rowID]; present && !child.DeletedRows[tableName][rowID] {
+				delta.Undeleted[tableName] = append(delta.Undeleted[tableName], rowID)
+			}
+		}
+		// Sorted so that the delta hash is deterministic
+		sortRowIDs(delta.Undeleted[tableName])
+	}
+
+	for tableName, rows := range parent.Tables {
+		childRows, exists := child.Tables[tableName]
+		if !exists {
+			delta.Dropped = append(delta.Dropped, tableName)
+			continue
+		}
+		for rowID := range rows {
+			if _, present := childRows[rowID]; present {
+				continue
+			}
+			if delta.Removed == nil {
+				delta.Removed = make(map[string][]int64)
+			}
+			delta.Removed[tableName] = append(delta.Removed[tableName], rowID)
+		}
+		sortRowIDs(delta.Removed[tableName])
+	}
+	sort.Strings(delta.Dropped)
+
+	return delta
+}
+
+// sortRowIDs sorts row IDs in place
+func sortRowIDs(ids []int64) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+// setDeltaRowVersion carries a row's schema version into a delta, if it is known
+func setDeltaRowVersion(delta *SnapshotData, child *DerivedState, tableName string, rowID int64) {
+	version := child.RowVersion(tableName, rowID)
+	if version == 0 {
+		return
+	}
+	if delta.RowVersions == nil {
+		delta.RowVersions = make(map[string]map[int64]int)
+	}
+	if delta.RowVersions[tableName] == nil {
+		delta.RowVersions[tableName] = make(map[int64]int)
+	}
+	delta.RowVersions[tableName][rowID] = version
+}
+
+// applyDelta applies a delta snapshot's changes to state in place
+func applyDelta(state *DerivedState, delta *SnapshotData) {
+	for _, tableName := range delta.Dropped {
+		delete(state.Tables, tableName)
+		delete(state.DeletedRows, tableName)
+		delete(state.RowVersions, tableName)
+	}
+
+	for tableName, rowIDs := range delta.Removed {
+		for _, rowID := range rowIDs {
+			delete(state.Tables[tableName], rowID)
+			delete(state.DeletedRows[tableName], rowID)
+			delete(state.RowVersions[tableName], rowID)
+		}
+	}
+
+	for tableName, rows := range delta.Tables {
+		if _, exists := state.Tables[tableName]; !exists {
+			state.Tables[tableName] = make(map[int64]Row)
+		}
+		if _, exists := state.DeletedRows[tableName]; !exists {
+			state.DeletedRows[tableName] = make(map[int64]bool)
+		}
+		for rowID, row := range rows {
+			state.Tables[tableName][rowID] = row
+			if version, ok := delta.RowVersions[tableName][rowID]; ok {
+				state.setRowVersion(tableName, rowID, version)
+			}
+		}
+	}
+
+	for tableName, deleted := range delta.DeletedRows {
+		if _, exists := state.DeletedRows[tableName]; !exists {
+			state.DeletedRows[tableName] = make(map[int64]bool)
+		}
+		for rowID := range deleted {
+			state.DeletedRows[tableName][rowID] = true
+		}
+	}
+
+	for tableName, rowIDs := range delta.Undeleted {
+		for _, rowID := range rowIDs {
+			delete(state.DeletedRows[tableName], rowID)
+		}
+	}
+}
+
+// rowsEqual compares rows by their JSON encoding, so that values restored from
+// disk (float64) compare equal to the in-memory values they were written from
+func rowsEqual(a, b Row) bool {
+	aData, errA := json.Marshal(a)
+	bData, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aData, bData)
+}
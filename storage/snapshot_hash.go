@@ -18,5 +18,39 @@ func computeSnapshotHash(state *DerivedState) (string, error) {
 	return hex.EncodeToString(hash[:]), nil
 }
 
+// computeSnapshotDataHash computes the hash stored in a snapshot's metadata.
+// Full snapshots hash the state; deltas hash the changes they carry.
+func computeSnapshotDataHash(kind string, snapData *SnapshotData) (string, error) {
+	if kind != SnapshotDelta {
+		return computeSnapshotHash(&DerivedState{
+			Tables:      snapData.Tables,
+			DeletedRows: snapData.DeletedRows,
+			RowVersions: snapData.RowVersions,
+		})
+	}
+
+	// Undeleted is omitted from the file when empty; hash it the same either way
+	undeleted := snapData.Undeleted
+	if len(undeleted) == 0 {
+		undeleted = nil
+	}
+
+	// Removals are left out when empty so that older deltas keep their hash
+	data, err := json.Marshal(struct {
+		Tables      map[string]map[int64]Row  `json:"tables"`
+		DeletedRows map[string]map[int64]bool `json:"deleted_rows"`
+		Undeleted   map[string][]int64        `json:"undeleted"`
+		Removed     map[string][]int64        `json:"removed,omitempty"`
+		Dropped     []string                  `json:"dropped,omitempty"`
+		RowVersions map[string]map[int64]int  `json:"row_versions,omitempty"`
+	}{snapData.Tables, snapData.DeletedRows, undeleted, snapData.Removed, snapData.Dropped, snapData.RowVersions})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
 // This function is removed as it cannot accurately calculate the event count from the state.
 // The event count should be tracked during state derivation and passed to the snapshot creation process.
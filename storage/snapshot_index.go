@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"rdbms/eventlog"
+)
+
+// snapshotTxID marks the events written for snapshots
+const snapshotTxID = "snapshot"
+
+// recordSnapshotLocked appends a SNAPSHOT_CREATED event for meta and stores the
+// event ID in meta.RecordedAt (caller holds sm.mu). Without an event store this is a no-op.
+func (sm *SnapshotManager) recordSnapshotLocked(meta *SnapshotMeta) error {
+	if sm.eventStore == nil {
+		return nil
+	}
+
+	path := meta.SnapshotPath
+	if rel, err := filepath.Rel(sm.dataDir, path); err == nil {
+		path = rel
+	}
+
+	event, err := sm.eventStore.RecordSnapshotCreated(&eventlog.SnapshotCreatedPayload{
+		SnapshotID:       meta.SnapshotID,
+		BaseEventID:      meta.BaseEventID,
+		CreatedAt:        meta.CreatedAt,
+		SnapshotPath:     filepath.ToSlash(path),
+		DataHash:         meta.DataHash,
+		EventsIncluded:   meta.EventsIncluded,
+		Kind:             meta.Kind,
+		ParentID:         meta.ParentID,
+		ChainDepth:       meta.ChainDepth,
+		MerkleRoot:       meta.MerkleRoot,
+		Format:           meta.Format,
+		Compression:      meta.Compression,
+		CompressedSize:   meta.CompressedSize,
+		UncompressedSize: meta.UncompressedSize,
+	}, snapshotTxID)
+	if err != nil {
+		return fmt.Errorf("failed to record snapshot %s: %w", meta.SnapshotID, err)
+	}
+
+	meta.RecordedAt = event.ID
+	return nil
+}
+
+// ReconcileIndex rebuilds the snapshot index from the SNAPSHOT_CREATED events in the log
+// and reports whether index.json changed.
+//
+// The log is authoritative for every snapshot it records: the latest event for a
+// snapshot ID supplies its metadata. Snapshots whose file is gone, whose base event
+// lies beyond the end of the log, or whose parent was dropped are removed. Index
+// entries the log does not mention (taken before snapshots were recorded) are kept
+// as long as their file exists.
+func (sm *SnapshotManager) ReconcileIndex() (bool, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.reconcileIndexLocked()
+}
+
+// reconcileIndexLocked implements ReconcileIndex (caller holds sm.mu)
+func (sm *SnapshotManager) reconcileIndexLocked() (bool, error) {
+	if sm.eventStore == nil {
+		return false, fmt.Errorf("no event store attached")
+	}
+
+	events, err := sm.eventStore.ReadAllEvents()
+	if err != nil {
+		return false, err
+	}
+	lastEventID := sm.eventStore.GetLastEventID()
+
+	// Latest recorded metadata per snapshot, in order of first appearance
+	recorded := make(map[string]SnapshotMeta)
+	order := make([]string, 0)
+	for _, e := range events {
+		if e.Type != eventlog.SnapshotCreated {
+			continue
+		}
+		payloadMap, ok := e.Payload.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var payload eventlog.SnapshotCreatedPayload
+		if err := ConvertPayload(payloadMap, &payload); err != nil || payload.SnapshotID == "" {
+			continue
+		}
+
+		if _, seen := recorded[payload.SnapshotID]; !seen {
+			order = append(order, payload.SnapshotID)
+		}
+		recorded[payload.SnapshotID] = sm.metaFromPayload(&payload, e.ID)
+	}
+
+	candidates := make([]SnapshotMeta, 0, len(sm.snapshotHistory)+len(order))
+	for _, meta := range sm.snapshotHistory {
+		if _, inLog := recorded[meta.SnapshotID]; !inLog {
+			candidates = append(candidates, meta)
+		}
+	}
+	for _, id := range order {
+		candidates = append(candidates, recorded[id])
+	}
+
+	kept := make([]SnapshotMeta, 0, len(candidates))
+	keptIDs := make(map[string]bool, len(candidates))
+	for _, meta := range candidates {
+		if meta.BaseEventID > lastEventID {
+			continue
+		}
+		if meta.Kind == SnapshotDelta && !keptIDs[meta.ParentID] {
+			continue
+		}
+		if _, err := os.Stat(meta.SnapshotPath); err != nil {
+			continue
+		}
+		kept = append(kept, meta)
+		keptIDs[meta.SnapshotID] = true
+	}
+
+	if sameHistory(sm.snapshotHistory, kept) {
+		return false, nil
+	}
+
+	sm.snapshotHistory = kept
+	sm.latestSnapshot = nil
+	if len(kept) > 0 {
+		sm.latestSnapshot = &sm.snapshotHistory[len(kept)-1]
+	}
+	return true, sm.saveSnapshotIndex()
+}
+
+// metaFromPayload converts a SNAPSHOT_CREATED payload back into index metadata
+func (sm *SnapshotManager) metaFromPayload(payload *eventlog.SnapshotCreatedPayload, eventID uint64) SnapshotMeta {
+	path := filepath.FromSlash(payload.SnapshotPath)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(sm.dataDir, path)
+	}
+
+	return SnapshotMeta{
+		SnapshotID:       payload.SnapshotID,
+		Kind:             payload.Kind,
+		ParentID:         payload.ParentID,
+		ChainDepth:       payload.ChainDepth,
+		BaseEventID:      payload.BaseEventID,
+		CreatedAt:        payload.CreatedAt,
+		SnapshotPath:     path,
+		DataHash:         payload.DataHash,
+		EventsIncluded:   payload.EventsIncluded,
+		MerkleRoot:       payload.MerkleRoot,
+		RecordedAt:       eventID,
+		Format:           payload.Format,
+		Compression:      payload.Compression,
+		CompressedSize:   payload.CompressedSize,
+		UncompressedSize: payload.UncompressedSize,
+	}
+}
+
+// sameHistory reports whether two snapshot histories are identical
+func sameHistory(a, b []SnapshotMeta) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if !x.CreatedAt.Equal(y.CreatedAt) {
+			return false
+		}
+		x.CreatedAt = y.CreatedAt
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
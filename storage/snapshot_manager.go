@@ -16,6 +16,8 @@ type SnapshotManager struct {
 	snapshotDir     string
 	latestSnapshot  *SnapshotMeta
 	snapshotHistory []SnapshotMeta
+	eventStore      *EventStore // Optional; used to record the Merkle root of each snapshot
+	maxChainDepth   int         // Deltas allowed after a full snapshot (0 = always full)
 }
 
 // NewSnapshotManager creates a new snapshot manager
@@ -29,6 +31,7 @@ func NewSnapshotManager(dataDir string) (*SnapshotManager, error) {
 		dataDir:         dataDir,
 		snapshotDir:     snapshotDir,
 		snapshotHistory: make([]SnapshotMeta, 0),
+		maxChainDepth:   DefaultMaxChainDepth,
 	}
 
 	// Load existing snapshot metadata
@@ -40,6 +43,18 @@ func NewSnapshotManager(dataDir string) (*SnapshotManager, error) {
 	return sm, nil
 }
 
+// SetEventStore attaches the event store whose events snapshots are built from.
+// Snapshots are recorded in the log from then on, and the index is reconciled
+// with the SNAPSHOT_CREATED events already there.
+func (sm *SnapshotManager) SetEventStore(es *EventStore) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.eventStore = es
+
+	// A stale index only costs replay time, so a failure here is not fatal
+	_, _ = sm.reconcileIndexLocked()
+}
+
 // loadSnapshotIndex loads the snapshot index from disk
 func (sm *SnapshotManager) loadSnapshotIndex() error {
 	indexPath := filepath.Join(sm.snapshotDir, "index.json")
@@ -71,50 +86,75 @@ func (sm *SnapshotManager) saveSnapshotIndex() error {
 	return os.WriteFile(indexPath, data, 0644)
 }
 
-// CreateSnapshot creates and saves a snapshot of the current state
+// SetMaxChainDepth sets how many delta snapshots may follow a full one (0 disables deltas)
+func (sm *SnapshotManager) SetMaxChainDepth(depth int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.maxChainDepth = depth
+}
+
+// CreateSnapshot creates and saves a snapshot of the given state.
+// When the latest snapshot can serve as a parent, only the rows that changed since
+// it are written (a delta); once the chain reaches the depth limit a full snapshot
+// is written instead.
 func (sm *SnapshotManager) CreateSnapshot(state *DerivedState, baseEventID uint64, eventsIncluded int64) (*SnapshotMeta, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	// Generate snapshot ID
-	snapshotID := fmt.Sprintf("snap_%d_%s", baseEventID, time.Now().Format("20060102_150405"))
-
-	// Compute data hash
-	dataHash, err := computeSnapshotHash(state)
-	if err != nil {
-		return nil, err
-	}
+	snapshotID := sm.uniqueIDLocked(fmt.Sprintf("snap_%d_%s", baseEventID, time.Now().Format("20060102_150405")))
 
 	// Create metadata
 	meta := SnapshotMeta{
 		SnapshotID:     snapshotID,
+		Kind:           SnapshotFull,
 		BaseEventID:    baseEventID,
 		CreatedAt:      time.Now().UTC(),
-		SnapshotPath:   filepath.Join(sm.snapshotDir, snapshotID+".json"),
-		DataHash:       dataHash,
+		SnapshotPath:   filepath.Join(sm.snapshotDir, snapshotID+".snap.gz"),
 		EventsIncluded: eventsIncluded,
 	}
 
-	// Create snapshot data
+	// Record which log prefix the snapshot summarizes so it can be audited
+	if sm.eventStore != nil {
+		if root, err := sm.eventStore.MerkleRootAt(baseEventID); err == nil {
+			meta.MerkleRoot = root
+		}
+	}
+
+	// Create snapshot data, as a delta against the latest snapshot when allowed
 	snapData := SnapshotData{
-		Meta:        meta,
 		Tables:      state.Tables,
 		DeletedRows: state.DeletedRows,
+		RowVersions: state.RowVersions,
+	}
+	// A parent from before the compaction boundary still holds history that
+	// compaction folded away, so the chain restarts with a full snapshot
+	var boundary uint64
+	if sm.eventStore != nil {
+		boundary = sm.eventStore.CompactionBoundary()
+	}
+	if parent := sm.latestSnapshot; parent != nil && sm.maxChainDepth > 0 &&
+		parent.ChainDepth < sm.maxChainDepth && parent.BaseEventID <= baseEventID &&
+		parent.BaseEventID >= boundary {
+		if parentState, _, err := sm.restoreLocked(parent.SnapshotID); err == nil {
+			snapData = diffStates(parentState, state)
+			meta.Kind = SnapshotDelta
+			meta.ParentID = parent.SnapshotID
+			meta.ChainDepth = parent.ChainDepth + 1
+		}
 	}
 
-	// Marshal and save
-	data, err := json.MarshalIndent(snapData, "", "  ")
-	if err != nil {
+	if err := sm.writeSnapshotLocked(&meta, &snapData); err != nil {
 		return nil, err
 	}
-
-	if err := os.WriteFile(meta.SnapshotPath, data, 0644); err != nil {
+	if err := sm.recordSnapshotLocked(&meta); err != nil {
+		os.Remove(meta.SnapshotPath)
 		return nil, err
 	}
 
 	// Update history
 	sm.snapshotHistory = append(sm.snapshotHistory, meta)
-	sm.latestSnapshot = &meta
+	sm.latestSnapshot = &sm.snapshotHistory[len(sm.snapshotHistory)-1]
 
 	// Persist index
 	if err := sm.saveSnapshotIndex(); err != nil {
@@ -124,54 +164,120 @@ func (sm *SnapshotManager) CreateSnapshot(state *DerivedState, baseEventID uint6
 	return &meta, nil
 }
 
-// RestoreFromSnapshot loads a snapshot from disk
-func (sm *SnapshotManager) RestoreFromSnapshot(snapshotID string) (*DerivedState, *SnapshotMeta, error) {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
+// uniqueIDLocked returns id, suffixed if a snapshot with that ID already exists (caller holds sm.mu)
+func (sm *SnapshotManager) uniqueIDLocked(id string) string {
+	candidate := id
+	for n := 2; sm.findLocked(candidate) != nil; n++ {
+		candidate = fmt.Sprintf("%s_%d", id, n)
+	}
+	return candidate
+}
 
-	// Find snapshot metadata
-	var meta *SnapshotMeta
+// findLocked returns the metadata of a snapshot in the history (caller holds sm.mu)
+func (sm *SnapshotManager) findLocked(snapshotID string) *SnapshotMeta {
 	for i := range sm.snapshotHistory {
 		if sm.snapshotHistory[i].SnapshotID == snapshotID {
-			meta = &sm.snapshotHistory[i]
-			break
+			return &sm.snapshotHistory[i]
 		}
 	}
+	return nil
+}
+
+// writeSnapshotLocked streams the snapshot to disk and records its hash and sizes in meta (caller holds sm.mu)
+func (sm *SnapshotManager) writeSnapshotLocked(meta *SnapshotMeta, snapData *SnapshotData) error {
+	if meta.Format == SnapshotFormatJSON || filepath.Ext(meta.SnapshotPath) == ".json" {
+		// Rewrites of legacy snapshots switch to the streaming format
+		meta.SnapshotPath = filepath.Join(sm.snapshotDir, meta.SnapshotID+".snap.gz")
+	}
+	return writeSnapshotStream(meta, snapData)
+}
+
+// RestoreFromSnapshot loads a snapshot from disk, walking the delta chain back to its full snapshot
+func (sm *SnapshotManager) RestoreFromSnapshot(snapshotID string) (*DerivedState, *SnapshotMeta, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.restoreLocked(snapshotID)
+}
 
+// restoreLocked loads and validates a snapshot and its ancestors (caller holds sm.mu)
+func (sm *SnapshotManager) restoreLocked(snapshotID string) (*DerivedState, *SnapshotMeta, error) {
+	// Find snapshot metadata
+	meta := sm.findLocked(snapshotID)
 	if meta == nil {
 		return nil, nil, fmt.Errorf("snapshot %s not found", snapshotID)
 	}
 
-	// Load snapshot file
+	// Collect the chain from this snapshot back to its full snapshot
+	chain := []*SnapshotMeta{meta}
+	for link := meta; link.Kind == SnapshotDelta; {
+		parent := sm.findLocked(link.ParentID)
+		if parent == nil {
+			return nil, nil, fmt.Errorf("snapshot %s: parent %s not found", link.SnapshotID, link.ParentID)
+		}
+		if len(chain) > len(sm.snapshotHistory) {
+			return nil, nil, fmt.Errorf("snapshot %s: delta chain has a cycle", snapshotID)
+		}
+		chain = append(chain, parent)
+		link = parent
+	}
+
+	// Apply links from the full snapshot forward, verifying each one
+	var state *DerivedState
+	for i := len(chain) - 1; i >= 0; i-- {
+		link := chain[i]
+		snapData, err := loadSnapshotData(link)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if state == nil {
+			state = &DerivedState{
+				Tables:      snapData.Tables,
+				DeletedRows: snapData.DeletedRows,
+				RowVersions: snapData.RowVersions,
+			}
+			if state.Tables == nil {
+				state.Tables = make(map[string]map[int64]Row)
+			}
+			if state.DeletedRows == nil {
+				state.DeletedRows = make(map[string]map[int64]bool)
+			}
+			continue
+		}
+		applyDelta(state, snapData)
+	}
+
+	return state, meta, nil
+}
+
+// loadSnapshotData reads one snapshot file and checks it against its metadata
+func loadSnapshotData(meta *SnapshotMeta) (*SnapshotData, error) {
+	if meta.Format == SnapshotFormatStream {
+		return readSnapshotStream(meta)
+	}
+
+	// Legacy single-document JSON snapshot
 	data, err := os.ReadFile(meta.SnapshotPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	var snapData SnapshotData
 	if err := json.Unmarshal(data, &snapData); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Validate hash
-	computedHash, err := computeSnapshotHash(&DerivedState{
-		Tables:      snapData.Tables,
-		DeletedRows: snapData.DeletedRows,
-	})
+	computedHash, err := computeSnapshotDataHash(meta.Kind, &snapData)
 	if err != nil {
-		return nil, nil, err
-	}
-
-	if computedHash != snapData.Meta.DataHash {
-		return nil, nil, fmt.Errorf("snapshot data corruption detected: hash mismatch")
+		return nil, err
 	}
 
-	state := &DerivedState{
-		Tables:      snapData.Tables,
-		DeletedRows: snapData.DeletedRows,
+	if computedHash != meta.DataHash || computedHash != snapData.Meta.DataHash {
+		return nil, fmt.Errorf("snapshot data corruption detected in %s: hash mismatch", meta.SnapshotID)
 	}
 
-	return state, meta, nil
+	return &snapData, nil
 }
 
 // RestoreLatestSnapshot restores the most recent snapshot
@@ -183,7 +289,7 @@ func (sm *SnapshotManager) RestoreLatestSnapshot() (*DerivedState, *SnapshotMeta
 		return nil, nil, fmt.Errorf("no snapshots available")
 	}
 
-	return sm.RestoreFromSnapshot(sm.latestSnapshot.SnapshotID)
+	return sm.restoreLocked(sm.latestSnapshot.SnapshotID)
 }
 
 // GetLatestSnapshotMeta returns metadata about the most recent snapshot
@@ -209,13 +315,76 @@ func (sm *SnapshotManager) PruneOldSnapshots(keepCount int) error {
 		return nil
 	}
 
-	// Delete old snapshot files
-	for i := 0; i < len(sm.snapshotHistory)-keepCount; i++ {
-		os.Remove(sm.snapshotHistory[i].SnapshotPath)
+	cutoff := len(sm.snapshotHistory) - keepCount
+	return sm.pruneLocked(func(i int, _ SnapshotMeta) bool { return i >= cutoff })
+}
+
+// ApplyRetention deletes the snapshots the policy does not keep and returns how many were removed.
+// The most recent snapshot is always kept.
+func (sm *SnapshotManager) ApplyRetention(policy RetentionPolicy) (int, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	keep := policy.selectKept(sm.snapshotHistory)
+	before := len(sm.snapshotHistory)
+	if len(keep) == before {
+		return 0, nil
+	}
+	if err := sm.pruneLocked(func(i int, _ SnapshotMeta) bool { return keep[i] }); err != nil {
+		return 0, err
+	}
+	return before - len(sm.snapshotHistory), nil
+}
+
+// pruneLocked deletes every snapshot for which keep returns false (caller holds sm.mu).
+// A kept delta whose parent is deleted is first rewritten as a full snapshot, so
+// every remaining chain still leads back to a full snapshot.
+func (sm *SnapshotManager) pruneLocked(keep func(i int, meta SnapshotMeta) bool) error {
+	keptIDs := make(map[string]bool, len(sm.snapshotHistory))
+	for i, meta := range sm.snapshotHistory {
+		if keep(i, meta) {
+			keptIDs[meta.SnapshotID] = true
+		}
+	}
+
+	// Rebase orphaned deltas while their ancestors are still on disk
+	for i := range sm.snapshotHistory {
+		meta := &sm.snapshotHistory[i]
+		if !keptIDs[meta.SnapshotID] || meta.Kind != SnapshotDelta || keptIDs[meta.ParentID] {
+			continue
+		}
+		state, _, err := sm.restoreLocked(meta.SnapshotID)
+		if err != nil {
+			return fmt.Errorf("cannot prune parent of snapshot %s: %w", meta.SnapshotID, err)
+		}
+		meta.Kind = SnapshotFull
+		meta.ParentID = ""
+		meta.ChainDepth = 0
+		if err := sm.writeSnapshotLocked(meta, &SnapshotData{Tables: state.Tables, DeletedRows: state.DeletedRows, RowVersions: state.RowVersions}); err != nil {
+			return err
+		}
+		if err := sm.recordSnapshotLocked(meta); err != nil {
+			return err
+		}
+	}
+
+	kept := make([]SnapshotMeta, 0, len(keptIDs))
+	depths := make(map[string]int, len(keptIDs))
+	for _, meta := range sm.snapshotHistory {
+		if !keptIDs[meta.SnapshotID] {
+			os.Remove(meta.SnapshotPath)
+			continue
+		}
+		meta.ChainDepth = 0
+		if meta.Kind == SnapshotDelta {
+			meta.ChainDepth = depths[meta.ParentID] + 1
+		}
+		depths[meta.SnapshotID] = meta.ChainDepth
+		kept = append(kept, meta)
 	}
 
 	// Update history
-	sm.snapshotHistory = sm.snapshotHistory[len(sm.snapshotHistory)-keepCount:]
+	sm.snapshotHistory = kept
 
 	// Update latest
 	if len(sm.snapshotHistory) > 0 {
@@ -2,14 +2,33 @@ package storage
 
 import "time"
 
+// Snapshot kinds
+const (
+	SnapshotFull  = "full"  // Complete state
+	SnapshotDelta = "delta" // Rows changed since the parent snapshot
+)
+
+// DefaultMaxChainDepth is how many delta snapshots may follow a full snapshot
+const DefaultMaxChainDepth = 10
+
 // SnapshotMeta contains metadata about a snapshot
 type SnapshotMeta struct {
 	SnapshotID     string    `json:"snapshot_id"`
+	Kind           string    `json:"kind,omitempty"`        // SnapshotFull or SnapshotDelta (empty means full)
+	ParentID       string    `json:"parent_id,omitempty"`   // Snapshot a delta applies to
+	ChainDepth     int       `json:"chain_depth,omitempty"` // Number of deltas since the last full snapshot
 	BaseEventID    uint64    `json:"base_event_id"`
 	CreatedAt      time.Time `json:"created_at"`
 	SnapshotPath   string    `json:"snapshot_path"`
 	DataHash       string    `json:"data_hash"`
 	EventsIncluded int64     `json:"events_included"`
+	MerkleRoot     string    `json:"merkle_root,omitempty"` // Root over the events the snapshot was built from
+	RecordedAt     uint64    `json:"recorded_at,omitempty"` // ID of the SNAPSHOT_CREATED event for this snapshot
+
+	Format           string `json:"format,omitempty"`            // SnapshotFormatStream or SnapshotFormatJSON (empty means JSON)
+	Compression      string `json:"compression,omitempty"`       // CompressionGzip or CompressionNone
+	CompressedSize   int64  `json:"compressed_size,omitempty"`   // Bytes on disk
+	UncompressedSize int64  `json:"uncompressed_size,omitempty"` // Bytes of the decoded stream
 }
 
 // SnapshotData holds the actual state data
@@ -17,4 +36,8 @@ type SnapshotData struct {
 	Meta        SnapshotMeta              `json:"meta"`
 	Tables      map[string]map[int64]Row  `json:"tables"`
 	DeletedRows map[string]map[int64]bool `json:"deleted_rows"`
+	Undeleted   map[string][]int64        `json:"undeleted,omitempty"` // Delta only: rows no longer deleted
+	Removed     map[string][]int64        `json:"removed,omitempty"`   // Delta only: rows no longer present at all
+	Dropped     []string                  `json:"dropped,omitempty"`   // Delta only: tables no longer present
+	RowVersions map[string]map[int64]int  `json:"row_versions,omitempty"`
 }
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SnapshotPolicy decides when the scheduler takes a snapshot. A snapshot is due
+// as soon as any enabled trigger fires; a zero field disables that trigger.
+type SnapshotPolicy struct {
+	EveryNEvents  uint64        // Events appended since the latest snapshot
+	Interval      time.Duration // Time since the latest snapshot, if new events arrived
+	MaxReplayTime time.Duration // Time the last state build spent replaying events past the snapshot
+	CheckInterval time.Duration // How often the scheduler evaluates the policy
+}
+
+// DefaultSnapshotPolicy returns the policy used by new databases
+func DefaultSnapshotPolicy() SnapshotPolicy {
+	return SnapshotPolicy{
+		EveryNEvents:  1000,
+		Interval:      10 * time.Minute,
+		MaxReplayTime: 200 * time.Millisecond,
+		CheckInterval: time.Second,
+	}
+}
+
+// RetentionPolicy decides which snapshots survive pruning. A snapshot is kept if
+// either rule selects it; the most recent snapshot is always kept. A zero policy
+// disables pruning.
+type RetentionPolicy struct {
+	KeepLast  int // Number of most recent snapshots to keep
+	KeepDaily int // Keep the newest snapshot of each of this many most recent days
+}
+
+// DefaultRetentionPolicy returns the retention used by new databases
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{KeepLast: 5, KeepDaily: 7}
+}
+
+// selectKept returns the indexes of the snapshots to keep from a chronological history
+func (p RetentionPolicy) selectKept(history []SnapshotMeta) map[int]bool {
+	keep := make(map[int]bool, len(history))
+	if len(history) == 0 {
+		return keep
+	}
+	if p.KeepLast <= 0 && p.KeepDaily <= 0 {
+		for i := range history {
+			keep[i] = true
+		}
+		return keep
+	}
+
+	keep[len(history)-1] = true
+	for i := len(history) - 1; i >= 0 && i >= len(history)-p.KeepLast; i-- {
+		keep[i] = true
+	}
+
+	days := 0
+	lastDay := ""
+	for i := len(history) - 1; i >= 0 && days < p.KeepDaily; i-- {
+		day := history[i].CreatedAt.UTC().Format("2006-01-02")
+		if day == lastDay {
+			continue
+		}
+		keep[i] = true
+		lastDay = day
+		days++
+	}
+
+	return keep
+}
+
+// SnapshotScheduler takes snapshots in the background according to a policy
+// and prunes old ones according to a retention policy
+type SnapshotScheduler struct {
+	mu              sync.Mutex
+	eventStore      *EventStore
+	snapshotManager *SnapshotManager
+	queryEngine     *QueryEngine
+	policy          SnapshotPolicy
+	retention       RetentionPolicy
+	startedAt       time.Time
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewSnapshotScheduler creates a scheduler; call Start to run it in the background
+func NewSnapshotScheduler(eventStore *EventStore, snapshotManager *SnapshotManager, queryEngine *QueryEngine, policy SnapshotPolicy, retention RetentionPolicy) *SnapshotScheduler {
+	return &SnapshotScheduler{
+		eventStore:      eventStore,
+		snapshotManager: snapshotManager,
+		queryEngine:     queryEngine,
+		policy:          policy,
+		retention:       retention,
+		startedAt:       time.Now(),
+	}
+}
+
+// SetPolicy replaces the snapshot and retention policies
+func (s *SnapshotScheduler) SetPolicy(policy SnapshotPolicy, retention RetentionPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = policy
+	s.retention = retention
+}
+
+// Due reports whether the policy calls for a snapshot, and which trigger fired
+func (s *SnapshotScheduler) Due() (bool, string) {
+	s.mu.Lock()
+	policy := s.policy
+	since := s.startedAt
+	s.mu.Unlock()
+
+	var lastSnapshotID uint64
+	if meta := s.snapshotManager.GetLatestSnapshotMeta(); meta != nil {
+		// The snapshot's own SNAPSHOT_CREATED event does not make it stale
+		lastSnapshotID = meta.BaseEventID
+		if meta.RecordedAt > lastSnapshotID {
+			lastSnapshotID = meta.RecordedAt
+		}
+		since = meta.CreatedAt
+	}
+
+	lastEventID := s.eventStore.GetLastEventID()
+	if lastEventID <= lastSnapshotID {
+		return false, ""
+	}
+	pending := lastEventID - lastSnapshotID
+
+	if policy.EveryNEvents > 0 && pending >= policy.EveryNEvents {
+		return true, fmt.Sprintf("%d events since last snapshot", pending)
+	}
+	if policy.Interval > 0 && time.Since(since) >= policy.Interval {
+		return true, fmt.Sprintf("%s since last snapshot", time.Since(since).Round(time.Second))
+	}
+	if policy.MaxReplayTime > 0 {
+		if events, took := s.queryEngine.LastReplay(); events > 0 && took >= policy.MaxReplayTime {
+			return true, fmt.Sprintf("replaying %d events took %s", events, took)
+		}
+	}
+	return false, ""
+}
+
+// RunOnce takes a snapshot if one is due and applies retention. It returns nil when nothing was due.
+func (s *SnapshotScheduler) RunOnce() (*SnapshotMeta, error) {
+	if due, _ := s.Due(); !due {
+		return nil, nil
+	}
+	return s.SnapshotNow()
+}
+
+// SnapshotNow snapshots the state pinned at the current last event and applies retention
+func (s *SnapshotScheduler) SnapshotNow() (*SnapshotMeta, error) {
+	state, eventID, err := s.queryEngine.PinnedState()
+	if err != nil {
+		return nil, err
+	}
+	if eventID == 0 {
+		return nil, fmt.Errorf("cannot snapshot an empty log")
+	}
+
+	meta, err := s.snapshotManager.CreateSnapshot(state, eventID, int64(eventID))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	retention := s.retention
+	s.mu.Unlock()
+
+	if _, err := s.snapshotManager.ApplyRetention(retention); err != nil {
+		return meta, fmt.Errorf("snapshot %s created but retention failed: %w", meta.SnapshotID, err)
+	}
+	return meta, nil
+}
+
+// Start runs the scheduler in the background until Stop is called
+func (s *SnapshotScheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+	interval := s.policy.CheckInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// Failures are retried on the next tick; queries fall back to replay
+				s.RunOnce()
+			}
+		}
+	}(s.stop, s.done)
+}
+
+// Stop halts the scheduler and waits for a running snapshot to finish
+func (s *SnapshotScheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+)
+
+// Snapshot file formats
+const (
+	SnapshotFormatJSON   = "json-v1"   // Single indented JSON document (legacy)
+	SnapshotFormatStream = "stream-v1" // One JSON record per line, table by table, row by row
+)
+
+// Snapshot compression schemes
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// Record types in a stream-v1 snapshot
+const (
+	recordHeader    = "header"
+	recordTable     = "table"
+	recordRow       = "row"
+	recordDeleted   = "deleted"
+	recordUndeleted = "undeleted"
+	recordRemoved   = "removed"
+	recordDropped   = "dropped"
+	recordTrailer   = "trailer"
+)
+
+// snapshotRecord is one line of a stream-v1 snapshot. The trailer carries the
+// SHA-256 of every uncompressed line before it, so truncation and tampering
+// are both detected once the stream has been read.
+type snapshotRecord struct {
+	Type  string        `json:"type"`
+	Meta  *SnapshotMeta `json:"meta,omitempty"`
+	Table string        `json:"table,omitempty"`
+	ID    int64         `json:"id"`
+	Data  Row           `json:"data,omitempty"`
+	Ver   int           `json:"version,omitempty"` // Schema version of a row's data
+	Rows  int64         `json:"rows,omitempty"`
+	Hash  string        `json:"hash,omitempty"`
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// snapshotStreamWriter encodes records into a gzip stream while hashing them
+type snapshotStreamWriter struct {
+	uncompressed *countingWriter
+	hasher       hash.Hash
+	rows         int64
+}
+
+func (sw *snapshotStreamWriter) write(rec *snapshotRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if rec.Type != recordTrailer {
+		sw.hasher.Write(line)
+	}
+	_, err = sw.uncompressed.Write(line)
+	return err
+}
+
+// writeSnapshotStream writes a snapshot as a gzip-compressed stream of records and
+// fills in the format, hash and size fields of meta. Tables and rows are written
+// in sorted order so the same state always produces the same hash.
+func writeSnapshotStream(meta *SnapshotMeta, snapData *SnapshotData) error {
+	meta.Format = SnapshotFormatStream
+	meta.Compression = CompressionGzip
+	meta.DataHash = ""
+	meta.CompressedSize = 0
+	meta.UncompressedSize = 0
+
+	tmp := meta.SnapshotPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	compressed := &countingWriter{w: f}
+	buffered := bufio.NewWriter(compressed)
+	gz := gzip.NewWriter(buffered)
+	sw := &snapshotStreamWriter{
+		uncompressed: &countingWriter{w: gz},
+		hasher:       sha256.New(),
+	}
+
+	if err := writeSnapshotRecords(sw, meta, snapData); err != nil {
+		f.Close()
+		return err
+	}
+
+	dataHash := hex.EncodeToString(sw.hasher.Sum(nil))
+	if err := sw.write(&snapshotRecord{Type: recordTrailer, Rows: sw.rows, Hash: dataHash}); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, meta.SnapshotPath); err != nil {
+		return err
+	}
+
+	meta.DataHash = dataHash
+	meta.CompressedSize = compressed.n
+	meta.UncompressedSize = sw.uncompressed.n
+	return nil
+}
+
+// writeSnapshotRecords writes the header and the body records of a snapshot
+func writeSnapshotRecords(sw *snapshotStreamWriter, meta *SnapshotMeta, snapData *SnapshotData) error {
+	header := *meta
+	if err := sw.write(&snapshotRecord{Type: recordHeader, Meta: &header}); err != nil {
+		return err
+	}
+	for _, name := range snapData.Dropped {
+		if err := sw.write(&snapshotRecord{Type: recordDropped, Table: name}); err != nil {
+			return err
+		}
+	}
+
+	tableSet := make(map[string]bool)
+	for name := range snapData.Tables {
+		tableSet[name] = true
+	}
+	for name := range snapData.DeletedRows {
+		tableSet[name] = true
+	}
+	for name := range snapData.Undeleted {
+		tableSet[name] = true
+	}
+	for name := range snapData.Removed {
+		tableSet[name] = true
+	}
+	tableNames := make([]string, 0, len(tableSet))
+	for name := range tableSet {
+		tableNames = append(tableNames, name)
+	}
+	sort.Strings(tableNames)
+
+	for _, name := range tableNames {
+		if err := sw.write(&snapshotRecord{Type: recordTable, Table: name}); err != nil {
+			return err
+		}
+
+		rows := snapData.Tables[name]
+		for _, id := range sortedRowIDs(rows) {
+			rec := &snapshotRecord{Type: recordRow, Table: name, ID: id, Data: rows[id], Ver: snapData.RowVersions[name][id]}
+			if err := sw.write(rec); err != nil {
+				return err
+			}
+			sw.rows++
+		}
+
+		deleted := make([]int64, 0, len(snapData.DeletedRows[name]))
+		for id := range snapData.DeletedRows[name] {
+			deleted = append(deleted, id)
+		}
+		sort.Slice(deleted, func(i, j int) bool { return deleted[i] < deleted[j] })
+		for _, id := range deleted {
+			if err := sw.write(&snapshotRecord{Type: recordDeleted, Table: name, ID: id}); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range snapData.Undeleted[name] {
+			if err := sw.write(&snapshotRecord{Type: recordUndeleted, Table: name, ID: id}); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range snapData.Removed[name] {
+			if err := sw.write(&snapshotRecord{Type: recordRemoved, Table: name, ID: id}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func sortedRowIDs(rows map[int64]Row) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// readSnapshotStream decodes a stream-v1 snapshot one record at a time and checks
+// the trailer hash against meta. Only the decoded state is held in memory.
+func readSnapshotStream(meta *SnapshotMeta) (*SnapshotData, error) {
+	f, err := os.Open(meta.SnapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var source io.Reader = bufio.NewReader(f)
+	if meta.Compression == CompressionGzip {
+		gz, err := gzip.NewReader(source)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %v", meta.SnapshotID, err)
+		}
+		defer gz.Close()
+		source = gz
+	}
+
+	snapData := &SnapshotData{
+		Tables:      make(map[string]map[int64]Row),
+		DeletedRows: make(map[string]map[int64]bool),
+	}
+	hasher := sha256.New()
+	reader := bufio.NewReader(source)
+	var rows int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil, fmt.Errorf("snapshot data corruption detected in %s: stream ends without trailer", meta.SnapshotID)
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("snapshot %s: %v", meta.SnapshotID, err)
+		}
+
+		var rec snapshotRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("snapshot data corruption detected in %s: %v", meta.SnapshotID, err)
+		}
+
+		switch rec.Type {
+		case recordTrailer:
+			computed := hex.EncodeToString(hasher.Sum(nil))
+			if computed != rec.Hash || computed != meta.DataHash || rows != rec.Rows {
+				return nil, fmt.Errorf("snapshot data corruption detected in %s: hash mismatch", meta.SnapshotID)
+			}
+			return snapData, nil
+		case recordHeader:
+			if rec.Meta != nil {
+				snapData.Meta = *rec.Meta
+			}
+		case recordTable:
+			if _, exists := snapData.Tables[rec.Table]; !exists {
+				snapData.Tables[rec.Table] = make(map[int64]Row)
+			}
+			if _, exists := snapData.DeletedRows[rec.Table]; !exists {
+				snapData.DeletedRows[rec.Table] = make(map[int64]bool)
+			}
+		case recordRow:
+			if snapData.Tables[rec.Table] == nil {
+				return nil, fmt.Errorf("snapshot data corruption detected in %s: row for undeclared table %s", meta.SnapshotID, rec.Table)
+			}
+			snapData.Tables[rec.Table][rec.ID] = rec.Data
+			if rec.Ver > 0 {
+				if snapData.RowVersions == nil {
+					snapData.RowVersions = make(map[string]map[int64]int)
+				}
+				if snapData.RowVersions[rec.Table] == nil {
+					snapData.RowVersions[rec.Table] = make(map[int64]int)
+				}
+				snapData.RowVersions[rec.Table][rec.ID] = rec.Ver
+			}
+			rows++
+		case recordDeleted:
+			if snapData.DeletedRows[rec.Table] == nil {
+				return nil, fmt.Errorf("snapshot data corruption detected in %s: row for undeclared table %s", meta.SnapshotID, rec.Table)
+			}
+			snapData.DeletedRows[rec.Table][rec.ID] = true
+		case recordUndeleted:
+			if snapData.Undeleted == nil {
+				snapData.Undeleted = make(map[string][]int64)
+			}
+			snapData.Undeleted[rec.Table] = append(snapData.Undeleted[rec.Table], rec.ID)
+		case recordRemoved:
+			if snapData.Removed == nil {
+				snapData.Removed = make(map[string][]int64)
+			}
+			snapData.Removed[rec.Table] = append(snapData.Removed[rec.Table], rec.ID)
+		case recordDropped:
+			snapData.Dropped = append(snapData.Dropped, rec.Table)
+		default:
+			return nil, fmt.Errorf("snapshot data corruption detected in %s: unknown record %q", meta.SnapshotID, rec.Type)
+		}
+		hasher.Write(line)
+
+		if err == io.EOF {
+			return nil, fmt.Errorf("snapshot data corruption detected in %s: stream ends without trailer", meta.SnapshotID)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"rdbms/cmd/web"
 	"rdbms/tests"
@@ -400,3 +401,106 @@ func TestWebEmptyPost(t *testing.T) {
 		t.Error("expected some response status")
 	}
 }
+
+// TestWebQueryStream tests that /query streams a SELECT's rows as NDJSON
+func TestWebQueryStream(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	app := web.New(tdb.DB)
+	if err := app.Initialize(); err != nil {
+		t.Fatalf("failed to initialize app: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := tdb.InsertRow("tasks", map[string]interface{}{
+			"id":        float64(i),
+			"title":     fmt.Sprintf("task %d", i),
+			"completed": i == 2,
+		}); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/query", bytes.NewReader([]byte("SELECT title FROM tasks WHERE id > 1")))
+	w := httptest.NewRecorder()
+	app.HandleQuery(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	expected := "{\"title\":\"task 2\"}\n{\"title\":\"task 3\"}\n"
+	if body := w.Body.String(); body != expected {
+		t.Errorf("unexpected body:\n%s\nexpected:\n%s", body, expected)
+	}
+
+	req = httptest.NewRequest("GET", "/query?sql=DELETE+FROM+tasks+WHERE+id+%3D+1", nil)
+	w = httptest.NewRecorder()
+	app.HandleQuery(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a DELETE, got %d", w.Code)
+	}
+}
+
+// stalledClient is a response writer whose client stalls on the first write
+// until stalled returns
+type stalledClient struct {
+	*httptest.ResponseRecorder
+	stalled func()
+}
+
+func (c *stalledClient) Write(data []byte) (int, error) {
+	if c.stalled != nil {
+		c.stalled()
+		c.stalled = nil
+	}
+	return c.ResponseRecorder.Write(data)
+}
+
+func TestWebResponseDoesNotHoldDatabaseLock(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	app := web.New(tdb.DB)
+	if err := app.Initialize(); err != nil {
+		t.Fatalf("failed to initialize app: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := tdb.InsertRow("tasks", map[string]interface{}{"id": float64(i), "title": fmt.Sprintf("task %d", i), "completed": false}); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	// While the client stalls, a write must still go through
+	id := 10
+	writeWhileStalled := func() {
+		id++
+		done := make(chan error, 1)
+		go func(id int) {
+			_, err := tdb.InsertRow("tasks", map[string]interface{}{"id": float64(id), "title": "late", "completed": false})
+			done <- err
+		}(id)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("insert while the client stalls: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("a write waited for a stalled client")
+		}
+	}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/tasks", nil),
+		httptest.NewRequest("POST", "/query", bytes.NewReader([]byte("SELECT title FROM tasks"))),
+	} {
+		w := &stalledClient{ResponseRecorder: httptest.NewRecorder(), stalled: writeWhileStalled}
+		if req.URL.Path == "/query" {
+			app.HandleQuery(w, req)
+		} else {
+			app.Handle(w, req)
+		}
+		if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("task 3")) {
+			t.Errorf("%s: unexpected response %d: %s", req.URL.Path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package integration
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"rdbms/catalog"
+	"rdbms/database"
+	"rdbms/eventlog"
+	"rdbms/schema"
+	"rdbms/tests"
+)
+
+// TestCatalogRebuiltFromEvents verifies that _catalog.json is only a cache of the schema events
+func TestCatalogRebuiltFromEvents(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	if _, err := tdb.InsertRow("users", userRow(1, "Alice", 30)); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	tdb.DB.Close()
+
+	// A lost cache is rebuilt from the log
+	catalogPath := tests.GetCatalogPath(tdb.DataDir)
+	os.Remove(catalogPath)
+
+	db, err := database.New(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	table, err := db.GetTable("users")
+	if err != nil {
+		t.Fatalf("table not rebuilt from log: %v", err)
+	}
+	if table.PrimaryKey != "id" || len(table.Columns) != 3 {
+		t.Errorf("unexpected rebuilt schema: %+v", table)
+	}
+	if _, err := db.Insert("users", userRow(2, "Bob", 40)); err != nil {
+		t.Errorf("insert after rebuild: %v", err)
+	}
+	db.Close()
+	tests.AssertFileExists(t, catalogPath)
+
+	// A cache that disagrees with the log loses
+	os.WriteFile(catalogPath, []byte(`{"ghost": {"name": "ghost", "columns": [], "primary_key": ""}}`), 0644)
+	db, err = database.New(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	tdb.DB = db
+	if _, err := db.GetTable("ghost"); err == nil {
+		t.Error("expected table missing from the log to be dropped")
+	}
+	if _, err := db.GetTable("users"); err != nil {
+		t.Errorf("expected users to survive a stale cache: %v", err)
+	}
+}
+
+// TestCreateTableWritesEventFirst verifies that a rejected table leaves neither an event nor a catalog entry
+func TestCreateTableWritesEventFirst(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	es := tdb.DB.GetEventStore()
+	before := es.GetLastEventID()
+
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err == nil {
+		t.Fatal("expected duplicate table to be rejected")
+	}
+	if es.GetLastEventID() != before {
+		t.Error("rejected CREATE TABLE appended an event")
+	}
+
+	// System table names are reserved
+	for _, name := range []string{"orders_dead_letter", schema.MigrationErrorTable} {
+		if err := tdb.CreateTable(name, tests.SampleTableColumns(), "id"); err == nil {
+			t.Errorf("expected reserved table name %s to be rejected", name)
+		}
+	}
+	if es.GetLastEventID() != before {
+		t.Error("rejected CREATE TABLE appended an event")
+	}
+
+	events, _ := es.ReadAllEvents()
+	schemas, err := catalog.Project(events, 0)
+	if err != nil {
+		t.Fatalf("project catalog: %v", err)
+	}
+	if len(schemas) != 1 || schemas["users"] == nil {
+		t.Errorf("expected the log to define exactly users, got %v", schemas)
+	}
+}
+
+// TestSelectAsOfUsesHistoricalSchema verifies that temporal queries see the schema of their point in time
+func TestSelectAsOfUsesHistoricalSchema(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	if err := tdb.CreateTable("tasks", tests.SampleTaskTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	es := tdb.DB.GetEventStore()
+	beforeTable := es.GetLastEventID()
+	seedUsers(t, tdb)
+	beforeEvolution := es.GetLastEventID()
+
+	// Drop the age column
+	table, _ := tdb.DB.GetTable("users")
+	oldSchema := schema.ColumnDefinitions(table.Columns)
+	newSchema := schema.ColumnDefinitions(table.Columns[:2])
+	evolved, err := es.RecordSchemaEvolved("users", oldSchema, newSchema,
+		eventlog.SchemaEvolution{RemovedColumns: []string{"age"}}, "tx-evolve")
+	if err != nil {
+		t.Fatalf("record evolution: %v", err)
+	}
+
+	rows, err := tdb.DB.SelectAsOf("users", nil, beforeEvolution)
+	if err != nil {
+		t.Fatalf("select before evolution: %v", err)
+	}
+	for _, row := range rows {
+		if _, exists := row["age"]; !exists {
+			t.Errorf("expected age before it was dropped, got %v", row)
+		}
+	}
+
+	rows, err = tdb.DB.SelectAsOf("users", nil, evolved.ID)
+	if err != nil {
+		t.Fatalf("select after evolution: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	for _, row := range rows {
+		if _, exists := row["age"]; exists {
+			t.Errorf("expected age to be gone after it was dropped, got %v", row)
+		}
+	}
+
+	if _, err := tdb.DB.SelectAsOf("users", nil, beforeTable); err == nil || !strings.Contains(err.Error(), "as of event") {
+		t.Errorf("expected table to be missing before it was created, got %v", err)
+	}
+}
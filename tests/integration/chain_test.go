@@ -0,0 +1,318 @@
+package integration
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rdbms/eventlog"
+	"rdbms/storage"
+)
+
+// appendRows writes n ROW_INSERTED events to a fresh log
+func appendRows(t *testing.T, log *eventlog.Log, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		payload := map[string]interface{}{
+			"table_name": "accounts",
+			"row_id":     float64(i),
+			"data":       map[string]interface{}{"id": float64(i), "balance": float64(i * 100)},
+		}
+		if _, err := log.Append(eventlog.RowInserted, payload, "tx-1", 1); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+}
+
+// rewriteLog applies fn to the decoded events and writes them back to disk
+func rewriteLog(t *testing.T, path string, fn func([]*eventlog.Event) []*eventlog.Event) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	var events []*eventlog.Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e eventlog.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		events = append(events, &e)
+	}
+	f.Close()
+
+	var sb strings.Builder
+	for _, e := range fn(events) {
+		data, _ := json.Marshal(e)
+		sb.Write(data)
+		sb.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+}
+
+func hasIssue(report *eventlog.ChainReport, kind eventlog.ChainIssueKind) bool {
+	for _, issue := range report.Issues {
+		if issue.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHashChainLinksEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, err := eventlog.NewLog(tmpDir, "events.log")
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	defer log.Close()
+
+	appendRows(t, log, 5)
+
+	events, errs := log.Read()
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected read errors: %v", errs)
+	}
+	if events[0].PrevHash != "" {
+		t.Errorf("First event should have empty PrevHash, got %s", events[0].PrevHash)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].PrevHash != events[i-1].Checksum {
+			t.Errorf("Event %d does not link to event %d", events[i].ID, events[i-1].ID)
+		}
+	}
+
+	report, err := log.VerifyChain()
+	if err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if !report.Valid() {
+		t.Errorf("Expected valid chain, got issues: %+v", report.Issues)
+	}
+
+	t.Log("✓ Hash chain link test passed")
+}
+
+func TestHashChainSurvivesReopen(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := eventlog.NewLog(tmpDir, "events.log")
+	appendRows(t, log, 3)
+	log.Close()
+
+	reopened, err := eventlog.NewLog(tmpDir, "events.log")
+	if err != nil {
+		t.Fatalf("Failed to reopen log: %v", err)
+	}
+	defer reopened.Close()
+	appendRows(t, reopened, 2)
+
+	report, _ := reopened.VerifyChain()
+	if !report.Valid() {
+		t.Errorf("Expected chain to continue across reopen, got issues: %+v", report.Issues)
+	}
+	if report.EventsChecked != 5 {
+		t.Errorf("Expected 5 events checked, got %d", report.EventsChecked)
+	}
+
+	t.Log("✓ Hash chain reopen test passed")
+}
+
+func TestHashChainDetectsRewrittenEvent(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := eventlog.NewLog(tmpDir, "events.log")
+	defer log.Close()
+	appendRows(t, log, 5)
+
+	// Rewrite event 3 and recompute its checksum so it looks self-consistent
+	rewriteLog(t, filepath.Join(tmpDir, "events.log"), func(events []*eventlog.Event) []*eventlog.Event {
+		payload := events[2].Payload.(map[string]interface{})
+		payload["data"].(map[string]interface{})["balance"] = float64(1000000)
+		events[2].Checksum, _ = eventlog.ComputeChecksum(events[2])
+		return events
+	})
+
+	report, _ := log.VerifyChain()
+	if !hasIssue(report, eventlog.ChainBreak) {
+		t.Errorf("Expected chain break after rewriting an event, got %+v", report.Issues)
+	}
+
+	t.Log("✓ Rewritten event detection test passed")
+}
+
+func TestHashChainDetectsDeletedEvent(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := eventlog.NewLog(tmpDir, "events.log")
+	defer log.Close()
+	appendRows(t, log, 5)
+
+	rewriteLog(t, filepath.Join(tmpDir, "events.log"), func(events []*eventlog.Event) []*eventlog.Event {
+		return append(events[:2], events[3:]...)
+	})
+
+	report, _ := log.VerifyChain()
+	if !hasIssue(report, eventlog.ChainGap) {
+		t.Errorf("Expected gap after deleting an event, got %+v", report.Issues)
+	}
+	if !hasIssue(report, eventlog.ChainBreak) {
+		t.Errorf("Expected chain break after deleting an event, got %+v", report.Issues)
+	}
+
+	t.Log("✓ Deleted event detection test passed")
+}
+
+func TestHashChainDetectsReordering(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := eventlog.NewLog(tmpDir, "events.log")
+	defer log.Close()
+	appendRows(t, log, 4)
+
+	rewriteLog(t, filepath.Join(tmpDir, "events.log"), func(events []*eventlog.Event) []*eventlog.Event {
+		events[1], events[2] = events[2], events[1]
+		return events
+	})
+
+	report, _ := log.VerifyChain()
+	if !hasIssue(report, eventlog.ChainReordered) {
+		t.Errorf("Expected reordering to be reported, got %+v", report.Issues)
+	}
+
+	t.Log("✓ Reordering detection test passed")
+}
+
+func TestSignedCheckpoints(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := eventlog.NewLog(tmpDir, "events.log")
+	defer log.Close()
+	log.SetCheckpointInterval(2)
+
+	appendRows(t, log, 5)
+
+	checkpoints, err := log.Checkpoints()
+	if err != nil {
+		t.Fatalf("Failed to read checkpoints: %v", err)
+	}
+	if len(checkpoints) != 2 {
+		t.Fatalf("Expected 2 automatic checkpoints, got %d", len(checkpoints))
+	}
+	for _, cp := range checkpoints {
+		if err := eventlog.VerifyCheckpoint(&cp, log.PublicKey()); err != nil {
+			t.Errorf("Checkpoint %d failed verification: %v", cp.EventID, err)
+		}
+	}
+
+	report, _ := log.VerifyChain()
+	if report.CheckpointsVerified != 2 {
+		t.Errorf("Expected 2 verified checkpoints, got %d", report.CheckpointsVerified)
+	}
+
+	// Truncating the log below a checkpoint is reported
+	rewriteLog(t, filepath.Join(tmpDir, "events.log"), func(events []*eventlog.Event) []*eventlog.Event {
+		return events[:3]
+	})
+	report, _ = log.VerifyChain()
+	if !hasIssue(report, eventlog.ChainTruncated) {
+		t.Errorf("Expected truncation to be reported, got %+v", report.Issues)
+	}
+
+	// A forged checkpoint does not verify
+	forged := checkpoints[0]
+	forged.ChainHead = strings.Repeat("0", 64)
+	if err := eventlog.VerifyCheckpoint(&forged, log.PublicKey()); err == nil {
+		t.Error("Forged checkpoint should not verify")
+	}
+
+	t.Log("✓ Signed checkpoint test passed")
+}
+
+func TestVersion1CheckpointsStillVerify(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := eventlog.NewLog(tmpDir, "events.log")
+	defer log.Close()
+	log.SetCheckpointInterval(0)
+	appendRows(t, log, 3)
+
+	// Version 1 checkpoints have no version or tree and sign only the chain head
+	seed, _ := os.ReadFile(filepath.Join(tmpDir, "events.key"))
+	keySeed, _ := hex.DecodeString(string(seed))
+	events, _ := log.Read()
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	message := fmt.Sprintf("%d:%s:%s", 2, events[1].Checksum, createdAt.Format(time.RFC3339Nano))
+	line, _ := json.Marshal(map[string]interface{}{
+		"event_id":   2,
+		"chain_head": events[1].Checksum,
+		"created_at": createdAt,
+		"signature":  hex.EncodeToString(ed25519.Sign(ed25519.NewKeyFromSeed(keySeed), []byte(message))),
+	})
+	if err := os.WriteFile(filepath.Join(tmpDir, "events.checkpoints"), append(line, '\n'), 0644); err != nil {
+		t.Fatalf("write checkpoint: %v", err)
+	}
+	if _, err := log.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+
+	checkpoints, err := log.Checkpoints()
+	if err != nil || len(checkpoints) != 2 {
+		t.Fatalf("expected 2 checkpoints, got %v (%v)", checkpoints, err)
+	}
+	for i, version := range []int{1, eventlog.CheckpointVersion} {
+		if got := checkpoints[i].FormatVersion(); got != version {
+			t.Errorf("checkpoint %d: expected version %d, got %d", i, version, got)
+		}
+	}
+	report, _ := log.VerifyChain()
+	if len(report.Issues) != 0 || report.CheckpointsVerified != 2 {
+		t.Errorf("expected both checkpoints to verify, got %d verified, issues %+v", report.CheckpointsVerified, report.Issues)
+	}
+
+	// A version 1 checkpoint's root is not signed, so proofs do not trust it
+	if _, err := log.ConsistencyProof(checkpoints[0].MerkleRoot, checkpoints[1].MerkleRoot); err == nil {
+		t.Error("expected a version 1 checkpoint to have no known root")
+	}
+}
+
+func TestDetectCorruptionReportsChainIssues(t *testing.T) {
+	tmpDir := t.TempDir()
+	es, err := storage.NewEventStore(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create event store: %v", err)
+	}
+	defer es.Close()
+
+	es.RecordSchemaCreated("accounts", []eventlog.ColumnDefinition{{Name: "id", Type: "INT", PrimaryKey: true}}, "id", "tx-1")
+	for i := 1; i <= 4; i++ {
+		es.RecordRowInserted("accounts", int64(i), storage.Row{"id": float64(i)}, "tx-2")
+	}
+
+	events, _ := es.ReadAllEvents()
+	if report := storage.DetectCorruption(events, nil); report.CorruptedEvents != 0 {
+		t.Fatalf("Expected clean log, got %+v", report.Issues)
+	}
+
+	// Drop event 3 (a whole event deletion)
+	tampered := append([]*eventlog.Event{}, events[:2]...)
+	tampered = append(tampered, events[3:]...)
+
+	report := storage.DetectCorruption(tampered, nil)
+	kinds := make(map[string]bool)
+	for _, issue := range report.Issues {
+		kinds[issue.IssueType] = true
+	}
+	if !kinds["event_gap"] || !kinds["chain_break"] {
+		t.Errorf("Expected event_gap and chain_break issues, got %+v", report.Issues)
+	}
+	if report.FirstIssueAt != 4 {
+		t.Errorf("Expected first issue at event 4, got %d", report.FirstIssueAt)
+	}
+
+	t.Log("✓ DetectCorruption chain issue test passed")
+}
@@ -0,0 +1,353 @@
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rdbms/database"
+	"rdbms/eventlog"
+	"rdbms/parser"
+	"rdbms/storage"
+	"rdbms/tests"
+)
+
+// userRow builds a users row with JSON-style numbers, as the database validates them
+func userRow(id int, name string, age int) map[string]interface{} {
+	return map[string]interface{}{"id": float64(id), "name": name, "age": float64(age)}
+}
+
+// seedUsers creates a users table with some history: inserts, an update and a delete
+func seedUsers(t *testing.T, tdb *tests.TestDB) {
+	t.Helper()
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for i, name := range []string{"Alice", "Bob", "Charlie"} {
+		if _, err := tdb.InsertRow("users", userRow(i+1, name, 30+i)); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if _, err := tdb.UpdateRows("users", map[string]interface{}{"age": float64(99)}, map[string]interface{}{"name": "Alice"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := tdb.DeleteRows("users", map[string]interface{}{"name": "Bob"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+}
+
+// TestCompactionPreservesState verifies that folding history keeps the current state intact
+func TestCompactionPreservesState(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedUsers(t, tdb)
+
+	es := tdb.DB.GetEventStore()
+	horizon := es.GetLastEventID()
+
+	result, err := es.Compact(horizon)
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if result.Boundary != horizon {
+		t.Errorf("expected boundary %d, got %d", horizon, result.Boundary)
+	}
+	if result.SurvivingRows != 2 {
+		t.Errorf("expected 2 surviving rows, got %d", result.SurvivingRows)
+	}
+
+	tdb.AssertRowCount("users", 2)
+	tdb.AssertRowExists("users", "name", "Charlie")
+
+	// New events chain onto the compacted log and survive a restart
+	if _, err := tdb.InsertRow("users", userRow(4, "Dana", 41)); err != nil {
+		t.Fatalf("insert after compaction: %v", err)
+	}
+	tdb.DB.Close()
+
+	db, err := database.New(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	tdb.DB = db
+
+	tdb.AssertRowCount("users", 3)
+	rows, err := tdb.SelectWhere("users", "name", "Alice")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected Alice after reopen, got %v (%v)", rows, err)
+	}
+	if age, _ := rows[0]["age"].(float64); age != 99 {
+		t.Errorf("expected updated age 99, got %v", rows[0]["age"])
+	}
+
+	report, err := db.GetEventStore().VerifyChain()
+	if err != nil {
+		t.Fatalf("verify chain: %v", err)
+	}
+	if !report.Valid() {
+		t.Errorf("expected valid chain after compaction, got %+v", report.Issues)
+	}
+	if got := db.GetEventStore().CompactionBoundary(); got != horizon {
+		t.Errorf("expected boundary %d after reopen, got %d", horizon, got)
+	}
+}
+
+// TestSelectAsOfCompactionBoundary verifies temporal queries around the boundary
+func TestSelectAsOfCompactionBoundary(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedUsers(t, tdb)
+
+	es := tdb.DB.GetEventStore()
+	horizon := es.GetLastEventID()
+	if _, err := tdb.InsertRow("users", userRow(4, "Dana", 41)); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if _, err := es.Compact(horizon); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	_, err := tdb.DB.SelectAsOf("users", nil, horizon-1)
+	if !errors.Is(err, storage.ErrBeforeCompaction) {
+		t.Fatalf("expected ErrBeforeCompaction, got %v", err)
+	}
+
+	rows, err := tdb.DB.SelectAsOf("users", nil, horizon)
+	if err != nil {
+		t.Fatalf("select as of boundary: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Errorf("expected 2 rows at the boundary, got %d", len(rows))
+	}
+
+	rows, err = tdb.DB.SelectAsOf("users", &parser.WhereClause{Column: "name", Value: "Dana"}, horizon+1)
+	if err != nil {
+		t.Fatalf("select as of after boundary: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Errorf("expected Dana after the boundary, got %d rows", len(rows))
+	}
+}
+
+// TestCompactorPolicy verifies that the retention policy bounds the horizon
+func TestCompactorPolicy(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedUsers(t, tdb)
+
+	es := tdb.DB.GetEventStore()
+	last := es.GetLastEventID()
+
+	compactor := storage.NewCompactor(es, storage.CompactionPolicy{RetainEvents: 2})
+	result, err := compactor.RunOnce()
+	if err != nil {
+		t.Fatalf("run compactor: %v", err)
+	}
+	if result == nil || result.Boundary != last-2 {
+		t.Fatalf("expected boundary %d, got %+v", last-2, result)
+	}
+
+	// Nothing new to fold
+	result, err = compactor.RunOnce()
+	if err != nil || result != nil {
+		t.Errorf("expected no-op second run, got %+v (%v)", result, err)
+	}
+
+	tdb.AssertRowCount("users", 2)
+
+	// Events inside the retention window are never folded
+	horizon, err := es.CompactionHorizon(storage.CompactionPolicy{RetainFor: time.Hour})
+	if err != nil {
+		t.Fatalf("horizon: %v", err)
+	}
+	if horizon != 0 {
+		t.Errorf("expected no horizon for recent events, got %d", horizon)
+	}
+}
+
+// TestSnapshotAfterCompactionKeepsDeletes verifies that a snapshot taken after
+// compaction does not bring back rows deleted before it
+func TestSnapshotAfterCompactionKeepsDeletes(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedUsers(t, tdb)
+
+	scheduler := tdb.DB.SnapshotScheduler()
+	if _, err := scheduler.SnapshotNow(); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	es := tdb.DB.GetEventStore()
+	if _, err := es.Compact(es.GetLastEventID()); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if _, err := tdb.InsertRow("users", userRow(4, "Dana", 41)); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	meta, err := scheduler.SnapshotNow()
+	if err != nil {
+		t.Fatalf("snapshot after compaction: %v", err)
+	}
+	if meta.Kind != storage.SnapshotFull {
+		t.Errorf("expected a full snapshot after compaction, got %q", meta.Kind)
+	}
+
+	rows, err := tdb.SelectWhere("users", "name", "Bob")
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("expected Bob to stay deleted, got %v", rows)
+	}
+	tdb.AssertRowCount("users", 3)
+}
+
+// compactAndTamper compacts the seeded log, closes the database and rewrites
+// its base segment with fn
+func compactAndTamper(t *testing.T, tdb *tests.TestDB, fn func([]byte) []byte) {
+	t.Helper()
+	seedUsers(t, tdb)
+	es := tdb.DB.GetEventStore()
+	if _, err := es.Compact(es.GetLastEventID()); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	tdb.DB.Close()
+	tdb.DB = nil
+
+	path := filepath.Join(tdb.DataDir, "events.base")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read base segment: %v", err)
+	}
+	if err := os.WriteFile(path, fn(data), 0644); err != nil {
+		t.Fatalf("write base segment: %v", err)
+	}
+}
+
+// assertBaseTamperDetected checks that the chain report, the corruption report
+// and opening the database all flag the base segment
+func assertBaseTamperDetected(t *testing.T, dataDir string, kind eventlog.ChainIssueKind) {
+	t.Helper()
+	es, err := storage.NewEventStore(dataDir)
+	if err != nil {
+		t.Fatalf("open event store: %v", err)
+	}
+	defer es.Close()
+
+	report, err := es.VerifyChain()
+	if err != nil {
+		t.Fatalf("verify chain: %v", err)
+	}
+	found := false
+	for _, issue := range report.Issues {
+		found = found || issue.Kind == kind
+	}
+	if !found {
+		t.Errorf("expected a %s issue, got %+v", kind, report.Issues)
+	}
+
+	corruption := es.DetectCorruption()
+	found = false
+	for _, issue := range corruption.Issues {
+		found = found || issue.IssueType == string(kind)
+	}
+	if !found || corruption.CorruptedEvents == 0 {
+		t.Errorf("expected DetectCorruption to report %s, got %+v", kind, corruption)
+	}
+
+	if db, err := database.New(dataDir); err == nil {
+		db.Close()
+		t.Error("expected the database to refuse a tampered base segment")
+	}
+}
+
+// TestCompactionBaseSegmentTamperEvident verifies that edits to the base
+// segment are detected, whether or not the checksums are recomputed
+func TestCompactionBaseSegmentTamperEvident(t *testing.T) {
+	t.Run("edited in place", func(t *testing.T) {
+		tdb := tests.NewTestDB(t)
+		defer tdb.Cleanup()
+		compactAndTamper(t, tdb, func(data []byte) []byte {
+			return []byte(strings.Replace(string(data), "Alice", "Mallo", 1))
+		})
+		assertBaseTamperDetected(t, tdb.DataDir, eventlog.ChainChecksumMismatch)
+	})
+
+	t.Run("checksums recomputed", func(t *testing.T) {
+		tdb := tests.NewTestDB(t)
+		defer tdb.Cleanup()
+		compactAndTamper(t, tdb, func(data []byte) []byte {
+			var base eventlog.BaseSegment
+			if err := json.Unmarshal(data, &base); err != nil {
+				t.Fatalf("decode base segment: %v", err)
+			}
+			for _, e := range base.Events {
+				forged := strings.Replace(mustJSON(t, e.Payload), "Alice", "Mallo", 1)
+				if err := json.Unmarshal([]byte(forged), &e.Payload); err != nil {
+					t.Fatalf("decode payload: %v", err)
+				}
+				e.Checksum, _ = eventlog.ComputeChecksum(e)
+			}
+			return []byte(mustJSON(t, &base))
+		})
+		assertBaseTamperDetected(t, tdb.DataDir, eventlog.ChainBadBase)
+	})
+
+	t.Run("written before signing", func(t *testing.T) {
+		tdb := tests.NewTestDB(t)
+		defer tdb.Cleanup()
+		compactAndTamper(t, tdb, func(data []byte) []byte {
+			var base eventlog.BaseSegment
+			if err := json.Unmarshal(data, &base); err != nil {
+				t.Fatalf("decode base segment: %v", err)
+			}
+			base.Signature = ""
+			return []byte(mustJSON(t, &base))
+		})
+
+		// Still readable, but the chain report says it cannot be vouched for
+		db, err := database.New(tdb.DataDir)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		tdb.DB = db
+		tdb.AssertRowCount("users", 2)
+		report, err := db.GetEventStore().VerifyChain()
+		if err != nil || len(report.Issues) != 1 || report.Issues[0].Kind != eventlog.ChainUnsignedBase {
+			t.Errorf("expected only an unsigned base segment issue, got %+v (%v)", report, err)
+		}
+	})
+
+	t.Run("untouched", func(t *testing.T) {
+		tdb := tests.NewTestDB(t)
+		defer tdb.Cleanup()
+		compactAndTamper(t, tdb, func(data []byte) []byte { return data })
+
+		db, err := database.New(tdb.DataDir)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		tdb.DB = db
+		report, err := db.GetEventStore().VerifyChain()
+		if err != nil || !report.Valid() {
+			t.Errorf("expected a valid chain, got %+v (%v)", report, err)
+		}
+		if corruption := db.GetEventStore().DetectCorruption(); corruption.CorruptedEvents != 0 {
+			t.Errorf("expected no corruption, got %+v", corruption.Issues)
+		}
+	})
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return string(data)
+}
@@ -0,0 +1,108 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"rdbms/parser"
+	"rdbms/tests"
+)
+
+func TestExplain(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+
+	seedRangeUsers(t, tdb)
+	r.mustExec("CREATE TABLE orders (id INT PRIMARY KEY, user_id INT, total INT)")
+	for i, userID := range []int{2, 1, 2, 3, 2, 1} {
+		r.mustExec(fmt.Sprintf("INSERT INTO orders VALUES (%d, %d, %d)", i+1, userID, 10*(i+1)))
+	}
+	r.mustExec("CREATE INDEX ON orders (user_id)")
+
+	out, err := r.exec("EXPLAIN SELECT * FROM users WHERE age > 23 ORDER BY age DESC LIMIT 2")
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	expected := strings.Join([]string{
+		"Limit 2 (cost=1279.36 rows=2)",
+		"   -> Sort by age DESC (cost=1279.36 rows=333)",
+		"      -> Full Scan on users (cost=1000.00 rows=333)",
+		"           Filter: age > 23",
+	}, "\n")
+	if out != expected {
+		t.Errorf("unexpected EXPLAIN:\n%s\nexpected:\n%s", out, expected)
+	}
+
+	out, _ = r.exec("EXPLAIN SELECT * FROM users JOIN orders ON users.id = orders.user_id WHERE users.id = 2")
+	expected = strings.Join([]string{
+		"Index Nested Loop Join on users.id = orders.user_id (cost=28.93 rows=1)",
+		"   -> Index Lookup on users.id (btree) (cost=11.47 rows=1)",
+		"        Index Cond: id = 2",
+		"   -> Index Lookup on orders.user_id (btree) (cost=17.47 rows=1)",
+		"        Index Cond: orders.user_id = users.id",
+	}, "\n")
+	if out != expected {
+		t.Errorf("unexpected EXPLAIN:\n%s\nexpected:\n%s", out, expected)
+	}
+
+	// EXPLAIN ANALYZE runs the query and counts each operator's rows
+	stmt, _ := r.p.Parse("SELECT * FROM users WHERE id > 8 AND age = 21 ORDER BY age LIMIT 5")
+	explanation, err := tdb.DB.ExplainSelect("users", stmt.Where, stmt.OrderBy, stmt.Limit, true)
+	if err != nil {
+		t.Fatalf("explain analyze: %v", err)
+	}
+	limit := explanation.Plan
+	if limit.Operator != "Limit 5" || len(limit.Children) != 1 || limit.ActualRows != 1 {
+		t.Fatalf("unexpected plan: %+v", limit)
+	}
+	if sort := limit.Children[0]; sort.Operator != "Sort by age" || sort.ActualRows != 1 || sort.Children[0].ActualRows != 1 ||
+		sort.Children[0].Operator != "Index Range on users.id (btree)" || sort.Children[0].Time > sort.Time {
+		t.Errorf("unexpected plan: %+v", sort)
+	}
+
+	// Index intersection probes count the row IDs they find
+	r.mustExec("CREATE INDEX ON users (age) USING HASH")
+	r.mustExec("CREATE INDEX ON users (name) USING HASH")
+	stmt, _ = r.p.Parse("SELECT * FROM users WHERE age = 21 AND name = 'user6'")
+	explanation, _ = tdb.DB.ExplainSelect("users", stmt.Where, nil, 0, true)
+	if plan := explanation.Plan; plan.Operator != "Index Intersection on users" || plan.ActualRows != 1 ||
+		len(plan.Children) != 2 || plan.Children[0].ActualRows != 3 || plan.Children[1].ActualRows != 1 {
+		t.Errorf("unexpected intersection: %s", explanation)
+	}
+
+	// The state a query reads comes from the latest snapshot plus the events
+	// after it: the snapshot's own event and two inserts
+	meta, err := tdb.DB.SnapshotScheduler().SnapshotNow()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	r.mustExec("INSERT INTO orders VALUES (7, 3, 70)")
+	r.mustExec("INSERT INTO orders VALUES (8, 2, 80)")
+	out, err = r.exec("EXPLAIN ANALYZE SELECT * FROM users JOIN orders ON users.id = orders.user_id WHERE users.id = 2")
+	if err != nil {
+		t.Fatalf("explain analyze: %v", err)
+	}
+	for _, line := range []string{
+		"Index Nested Loop Join on users.id = orders.user_id (cost=28.93 rows=1) (actual rows=4 time=",
+		"   -> Index Lookup on users.id (btree) (cost=11.47 rows=1) (actual rows=1 time=",
+		"   -> Index Lookup on orders.user_id (btree) (cost=17.47 rows=1) (actual rows=4 time=",
+		fmt.Sprintf("State: snapshot at event %d + 3 replayed events (", meta.BaseEventID),
+		"Execution time: ",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in:\n%s", line, out)
+		}
+	}
+
+	for _, sql := range []string{
+		"EXPLAIN DELETE FROM users WHERE id = 1",
+		"EXPLAIN ANALYZE",
+		"EXPLAIN SELECT * FROM users AS OF 3",
+	} {
+		if _, err := r.exec(sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
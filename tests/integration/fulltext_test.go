@@ -0,0 +1,124 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"rdbms/database"
+	"rdbms/executor"
+	"rdbms/parser"
+	"rdbms/tests"
+)
+
+func TestFullTextSearch(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	p := parser.New()
+	run := func(sql string) (string, error) {
+		stmt, err := p.Parse(sql)
+		if err != nil {
+			return "", err
+		}
+		return executor.New(tdb.DB).Execute(stmt)
+	}
+	search := func(db *database.Database, where string, limit int) string {
+		t.Helper()
+		stmt, err := p.Parse("SELECT * FROM tickets WHERE " + where)
+		if err != nil {
+			t.Fatalf("parse %s: %v", where, err)
+		}
+		rows, err := db.SelectOrdered("tickets", stmt.Where, nil, limit)
+		if err != nil {
+			t.Fatalf("select %s: %v", where, err)
+		}
+		return rowIDs(rows)
+	}
+
+	if _, err := run("CREATE TABLE tickets (id INT PRIMARY KEY, status TEXT, description TEXT)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for i, ticket := range [][2]string{
+		{"open", "Login page crashes on submit"},
+		{"open", "Crash when uploading a large file and the upload crashed twice"},
+		{"closed", "Typo on the pricing page"},
+		{"open", "Dark mode request for the settings page and the login page"},
+	} {
+		if _, err := run(fmt.Sprintf("INSERT INTO tickets VALUES (%d, '%s', '%s')", i+1, ticket[0], ticket[1])); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	// MATCH needs the index
+	if _, err := tdb.DB.Select("tickets", &parser.WhereClause{Column: "description", Operator: "MATCH", Value: "crash"}); err == nil ||
+		!strings.Contains(err.Error(), "needs a FULLTEXT index") {
+		t.Errorf("expected a missing index error, got %v", err)
+	}
+	if _, err := run("CREATE FULLTEXT INDEX ON tickets (status) WITH (bogus)"); err == nil {
+		t.Error("expected an unknown option to be rejected")
+	}
+	if _, err := run("CREATE FULLTEXT INDEX ON tickets (id)"); err == nil {
+		t.Error("expected a FULLTEXT index on an INT column to be rejected")
+	}
+
+	msg, err := run("CREATE FULLTEXT INDEX ON tickets (description) WITH (stemming, stopwords)")
+	if err != nil {
+		t.Fatalf("create fulltext index: %v", err)
+	}
+	if msg != "Index 'tickets_description_fulltext' created on 'tickets'" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+
+	cases := []struct {
+		where    string
+		limit    int
+		expected string
+	}{
+		{"MATCH(description) AGAINST('crashing')", 0, "2,1"},
+		{"MATCH(description) AGAINST('login page')", 0, "1,4,3"},
+		{"MATCH(description) AGAINST('login page')", 1, "1"},
+		{"MATCH(description) AGAINST('page') AND status = 'open'", 0, "4,1"},
+		{"MATCH(description) AGAINST('the and of')", 0, ""},
+		{"MATCH(description) AGAINST('page') AND MATCH(description) AGAINST('dark')", 0, "4"},
+	}
+	for _, c := range cases {
+		if got := search(tdb.DB, c.where, c.limit); got != c.expected {
+			t.Errorf("%s limit %d: expected [%s], got [%s]", c.where, c.limit, c.expected, got)
+		}
+	}
+
+	stmt, _ := p.Parse("SELECT * FROM tickets WHERE MATCH(description) AGAINST('upload') ORDER BY id LIMIT 3")
+	if plan, _ := tdb.DB.PlanSelect("tickets", stmt.Where, stmt.OrderBy, stmt.Limit); plan != "full-text search on tickets.description (fulltext), sort by id, limit 3" {
+		t.Errorf("unexpected plan: %q", plan)
+	}
+
+	// Writes keep the index current
+	if _, err := run("UPDATE tickets SET description = 'Pricing page shows the wrong currency' WHERE id = 1"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := run("DELETE FROM tickets WHERE MATCH(description) AGAINST('typo')"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := run("INSERT INTO tickets VALUES (5, 'open', 'Pricing export crashes')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if got := search(tdb.DB, "MATCH(description) AGAINST('pricing')", 0); got != "5,1" {
+		t.Errorf("pricing after writes: expected [5,1], got [%s]", got)
+	}
+	if got := search(tdb.DB, "MATCH(description) AGAINST('crash')", 0); got != "5,2" {
+		t.Errorf("crash after writes: expected [5,2], got [%s]", got)
+	}
+
+	// The index survives a restart through its checkpoint
+	db := reopen(t, tdb, false)
+	if loaded := db.IndexRecovery().Loaded; strings.Join(loaded, ",") != "tickets" {
+		t.Errorf("expected tickets loaded from its checkpoint, got %+v", db.IndexRecovery())
+	}
+	if got := search(db, "MATCH(description) AGAINST('crash')", 0); got != "5,2" {
+		t.Errorf("crash after restart: expected [5,2], got [%s]", got)
+	}
+	if _, err := db.SelectAsOf("tickets", &parser.WhereClause{Column: "description", Operator: "MATCH", Value: "crash"}, 3); err == nil {
+		t.Error("expected MATCH to be rejected in an AS OF query")
+	}
+}
@@ -0,0 +1,238 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rdbms/database"
+	"rdbms/parser"
+	"rdbms/schema"
+	"rdbms/tests"
+)
+
+// seedIndexedUsers creates users with the range fixture plus hash and B-tree
+// secondary indexes on name and age
+func seedIndexedUsers(t *testing.T, tdb *tests.TestDB) {
+	t.Helper()
+	seedRangeUsers(t, tdb)
+	for _, def := range []schema.Index{
+		{Name: "users_name", Columns: []string{"name"}, Method: schema.IndexHash},
+		{Name: "users_age_name", Columns: []string{"age", "name"}},
+	} {
+		if err := tdb.DB.CreateIndex("users", def); err != nil {
+			t.Fatalf("create index %s: %v", def.Name, err)
+		}
+	}
+}
+
+// reopen opens the database in the same directory again. With crash set the
+// old instance is abandoned without Close, so its indexes are not checkpointed.
+func reopen(t *testing.T, tdb *tests.TestDB, crash bool) *database.Database {
+	t.Helper()
+	if !crash {
+		if err := tdb.DB.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+	db, err := database.New(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	tdb.DB = db
+	return db
+}
+
+// assertIndexedUsers checks indexed lookups against the expected ids
+func assertIndexedUsers(t *testing.T, db *database.Database, where *parser.WhereClause, expected string) {
+	t.Helper()
+	rows, err := db.SelectOrdered("users", where, &parser.OrderBy{Column: "id"}, 0)
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if got := rowIDs(rows); got != expected {
+		t.Errorf("%s %s %v: expected ids [%s], got [%s]", where.Column, where.Operator, where.Value, expected, got)
+	}
+	if plan, _ := db.PlanSelect("users", where, nil, 0); !strings.HasPrefix(plan, "index") {
+		t.Errorf("%s: expected an index plan, got %q", where.Column, plan)
+	}
+}
+
+func TestIndexCheckpointRoundTrip(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedIndexedUsers(t, tdb)
+
+	db := reopen(t, tdb, false)
+	recovery := db.IndexRecovery()
+	if len(recovery.Rebuilt) != 0 || strings.Join(recovery.Loaded, ",") != "users" || recovery.EventsReplayed != 0 {
+		t.Fatalf("expected users loaded from its checkpoint, got %+v", recovery)
+	}
+
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "name", Operator: "=", Value: "user7"}, "7")
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "age", Operator: "=", Value: float64(22)}, "2,7,12")
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "id", Operator: "BETWEEN", Value: float64(3), High: float64(5)}, "3,4,5")
+	if _, err := db.Insert("users", userRow(7, "again", 30)); err == nil {
+		t.Error("expected a primary key violation from the loaded index")
+	}
+}
+
+func TestIndexCheckpointAfterFailedUpdate(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedIndexedUsers(t, tdb)
+
+	// A rejected update must leave every index as it was
+	where := &parser.WhereClause{Column: "id", Operator: "=", Value: float64(1)}
+	if _, err := tdb.DB.Update("users", "age", "not a number", where); err == nil {
+		t.Fatal("expected the update to fail validation")
+	}
+
+	db := reopen(t, tdb, false)
+	recovery := db.IndexRecovery()
+	if len(recovery.Rebuilt) != 0 || strings.Join(recovery.Loaded, ",") != "users" {
+		t.Fatalf("expected users loaded from its checkpoint, got %+v", recovery)
+	}
+	if _, err := db.Insert("users", userRow(1, "again", 30)); err == nil {
+		t.Error("expected a primary key violation after the failed update")
+	}
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "name", Operator: "=", Value: "user1"}, "1")
+}
+
+func TestIndexCheckpointCatchUp(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedIndexedUsers(t, tdb)
+	db := reopen(t, tdb, false)
+
+	// Changes after the checkpoint are lost with the crash, except in the log
+	if _, err := db.Insert("users", userRow(13, "user13", 22)); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := db.Update("users", "name", "renamed", &parser.WhereClause{Column: "id", Operator: "=", Value: float64(7)}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := db.Update("users", "age", float64(40), &parser.WhereClause{Column: "id", Operator: "=", Value: float64(2)}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := db.Delete("users", &parser.WhereClause{Column: "id", Operator: "=", Value: float64(12)}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	db = reopen(t, tdb, true)
+	recovery := db.IndexRecovery()
+	if len(recovery.Rebuilt) != 0 || recovery.EventsReplayed != 4 {
+		t.Fatalf("expected 4 events replayed onto the checkpoint, got %+v", recovery)
+	}
+
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "name", Operator: "=", Value: "user7"}, "")
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "name", Operator: "=", Value: "renamed"}, "7")
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "age", Operator: "=", Value: float64(22)}, "7,13")
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "age", Operator: "=", Value: float64(40)}, "2")
+	assertIndexedUsers(t, db, &parser.WhereClause{Column: "id", Operator: ">", Value: float64(10)}, "11,13")
+
+	// The row IDs handed out before the crash stay taken
+	rowID, err := db.Insert("users", userRow(14, "user14", 20))
+	if err != nil {
+		t.Fatalf("insert after recovery: %v", err)
+	}
+	if rowID != 13 {
+		t.Errorf("expected row ID 13 after 13 inserts, got %d", rowID)
+	}
+}
+
+func TestIndexCheckpointFallsBackToRebuild(t *testing.T) {
+	cases := []struct {
+		name   string
+		damage func(t *testing.T, tdb *tests.TestDB)
+		reason string
+	}{
+		{
+			name: "corrupt file",
+			damage: func(t *testing.T, tdb *tests.TestDB) {
+				path := filepath.Join(tdb.DataDir, "indexes", "users.json")
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("read index file: %v", err)
+				}
+				data = []byte(strings.Replace(string(data), `"event_id":`, `"event_id":1`, 1))
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					t.Fatalf("write index file: %v", err)
+				}
+			},
+			reason: "checksum mismatch",
+		},
+		{
+			name: "missing file",
+			damage: func(t *testing.T, tdb *tests.TestDB) {
+				os.Remove(filepath.Join(tdb.DataDir, "indexes", "users.json"))
+			},
+			reason: "no such file",
+		},
+		{
+			name: "compacted past the checkpoint",
+			damage: func(t *testing.T, tdb *tests.TestDB) {
+				tdb.DB = reopen(t, tdb, false)
+				if _, err := tdb.DB.Insert("users", userRow(13, "user13", 22)); err != nil {
+					t.Fatalf("insert: %v", err)
+				}
+				es := tdb.DB.GetEventStore()
+				if _, err := es.Compact(es.GetLastEventID()); err != nil {
+					t.Fatalf("compact: %v", err)
+				}
+				tdb.DB = reopen(t, tdb, true)
+			},
+			reason: "behind the compaction boundary",
+		},
+		{
+			name: "schema change after the checkpoint",
+			damage: func(t *testing.T, tdb *tests.TestDB) {
+				tdb.DB = reopen(t, tdb, false)
+				evolve(t, tdb.DB, "users", schema.ConversionPolicy{}, &schema.AddColumnOp{Column: schema.Column{Name: "email", Type: schema.TypeText}})
+				tdb.DB = reopen(t, tdb, true)
+			},
+			reason: "SCHEMA_EVOLVED",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tdb := tests.NewTestDB(t)
+			defer tdb.Cleanup()
+			seedIndexedUsers(t, tdb)
+			if err := tdb.DB.CheckpointIndexes(); err != nil {
+				t.Fatalf("checkpoint: %v", err)
+			}
+
+			c.damage(t, tdb)
+			db := reopen(t, tdb, true)
+			recovery := db.IndexRecovery()
+			if !strings.Contains(recovery.Rebuilt["users"], c.reason) || len(recovery.Loaded) != 0 {
+				t.Fatalf("expected users rebuilt because of %q, got %+v", c.reason, recovery)
+			}
+
+			assertIndexedUsers(t, db, &parser.WhereClause{Column: "name", Operator: "=", Value: "user7"}, "7")
+			assertIndexedUsers(t, db, &parser.WhereClause{Column: "age", Operator: "=", Value: float64(20)}, "5,10")
+		})
+	}
+}
+
+func TestIndexCheckpointAfterDropIndex(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedIndexedUsers(t, tdb)
+	if err := tdb.DB.DropIndex("users_name"); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+
+	// The drop came after the checkpoint CREATE INDEX wrote, so that
+	// checkpoint no longer matches the table's indexes
+	db := reopen(t, tdb, true)
+	if _, rebuilt := db.IndexRecovery().Rebuilt["users"]; !rebuilt {
+		t.Errorf("expected a rebuild after DROP INDEX, got %+v", db.IndexRecovery())
+	}
+	if plan, _ := db.PlanSelect("users", &parser.WhereClause{Column: "name", Operator: "=", Value: "user7"}, nil, 0); plan != "full scan on users" {
+		t.Errorf("expected a full scan once the index is dropped, got %q", plan)
+	}
+}
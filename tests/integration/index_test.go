@@ -0,0 +1,498 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"rdbms/database"
+	"rdbms/executor"
+	"rdbms/parser"
+	"rdbms/schema"
+	"rdbms/storage"
+	"rdbms/tests"
+)
+
+// rowIDs returns the id column of each row, in order
+func rowIDs(rows []storage.Row) string {
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = fmt.Sprint(row["id"])
+	}
+	return strings.Join(ids, ",")
+}
+
+// seedRangeUsers creates users with ids 1-12, inserted out of order
+func seedRangeUsers(t *testing.T, tdb *tests.TestDB) {
+	t.Helper()
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for _, id := range []int{9, 10, 2, 12, 1, 7, 11, 3, 5, 8, 4, 6} {
+		if _, err := tdb.InsertRow("users", userRow(id, fmt.Sprintf("user%d", id), 20+id%5)); err != nil {
+			t.Fatalf("insert %d: %v", id, err)
+		}
+	}
+}
+
+func TestBTreeRangeSelect(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedRangeUsers(t, tdb)
+
+	cases := []struct {
+		where    *parser.WhereClause
+		order    *parser.OrderBy
+		limit    int
+		expected string
+		plan     string
+	}{
+		{
+			where:    &parser.WhereClause{Column: "id", Operator: "<", Value: float64(4)},
+			expected: "1,2,3",
+			plan:     "index range on users.id (btree)",
+		},
+		{
+			// Numeric keys: 9 and 10 are adjacent, "10" does not sort before "9"
+			where:    &parser.WhereClause{Column: "id", Operator: "BETWEEN", Value: float64(9), High: float64(10)},
+			expected: "9,10",
+			plan:     "index range on users.id (btree)",
+		},
+		{
+			where:    &parser.WhereClause{Column: "id", Operator: ">=", Value: float64(5)},
+			order:    &parser.OrderBy{Column: "id", Desc: true},
+			limit:    3,
+			expected: "12,11,10",
+			plan:     "index range on users.id (btree), limit 3",
+		},
+		{
+			order:    &parser.OrderBy{Column: "id", Desc: true},
+			limit:    2,
+			expected: "12,11",
+			plan:     "index order on users.id (btree), limit 2",
+		},
+		{
+			// The ORDER BY walk filters rows on an unindexed column as it goes
+			where:    &parser.WhereClause{Column: "age", Operator: ">", Value: float64(23)},
+			order:    &parser.OrderBy{Column: "id"},
+			limit:    2,
+			expected: "4,9",
+			plan:     "index order on users.id (btree), limit 2",
+		},
+		{
+			where:    &parser.WhereClause{Column: "id", Operator: ">", Value: float64(8)},
+			order:    &parser.OrderBy{Column: "age"},
+			expected: "10,11,12,9",
+			plan:     "index range on users.id (btree), sort by age",
+		},
+		{
+			// Ties keep insertion order: user 9 was inserted before user 4
+			order:    &parser.OrderBy{Column: "age", Desc: true},
+			limit:    2,
+			expected: "9,4",
+			plan:     "full scan on users, sort by age, limit 2",
+		},
+	}
+
+	for _, c := range cases {
+		rows, err := tdb.DB.SelectOrdered("users", c.where, c.order, c.limit)
+		if err != nil {
+			t.Fatalf("select %+v: %v", c.where, err)
+		}
+		if got := rowIDs(rows); got != c.expected {
+			t.Errorf("where %+v order %+v limit %d: expected ids %s, got %s", c.where, c.order, c.limit, c.expected, got)
+		}
+		if plan, _ := tdb.DB.PlanSelect("users", c.where, c.order, c.limit); plan != c.plan {
+			t.Errorf("where %+v order %+v limit %d: expected plan %q, got %q", c.where, c.order, c.limit, c.plan, plan)
+		}
+	}
+
+	// The index follows deletes and updates
+	if _, err := tdb.DB.Delete("users", &parser.WhereClause{Column: "id", Value: float64(2)}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := tdb.DB.Update("users", "id", float64(0), &parser.WhereClause{Column: "id", Value: float64(3)}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	rows, _ := tdb.DB.SelectOrdered("users", &parser.WhereClause{Column: "id", Operator: "<=", Value: float64(3)}, nil, 0)
+	if got := rowIDs(rows); got != "0,1" {
+		t.Errorf("expected ids 0,1 after delete and update, got %s", got)
+	}
+}
+
+func TestExecutorOrderByLimit(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedRangeUsers(t, tdb)
+
+	exec := executor.New(tdb.DB)
+	p := parser.New()
+
+	stmt, err := p.Parse("SELECT * FROM users WHERE id BETWEEN 8 AND 11 ORDER BY id DESC LIMIT 3")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	result, err := exec.Execute(stmt)
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	lines := strings.Split(result, "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "user11") || !strings.Contains(lines[2], "user9") {
+		t.Errorf("expected users 11, 10, 9 in order, got:\n%s", result)
+	}
+}
+
+func TestCreateAndDropIndex(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedRangeUsers(t, tdb)
+
+	exec := executor.New(tdb.DB)
+	p := parser.New()
+	run := func(sql string) error {
+		stmt, err := p.Parse(sql)
+		if err != nil {
+			return err
+		}
+		_, err = exec.Execute(stmt)
+		return err
+	}
+
+	byAge := &parser.WhereClause{Column: "age", Operator: ">", Value: float64(23)}
+	if plan, _ := tdb.DB.PlanSelect("users", byAge, nil, 0); plan != "full scan on users" {
+		t.Errorf("expected a full scan before CREATE INDEX, got %q", plan)
+	}
+
+	if err := run("CREATE INDEX users_age ON users (age)"); err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	if plan, _ := tdb.DB.PlanSelect("users", byAge, nil, 0); plan != "index range on users.age (btree)" {
+		t.Errorf("unexpected plan: %q", plan)
+	}
+	rows, _ := tdb.DB.SelectOrdered("users", byAge, nil, 0)
+	if got := rowIDs(rows); got != "9,4" {
+		t.Errorf("expected ids 9,4, got %s", got)
+	}
+
+	if err := run("CREATE INDEX users_age ON users (name)"); err == nil {
+		t.Error("expected an error for a duplicate index name")
+	}
+	if err := run("CREATE INDEX users_nick ON users (nick)"); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+
+	// A unique index is refused when existing rows share a value
+	if err := run("CREATE UNIQUE INDEX users_age_unique ON users (age) USING HASH"); err == nil ||
+		!strings.Contains(err.Error(), "duplicate value") {
+		t.Errorf("expected a duplicate value error, got %v", err)
+	}
+	if err := run("CREATE UNIQUE INDEX users_name ON users (name) USING HASH"); err != nil {
+		t.Fatalf("create unique index: %v", err)
+	}
+	if _, err := tdb.InsertRow("users", userRow(13, "user1", 30)); err == nil ||
+		!strings.Contains(err.Error(), "users_name") {
+		t.Errorf("expected a unique violation on users_name, got %v", err)
+	}
+	if _, err := tdb.DB.Update("users", "name", "user2", &parser.WhereClause{Column: "id", Value: float64(3)}); err == nil {
+		t.Error("expected a unique violation from UPDATE")
+	}
+
+	// Definitions are events: they survive a restart, failed builds included
+	tdb.DB.Close()
+	db, err := database.New(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	tdb.DB = db
+	exec = executor.New(db)
+
+	table, _ := db.GetTable("users")
+	var names []string
+	for _, idx := range table.Indexes {
+		names = append(names, fmt.Sprintf("%s(%s) %s unique=%v", idx.Name, strings.Join(idx.Columns, ","), idx.Method, idx.Unique))
+	}
+	if got := strings.Join(names, "; "); got != "users_age(age) BTREE unique=false; users_name(name) HASH unique=true" {
+		t.Errorf("unexpected catalog indexes: %s", got)
+	}
+	if plan, _ := db.PlanSelect("users", &parser.WhereClause{Column: "name", Value: "user7"}, nil, 0); plan != "index lookup on users.name (hash)" {
+		t.Errorf("unexpected plan after restart: %q", plan)
+	}
+
+	if err := run("DROP INDEX users_pkey"); err == nil {
+		t.Error("expected an error dropping a primary key index")
+	}
+	if err := run("DROP INDEX users_age"); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+	if err := run("DROP INDEX users_age"); err == nil {
+		t.Error("expected an error dropping a missing index")
+	}
+	if plan, _ := db.PlanSelect("users", byAge, nil, 0); plan != "full scan on users" {
+		t.Errorf("expected a full scan after DROP INDEX, got %q", plan)
+	}
+}
+
+func TestCreateIndexFollowsSchemaEvolution(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedRangeUsers(t, tdb)
+
+	if err := tdb.DB.CreateIndex("users", schema.Index{Name: "users_age", Columns: []string{"age"}}); err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+
+	evolve(t, tdb.DB, "users", schema.ConversionPolicy{}, &schema.RenameColumnOp{OldName: "age", NewName: "years"})
+
+	table, _ := tdb.DB.GetTable("users")
+	if len(table.Indexes) != 1 || table.Indexes[0].Columns[0] != "years" {
+		t.Fatalf("expected the index to follow the rename, got %+v", table.Indexes)
+	}
+	where := &parser.WhereClause{Column: "years", Operator: "<", Value: float64(21)}
+	if plan, _ := tdb.DB.PlanSelect("users", where, nil, 0); plan != "index range on users.years (btree)" {
+		t.Errorf("unexpected plan: %q", plan)
+	}
+	rows, _ := tdb.DB.SelectOrdered("users", where, &parser.OrderBy{Column: "id"}, 0)
+	if got := rowIDs(rows); got != "5,10" {
+		t.Errorf("expected ids 5,10, got %s", got)
+	}
+}
+
+func TestCreateIndexWithConcurrentWrites(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedRangeUsers(t, tdb)
+
+	// Writers keep inserting, updating and deleting while the index builds
+	done := make(chan error)
+	go func() {
+		for id := 13; id < 60; id++ {
+			if _, err := tdb.DB.Insert("users", userRow(id, fmt.Sprintf("user%d", id), 20+id%5)); err != nil {
+				done <- err
+				return
+			}
+			if id%3 == 0 {
+				if _, err := tdb.DB.Update("users", "age", float64(40), &parser.WhereClause{Column: "id", Value: float64(id - 1)}); err != nil {
+					done <- err
+					return
+				}
+			}
+			if id%7 == 0 {
+				if _, err := tdb.DB.Delete("users", &parser.WhereClause{Column: "id", Value: float64(id - 2)}); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+
+	if err := tdb.DB.CreateIndex("users", schema.Index{Name: "users_age", Columns: []string{"age"}, Method: schema.IndexHash}); err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("writer: %v", err)
+	}
+
+	// Every lookup through the index agrees with a scan of the table
+	all, _ := tdb.SelectAll("users")
+	for _, age := range []float64{20, 21, 22, 23, 24, 40} {
+		expected := 0
+		for _, row := range all {
+			if row["age"] == age {
+				expected++
+			}
+		}
+		where := &parser.WhereClause{Column: "age", Value: age}
+		if plan, _ := tdb.DB.PlanSelect("users", where, nil, 0); plan != "index lookup on users.age (hash)" {
+			t.Fatalf("unexpected plan: %q", plan)
+		}
+		rows, _ := tdb.DB.Select("users", where)
+		if len(rows) != expected {
+			t.Errorf("age %v: index returned %d rows, table has %d", age, len(rows), expected)
+		}
+	}
+}
+
+func TestCompositeKeys(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	exec := executor.New(tdb.DB)
+	p := parser.New()
+	run := func(sql string) (string, error) {
+		stmt, err := p.Parse(sql)
+		if err != nil {
+			return "", err
+		}
+		return exec.Execute(stmt)
+	}
+
+	if _, err := run("CREATE TABLE members (user_id INT, group_id INT, role TEXT, PRIMARY KEY (group_id, user_id))"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for _, m := range [][3]interface{}{{1, 1, "owner"}, {2, 1, "member"}, {3, 1, "member"}, {1, 2, "member"}, {2, 2, "owner"}} {
+		if _, err := run(fmt.Sprintf("INSERT INTO members VALUES (%d, %d, '%s')", m[0], m[1], m[2])); err != nil {
+			t.Fatalf("insert %v: %v", m, err)
+		}
+	}
+
+	// The key is unique as a whole, not per column
+	if _, err := run("INSERT INTO members VALUES (2, 1, 'owner')"); err == nil ||
+		!strings.Contains(err.Error(), "primary key violation: duplicate value '(1, 2)'") {
+		t.Errorf("expected a composite primary key violation, got %v", err)
+	}
+
+	userIDs := func(rows []storage.Row) string {
+		ids := make([]string, len(rows))
+		for i, row := range rows {
+			ids[i] = fmt.Sprintf("%v/%v", row["group_id"], row["user_id"])
+		}
+		return strings.Join(ids, ",")
+	}
+	where := func(sql string) *parser.WhereClause {
+		stmt, err := p.Parse("SELECT * FROM members WHERE " + sql)
+		if err != nil {
+			t.Fatalf("parse %s: %v", sql, err)
+		}
+		return stmt.Where
+	}
+
+	cases := []struct {
+		where    string
+		order    *parser.OrderBy
+		expected string
+		plan     string
+	}{
+		{
+			where:    "user_id = 2 AND group_id = 1",
+			expected: "1/2",
+			plan:     "index lookup on members.(group_id, user_id) (btree)",
+		},
+		{
+			// A leftmost prefix of the key walks the B-tree in key order
+			where:    "group_id = 1",
+			expected: "1/1,1/2,1/3",
+			plan:     "index prefix on members.(group_id, user_id) (btree), 1 of 2 columns",
+		},
+		{
+			where:    "group_id = 1 AND role = 'member'",
+			order:    &parser.OrderBy{Column: "user_id", Desc: true},
+			expected: "1/3,1/2",
+			plan:     "index prefix on members.(group_id, user_id) (btree), 1 of 2 columns",
+		},
+		{
+			// user_id alone is not a leftmost prefix
+			where:    "user_id = 2",
+			order:    &parser.OrderBy{Column: "group_id"},
+			expected: "1/2,2/2",
+			plan:     "full scan on members, sort by group_id",
+		},
+	}
+	for _, c := range cases {
+		rows, err := tdb.DB.SelectOrdered("members", where(c.where), c.order, 0)
+		if err != nil {
+			t.Fatalf("select %s: %v", c.where, err)
+		}
+		if got := userIDs(rows); got != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.where, c.expected, got)
+		}
+		if plan, _ := tdb.DB.PlanSelect("members", where(c.where), c.order, 0); plan != c.plan {
+			t.Errorf("%s: expected plan %q, got %q", c.where, c.plan, plan)
+		}
+	}
+
+	// A composite secondary index serves its own leftmost prefix
+	if _, err := run("CREATE INDEX members_by_user ON members (user_id, role)"); err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	if plan, _ := tdb.DB.PlanSelect("members", where("user_id = 2"), nil, 0); plan != "index prefix on members.(user_id, role) (btree), 1 of 2 columns" {
+		t.Errorf("unexpected plan: %q", plan)
+	}
+	if _, err := run("CREATE UNIQUE INDEX members_owner ON members (group_id, role) USING HASH"); err == nil {
+		t.Error("expected group 1's two members to break a unique (group_id, role) index")
+	}
+
+	if _, err := run("DELETE FROM members WHERE group_id = 1 AND user_id = 3"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	// The key order survives a restart
+	tdb.DB.Close()
+	db, err := database.New(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	tdb.DB = db
+
+	table, _ := db.GetTable("members")
+	if fmt.Sprint(table.KeyColumns()) != "[group_id user_id]" || table.PrimaryKey != "" {
+		t.Errorf("unexpected key after restart: %v (%q)", table.KeyColumns(), table.PrimaryKey)
+	}
+	rows, _ := db.SelectOrdered("members", where("group_id = 1"), nil, 0)
+	if got := userIDs(rows); got != "1/1,1/2" {
+		t.Errorf("expected 1/1,1/2 after restart, got %s", got)
+	}
+	if _, err := db.Insert("members", storage.Row{"user_id": float64(3), "group_id": float64(1), "role": "member"}); err != nil {
+		t.Errorf("expected the deleted key to be free again: %v", err)
+	}
+}
+
+// TestTypedIndexKeys tests that lookups and scans compare values by type: a
+// number never matches a TEXT value that prints the same
+func TestTypedIndexKeys(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	exec := executor.New(tdb.DB)
+	p := parser.New()
+	for _, sql := range []string{
+		"CREATE TABLE codes (id INT PRIMARY KEY, code TEXT UNIQUE, label TEXT)",
+		"INSERT INTO codes VALUES (1, '1', 'true')",
+		"INSERT INTO codes VALUES (2, '1.0', '1e+21')",
+		"INSERT INTO codes VALUES (3, '01', '2')",
+	} {
+		stmt, err := p.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %s: %v", sql, err)
+		}
+		if _, err := exec.Execute(stmt); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+
+	cases := []struct {
+		where    string
+		expected string
+	}{
+		{"code = '1'", "1"},
+		{"code = 1", ""}, // index lookup
+		{"code = '1.0'", "2"},
+		{"label = true", ""}, // full scan
+		{"label = 'true'", "1"},
+		{"label = 1e21", ""},
+		{"label = 2", ""},
+		{"id = 1.0", "1"},
+	}
+	for _, c := range cases {
+		stmt, err := p.Parse("SELECT * FROM codes WHERE " + c.where)
+		if err != nil {
+			t.Fatalf("parse %s: %v", c.where, err)
+		}
+		rows, err := tdb.DB.SelectOrdered("codes", stmt.Where, nil, 0)
+		if err != nil {
+			t.Fatalf("select %s: %v", c.where, err)
+		}
+		if got := rowIDs(rows); got != c.expected {
+			t.Errorf("%s: expected rows [%s], got [%s]", c.where, c.expected, got)
+		}
+	}
+
+	// Texts that read as the same number are still distinct values
+	if _, err := tdb.DB.Insert("codes", storage.Row{"id": float64(4), "code": "1e0", "label": "x"}); err != nil {
+		t.Errorf("expected '1e0' to be unique next to '1': %v", err)
+	}
+	if _, err := tdb.DB.Insert("codes", storage.Row{"id": float64(5), "code": "1", "label": "x"}); err == nil {
+		t.Error("expected a unique violation for a repeated '1'")
+	}
+}
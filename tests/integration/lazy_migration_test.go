@@ -0,0 +1,174 @@
+package integration
+
+import (
+	"testing"
+
+	"rdbms/database"
+	"rdbms/eventlog"
+	"rdbms/parser"
+	"rdbms/schema"
+	"rdbms/storage"
+	"rdbms/tests"
+)
+
+// evolve applies migration operations to a table through a SCHEMA_EVOLVED event
+func evolve(t *testing.T, db *database.Database, tableName string, policy schema.ConversionPolicy, ops ...schema.MigrationOp) {
+	t.Helper()
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("get table: %v", err)
+	}
+	after, resolved, err := schema.ApplyOpsToColumns(table.Columns, ops)
+	if err != nil {
+		t.Fatalf("apply ops: %v", err)
+	}
+	if _, err := db.EvolveTable(tableName, after, schema.EvolutionFromOps(resolved, policy), "", ""); err != nil {
+		t.Fatalf("evolve %s: %v", tableName, err)
+	}
+}
+
+func TestLazyMigrationOnRead(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedUsers(t, tdb)
+
+	evolve(t, tdb.DB, "users", schema.ConversionPolicy{}, &schema.RenameColumnOp{OldName: "name", NewName: "full_name"})
+
+	// The log still holds the rows in version 1; reads see them in version 2
+	events, _ := tdb.DB.GetEventStore().ReadAllEvents()
+	raw, _ := storage.ReplayEvents(events)
+	for _, r := range raw.GetTableRows("users") {
+		if raw.RowVersion("users", r.ID) != 1 || r.Row["name"] == nil {
+			t.Errorf("expected row %d stored in version 1, got v%d %v", r.ID, raw.RowVersion("users", r.ID), r.Row)
+		}
+	}
+
+	rows, err := tdb.DB.Select("users", nil)
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	for _, row := range rows {
+		if row["full_name"] == nil || row["name"] != nil {
+			t.Errorf("expected row upgraded to version 2, got %v", row)
+		}
+	}
+
+	// An update written in version 2 migrates the old row before it applies
+	where := &parser.WhereClause{Column: "full_name", Value: "Alice"}
+	if n, err := tdb.DB.Update("users", "full_name", "Alicia", where); err != nil || n != 1 {
+		t.Fatalf("update: %d (%v)", n, err)
+	}
+	rows, _ = tdb.DB.Select("users", &parser.WhereClause{Column: "full_name", Value: "Alicia"})
+	if len(rows) != 1 || rows[0]["name"] != nil {
+		t.Errorf("expected the update to land on the migrated row, got %v", rows)
+	}
+
+	// New rows are written in version 2 and need no migration
+	if _, err := tdb.DB.Insert("users", map[string]interface{}{"id": float64(9), "full_name": "Dan", "age": float64(50)}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	tdb.AssertRowExists("users", "full_name", "Dan")
+}
+
+func TestMigrationFailuresAreQueryable(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	columns := []schema.Column{
+		{Name: "id", Type: schema.TypeInt, PrimaryKey: true, Unique: true},
+		{Name: "code", Type: schema.TypeText},
+	}
+	if err := tdb.CreateTable("items", columns, "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	tdb.InsertRow("items", map[string]interface{}{"id": float64(1), "code": "17"})
+	tdb.InsertRow("items", map[string]interface{}{"id": float64(2), "code": "n/a"})
+
+	evolve(t, tdb.DB, "items", schema.ConversionPolicy{}, &schema.ModifyColumnOp{
+		ColumnName: "code",
+		NewDef:     schema.Column{Name: "code", Type: schema.TypeInt},
+	})
+
+	// The unconvertible row is held back rather than returned half-migrated
+	rows, err := tdb.DB.Select("items", nil)
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if len(rows) != 1 || rows[0]["code"] != float64(17) {
+		t.Errorf("expected only the converted row, got %v", rows)
+	}
+
+	failed, err := tdb.DB.Select(schema.MigrationErrorTable, nil)
+	if err != nil {
+		t.Fatalf("select %s: %v", schema.MigrationErrorTable, err)
+	}
+	if len(failed) != 1 || failed[0]["table"] != "items" || failed[0]["from_version"] != float64(1) ||
+		failed[0]["to_version"] != float64(2) || failed[0]["error"] == "" {
+		t.Errorf("unexpected migration errors: %v", failed)
+	}
+	if errs, err := tdb.DB.MigrationErrors(); err != nil || len(errs) != 1 || errs[0].Row["code"] != "n/a" {
+		t.Errorf("unexpected migration errors: %+v (%v)", errs, err)
+	}
+
+	// New rows do not reuse the held-back row's ID (rows 0 and 1)
+	rowID, err := tdb.DB.Insert("items", map[string]interface{}{"id": float64(3), "code": float64(3)})
+	if err != nil || rowID != 2 {
+		t.Errorf("expected row ID 2, got %d (%v)", rowID, err)
+	}
+
+	// The rewriter persists migrated rows in a snapshot, with their versions
+	stats, err := tdb.DB.MigrationRewriter().RunOnce()
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if stats.Pending != 2 || stats.Migrated != 1 || stats.Failed != 1 || stats.Snapshot == nil {
+		t.Errorf("unexpected rewrite stats: %+v", stats)
+	}
+	if stats, _ := tdb.DB.MigrationRewriter().RunOnce(); stats.Migrated != 0 || stats.Snapshot != nil {
+		t.Errorf("expected nothing left to rewrite, got %+v", stats)
+	}
+
+	sm, err := storage.NewSnapshotManager(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("snapshot manager: %v", err)
+	}
+	snap, _, err := sm.RestoreLatestSnapshot()
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if snap.RowVersion("items", 0) != 2 || snap.Tables["items"][0]["code"] != float64(17) {
+		t.Errorf("expected row 0 rewritten in version 2, got v%d %v", snap.RowVersion("items", 0), snap.Tables["items"][0])
+	}
+	if snap.RowVersion("items", 1) != 1 || snap.Tables["items"][1]["code"] != "n/a" {
+		t.Errorf("expected the failed row kept in version 1, got v%d %v", snap.RowVersion("items", 1), snap.Tables["items"][1])
+	}
+
+	// Reads from the rewritten snapshot give the same answer
+	tdb.AssertRowCount("items", 2)
+}
+
+func TestEagerReplayRecordsMigrationErrors(t *testing.T) {
+	events := []*eventlog.Event{
+		{ID: 1, Type: eventlog.SchemaCreated, Version: 1, Payload: map[string]interface{}{"table_name": "users"}},
+		{ID: 2, Type: eventlog.RowInserted, Version: 1, Payload: map[string]interface{}{
+			"table_name": "users", "row_id": float64(1), "data": map[string]interface{}{"id": float64(1), "age": "30"},
+		}},
+		{ID: 3, Type: eventlog.RowInserted, Version: 1, Payload: map[string]interface{}{
+			"table_name": "users", "row_id": float64(2), "data": map[string]interface{}{"id": float64(2), "age": "old"},
+		}},
+	}
+
+	handler := storage.NewMigrationHandler(textToIntRegistry(schema.ConversionPolicy{}))
+	state, err := storage.ReplayEventsWithMigrations(events, storage.SchemaTargets{"users": 2}, handler)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	if rows := state.GetTableRows("users"); len(rows) != 1 || rows[0].Row["age"] != float64(30) {
+		t.Errorf("expected only the migrated row, got %v", rows)
+	}
+	failed := state.GetTableRows(schema.MigrationErrorTable)
+	if len(failed) != 1 || failed[0].Row["row_id"] != float64(2) {
+		t.Errorf("expected the failed row in %s, got %v", schema.MigrationErrorTable, failed)
+	}
+}
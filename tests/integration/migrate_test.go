@@ -0,0 +1,256 @@
+package integration
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rdbms/database"
+	"rdbms/migrate"
+	"rdbms/schema"
+	"rdbms/tests"
+)
+
+// writeMigration writes a migration file into dir
+func writeMigration(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+// columnNames returns a table's column names in order
+func columnNames(table *schema.Table) string {
+	names := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		names[i] = col.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestMigrateUpStatusDown(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	if _, err := tdb.InsertRow("users", userRow(1, "Alice", 30)); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	dir := t.TempDir()
+	writeMigration(t, dir, "0001_add_email.sql", `-- +up
+ALTER TABLE users ADD COLUMN email TEXT DEFAULT 'none';
+-- +down
+ALTER TABLE users DROP COLUMN email;
+`)
+	writeMigration(t, dir, "0002_rename_name.json", `{"table": "users", "up": [{"op": "rename_column", "from": "name", "to": "full_name"}]}`)
+	writeMigration(t, dir, "notes.txt", "not a migration")
+
+	m, err := migrate.New(tdb.DB, dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(m.Files()) != 2 {
+		t.Fatalf("expected 2 migration files, got %d", len(m.Files()))
+	}
+
+	steps, err := m.Up(0)
+	if err != nil || len(steps) != 2 {
+		t.Fatalf("up: %d steps (%v)", len(steps), err)
+	}
+	table, _ := tdb.DB.GetTable("users")
+	if got := columnNames(table); got != "id,full_name,age,email" {
+		t.Errorf("unexpected columns after up: %s", got)
+	}
+	if v := tdb.DB.GetEventStore().GetSchemaVersion("users"); v != 3 {
+		t.Errorf("expected users v3, got v%d", v)
+	}
+
+	var out bytes.Buffer
+	if err := migrate.Run(tdb.DB, []string{"status", "-dir", dir}, &out); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if strings.Count(out.String(), "applied") != 2 {
+		t.Errorf("expected both migrations applied:\n%s", out.String())
+	}
+
+	// Without a down section the rename is inverted from what the up step recorded
+	steps, err = m.Down(1)
+	if err != nil || len(steps) != 1 || steps[0].ID != "0002_rename_name" {
+		t.Fatalf("down: %+v (%v)", steps, err)
+	}
+	table, _ = tdb.DB.GetTable("users")
+	if got := columnNames(table); got != "id,name,age,email" {
+		t.Errorf("unexpected columns after down: %s", got)
+	}
+	if v := tdb.DB.GetEventStore().GetSchemaVersion("users"); v != 4 {
+		t.Errorf("expected versions to keep increasing, got v%d", v)
+	}
+
+	pending, err := m.Pending()
+	if err != nil || len(pending) != 1 || pending[0].ID != "0002_rename_name" {
+		t.Errorf("expected the rolled back migration pending, got %v (%v)", pending, err)
+	}
+
+	// The explicit down section drops the added column
+	if _, err := m.Down(1); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	table, _ = tdb.DB.GetTable("users")
+	if got := columnNames(table); got != "id,name,age" {
+		t.Errorf("unexpected columns after second down: %s", got)
+	}
+
+	// Applied state comes from the log, so it survives a restart
+	if _, err := m.Up(1); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	tdb.DB.Close()
+	reopened, err := database.New(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	tdb.DB = reopened
+	m, _ = migrate.New(reopened, dir)
+	pending, _ = m.Pending()
+	if len(pending) != 1 || pending[0].ID != "0002_rename_name" {
+		t.Errorf("expected only 0002 pending after restart, got %v", pending)
+	}
+}
+
+func TestMigrateRefusesFailingSteps(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	columns := []schema.Column{
+		{Name: "id", Type: schema.TypeInt, PrimaryKey: true, Unique: true},
+		{Name: "code", Type: schema.TypeText},
+	}
+	if err := tdb.CreateTable("items", columns, "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	tdb.InsertRow("items", map[string]interface{}{"id": float64(1), "code": "17"})
+	tdb.InsertRow("items", map[string]interface{}{"id": float64(2), "code": "n/a"})
+
+	dir := t.TempDir()
+	writeMigration(t, dir, "0001_code_to_int.sql", "ALTER TABLE items ALTER COLUMN code TYPE INT")
+	writeMigration(t, dir, "0002_add_note.sql", "ALTER TABLE items ADD COLUMN note TEXT")
+
+	m, err := migrate.New(tdb.DB, dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	lastEvent := tdb.DB.GetEventStore().GetLastEventID()
+
+	var out bytes.Buffer
+	if err := migrate.Run(tdb.DB, []string{"plan", "-dir", dir}, &out); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if !strings.Contains(out.String(), "REFUSED") {
+		t.Errorf("expected the plan to flag the failing step:\n%s", out.String())
+	}
+
+	// The whole batch is refused, including the harmless second step
+	if _, err := m.Up(0); err == nil {
+		t.Fatal("expected unconvertible rows to refuse the migration")
+	}
+	if tdb.DB.GetEventStore().GetLastEventID() != lastEvent {
+		t.Error("expected no events recorded for a refused batch")
+	}
+	table, _ := tdb.DB.GetTable("items")
+	if table.Columns[1].Type != schema.TypeText {
+		t.Errorf("expected code to stay TEXT, got %s", table.Columns[1].Type)
+	}
+
+	// A policy that absorbs the failure lets it through, once narrowing the
+	// type is allowed
+	writeMigration(t, dir, "0001_code_to_int.sql", "-- +on_failure null\nALTER TABLE items ALTER COLUMN code TYPE INT")
+	m, _ = migrate.New(tdb.DB, dir)
+	m.SetCompatibilityMode(schema.CompatibilityNone)
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("up with SetNull policy: %v", err)
+	}
+	table, _ = tdb.DB.GetTable("items")
+	if table.Columns[1].Type != schema.TypeInt || columnNames(table) != "id,code,note" {
+		t.Errorf("unexpected schema after migration: %+v", table.Columns)
+	}
+
+	// Operations that depend on their order cannot be expressed as one event
+	writeMigration(t, dir, "0003_swap.sql", "ALTER TABLE items ADD COLUMN tmp TEXT, RENAME tmp TO label")
+	m, _ = migrate.New(tdb.DB, dir)
+	if _, err := m.Plan(0); err == nil {
+		t.Error("expected order-dependent operations to be rejected")
+	}
+}
+
+func TestMigrateEnforcesCompatibility(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	dir := t.TempDir()
+	writeMigration(t, dir, "0001_add_email.sql", "ALTER TABLE users ADD COLUMN email TEXT NOT NULL")
+
+	// By default the breaking change is refused before anything is applied
+	lastEvent := tdb.DB.GetEventStore().GetLastEventID()
+	var out bytes.Buffer
+	if err := migrate.Run(tdb.DB, []string{"plan", "-dir", dir}, &out); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if !strings.Contains(out.String(), "breaks backward") || !strings.Contains(out.String(), "REFUSED") {
+		t.Errorf("expected the change refused:\n%s", out.String())
+	}
+	if err := migrate.Run(tdb.DB, []string{"up", "-dir", dir}, &out); err == nil {
+		t.Fatal("expected a default up to refuse a NOT NULL column without a default")
+	}
+	if tdb.DB.GetEventStore().GetLastEventID() != lastEvent {
+		t.Error("expected no events recorded for a refused migration")
+	}
+
+	// -compat none only reports it
+	out.Reset()
+	if err := migrate.Run(tdb.DB, []string{"plan", "-dir", dir, "-compat", "none"}, &out); err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if !strings.Contains(out.String(), "breaks backward") || strings.Contains(out.String(), "REFUSED") {
+		t.Errorf("expected the change reported but not refused:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := migrate.Run(tdb.DB, []string{"up", "-dir", dir, "-compat", "backward"}, &out); err == nil {
+		t.Fatal("expected backward compatibility to refuse a NOT NULL column without a default")
+	}
+	if err := migrate.Run(tdb.DB, []string{"up", "-dir", dir, "-compat", "sideways"}, &out); err == nil {
+		t.Error("expected an unknown compatibility mode to be rejected")
+	}
+
+	// With a default the same column is backward compatible
+	writeMigration(t, dir, "0001_add_email.sql", "ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT 'none'")
+	if err := migrate.Run(tdb.DB, []string{"up", "-dir", dir, "-compat", "backward"}, &out); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	table, _ := tdb.DB.GetTable("users")
+	if email := table.Columns[len(table.Columns)-1]; !email.NotNull || email.Default != "none" {
+		t.Errorf("expected email NOT NULL with default 'none', got %+v", email)
+	}
+
+	// Dropping a column without a default is refused unless opted out of
+	writeMigration(t, dir, "0002_drop_age.sql", "ALTER TABLE users DROP COLUMN age")
+	if err := migrate.Run(tdb.DB, []string{"up", "-dir", dir}, &out); err == nil {
+		t.Fatal("expected a default up to refuse dropping a column without a default")
+	}
+	if err := migrate.Run(tdb.DB, []string{"up", "-dir", dir, "-compat", "none"}, &out); err != nil {
+		t.Fatalf("up -compat none: %v", err)
+	}
+	table, _ = tdb.DB.GetTable("users")
+	if got := columnNames(table); got != "id,name,email" {
+		t.Errorf("unexpected columns after dropping age: %s", got)
+	}
+}
@@ -121,7 +121,7 @@ func TestReplayEventsWithMigrations(t *testing.T) {
 	}
 
 	// Replay with migration to v2
-	state, err := storage.ReplayEventsWithMigrations(events, 2, handler)
+	state, err := storage.ReplayEventsWithMigrations(events, storage.SchemaTargets{"users": 2}, handler)
 	if err != nil {
 		t.Fatalf("Replay failed: %v", err)
 	}
@@ -227,7 +227,7 @@ func TestMigrationFailureRecovery(t *testing.T) {
 
 	// Replay to higher version without migration should gracefully handle errors
 	// (it should skip the problematic row but continue)
-	state, err := storage.ReplayEventsWithMigrations(events, 2, handler)
+	state, err := storage.ReplayEventsWithMigrations(events, storage.SchemaTargets{"test": 2}, handler)
 	if err != nil {
 		t.Fatalf("Replay should not fail on missing migration: %v", err)
 	}
@@ -315,7 +315,8 @@ func TestComplexMultiTableMigration(t *testing.T) {
 		},
 	}
 
-	state, _ := storage.ReplayEventsWithMigrations(events, 2, handler)
+	// No explicit targets: every table migrates to its latest registered version
+	state, _ := storage.ReplayEventsWithMigrations(events, nil, handler)
 
 	// Check users table
 	userRows := state.GetTableRows("users")
@@ -337,3 +338,102 @@ func TestComplexMultiTableMigration(t *testing.T) {
 
 	t.Log("✓ Complex multi-table migration test passed")
 }
+
+func TestSchemaVersionsArePerTable(t *testing.T) {
+	dir := t.TempDir()
+	es, err := storage.NewEventStore(dir)
+	if err != nil {
+		t.Fatalf("open event store: %v", err)
+	}
+
+	idCol := eventlog.ColumnDefinition{Name: "id", Type: "INT", PrimaryKey: true}
+	nameCol := eventlog.ColumnDefinition{Name: "name", Type: "TEXT"}
+	es.RecordSchemaCreated("users", []eventlog.ColumnDefinition{idCol}, "id", "tx-1")
+	es.RecordSchemaCreated("products", []eventlog.ColumnDefinition{idCol}, "id", "tx-2")
+	es.RecordRowInserted("users", 1, storage.Row{"id": float64(1)}, "tx-3")
+
+	// Evolving users must not move products to a new version
+	evolved, err := es.RecordSchemaEvolved("users", []eventlog.ColumnDefinition{idCol}, []eventlog.ColumnDefinition{idCol, nameCol},
+		eventlog.SchemaEvolution{AddedColumns: []eventlog.ColumnDefinition{nameCol}}, "tx-4")
+	if err != nil {
+		t.Fatalf("record evolution: %v", err)
+	}
+	if evolved.Version != 2 {
+		t.Errorf("expected evolution to produce users v2, got v%d", evolved.Version)
+	}
+	product, _ := es.RecordRowInserted("products", 1, storage.Row{"id": float64(1)}, "tx-5")
+	user, _ := es.RecordRowInserted("users", 2, storage.Row{"id": float64(2), "name": "Bob"}, "tx-6")
+	if product.Version != 1 || user.Version != 2 {
+		t.Errorf("expected products v1 and users v2, got v%d and v%d", product.Version, user.Version)
+	}
+	es.Close()
+
+	// Versions are rebuilt per table from the log
+	es, err = storage.NewEventStore(dir)
+	if err != nil {
+		t.Fatalf("reopen event store: %v", err)
+	}
+	defer es.Close()
+	if v := es.GetSchemaVersions(); v["users"] != 2 || v["products"] != 1 {
+		t.Errorf("unexpected versions after reopen: %v", v)
+	}
+
+	// Only users has migrations; products rows replay without any
+	registry := schema.NewSchemaRegistry()
+	registry.RegisterSchema("users", 1, []schema.Column{{Name: "id", Type: schema.TypeInt, PrimaryKey: true}})
+	registry.RegisterSchema("users", 2, []schema.Column{{Name: "id", Type: schema.TypeInt, PrimaryKey: true}, {Name: "name", Type: schema.TypeText}})
+	registry.RegisterMigration("users", 1, 2, []schema.MigrationOp{
+		&schema.AddColumnOp{Column: schema.Column{Name: "name", Type: schema.TypeText}, Default: "unnamed"},
+	})
+
+	events, _ := es.ReadAllEvents()
+	state, err := storage.ReplayEventsWithMigrations(events, nil, storage.NewMigrationHandler(registry))
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if n := len(state.GetTableRows("products")); n != 1 {
+		t.Errorf("expected products row to survive replay, got %d rows", n)
+	}
+	if row, _ := state.GetRow("users", 1); row["name"] != "unnamed" {
+		t.Errorf("expected users v1 row to be migrated, got %v", row)
+	}
+	if row, _ := state.GetRow("users", 2); row["name"] != "Bob" {
+		t.Errorf("expected users v2 row untouched, got %v", row)
+	}
+}
+
+func TestDeterministicReplayMigratesBackwards(t *testing.T) {
+	registry := schema.NewSchemaRegistry()
+	registry.RegisterMigration("users", 1, 2, []schema.MigrationOp{
+		&schema.RenameColumnOp{OldName: "fullname", NewName: "name"},
+	})
+	handler := storage.NewMigrationHandler(registry)
+
+	events := []*eventlog.Event{
+		{ID: 1, Type: eventlog.SchemaCreated, Version: 1, Payload: map[string]interface{}{"table_name": "users"}},
+		{ID: 2, Type: eventlog.RowInserted, Version: 1, Payload: map[string]interface{}{
+			"table_name": "users", "row_id": float64(1), "data": map[string]interface{}{"id": float64(1), "fullname": "Alice"},
+		}},
+		{ID: 3, Type: eventlog.SchemaEvolved, Version: 2, Payload: map[string]interface{}{"table_name": "users"}},
+		{ID: 4, Type: eventlog.RowInserted, Version: 2, Payload: map[string]interface{}{
+			"table_name": "users", "row_id": float64(2), "data": map[string]interface{}{"id": float64(2), "name": "Bob"},
+		}},
+	}
+
+	// Roll back to v1: rows written in v2 are migrated down
+	opts := &storage.DeterministicReplayOptions{TargetSchemaVersions: storage.SchemaTargets{"users": 1}}
+	result := storage.ReplayEventsDeterministic(events, opts, handler)
+	for _, r := range result.State.GetTableRows("users") {
+		if _, exists := r.Row["fullname"]; !exists {
+			t.Errorf("expected row %d in v1 shape, got %v", r.ID, r.Row)
+		}
+	}
+
+	state, err := storage.ReplayEventsWithMigrations(events, storage.SchemaTargets{"users": 1}, handler)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if row, _ := state.GetRow("users", 2); row["fullname"] != "Bob" {
+		t.Errorf("expected v2 row migrated down, got %v", row)
+	}
+}
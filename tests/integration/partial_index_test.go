@@ -0,0 +1,224 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"rdbms/executor"
+	"rdbms/parser"
+	"rdbms/schema"
+	"rdbms/tests"
+)
+
+// sqlRunner runs statements against a test database and queries its rows
+type sqlRunner struct {
+	t   *testing.T
+	tdb *tests.TestDB
+	p   *parser.Parser
+}
+
+func (r sqlRunner) exec(sql string) (string, error) {
+	stmt, err := r.p.Parse(sql)
+	if err != nil {
+		return "", err
+	}
+	return executor.New(r.tdb.DB).Execute(stmt)
+}
+
+func (r sqlRunner) mustExec(sql string) {
+	r.t.Helper()
+	if _, err := r.exec(sql); err != nil {
+		r.t.Fatalf("%s: %v", sql, err)
+	}
+}
+
+// query returns the ids of the rows a SELECT finds, in id order, and its plan
+func (r sqlRunner) query(sql string) (string, string) {
+	r.t.Helper()
+	stmt, err := r.p.Parse(sql)
+	if err != nil {
+		r.t.Fatalf("parse %s: %v", sql, err)
+	}
+	rows, err := r.tdb.DB.SelectOrdered(stmt.TableName, stmt.Where, &parser.OrderBy{Column: "id"}, 0)
+	if err != nil {
+		r.t.Fatalf("select %s: %v", sql, err)
+	}
+	plan, _ := r.tdb.DB.PlanSelect(stmt.TableName, stmt.Where, nil, 0)
+	return rowIDs(rows), plan
+}
+
+func TestPartialIndex(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+
+	r.mustExec("CREATE TABLE tasks (id INT PRIMARY KEY, owner TEXT, completed BOOL, priority INT)")
+	for i, task := range []string{"'ann', false, 1", "'ann', true, 2", "'bob', false, 3", "'ann', false, 4", "'bob', true, 5"} {
+		r.mustExec(fmt.Sprintf("INSERT INTO tasks VALUES (%d, %s)", i+1, task))
+	}
+
+	msg, err := r.exec("CREATE INDEX ON tasks (owner) WHERE completed = false")
+	if err != nil {
+		t.Fatalf("create partial index: %v", err)
+	}
+	if msg != "Index 'tasks_owner_idx' created on 'tasks'" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+	if table, _ := tdb.DB.GetTable("tasks"); table.Indexes[0].Where != "completed = false" {
+		t.Errorf("expected the predicate in the catalog, got %+v", table.Indexes)
+	}
+	r.mustExec("CREATE INDEX tasks_urgent ON tasks (priority) WHERE priority >= 3 AND completed = false")
+
+	cases := []struct {
+		sql, expected, plan string
+	}{
+		{"SELECT * FROM tasks WHERE owner = 'ann' AND completed = false", "1,4", "index lookup on tasks.owner (btree, partial)"},
+		{"SELECT * FROM tasks WHERE completed = false AND owner = 'bob'", "3", "index lookup on tasks.owner (btree, partial)"},
+		// The index lacks completed tasks, so it cannot answer these
+		{"SELECT * FROM tasks WHERE owner = 'ann'", "1,2,4", "full scan on tasks"},
+		{"SELECT * FROM tasks WHERE owner = 'ann' AND completed = true", "2", "full scan on tasks"},
+		// A narrower range implies the wider predicate
+		{"SELECT * FROM tasks WHERE priority > 3 AND completed = false", "4", "index range on tasks.priority (btree, partial)"},
+		{"SELECT * FROM tasks WHERE priority BETWEEN 3 AND 4 AND completed = false", "3,4", "index range on tasks.priority (btree, partial)"},
+		{"SELECT * FROM tasks WHERE priority > 2 AND completed = false", "3,4", "full scan on tasks"},
+		{"SELECT * FROM tasks WHERE priority > 3", "4,5", "full scan on tasks"},
+	}
+	for _, c := range cases {
+		ids, plan := r.query(c.sql)
+		if ids != c.expected || plan != c.plan {
+			t.Errorf("%s: expected [%s] by %q, got [%s] by %q", c.sql, c.expected, c.plan, ids, plan)
+		}
+	}
+
+	// Rows move in and out of the index as they change
+	r.mustExec("UPDATE tasks SET completed = true WHERE id = 1")
+	r.mustExec("UPDATE tasks SET completed = false WHERE id = 2")
+	r.mustExec("INSERT INTO tasks VALUES (6, 'ann', false, 6)")
+	r.mustExec("DELETE FROM tasks WHERE id = 4")
+	if ids, _ := r.query("SELECT * FROM tasks WHERE owner = 'ann' AND completed = false"); ids != "2,6" {
+		t.Errorf("after writes: expected [2,6], got [%s]", ids)
+	}
+
+	// The checkpoint keeps the predicate; an update to a predicate column
+	// after it cannot be replayed from the index alone
+	db := reopen(t, tdb, false)
+	if strings.Join(db.IndexRecovery().Loaded, ",") != "tasks" {
+		t.Errorf("expected tasks loaded from its checkpoint, got %+v", db.IndexRecovery())
+	}
+	r.mustExec("UPDATE tasks SET owner = 'cat' WHERE id = 6")
+	r.mustExec("UPDATE tasks SET completed = true WHERE id = 3")
+	db = reopen(t, tdb, true)
+	if reason := db.IndexRecovery().Rebuilt["tasks"]; !strings.Contains(reason, "predicate of index") {
+		t.Errorf("expected tasks rebuilt after a predicate update, got %+v", db.IndexRecovery())
+	}
+	if ids, _ := r.query("SELECT * FROM tasks WHERE owner = 'cat' AND completed = false"); ids != "6" {
+		t.Errorf("after recovery: expected [6], got [%s]", ids)
+	}
+	if ids, _ := r.query("SELECT * FROM tasks WHERE owner = 'bob' AND completed = false"); ids != "" {
+		t.Errorf("after recovery: expected no open tasks for bob, got [%s]", ids)
+	}
+
+	// Renaming a column rewrites the predicate
+	evolve(t, db, "tasks", schema.ConversionPolicy{}, &schema.RenameColumnOp{OldName: "completed", NewName: "done"})
+	table, _ := db.GetTable("tasks")
+	if idx, _ := table.GetIndex("tasks_urgent"); idx.Where != "priority >= 3 AND done = false" {
+		t.Errorf("expected the predicate to follow the rename, got %q", idx.Where)
+	}
+	if ids, plan := r.query("SELECT * FROM tasks WHERE owner = 'ann' AND done = false"); ids != "2" || !strings.Contains(plan, "partial") {
+		t.Errorf("after rename: expected [2] from the partial index, got [%s] by %q", ids, plan)
+	}
+
+	for _, sql := range []string{
+		"CREATE INDEX ON tasks (owner) WHERE missing = 1",
+		"CREATE INDEX ON tasks (owner) WHERE owner =",
+	} {
+		if _, err := r.exec(sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
+
+func TestPartialUniqueIndex(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+
+	// One open task per owner; completed ones do not count
+	r.mustExec("CREATE TABLE tasks (id INT PRIMARY KEY, owner TEXT, completed BOOL, priority INT)")
+	r.mustExec("INSERT INTO tasks VALUES (1, 'ann', false, 1)")
+	r.mustExec("INSERT INTO tasks VALUES (2, 'ann', true, 1)")
+	r.mustExec("CREATE UNIQUE INDEX tasks_one_open ON tasks (owner) WHERE completed = false")
+
+	r.mustExec("INSERT INTO tasks VALUES (3, 'ann', true, 1)")
+	if _, err := r.exec("INSERT INTO tasks VALUES (4, 'ann', false, 1)"); err == nil || !strings.Contains(err.Error(), "tasks_one_open") {
+		t.Errorf("expected a unique violation for a second open task, got %v", err)
+	}
+	if _, err := r.exec("UPDATE tasks SET completed = false WHERE id = 2"); err == nil {
+		t.Error("expected a unique violation when reopening a task")
+	}
+	r.mustExec("UPDATE tasks SET completed = true WHERE id = 1")
+	r.mustExec("UPDATE tasks SET completed = false WHERE id = 2")
+}
+
+func TestExpressionIndex(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+
+	r.mustExec("CREATE TABLE accounts (id INT PRIMARY KEY, email TEXT, age INT)")
+	r.mustExec("INSERT INTO accounts VALUES (1, 'Ann@Example.com', 30)")
+	r.mustExec("INSERT INTO accounts VALUES (2, 'bob@example.com', 40)")
+
+	msg, err := r.exec("CREATE UNIQUE INDEX ON accounts (lower( email ))")
+	if err != nil {
+		t.Fatalf("create expression index: %v", err)
+	}
+	if msg != "Index 'accounts_lower_email_idx' created on 'accounts'" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+
+	// Case-insensitive uniqueness
+	if _, err := r.exec("INSERT INTO accounts VALUES (3, 'ANN@example.COM', 50)"); err == nil {
+		t.Error("expected a unique violation for an email differing only in case")
+	}
+	if _, err := r.exec("UPDATE accounts SET email = 'BOB@EXAMPLE.COM' WHERE id = 1"); err == nil {
+		t.Error("expected a unique violation when updating to another account's email")
+	}
+	r.mustExec("UPDATE accounts SET email = 'ANN@EXAMPLE.COM' WHERE id = 1")
+
+	cases := []struct {
+		sql, expected, plan string
+	}{
+		{"SELECT * FROM accounts WHERE LOWER(email) = 'ann@example.com'", "1", "index lookup on accounts.LOWER(email) (btree)"},
+		{"SELECT * FROM accounts WHERE lower(email) > 'b'", "2", "index range on accounts.LOWER(email) (btree)"},
+		{"SELECT * FROM accounts WHERE UPPER(email) = 'BOB@EXAMPLE.COM'", "2", "full scan on accounts"},
+		{"SELECT * FROM accounts WHERE email = 'ann@example.com'", "", "full scan on accounts"},
+	}
+	for _, c := range cases {
+		ids, plan := r.query(c.sql)
+		if ids != c.expected || plan != c.plan {
+			t.Errorf("%s: expected [%s] by %q, got [%s] by %q", c.sql, c.expected, c.plan, ids, plan)
+		}
+	}
+
+	// Catching up from the checkpoint applies the expression to updated values
+	reopen(t, tdb, false)
+	r.mustExec("UPDATE accounts SET email = 'Carol@Example.com' WHERE id = 2")
+	db := reopen(t, tdb, true)
+	if len(db.IndexRecovery().Rebuilt) != 0 {
+		t.Errorf("expected accounts caught up from its checkpoint, got %+v", db.IndexRecovery())
+	}
+	if ids, plan := r.query("SELECT * FROM accounts WHERE LOWER(email) = 'carol@example.com'"); ids != "2" || !strings.HasPrefix(plan, "index lookup") {
+		t.Errorf("after recovery: expected [2] by index lookup, got [%s] by %q", ids, plan)
+	}
+
+	for _, sql := range []string{
+		"CREATE INDEX ON accounts (LOWER(age))",
+		"CREATE INDEX ON accounts (TRIM(email))",
+	} {
+		if _, err := r.exec(sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"rdbms/parser"
+	"rdbms/storage"
+	"rdbms/tests"
+)
+
+func TestCostBasedPlanner(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+
+	// kind and region each take 20 values, independently of each other
+	r.mustExec("CREATE TABLE events (id INT PRIMARY KEY, kind INT, region INT, score INT)")
+	for id := 1; id <= 400; id++ {
+		if _, err := tdb.DB.Insert("events", storage.Row{
+			"id": float64(id), "kind": float64(id % 20), "region": float64(id / 20 % 20), "score": float64(id),
+		}); err != nil {
+			t.Fatalf("insert %d: %v", id, err)
+		}
+	}
+	r.mustExec("CREATE INDEX ON events (kind) USING HASH")
+	r.mustExec("CREATE INDEX ON events (region) USING HASH")
+	r.mustExec("CREATE INDEX ON events (score)")
+
+	// Without statistics a range is assumed to keep a third of the rows
+	if _, plan := r.query("SELECT * FROM events WHERE score > 40"); plan != "index range on events.score (btree)" {
+		t.Errorf("before ANALYZE: unexpected plan %q", plan)
+	}
+
+	if msg, err := r.exec("ANALYZE events"); err != nil || msg != "Analyzed 'events'" {
+		t.Fatalf("analyze: %q, %v", msg, err)
+	}
+	stats, ok := tdb.DB.Statistics("events")
+	if !ok || stats.RowCount != 400 || stats.Columns["kind"].Distinct != 20 || len(stats.Columns["score"].Histogram) != 11 {
+		t.Fatalf("unexpected statistics: %+v", stats)
+	}
+
+	cases := []struct {
+		sql, expected, plan string
+	}{
+		// Nine in ten rows: reading them through the index costs more than a scan
+		{"SELECT * FROM events WHERE score > 40 AND kind = 0", "60,80,100,120,140,160,180,200,220,240,260,280,300,320,340,360,380,400", "index lookup on events.kind (hash)"},
+		{"SELECT * FROM events WHERE score > 40", "", "full scan on events"},
+		{"SELECT * FROM events WHERE score > 397", "398,399,400", "index range on events.score (btree)"},
+		{"SELECT * FROM events WHERE score BETWEEN 100 AND 102", "100,101,102", "index range on events.score (btree)"},
+		// Either index alone finds 20 rows; together they find one
+		{"SELECT * FROM events WHERE kind = 3 AND region = 5", "103", "index intersection of events.kind (hash) and events.region (hash)"},
+		{"SELECT * FROM events WHERE region = 5 AND kind = 3 AND score > 100", "103", "index intersection of events.kind (hash) and events.region (hash)"},
+	}
+	for _, c := range cases {
+		ids, plan := r.query(c.sql)
+		if c.expected != "" && ids != c.expected || plan != c.plan {
+			t.Errorf("%s: expected [%s] by %q, got [%s] by %q", c.sql, c.expected, c.plan, ids, plan)
+		}
+	}
+
+	// Writes keep the row count current until the next ANALYZE
+	r.mustExec("INSERT INTO events VALUES (401, 1, 1, 401)")
+	r.mustExec("DELETE FROM events WHERE id = 1")
+	r.mustExec("UPDATE events SET score = 500 WHERE id = 2")
+	if stats, _ := tdb.DB.Statistics("events"); stats.RowCount != 400 || stats.Changes != 3 || stats.Columns["score"].Max != float64(500) {
+		t.Errorf("after writes: unexpected statistics %+v", stats)
+	}
+
+	// Statistics survive a restart
+	db := reopen(t, tdb, false)
+	if stats, ok := db.Statistics("events"); !ok || stats.RowCount != 400 || stats.Columns["region"].Distinct != 20 {
+		t.Errorf("after restart: unexpected statistics %+v", stats)
+	}
+	if _, plan := r.query("SELECT * FROM events WHERE score > 40"); plan != "full scan on events" {
+		t.Errorf("after restart: unexpected plan %q", plan)
+	}
+
+	// Writes the saved statistics missed before a crash are recounted on open
+	r.mustExec("INSERT INTO events VALUES (402, 1, 1, 402)")
+	r.mustExec("INSERT INTO events VALUES (403, 1, 1, 403)")
+	if stats, ok := reopen(t, tdb, true).Statistics("events"); !ok || stats.RowCount != 402 {
+		t.Errorf("after crash: expected 402 rows, got %+v", stats)
+	}
+
+	if msg, err := r.exec("ANALYZE"); err != nil || msg != "Analyzed all tables" {
+		t.Errorf("analyze all: %q, %v", msg, err)
+	}
+	if _, err := r.exec("ANALYZE missing"); err == nil {
+		t.Error("expected an error analyzing a missing table")
+	}
+}
+
+func TestJoinStrategies(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+
+	r.mustExec("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+	r.mustExec("CREATE TABLE orders (id INT PRIMARY KEY, user_id INT, total INT)")
+	for i, name := range []string{"ann", "bob", "cat"} {
+		r.mustExec(fmt.Sprintf("INSERT INTO users VALUES (%d, '%s')", i+1, name))
+	}
+	for i, userID := range []int{2, 1, 2, 3, 2, 1} {
+		r.mustExec(fmt.Sprintf("INSERT INTO orders VALUES (%d, %d, %d)", i+1, userID, 10*(i+1)))
+	}
+	r.mustExec("CREATE INDEX ON orders (user_id)")
+
+	join := func(sql string) (string, string) {
+		t.Helper()
+		stmt, err := r.p.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %s: %v", sql, err)
+		}
+		rows, err := tdb.DB.Join(stmt.TableName, stmt.JoinTable, stmt.JoinCondition, stmt.Where)
+		if err != nil {
+			t.Fatalf("join %s: %v", sql, err)
+		}
+		pairs := make([]string, len(rows))
+		for i, row := range rows {
+			pairs[i] = fmt.Sprintf("%v/%v", row["users.id"], row["orders.id"])
+		}
+		plan, _ := tdb.DB.PlanJoin(stmt.TableName, stmt.JoinTable, stmt.JoinCondition, stmt.Where)
+		return strings.Join(pairs, ","), plan
+	}
+	const all = "SELECT * FROM users JOIN orders ON users.id = orders.user_id"
+	const bob = all + " WHERE users.id = 2"
+
+	cases := []struct {
+		sql, expected, plan string
+	}{
+		// Without statistics both tables are assumed large
+		{all, "1/2,1/6,2/1,2/3,2/5,3/4", "hash join on users.id = orders.user_id, build orders"},
+		// One user's orders are cheapest found through the index
+		{bob, "2/1,2/3,2/5", "index nested loop join on users.id = orders.user_id, probe orders.user_id (btree)"},
+	}
+	for _, c := range cases {
+		if pairs, plan := join(c.sql); pairs != c.expected || plan != c.plan {
+			t.Errorf("%s: expected [%s] by %q, got [%s] by %q", c.sql, c.expected, c.plan, pairs, plan)
+		}
+	}
+
+	// Once ANALYZE has seen how small the tables are, comparing every pair is cheapest
+	r.mustExec("ANALYZE")
+	if pairs, plan := join(all); pairs != cases[0].expected || plan != "nested loop join on users.id = orders.user_id" {
+		t.Errorf("after ANALYZE: expected [%s] by a nested loop, got [%s] by %q", cases[0].expected, pairs, plan)
+	}
+	if pairs, _ := join(bob); pairs != cases[1].expected {
+		t.Errorf("after ANALYZE: expected [%s], got [%s]", cases[1].expected, pairs)
+	}
+}
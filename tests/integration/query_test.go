@@ -0,0 +1,206 @@
+package integration
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"rdbms/parser"
+	"rdbms/tests"
+)
+
+func TestQueryStreamsRows(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+	seedRangeUsers(t, tdb)
+
+	stmt, _ := r.p.Parse("SELECT * FROM users WHERE age > 20")
+	rows, err := tdb.DB.Query(stmt)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	if columns := rows.Columns(); !reflect.DeepEqual(columns, []string{"id", "name", "age"}) {
+		t.Errorf("unexpected columns: %v", columns)
+	}
+	// A full scan reads in row ID order, the order the rows were inserted in
+	for _, id := range []float64{9, 2} {
+		if !rows.Next() || rows.Row()["id"] != id {
+			t.Fatalf("expected row %v, got %v", id, rows.Row())
+		}
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if rows.Next() {
+		t.Error("expected no rows after Close")
+	}
+
+	// Writes do not wait for the query
+	if _, err := tdb.InsertRow("users", userRow(13, "user13", 25)); err != nil {
+		t.Fatalf("insert after close: %v", err)
+	}
+
+	// A LIMIT stops the operators below it once it has enough rows
+	for _, sql := range []string{
+		"SELECT * FROM users LIMIT 3",
+		"SELECT * FROM users WHERE age > 20 LIMIT 3",
+		"SELECT * FROM users ORDER BY id DESC LIMIT 3",
+	} {
+		stmt, _ := r.p.Parse(sql)
+		explanation, err := tdb.DB.Explain(stmt, true)
+		if err != nil {
+			t.Fatalf("explain %s: %v", sql, err)
+		}
+		if access := explanation.Plan.Children[0]; access.ActualRows != 3 {
+			t.Errorf("%s: expected the access path to stop after 3 rows, got %d:\n%s", sql, access.ActualRows, explanation)
+		}
+	}
+
+	out, _ := r.exec("SELECT * FROM users ORDER BY id DESC LIMIT 2")
+	expected := "map[age:25 id:13 name:user13]\nmap[age:22 id:12 name:user12]"
+	if out != expected {
+		t.Errorf("unexpected rows:\n%s\nexpected:\n%s", out, expected)
+	}
+	if out, _ := r.exec("SELECT * FROM users WHERE id > 100"); out != "No rows returned" {
+		t.Errorf("unexpected rows: %s", out)
+	}
+}
+
+func TestQueryProjectionAndAggregates(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+	seedRangeUsers(t, tdb)
+
+	// ORDER BY may name a column the SELECT list leaves out
+	queries := map[string][]string{
+		"SELECT name FROM users WHERE id <= 3 ORDER BY age DESC": {
+			"map[name:user3]",
+			"map[name:user2]",
+			"map[name:user1]",
+		},
+		"SELECT age, COUNT(*), SUM(id), MAX(name) FROM users GROUP BY age ORDER BY age DESC LIMIT 2": {
+			"map[COUNT(*):2 MAX(name):user9 SUM(id):13 age:24]",
+			"map[COUNT(*):2 MAX(name):user8 SUM(id):11 age:23]",
+		},
+		"SELECT COUNT(*), AVG(id) FROM users WHERE age = 22": {
+			"map[AVG(id):7 COUNT(*):3]",
+		},
+		"SELECT COUNT(*), COUNT(name), MIN(age) FROM users WHERE id > 100": {
+			"map[COUNT(*):0 COUNT(name):0 MIN(age):<nil>]",
+		},
+	}
+	for sql, lines := range queries {
+		out, err := r.exec(sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		if expected := strings.Join(lines, "\n"); out != expected {
+			t.Errorf("%s:\n%s\nexpected:\n%s", sql, out, expected)
+		}
+	}
+
+	stmt, _ := r.p.Parse("SELECT age, COUNT(*) FROM users GROUP BY age")
+	rows, err := tdb.DB.Query(stmt)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	all, err := rows.All()
+	if err != nil || len(all) != 5 || !reflect.DeepEqual(rows.Columns(), []string{"age", "COUNT(*)"}) {
+		t.Errorf("unexpected groups: %v, %v", all, err)
+	}
+
+	out, _ := r.exec("EXPLAIN SELECT age, COUNT(*) FROM users GROUP BY age ORDER BY age")
+	if !strings.HasPrefix(out, "Sort by age") || !strings.Contains(out, "-> Group by age") || !strings.Contains(out, "Output: age, COUNT(*)") {
+		t.Errorf("unexpected EXPLAIN:\n%s", out)
+	}
+	out, _ = r.exec("EXPLAIN SELECT name FROM users")
+	if !strings.HasPrefix(out, "Project name") {
+		t.Errorf("unexpected EXPLAIN:\n%s", out)
+	}
+
+	for _, sql := range []string{
+		"SELECT name, COUNT(*) FROM users",
+		"SELECT SUM(name) FROM users",
+		"SELECT * FROM users GROUP BY age",
+		"SELECT missing FROM users",
+		"SELECT age, COUNT(*) FROM users GROUP BY age ORDER BY name",
+	} {
+		if _, err := r.exec(sql); err == nil {
+			t.Errorf("expected error for: %s", sql)
+		}
+	}
+}
+
+func TestQueryReadsStateAsOfOpen(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+
+	r.mustExec("CREATE TABLE items (id INT PRIMARY KEY, rank INT)")
+	for i := 1; i <= 150; i++ {
+		r.mustExec(fmt.Sprintf("INSERT INTO items VALUES (%d, %d)", i, i))
+	}
+	r.mustExec("CREATE INDEX ON items (rank)")
+
+	// The walk spans several batches; writes between rows must not show up
+	// in it, even where they move a row ahead of the walk
+	stmt, _ := r.p.Parse("SELECT * FROM items ORDER BY rank LIMIT 1000")
+	if plan, _ := tdb.DB.PlanSelect("items", nil, stmt.OrderBy, stmt.Limit); !strings.HasPrefix(plan, "index") {
+		t.Fatalf("expected an index walk, got %q", plan)
+	}
+	rows, err := tdb.DB.Query(stmt)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatalf("expected a first row: %v", rows.Err())
+	}
+	r.mustExec("UPDATE items SET rank = 500 WHERE id = 2")
+	r.mustExec("DELETE FROM items WHERE id = 100")
+	r.mustExec("INSERT INTO items VALUES (1000, 50)")
+
+	ranks := []float64{rows.Row()["rank"].(float64)}
+	for rows.Next() {
+		ranks = append(ranks, rows.Row()["rank"].(float64))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	if len(ranks) != 150 {
+		t.Fatalf("expected the 150 rows as of the query, got %d", len(ranks))
+	}
+	for i, rank := range ranks {
+		if rank != float64(i+1) {
+			t.Fatalf("row %d: expected rank %d, got %v", i, i+1, rank)
+		}
+	}
+
+	// An index nested loop join looks its rows up as of the query too
+	r.mustExec("CREATE TABLE orders (id INT PRIMARY KEY, item_id INT)")
+	r.mustExec("CREATE INDEX ON orders (item_id)")
+	r.mustExec("INSERT INTO orders VALUES (1, 3)")
+	r.mustExec("INSERT INTO orders VALUES (2, 3)")
+	stmt, _ = r.p.Parse("SELECT * FROM items JOIN orders ON items.id = orders.item_id WHERE items.id = 3")
+	if plan, _ := tdb.DB.PlanJoin(stmt.TableName, stmt.JoinTable, stmt.JoinCondition, stmt.Where); !strings.HasPrefix(plan, "index nested loop") {
+		t.Fatalf("expected an index nested loop join, got %q", plan)
+	}
+	joined, err := tdb.DB.Query(stmt)
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	defer joined.Close()
+	r.mustExec("DELETE FROM orders WHERE id = 1")
+	r.mustExec("INSERT INTO orders VALUES (3, 3)")
+	var orders []string
+	for joined.Next() {
+		orders = append(orders, fmt.Sprint(joined.Row()["orders.id"]))
+	}
+	if got := strings.Join(orders, ","); got != "1,2" {
+		t.Errorf("expected orders [1,2] as of the join, got [%s]", got)
+	}
+}
@@ -225,9 +225,9 @@ func TestReplayEventsDeterministic(t *testing.T) {
 
 	// Replay deterministically
 	opts := &storage.DeterministicReplayOptions{
-		TargetSchemaVersion: 1,
-		SkipCorrupted:       false,
-		CollectErrors:       true,
+		TargetSchemaVersions: storage.SchemaTargets{"users": 1},
+		SkipCorrupted:        false,
+		CollectErrors:        true,
 	}
 
 	result := storage.ReplayEventsDeterministic(events, opts, nil)
@@ -340,9 +340,9 @@ func TestReplayWithCorruptedEvents(t *testing.T) {
 
 	// Replay with partial recovery
 	opts := &storage.DeterministicReplayOptions{
-		TargetSchemaVersion: 1,
-		SkipCorrupted:       true, // Skip corrupted events
-		CollectErrors:       true,
+		TargetSchemaVersions: storage.SchemaTargets{"users": 1},
+		SkipCorrupted:        true, // Skip corrupted events
+		CollectErrors:        true,
 	}
 
 	result := storage.ReplayEventsDeterministic(events, opts, nil)
@@ -481,9 +481,9 @@ func TestDeterministicReplayAcrossVersions(t *testing.T) {
 
 	// Replay deterministically targeting v2
 	opts := &storage.DeterministicReplayOptions{
-		TargetSchemaVersion: 2,
-		SkipCorrupted:       false,
-		CollectErrors:       true,
+		TargetSchemaVersions: storage.SchemaTargets{"users": 2},
+		SkipCorrupted:        false,
+		CollectErrors:        true,
 	}
 
 	result := storage.ReplayEventsDeterministic(events, opts, nil)
@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"strings"
 	"testing"
 
 	"rdbms/schema"
@@ -241,6 +242,16 @@ func TestCompatibilityCheck(t *testing.T) {
 		t.Errorf("Expected compatible status for same version, got %s", check.Status)
 	}
 
+	// Multi-step paths need every step of the table's own chain
+	sr.RegisterSchema("users", 3, v2Cols)
+	if check = sr.CheckCompatibility("users", 1, 3); check.Status != schema.Incompatible {
+		t.Errorf("Expected incompatible status with a missing v2->v3 step, got %s", check.Status)
+	}
+	sr.RegisterMigration("users", 2, 3, []schema.MigrationOp{})
+	if check = sr.CheckCompatibility("users", 1, 3); check.Status != schema.MigrationNeeded {
+		t.Errorf("Expected migration-needed status across two steps, got %s: %s", check.Status, check.Message)
+	}
+
 	t.Log("✓ Compatibility check test passed")
 }
 
@@ -293,7 +304,7 @@ func TestBackwardMigrationFails(t *testing.T) {
 
 	row := map[string]interface{}{"id": int64(1), "name": "Alice"}
 
-	// Try to migrate backwards (should fail)
+	// Try to migrate backwards without a migration to invert (should fail)
 	_, err := sr.MigrateRow("users", row, 2, 1)
 	if err == nil {
 		t.Error("Backward migration should fail")
@@ -301,3 +312,165 @@ func TestBackwardMigrationFails(t *testing.T) {
 
 	t.Log("✓ Backward migration rejection test passed")
 }
+
+func TestBackwardMigrationDerivesInverse(t *testing.T) {
+	sr := schema.NewSchemaRegistry()
+
+	v1Cols := []schema.Column{{Name: "id", Type: schema.TypeInt, PrimaryKey: true}, {Name: "nick", Type: schema.TypeText}, {Name: "legacy", Type: schema.TypeText}}
+	v2Cols := []schema.Column{{Name: "id", Type: schema.TypeInt, PrimaryKey: true}, {Name: "name", Type: schema.TypeText}, {Name: "email", Type: schema.TypeText}}
+	sr.RegisterSchema("users", 1, v1Cols)
+	sr.RegisterSchema("users", 2, v2Cols)
+	sr.RegisterMigration("users", 1, 2, []schema.MigrationOp{
+		&schema.RenameColumnOp{OldName: "nick", NewName: "name"},
+		&schema.AddColumnOp{Column: schema.Column{Name: "email", Type: schema.TypeText}, Default: "none"},
+		&schema.RemoveColumnOp{ColumnName: "legacy", Default: "restored"},
+	})
+
+	row := map[string]interface{}{"id": float64(1), "name": "Alice", "email": "alice@example.com"}
+	down, err := sr.MigrateRow("users", row, 2, 1)
+	if err != nil {
+		t.Fatalf("backward migration: %v", err)
+	}
+	if down["nick"] != "Alice" || down["legacy"] != "restored" {
+		t.Errorf("expected rename and removal undone, got %v", down)
+	}
+	if _, exists := down["email"]; exists {
+		t.Errorf("expected added column to be dropped, got %v", down)
+	}
+
+	// Round trip forwards again
+	up, err := sr.MigrateRow("users", down, 1, 2)
+	if err != nil || up["name"] != "Alice" {
+		t.Errorf("expected round trip to restore name, got %v (%v)", up, err)
+	}
+
+	if check := sr.CheckCompatibility("users", 2, 1); check.Status != schema.MigrationNeeded {
+		t.Errorf("expected backward path to be available, got %s: %s", check.Status, check.Message)
+	}
+}
+
+func TestBackwardMigrationExplicitDown(t *testing.T) {
+	sr := schema.NewSchemaRegistry()
+	sr.RegisterSchema("users", 1, []schema.Column{{Name: "id", Type: schema.TypeInt, PrimaryKey: true}})
+	sr.RegisterSchema("users", 2, []schema.Column{{Name: "id", Type: schema.TypeInt, PrimaryKey: true}, {Name: "tier", Type: schema.TypeText}})
+
+	// An operation without a derivable inverse makes the migration one-way
+	sr.RegisterMigration("users", 1, 2, []schema.MigrationOp{"backfill tiers from billing"})
+	if _, err := sr.MigrateRow("users", map[string]interface{}{"id": float64(1)}, 2, 1); err == nil {
+		t.Error("expected migration without an inverse to be irreversible")
+	}
+	if check := sr.CheckCompatibility("users", 2, 1); check.Status != schema.Incompatible {
+		t.Errorf("expected incompatible status, got %s", check.Status)
+	}
+
+	// Supplying down operations makes it reversible
+	sr.RegisterReversibleMigration("users", 1, 2,
+		[]schema.MigrationOp{&schema.AddColumnOp{Column: schema.Column{Name: "tier", Type: schema.TypeText}, Default: "free"}},
+		[]schema.MigrationOp{&schema.RemoveColumnOp{ColumnName: "tier"}})
+	row, err := sr.MigrateRow("users", map[string]interface{}{"id": float64(1), "tier": "gold"}, 2, 1)
+	if err != nil {
+		t.Fatalf("backward migration: %v", err)
+	}
+	if _, exists := row["tier"]; exists {
+		t.Errorf("expected tier to be removed, got %v", row)
+	}
+}
+
+func TestCompatibilityReport(t *testing.T) {
+	sr := schema.NewSchemaRegistry()
+	sr.RegisterSchema("users", 1, []schema.Column{
+		{Name: "id", Type: schema.TypeInt, PrimaryKey: true},
+		{Name: "nick", Type: schema.TypeText},
+		{Name: "age", Type: schema.TypeText},
+		{Name: "legacy", Type: schema.TypeText},
+		{Name: "score", Type: schema.TypeInt},
+	})
+	sr.RegisterSchema("users", 2, []schema.Column{
+		{Name: "id", Type: schema.TypeInt, PrimaryKey: true},
+		{Name: "name", Type: schema.TypeText},
+		{Name: "age", Type: schema.TypeInt},
+		{Name: "score", Type: schema.TypeText, Unique: true},
+		{Name: "email", Type: schema.TypeText, NotNull: true},
+	})
+	sr.RegisterMigration("users", 1, 2, []schema.MigrationOp{
+		&schema.RenameColumnOp{OldName: "nick", NewName: "name"},
+		&schema.RemoveColumnOp{ColumnName: "legacy"},
+		&schema.AddColumnOp{Column: schema.Column{Name: "email", Type: schema.TypeText, NotNull: true}},
+	})
+
+	check := sr.CheckCompatibility("users", 1, 2)
+	if check.Status != schema.MigrationNeeded || check.Report == nil {
+		t.Fatalf("expected changes to be reported but not enforced, got %s: %s", check.Status, check.Message)
+	}
+
+	kinds := make(map[schema.ChangeKind]string)
+	for _, c := range check.Report.Changes {
+		kinds[c.Kind] = c.Column
+	}
+	expected := map[schema.ChangeKind]string{
+		schema.ColumnRenamed: "nick",
+		schema.TypeNarrowed:  "age",
+		schema.ColumnRemoved: "legacy",
+		schema.TypeWidened:   "score",
+		schema.UniqueAdded:   "score",
+		schema.ColumnAdded:   "email",
+	}
+	for kind, column := range expected {
+		if kinds[kind] != column {
+			t.Errorf("expected %s on %s, got changes %v", kind, column, kinds)
+		}
+	}
+	if len(check.Report.Changes) != len(expected) {
+		t.Errorf("expected %d changes, got %+v", len(expected), check.Report.Changes)
+	}
+
+	// Narrowing, a new unique constraint and a NOT NULL column without a default
+	// break backward; widening and removing a column without a default break forward
+	if n := len(check.Report.Breaking(schema.CompatibilityBackward)); n != 3 {
+		t.Errorf("expected 3 backward-breaking changes, got %d", n)
+	}
+	if n := len(check.Report.Breaking(schema.CompatibilityForward)); n != 2 {
+		t.Errorf("expected 2 forward-breaking changes, got %d", n)
+	}
+	if check.Report.Level() != schema.CompatibilityNone {
+		t.Errorf("expected no compatibility, got %s", check.Report.Level())
+	}
+
+	sr.SetCompatibilityMode(schema.CompatibilityBackward)
+	if check = sr.CheckCompatibility("users", 1, 2); check.Status != schema.Incompatible || !strings.Contains(check.Message, "email") {
+		t.Errorf("expected backward mode to refuse the change, got %s: %s", check.Status, check.Message)
+	}
+}
+
+func TestCompatibilityLevels(t *testing.T) {
+	v1 := []schema.Column{{Name: "id", Type: schema.TypeInt, PrimaryKey: true}, {Name: "tier", Type: schema.TypeText}}
+
+	// A nullable column added, or one removed with a default, reads both ways
+	full := schema.CompareColumns(v1, append(v1[:1:1], schema.Column{Name: "tier", Type: schema.TypeText}, schema.Column{Name: "note", Type: schema.TypeText}), nil)
+	if full.Level() != schema.CompatibilityFull {
+		t.Errorf("expected FULL, got %s: %+v", full.Level(), full.Changes)
+	}
+
+	sr := schema.NewSchemaRegistry()
+	sr.RegisterSchema("users", 1, v1)
+	sr.RegisterSchema("users", 2, v1[:1])
+	sr.RegisterMigration("users", 1, 2, []schema.MigrationOp{&schema.RemoveColumnOp{ColumnName: "tier", Default: "free"}})
+	sr.SetCompatibilityMode(schema.CompatibilityFull)
+	if check := sr.CheckCompatibility("users", 1, 2); check.Status != schema.MigrationNeeded || check.Report.Level() != schema.CompatibilityFull {
+		t.Errorf("expected a removal with a default to be fully compatible, got %s: %s", check.Status, check.Message)
+	}
+
+	// Going down, the removal is an addition, judged by the same default
+	if check := sr.CheckCompatibility("users", 2, 1); check.Report == nil || check.Report.Changes[0].Kind != schema.ColumnAdded {
+		t.Errorf("expected the down path to add tier, got %+v", check.Report)
+	}
+
+	// Tightening a column lets old readers read new rows, not the other way round
+	notNull := schema.CompareColumns(v1, []schema.Column{v1[0], {Name: "tier", Type: schema.TypeText, NotNull: true}}, nil)
+	if notNull.Level() != schema.CompatibilityForward {
+		t.Errorf("expected NOT NULL on an existing column to be FORWARD only, got %s", notNull.Level())
+	}
+	if pk := schema.CompareColumns(v1, []schema.Column{{Name: "id", Type: schema.TypeInt}, v1[1]}, nil); pk.Level() != schema.CompatibilityNone {
+		t.Errorf("expected a primary key change to break both ways, got %s", pk.Level())
+	}
+}
@@ -0,0 +1,252 @@
+package integration
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"rdbms/storage"
+)
+
+// largeState builds a state with n rows in a "users" table
+func largeState(n int) *storage.DerivedState {
+	rows := make(map[int64]storage.Row, n)
+	for i := 1; i <= n; i++ {
+		rows[int64(i)] = storage.Row{"id": float64(i), "name": fmt.Sprintf("user-%d", i)}
+	}
+	return &storage.DerivedState{
+		Tables:      map[string]map[int64]storage.Row{"users": rows},
+		DeletedRows: map[string]map[int64]bool{"users": {}},
+	}
+}
+
+// rewriteSnapshot decompresses a snapshot file, applies fn, and compresses it again
+func rewriteSnapshot(t *testing.T, path string, fn func(string) string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open snapshot: %v", err)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gunzip snapshot: %v", err)
+	}
+	data, err := io.ReadAll(gz)
+	f.Close()
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(fn(string(data))))
+	w.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+}
+
+// cloneState deep-copies a state so snapshots can be taken of successive versions
+func cloneState(s *storage.DerivedState) *storage.DerivedState {
+	c := &storage.DerivedState{
+		Tables:      make(map[string]map[int64]storage.Row),
+		DeletedRows: make(map[string]map[int64]bool),
+	}
+	for tbl, rows := range s.Tables {
+		c.Tables[tbl] = make(map[int64]storage.Row)
+		for id, row := range rows {
+			r := make(storage.Row)
+			for k, v := range row {
+				r[k] = v
+			}
+			c.Tables[tbl][id] = r
+		}
+	}
+	for tbl, del := range s.DeletedRows {
+		c.DeletedRows[tbl] = make(map[int64]bool)
+		for id := range del {
+			c.DeletedRows[tbl][id] = true
+		}
+	}
+	return c
+}
+
+func TestDeltaSnapshotRoundTrip(t *testing.T) {
+	sm, _ := storage.NewSnapshotManager(t.TempDir())
+
+	base := largeState(200)
+	base.DeletedRows["users"][7] = true
+	full, err := sm.CreateSnapshot(base, 10, 10)
+	if err != nil {
+		t.Fatalf("full snapshot: %v", err)
+	}
+	if full.Kind != storage.SnapshotFull {
+		t.Fatalf("expected first snapshot to be full, got %q", full.Kind)
+	}
+
+	next := cloneState(base)
+	next.Tables["users"][1]["name"] = "renamed"
+	next.DeletedRows["users"][2] = true
+	delete(next.DeletedRows["users"], 7)
+	next.Tables["orders"] = map[int64]storage.Row{}
+	next.DeletedRows["orders"] = map[int64]bool{}
+
+	delta, err := sm.CreateSnapshot(next, 20, 20)
+	if err != nil {
+		t.Fatalf("delta snapshot: %v", err)
+	}
+	if delta.Kind != storage.SnapshotDelta || delta.ParentID != full.SnapshotID || delta.ChainDepth != 1 {
+		t.Fatalf("expected delta on %s, got %+v", full.SnapshotID, delta)
+	}
+
+	if delta.UncompressedSize*10 > full.UncompressedSize {
+		t.Errorf("delta (%d bytes) should be much smaller than full (%d bytes)", delta.UncompressedSize, full.UncompressedSize)
+	}
+
+	restored, _, err := sm.RestoreFromSnapshot(delta.SnapshotID)
+	if err != nil {
+		t.Fatalf("restore delta: %v", err)
+	}
+	if row, ok := restored.GetRow("users", 1); !ok || row["name"] != "renamed" {
+		t.Errorf("updated row not restored: %v", row)
+	}
+	if _, ok := restored.GetRow("users", 2); ok {
+		t.Error("row 2 should be deleted")
+	}
+	if _, ok := restored.GetRow("users", 7); !ok {
+		t.Error("row 7 should no longer be deleted")
+	}
+	if _, ok := restored.Tables["orders"]; !ok {
+		t.Error("new empty table not restored")
+	}
+	if n := len(restored.GetTableRows("users")); n != 199 {
+		t.Errorf("expected 199 live rows, got %d", n)
+	}
+}
+
+func TestDeltaSnapshotRecordsRemovals(t *testing.T) {
+	sm, _ := storage.NewSnapshotManager(t.TempDir())
+
+	base := largeState(5)
+	base.DeletedRows["users"][2] = true
+	base.Tables["orders"] = map[int64]storage.Row{1: {"id": float64(1)}}
+	base.DeletedRows["orders"] = map[int64]bool{}
+	if _, err := sm.CreateSnapshot(base, 10, 10); err != nil {
+		t.Fatalf("full snapshot: %v", err)
+	}
+
+	// Deleted row 2 and live row 3 vanish entirely, as they do after compaction
+	next := cloneState(base)
+	delete(next.Tables["users"], 2)
+	delete(next.DeletedRows["users"], 2)
+	delete(next.Tables["users"], 3)
+	delete(next.Tables, "orders")
+	delete(next.DeletedRows, "orders")
+
+	delta, err := sm.CreateSnapshot(next, 20, 20)
+	if err != nil {
+		t.Fatalf("delta snapshot: %v", err)
+	}
+	if delta.Kind != storage.SnapshotDelta {
+		t.Fatalf("expected a delta, got %q", delta.Kind)
+	}
+
+	restored, _, err := sm.RestoreFromSnapshot(delta.SnapshotID)
+	if err != nil {
+		t.Fatalf("restore delta: %v", err)
+	}
+	for _, id := range []int64{2, 3} {
+		if _, ok := restored.Tables["users"][id]; ok {
+			t.Errorf("row %d should be gone", id)
+		}
+	}
+	if n := len(restored.GetTableRows("users")); n != 3 {
+		t.Errorf("expected 3 live rows, got %d", n)
+	}
+	if _, ok := restored.Tables["orders"]; ok {
+		t.Error("dropped table should be gone")
+	}
+}
+
+func TestDeltaSnapshotChainDepthLimit(t *testing.T) {
+	sm, _ := storage.NewSnapshotManager(t.TempDir())
+	sm.SetMaxChainDepth(2)
+
+	state := largeState(10)
+	var kinds []string
+	for i := 1; i <= 4; i++ {
+		state = cloneState(state)
+		state.Tables["users"][int64(i)]["name"] = fmt.Sprintf("v%d", i)
+		meta, err := sm.CreateSnapshot(state, uint64(i*10), int64(i*10))
+		if err != nil {
+			t.Fatalf("snapshot %d: %v", i, err)
+		}
+		kinds = append(kinds, meta.Kind)
+	}
+
+	expected := []string{storage.SnapshotFull, storage.SnapshotDelta, storage.SnapshotDelta, storage.SnapshotFull}
+	if strings.Join(kinds, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected kinds %v, got %v", expected, kinds)
+	}
+}
+
+func TestDeltaSnapshotDetectsCorruptLink(t *testing.T) {
+	sm, _ := storage.NewSnapshotManager(t.TempDir())
+
+	base := largeState(5)
+	full, _ := sm.CreateSnapshot(base, 10, 10)
+	next := cloneState(base)
+	next.Tables["users"][3]["name"] = "changed"
+	delta, err := sm.CreateSnapshot(next, 20, 20)
+	if err != nil {
+		t.Fatalf("delta snapshot: %v", err)
+	}
+
+	// Tamper with the parent: the delta itself is intact but its chain is not
+	rewriteSnapshot(t, full.SnapshotPath, func(s string) string {
+		return strings.Replace(s, "user-5", "user-X", 1)
+	})
+
+	if _, _, err := sm.RestoreFromSnapshot(delta.SnapshotID); err == nil || !strings.Contains(err.Error(), full.SnapshotID) {
+		t.Errorf("expected hash mismatch on parent %s, got %v", full.SnapshotID, err)
+	}
+}
+
+func TestDeltaSnapshotPruningRebasesChain(t *testing.T) {
+	sm, _ := storage.NewSnapshotManager(t.TempDir())
+
+	state := largeState(10)
+	for i := 1; i <= 4; i++ {
+		state = cloneState(state)
+		state.Tables["users"][int64(i)]["name"] = fmt.Sprintf("v%d", i)
+		if _, err := sm.CreateSnapshot(state, uint64(i*10), int64(i*10)); err != nil {
+			t.Fatalf("snapshot %d: %v", i, err)
+		}
+	}
+
+	if err := sm.PruneOldSnapshots(2); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+
+	history := sm.GetSnapshotHistory()
+	if len(history) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(history))
+	}
+	if history[0].Kind != storage.SnapshotFull || history[1].ChainDepth != 1 {
+		t.Errorf("expected a full snapshot followed by a depth-1 delta, got %+v", history)
+	}
+
+	restored, _, err := sm.RestoreLatestSnapshot()
+	if err != nil {
+		t.Fatalf("restore after prune: %v", err)
+	}
+	for i := 1; i <= 4; i++ {
+		if row, _ := restored.GetRow("users", int64(i)); row["name"] != fmt.Sprintf("v%d", i) {
+			t.Errorf("row %d: expected v%d, got %v", i, i, row["name"])
+		}
+	}
+}
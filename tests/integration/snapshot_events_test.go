@@ -0,0 +1,110 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"rdbms/eventlog"
+	"rdbms/storage"
+)
+
+// snapshotFixture opens an event store with a few rows and an attached snapshot manager
+func snapshotFixture(t *testing.T, dir string) (*storage.EventStore, *storage.SnapshotManager, *storage.QueryEngine) {
+	t.Helper()
+	es, err := storage.NewEventStore(dir)
+	if err != nil {
+		t.Fatalf("open event store: %v", err)
+	}
+	sm, err := storage.NewSnapshotManager(dir)
+	if err != nil {
+		t.Fatalf("open snapshot manager: %v", err)
+	}
+	sm.SetEventStore(es)
+	return es, sm, storage.NewQueryEngine(es, sm)
+}
+
+func takeSnapshot(t *testing.T, es *storage.EventStore, qe *storage.QueryEngine, sm *storage.SnapshotManager) *storage.SnapshotMeta {
+	t.Helper()
+	state, eventID, err := qe.PinnedState()
+	if err != nil {
+		t.Fatalf("pin state: %v", err)
+	}
+	meta, err := sm.CreateSnapshot(state, eventID, int64(eventID))
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+	return meta
+}
+
+func TestSnapshotCreatedEventRecorded(t *testing.T) {
+	dir := t.TempDir()
+	es, sm, qe := snapshotFixture(t, dir)
+	defer es.Close()
+
+	es.RecordSchemaCreated("ledger", []eventlog.ColumnDefinition{{Name: "id", Type: "INT", PrimaryKey: true}}, "id", "tx-1")
+	es.RecordRowInserted("ledger", 1, storage.Row{"id": float64(1)}, "tx-2")
+	before, _ := qe.GetCurrentState()
+
+	meta := takeSnapshot(t, es, qe, sm)
+
+	events, err := es.ReadAllEvents()
+	if err != nil {
+		t.Fatalf("read events: %v", err)
+	}
+	last := events[len(events)-1]
+	if last.Type != eventlog.SnapshotCreated || last.ID != meta.RecordedAt {
+		t.Fatalf("expected SNAPSHOT_CREATED as event %d, got %s at %d", meta.RecordedAt, last.Type, last.ID)
+	}
+	payload := last.Payload.(map[string]interface{})
+	if payload["snapshot_id"] != meta.SnapshotID || payload["data_hash"] != meta.DataHash {
+		t.Errorf("payload does not describe the snapshot: %v", payload)
+	}
+
+	// Every replay path ignores the bookkeeping event
+	replayed, _ := storage.ReplayEvents(events)
+	if len(replayed.GetTableRows("ledger")) != len(before.GetTableRows("ledger")) {
+		t.Error("ReplayEvents changed state on SNAPSHOT_CREATED")
+	}
+	result := storage.ReplayEventsDeterministic(events, &storage.DeterministicReplayOptions{SkipCorrupted: true}, nil)
+	if result.ErrorsEncountered != 0 || len(result.State.GetTableRows("ledger")) != 1 {
+		t.Errorf("deterministic replay mishandled SNAPSHOT_CREATED: %+v", result.CorruptionReport.Issues)
+	}
+	current, _ := qe.GetCurrentState()
+	if len(current.GetTableRows("ledger")) != 1 {
+		t.Error("query engine state changed after snapshot event")
+	}
+}
+
+func TestSnapshotIndexRebuiltFromLog(t *testing.T) {
+	dir := t.TempDir()
+	es, sm, qe := snapshotFixture(t, dir)
+
+	es.RecordSchemaCreated("ledger", []eventlog.ColumnDefinition{{Name: "id", Type: "INT", PrimaryKey: true}}, "id", "tx-1")
+	es.RecordRowInserted("ledger", 1, storage.Row{"id": float64(1)}, "tx-2")
+	first := takeSnapshot(t, es, qe, sm)
+	es.RecordRowInserted("ledger", 2, storage.Row{"id": float64(2)}, "tx-3")
+	second := takeSnapshot(t, es, qe, sm)
+	es.Close()
+
+	// Lose the index entirely
+	os.Remove(filepath.Join(dir, "snapshots", "index.json"))
+
+	es, sm, _ = snapshotFixture(t, dir)
+	history := sm.GetSnapshotHistory()
+	if len(history) != 2 || history[0].SnapshotID != first.SnapshotID || history[1].SnapshotID != second.SnapshotID {
+		t.Fatalf("index not rebuilt from log: %+v", history)
+	}
+	if _, _, err := sm.RestoreLatestSnapshot(); err != nil {
+		t.Errorf("restore from rebuilt index: %v", err)
+	}
+	es.Close()
+
+	// A snapshot whose file is gone is dropped, along with deltas built on it
+	os.Remove(first.SnapshotPath)
+	es, sm, _ = snapshotFixture(t, dir)
+	defer es.Close()
+	if n := len(sm.GetSnapshotHistory()); n != 0 {
+		t.Errorf("expected missing snapshot and its delta to be dropped, got %d entries", n)
+	}
+}
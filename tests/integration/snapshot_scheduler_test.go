@@ -0,0 +1,116 @@
+package integration
+
+import (
+	"testing"
+
+	"rdbms/storage"
+	"rdbms/tests"
+)
+
+// TestSnapshotSchedulerEventCountPolicy verifies that any kind of event counts toward the policy
+func TestSnapshotSchedulerEventCountPolicy(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	scheduler := tdb.DB.SnapshotScheduler()
+	scheduler.SetPolicy(storage.SnapshotPolicy{EveryNEvents: 5}, storage.RetentionPolicy{})
+
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for i, name := range []string{"Alice", "Bob"} {
+		if _, err := tdb.InsertRow("users", userRow(i+1, name, 30)); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if due, _ := scheduler.Due(); due {
+		t.Fatal("snapshot should not be due after 3 events")
+	}
+
+	// Updates and deletes trigger snapshots too, not just inserts
+	if _, err := tdb.UpdateRows("users", map[string]interface{}{"age": float64(40)}, map[string]interface{}{"name": "Alice"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := tdb.DeleteRows("users", map[string]interface{}{"name": "Bob"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	lastEventID := tdb.DB.GetEventStore().GetLastEventID()
+	meta, err := scheduler.RunOnce()
+	if err != nil {
+		t.Fatalf("run scheduler: %v", err)
+	}
+	if meta == nil {
+		t.Fatal("expected a snapshot after 5 events")
+	}
+
+	// The snapshot is labelled with the event its state was built from
+	if meta.BaseEventID != lastEventID {
+		t.Errorf("expected snapshot at event %d, got %d", lastEventID, meta.BaseEventID)
+	}
+
+	sm, err := storage.NewSnapshotManager(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("open snapshot manager: %v", err)
+	}
+	state, _, err := sm.RestoreFromSnapshot(meta.SnapshotID)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	rows := state.GetTableRows("users")
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 live row in snapshot, got %d", len(rows))
+	}
+	if age, _ := rows[0].Row["age"].(float64); age != 40 {
+		t.Errorf("expected snapshot to include the update, got age %v", rows[0].Row["age"])
+	}
+
+	if meta, _ := scheduler.RunOnce(); meta != nil {
+		t.Error("expected no snapshot without new events")
+	}
+}
+
+// TestSnapshotSchedulerRetention verifies that pruning runs after each snapshot
+func TestSnapshotSchedulerRetention(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+
+	scheduler := tdb.DB.SnapshotScheduler()
+	scheduler.SetPolicy(storage.SnapshotPolicy{EveryNEvents: 1}, storage.RetentionPolicy{KeepLast: 2})
+
+	if err := tdb.CreateTable("users", tests.SampleTableColumns(), "id"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	var last *storage.SnapshotMeta
+	for i := 1; i <= 4; i++ {
+		if _, err := tdb.InsertRow("users", userRow(i, "user", 20+i)); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		meta, err := scheduler.RunOnce()
+		if err != nil {
+			t.Fatalf("run scheduler: %v", err)
+		}
+		last = meta
+	}
+
+	sm, err := storage.NewSnapshotManager(tdb.DataDir)
+	if err != nil {
+		t.Fatalf("open snapshot manager: %v", err)
+	}
+	history := sm.GetSnapshotHistory()
+	if len(history) != 2 {
+		t.Fatalf("expected 2 snapshots after retention, got %d", len(history))
+	}
+	if history[1].SnapshotID != last.SnapshotID {
+		t.Errorf("expected the latest snapshot to be kept, got base event %d", history[1].BaseEventID)
+	}
+
+	// All snapshots were taken today, so keep-daily alone keeps only the newest
+	removed, err := sm.ApplyRetention(storage.RetentionPolicy{KeepDaily: 3})
+	if err != nil {
+		t.Fatalf("apply retention: %v", err)
+	}
+	if removed != 1 || len(sm.GetSnapshotHistory()) != 1 {
+		t.Errorf("expected keep-daily to remove 1 snapshot, removed %d", removed)
+	}
+}
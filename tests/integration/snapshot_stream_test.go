@@ -0,0 +1,99 @@
+package integration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rdbms/storage"
+)
+
+func TestStreamingSnapshotMetadata(t *testing.T) {
+	sm, _ := storage.NewSnapshotManager(t.TempDir())
+
+	meta, err := sm.CreateSnapshot(largeState(500), 10, 10)
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+
+	if meta.Format != storage.SnapshotFormatStream || meta.Compression != storage.CompressionGzip {
+		t.Errorf("expected gzip stream-v1, got %s/%s", meta.Format, meta.Compression)
+	}
+	info, err := os.Stat(meta.SnapshotPath)
+	if err != nil {
+		t.Fatalf("stat snapshot: %v", err)
+	}
+	if meta.CompressedSize != info.Size() {
+		t.Errorf("compressed size %d does not match file size %d", meta.CompressedSize, info.Size())
+	}
+	if meta.UncompressedSize <= meta.CompressedSize {
+		t.Errorf("expected compression, got %d -> %d bytes", meta.UncompressedSize, meta.CompressedSize)
+	}
+
+	restored, _, err := sm.RestoreFromSnapshot(meta.SnapshotID)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if n := len(restored.GetTableRows("users")); n != 500 {
+		t.Errorf("expected 500 rows, got %d", n)
+	}
+}
+
+func TestStreamingSnapshotDetectsTruncation(t *testing.T) {
+	sm, _ := storage.NewSnapshotManager(t.TempDir())
+
+	meta, err := sm.CreateSnapshot(largeState(20), 10, 10)
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+
+	// Drop the trailer record
+	rewriteSnapshot(t, meta.SnapshotPath, func(s string) string {
+		lines := strings.SplitAfter(strings.TrimSuffix(s, "\n"), "\n")
+		return strings.Join(lines[:len(lines)-1], "")
+	})
+
+	if _, _, err := sm.RestoreFromSnapshot(meta.SnapshotID); err == nil || !strings.Contains(err.Error(), "trailer") {
+		t.Errorf("expected missing trailer error, got %v", err)
+	}
+}
+
+func TestLegacyJSONSnapshotStillRestores(t *testing.T) {
+	dir := t.TempDir()
+	snapDir := filepath.Join(dir, "snapshots")
+	os.MkdirAll(snapDir, 0755)
+
+	state := largeState(3)
+	stateJSON, _ := json.Marshal(state)
+	sum := sha256.Sum256(stateJSON)
+
+	meta := storage.SnapshotMeta{
+		SnapshotID:     "snap_legacy",
+		BaseEventID:    5,
+		CreatedAt:      time.Now().UTC(),
+		SnapshotPath:   filepath.Join(snapDir, "snap_legacy.json"),
+		DataHash:       hex.EncodeToString(sum[:]),
+		EventsIncluded: 5,
+	}
+	data, _ := json.MarshalIndent(storage.SnapshotData{Meta: meta, Tables: state.Tables, DeletedRows: state.DeletedRows}, "", "  ")
+	os.WriteFile(meta.SnapshotPath, data, 0644)
+	index, _ := json.Marshal([]storage.SnapshotMeta{meta})
+	os.WriteFile(filepath.Join(snapDir, "index.json"), index, 0644)
+
+	sm, err := storage.NewSnapshotManager(dir)
+	if err != nil {
+		t.Fatalf("open snapshot manager: %v", err)
+	}
+	restored, _, err := sm.RestoreLatestSnapshot()
+	if err != nil {
+		t.Fatalf("restore legacy snapshot: %v", err)
+	}
+	if n := len(restored.GetTableRows("users")); n != 3 {
+		t.Errorf("expected 3 rows, got %d", n)
+	}
+}
@@ -0,0 +1,202 @@
+package integration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"rdbms/database"
+	"rdbms/parser"
+	"rdbms/tests"
+)
+
+// spilledFiles sums the spill files of a plan's nodes
+func spilledFiles(node *database.PlanNode) int {
+	files := node.SpillFiles
+	for _, child := range node.Children {
+		files += spilledFiles(child)
+	}
+	return files
+}
+
+// assertNoSpillFiles checks that no spill file outlived its query
+func assertNoSpillFiles(t *testing.T, tdb *tests.TestDB) {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(tdb.DataDir, "spill", "*"))
+	if len(files) > 0 {
+		t.Errorf("expected no spill files, found %v", files)
+	}
+}
+
+func TestQuerySpillsOverMemoryBudget(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+	seedRangeUsers(t, tdb)
+
+	queries := []string{
+		"SELECT * FROM users ORDER BY name DESC",
+		"SELECT * FROM users ORDER BY age LIMIT 5",
+		"SELECT age, COUNT(*), SUM(id), MIN(name), MAX(name), AVG(id) FROM users GROUP BY age",
+		"SELECT COUNT(*), MAX(age) FROM users",
+	}
+	inMemory := make(map[string]string)
+	for _, sql := range queries {
+		out, err := r.exec(sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		inMemory[sql] = out
+	}
+
+	tdb.DB.SetQueryMemory(1)
+	if memory := tdb.DB.QueryMemory(); memory != 1 {
+		t.Fatalf("expected a 1 byte budget, got %d", memory)
+	}
+	for _, sql := range queries {
+		out, err := r.exec(sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		expected := inMemory[sql]
+		if strings.Contains(sql, "GROUP BY") {
+			// Spilled groups come out partition by partition
+			out, expected = sortedLines(out), sortedLines(expected)
+		}
+		if out != expected {
+			t.Errorf("%s spilled:\n%s\nexpected:\n%s", sql, out, expected)
+		}
+		assertNoSpillFiles(t, tdb)
+	}
+
+	for _, sql := range []string{"SELECT * FROM users ORDER BY name", queries[2]} {
+		stmt, _ := r.p.Parse(sql)
+		explanation, err := tdb.DB.Explain(stmt, true)
+		if err != nil {
+			t.Fatalf("explain %s: %v", sql, err)
+		}
+		if spilledFiles(explanation.Plan) == 0 || !strings.Contains(explanation.String(), "spill files=") {
+			t.Errorf("%s: expected spill files:\n%s", sql, explanation)
+		}
+		assertNoSpillFiles(t, tdb)
+	}
+
+	// Closing a result early removes the files it spilled
+	stmt, _ := r.p.Parse("SELECT * FROM users ORDER BY name")
+	rows, err := tdb.DB.Query(stmt)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if !rows.Next() || rows.Row()["name"] != "user1" {
+		t.Fatalf("expected user1 first, got %v (%v)", rows.Row(), rows.Err())
+	}
+	if files, _ := filepath.Glob(filepath.Join(tdb.DataDir, "spill", "*")); len(files) == 0 {
+		t.Error("expected spill files while the sort is being read")
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	assertNoSpillFiles(t, tdb)
+
+	tdb.DB.SetQueryMemory(0)
+	if memory := tdb.DB.QueryMemory(); memory != database.DefaultQueryMemory {
+		t.Errorf("expected the default budget, got %d", memory)
+	}
+}
+
+func TestJoinSpillsOverMemoryBudget(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	r := sqlRunner{t: t, tdb: tdb, p: parser.New()}
+
+	r.mustExec("CREATE TABLE users (id INT PRIMARY KEY, name TEXT)")
+	r.mustExec("CREATE TABLE orders (id INT PRIMARY KEY, user_id INT, total INT)")
+	for i := 1; i <= 8; i++ {
+		r.mustExec(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user%d')", i, i))
+	}
+	for i := 1; i <= 20; i++ {
+		r.mustExec(fmt.Sprintf("INSERT INTO orders VALUES (%d, %d, %d)", i, i%10, 10*i))
+	}
+
+	const sql = "SELECT * FROM users JOIN orders ON users.id = orders.user_id"
+	stmt, _ := r.p.Parse(sql)
+	join := func() ([]string, *database.Explanation) {
+		t.Helper()
+		explanation, err := tdb.DB.Explain(stmt, true)
+		if err != nil {
+			t.Fatalf("explain: %v", err)
+		}
+		rows, err := tdb.DB.Join(stmt.TableName, stmt.JoinTable, stmt.JoinCondition, stmt.Where)
+		if err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		pairs := make([]string, len(rows))
+		for i, row := range rows {
+			pairs[i] = fmt.Sprintf("%v/%v", row["users.id"], row["orders.id"])
+		}
+		sort.Strings(pairs)
+		return pairs, explanation
+	}
+
+	// Without statistics the join hashes orders; after ANALYZE it compares every pair
+	for _, method := range []string{"Hash Join", "Nested Loop"} {
+		if method == "Nested Loop" {
+			r.mustExec("ANALYZE")
+		}
+		tdb.DB.SetQueryMemory(0)
+		expected, explanation := join()
+		if len(expected) != 16 || !strings.HasPrefix(explanation.String(), method) {
+			t.Fatalf("expected 16 pairs by %s, got %v:\n%s", method, expected, explanation)
+		}
+
+		tdb.DB.SetQueryMemory(1)
+		pairs, explanation := join()
+		if !reflect.DeepEqual(pairs, expected) {
+			t.Errorf("%s spilled: expected %v, got %v", method, expected, pairs)
+		}
+		if spilledFiles(explanation.Plan) == 0 {
+			t.Errorf("%s: expected spill files:\n%s", method, explanation)
+		}
+		assertNoSpillFiles(t, tdb)
+	}
+}
+
+func TestSpillFilesRemovedOnReopen(t *testing.T) {
+	tdb := tests.NewTestDB(t)
+	defer tdb.Cleanup()
+	seedRangeUsers(t, tdb)
+
+	// A crash mid-query leaves its spill files behind
+	stray := filepath.Join(tdb.DataDir, "spill", "spill-1.ndjson")
+	if err := os.MkdirAll(filepath.Dir(stray), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	// Files that are not spill files are not the database's to delete
+	others := []string{filepath.Join(tdb.DataDir, "spill", "notes.txt"), filepath.Join(tdb.DataDir, "tmp", "spill-2.ndjson")}
+	if err := os.MkdirAll(filepath.Join(tdb.DataDir, "tmp"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, path := range append(others, stray) {
+		if err := os.WriteFile(path, []byte("{}\n"), 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	reopen(t, tdb, true)
+	tests.AssertFileNotExists(t, stray)
+	for _, path := range others {
+		tests.AssertFileExists(t, path)
+	}
+	tdb.AssertRowCount("users", 12)
+}
+
+// sortedLines sorts the lines of a query's output
+func sortedLines(out string) string {
+	lines := strings.Split(out, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"testing"
+
+	"rdbms/eventlog"
+	"rdbms/schema"
+	"rdbms/storage"
+)
+
+func TestConvertValueBetweenTypes(t *testing.T) {
+	cases := []struct {
+		val      interface{}
+		from, to schema.ColumnType
+		want     interface{}
+		fails    bool
+	}{
+		{" 42 ", schema.TypeText, schema.TypeInt, float64(42), false},
+		{"4.5", schema.TypeText, schema.TypeInt, nil, true},
+		{"abc", schema.TypeText, schema.TypeInt, nil, true},
+		{"TRUE", schema.TypeText, schema.TypeBool, true, false},
+		{"yes", schema.TypeText, schema.TypeBool, nil, true},
+		{float64(7), schema.TypeInt, schema.TypeText, "7", false},
+		{int64(1), schema.TypeInt, schema.TypeBool, true, false},
+		{float64(2), schema.TypeInt, schema.TypeBool, nil, true},
+		{false, schema.TypeBool, schema.TypeInt, float64(0), false},
+		{true, schema.TypeBool, schema.TypeText, "true", false},
+		{nil, schema.TypeText, schema.TypeInt, nil, false},
+	}
+
+	for _, c := range cases {
+		got, err := schema.ConvertValue(c.val, c.from, c.to)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%v %s->%s: expected failure, got %v", c.val, c.from, c.to, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%v %s->%s: expected %v, got %v (%v)", c.val, c.from, c.to, c.want, got, err)
+		}
+	}
+}
+
+// textToIntRegistry registers users v1 -> v2 changing age from TEXT to INT under the given policy
+func textToIntRegistry(policy schema.ConversionPolicy) *schema.SchemaRegistry {
+	registry := schema.NewSchemaRegistry()
+	registry.RegisterMigrationWithPolicy("users", 1, 2, []schema.MigrationOp{
+		&schema.ModifyColumnOp{
+			ColumnName: "age",
+			OldDef:     schema.Column{Name: "age", Type: schema.TypeText},
+			NewDef:     schema.Column{Name: "age", Type: schema.TypeInt},
+		},
+	}, policy)
+	return registry
+}
+
+func TestModifyColumnFailurePolicies(t *testing.T) {
+	bad := map[string]interface{}{"id": float64(1), "age": "unknown"}
+	good := map[string]interface{}{"id": float64(2), "age": "31"}
+
+	registry := textToIntRegistry(schema.ConversionPolicy{})
+	if row, err := registry.MigrateRow("users", good, 1, 2); err != nil || row["age"] != float64(31) {
+		t.Errorf("expected age converted to 31, got %v (%v)", row, err)
+	}
+	if _, err := registry.MigrateRow("users", bad, 1, 2); err == nil {
+		t.Error("expected conversion error under the default policy")
+	}
+
+	registry = textToIntRegistry(schema.ConversionPolicy{OnFailure: schema.SetNull})
+	if row, err := registry.MigrateRow("users", bad, 1, 2); err != nil || row["age"] != nil {
+		t.Errorf("expected NULL age, got %v (%v)", row, err)
+	}
+
+	registry = textToIntRegistry(schema.ConversionPolicy{OnFailure: schema.UseDefault, Default: float64(-1)})
+	if row, err := registry.MigrateRow("users", bad, 1, 2); err != nil || row["age"] != float64(-1) {
+		t.Errorf("expected default age, got %v (%v)", row, err)
+	}
+
+	registry = textToIntRegistry(schema.ConversionPolicy{OnFailure: schema.DeadLetterRow})
+	if _, err := registry.MigrateRow("users", bad, 1, 2); !schema.IsDeadLetter(err) {
+		t.Errorf("expected dead-letter error, got %v", err)
+	}
+}
+
+func TestModifyColumnDeadLetterReplay(t *testing.T) {
+	insert := func(id uint64, rowID float64, age string) *eventlog.Event {
+		return &eventlog.Event{
+			ID:      id,
+			Type:    eventlog.RowInserted,
+			Version: 1,
+			Payload: map[string]interface{}{
+				"table_name": "users",
+				"row_id":     rowID,
+				"data":       map[string]interface{}{"id": rowID, "age": age},
+			},
+		}
+	}
+	events := []*eventlog.Event{
+		{ID: 1, Type: eventlog.SchemaCreated, Version: 1, Payload: map[string]interface{}{"table_name": "users"}},
+		insert(2, 1, "30"),
+		insert(3, 2, "n/a"),
+		insert(4, 3, "??"),
+		{ID: 5, Type: eventlog.RowDeleted, Version: 1, Payload: map[string]interface{}{"table_name": "users", "row_id": float64(3)}},
+	}
+
+	handler := storage.NewMigrationHandler(textToIntRegistry(schema.ConversionPolicy{OnFailure: schema.DeadLetterRow}))
+
+	// The dry run reports failures before anything is replayed
+	report := handler.DryRun(events, "users", 2)
+	if report.Rows != 2 || report.Failed != 1 || report.DeadLettered != 1 || len(report.Samples) != 1 {
+		t.Errorf("unexpected dry run report: %+v", report)
+	}
+
+	state, err := storage.ReplayEventsWithMigrations(events, storage.SchemaTargets{"users": 2}, handler)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	rows := state.GetTableRows("users")
+	if len(rows) != 1 || rows[0].Row["age"] != float64(30) {
+		t.Errorf("expected only the convertible row, got %v", rows)
+	}
+	dead := state.GetTableRows(schema.DeadLetterTable("users"))
+	if len(dead) != 1 || dead[0].Row["age"] != "n/a" || dead[0].Row["_error"] == nil {
+		t.Errorf("expected the failed row in the dead-letter table, got %v", dead)
+	}
+}
@@ -1,14 +1,20 @@
 package unit
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"rdbms/catalog"
 	"rdbms/eventlog"
 	"rdbms/index"
 	"rdbms/schema"
+	"rdbms/storage"
 	"rdbms/tests"
 )
 
@@ -100,7 +106,7 @@ func TestIndexAdd(t *testing.T) {
 	}
 
 	// Check that duplicate values are stored
-	aliceRowIDs := idx.Data["alice@example.com"]
+	aliceRowIDs := idx.Data[index.EncodeKey("alice@example.com")]
 	if len(aliceRowIDs) != 2 {
 		t.Errorf("expected 2 row IDs for alice, got %d", len(aliceRowIDs))
 	}
@@ -154,6 +160,280 @@ func TestIndexRemove(t *testing.T) {
 	}
 }
 
+// btreeKeys collects the keys a B-tree scan visits
+func btreeKeys(scan func(index.Visitor)) []interface{} {
+	var keys []interface{}
+	scan(func(key interface{}, rowIDs []int64) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// TestBTreeOrdering tests that B-tree keys compare by type, not as text
+func TestBTreeOrdering(t *testing.T) {
+	bt := index.NewBTree("age")
+	for i, v := range []interface{}{float64(10), float64(9), "b", "a", true, nil, float64(100)} {
+		bt.Add(v, int64(i))
+	}
+
+	keys := btreeKeys(func(v index.Visitor) { bt.Ascend(index.Unbounded(), index.Unbounded(), v) })
+	expected := []interface{}{nil, true, float64(9), float64(10), float64(100), "a", "b"}
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+
+	// Integer and float keys for the same number are the same key
+	if ids, found := bt.Lookup(10); !found || len(ids) != 1 || ids[0] != 0 {
+		t.Errorf("expected lookup of 10 to find row 0, got %v", ids)
+	}
+}
+
+// TestBTreeRangeAndPrefix tests range, reverse and prefix scans
+func TestBTreeRangeAndPrefix(t *testing.T) {
+	bt := index.NewBTree("id")
+	for i := 1; i <= 500; i++ {
+		bt.Add(float64(i), int64(i))
+	}
+
+	between := btreeKeys(func(v index.Visitor) { bt.Ascend(index.Inclusive(float64(10)), index.Exclusive(float64(15)), v) })
+	if fmt.Sprint(between) != "[10 11 12 13 14]" {
+		t.Errorf("unexpected range [10, 15): %v", between)
+	}
+
+	reverse := btreeKeys(func(v index.Visitor) { bt.Descend(index.Exclusive(float64(495)), index.Unbounded(), v) })
+	if fmt.Sprint(reverse) != "[500 499 498 497 496]" {
+		t.Errorf("unexpected reverse range (495, inf): %v", reverse)
+	}
+
+	// A visitor can stop a scan early
+	var first []interface{}
+	bt.Descend(index.Unbounded(), index.Inclusive(float64(300)), func(key interface{}, rowIDs []int64) bool {
+		first = append(first, key)
+		return len(first) < 3
+	})
+	if fmt.Sprint(first) != "[300 299 298]" {
+		t.Errorf("expected the scan to stop after 3 keys, got %v", first)
+	}
+
+	names := index.NewBTree("name")
+	for i, name := range []string{"alice", "albert", "bob", "al", "alfred", "amy"} {
+		names.Add(name, int64(i))
+	}
+	prefix := btreeKeys(func(v index.Visitor) { names.AscendPrefix("al", v) })
+	if fmt.Sprint(prefix) != "[al albert alfred alice]" {
+		t.Errorf("unexpected prefix scan: %v", prefix)
+	}
+	prefix = btreeKeys(func(v index.Visitor) { names.DescendPrefix("al", v) })
+	if fmt.Sprint(prefix) != "[alice alfred albert al]" {
+		t.Errorf("unexpected reverse prefix scan: %v", prefix)
+	}
+}
+
+// TestBTreeAddRemove tests the B-tree against a sorted reference through
+// enough inserts and removals to split and merge nodes
+func TestBTreeAddRemove(t *testing.T) {
+	bt := index.NewBTree("n")
+	rng := rand.New(rand.NewSource(1))
+	present := make(map[int]bool)
+
+	for i := 0; i < 20000; i++ {
+		n := rng.Intn(3000)
+		if present[n] && rng.Intn(2) == 0 {
+			bt.Remove(float64(n), int64(n))
+			delete(present, n)
+		} else if !present[n] {
+			bt.Add(float64(n), int64(n))
+			present[n] = true
+		}
+	}
+
+	var expected []int
+	for n := range present {
+		expected = append(expected, n)
+	}
+	sort.Ints(expected)
+
+	keys := btreeKeys(func(v index.Visitor) { bt.Ascend(index.Unbounded(), index.Unbounded(), v) })
+	if bt.Len() != len(expected) || len(keys) != len(expected) {
+		t.Fatalf("expected %d keys, got Len %d and %d scanned", len(expected), bt.Len(), len(keys))
+	}
+	for i, n := range expected {
+		if keys[i] != float64(n) {
+			t.Fatalf("key %d: expected %d, got %v", i, n, keys[i])
+		}
+	}
+
+	// Removing one of several rows keeps the key
+	bt.Add(float64(expected[0]), 99999)
+	bt.Remove(float64(expected[0]), int64(expected[0]))
+	if ids, found := bt.Lookup(float64(expected[0])); !found || len(ids) != 1 || ids[0] != 99999 {
+		t.Errorf("expected the remaining row to keep the key, got %v", ids)
+	}
+
+	for _, n := range expected {
+		bt.Remove(float64(n), int64(n))
+	}
+	bt.Remove(float64(expected[0]), 99999)
+	if bt.Len() != 0 || bt.Exists(float64(expected[0])) {
+		t.Errorf("expected an empty tree, got %d keys", bt.Len())
+	}
+}
+
+// TestEncodeKey tests that encoded keys keep types apart and sort like Compare
+func TestEncodeKey(t *testing.T) {
+	distinct := [][2]interface{}{
+		{"1", float64(1)},
+		{"true", true},
+		{"", nil},
+		{"1e+21", 1e21},
+		{false, float64(0)},
+		{index.Key{"a", "b"}, index.Key{"a\x00b"}},
+		{index.Key{"ab"}, index.Key{"a", "b"}},
+	}
+	for _, pair := range distinct {
+		if index.EncodeKey(pair[0]) == index.EncodeKey(pair[1]) {
+			t.Errorf("expected %#v and %#v to encode differently", pair[0], pair[1])
+		}
+	}
+
+	same := [][2]interface{}{
+		{float64(1), 1},
+		{int64(7), float32(7)},
+		{math.Copysign(0, -1), float64(0)},
+	}
+	for _, pair := range same {
+		if index.EncodeKey(pair[0]) != index.EncodeKey(pair[1]) {
+			t.Errorf("expected %#v and %#v to encode the same", pair[0], pair[1])
+		}
+	}
+
+	values := []interface{}{
+		nil, false, true, math.Inf(-1), -1e300, float64(-2), -0.5, float64(0), 1e-300, float64(9), float64(10),
+		math.Inf(1), "", "\x00", "a", "a\x00", "a\x00b", "ab", "b",
+		index.Key{nil}, index.Key{float64(1)}, index.Key{float64(1), "a"}, index.Key{float64(1), "a", true},
+		index.Key{float64(1), "b"}, index.Key{float64(2)}, index.Key{"a"},
+	}
+	for _, a := range values {
+		for _, b := range values {
+			got := strings.Compare(index.EncodeKey(a), index.EncodeKey(b))
+			if got != index.Compare(a, b) {
+				t.Errorf("%#v vs %#v: encodings compare %d, values %d", a, b, got, index.Compare(a, b))
+			}
+		}
+	}
+
+	// Keys decode to values that encode the same way again
+	for _, v := range values {
+		decoded, err := index.DecodeKey(index.EncodeKey(v))
+		if err != nil || index.Compare(decoded, v) != 0 {
+			t.Errorf("%#v: decoded as %#v (%v)", v, decoded, err)
+		}
+	}
+	if _, err := index.DecodeKey(index.EncodeKey("abc")[:3]); err == nil {
+		t.Error("expected a truncated key to fail to decode")
+	}
+
+	// A hash index no longer mixes up types
+	idx := index.New("code")
+	idx.Add("1", 1)
+	if idx.Exists(float64(1)) || !idx.Exists("1") {
+		t.Error("expected the hash index to tell \"1\" from 1")
+	}
+}
+
+// TestFullTextIndex tests analysis, BM25 ranking and saving a full-text index
+func TestFullTextIndex(t *testing.T) {
+	plain := index.Analyzer{}
+	if terms := plain.Terms("The disk's I/O-errors, again!"); fmt.Sprint(terms) != "[the disk s i o errors again]" {
+		t.Errorf("unexpected terms: %v", terms)
+	}
+	english := index.Analyzer{Stem: true, Stopwords: true}
+	if terms := english.Terms("The crashes of running jobs, crashed quickly"); fmt.Sprint(terms) != "[crash run job crash quick]" {
+		t.Errorf("unexpected analyzed terms: %v", terms)
+	}
+
+	ft := index.NewFullText("body", english)
+	docs := map[int64]string{
+		1: "Login page crashes on submit",
+		2: "Crash when uploading a large file, the upload crashed twice",
+		3: "Typo on the pricing page",
+		4: "Dark mode request for the settings page and the login page",
+	}
+	for id, text := range docs {
+		ft.Add(text, id)
+	}
+
+	ranked := func(query string) string {
+		var ids []string
+		for _, m := range ft.Search(query) {
+			ids = append(ids, fmt.Sprint(m.RowID))
+		}
+		return strings.Join(ids, ",")
+	}
+	// Two mentions of "crash" outrank one in a text of similar weight
+	if got := ranked("crashing"); got != "2,1" {
+		t.Errorf("crashing: expected 2,1, got %s", got)
+	}
+	// "login" is rarer than "page", so the rows with both come first, the
+	// shorter one ahead of the longer
+	if got := ranked("login page"); got != "1,4,3" {
+		t.Errorf("login page: expected 1,4,3, got %s", got)
+	}
+	if got := ranked("the of"); got != "" {
+		t.Errorf("stopwords alone should match nothing, got %s", got)
+	}
+
+	saved := index.NewFullText("body", english)
+	if err := saved.Load(ft.Entries()); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	before, after := ft.Search("login page crash"), saved.Search("login page crash")
+	if fmt.Sprint(before) != fmt.Sprint(after) {
+		t.Errorf("scores changed across save and load: %v vs %v", before, after)
+	}
+
+	ft.Remove(nil, 1)
+	ft.Add("Login works again", 3)
+	if got := ranked("login"); got != "3,4" {
+		t.Errorf("after changes expected 3,4, got %s", got)
+	}
+	if ft.Exists("pricing") || !ft.Exists("upload") {
+		t.Error("unexpected Exists results after replacing row 3")
+	}
+}
+
+func TestIndexExpressions(t *testing.T) {
+	row := storage.Row{"email": "Ann@Example.com", "age": float64(30)}
+	cases := []struct {
+		ref      string
+		expected interface{}
+		exists   bool
+	}{
+		{"email", "Ann@Example.com", true},
+		{"LOWER(email)", "ann@example.com", true},
+		{"UPPER(email)", "ANN@EXAMPLE.COM", true},
+		{"LOWER(age)", float64(30), true},
+		{"LOWER(missing)", nil, false},
+		{"TRIM(email)", nil, false},
+	}
+	for _, c := range cases {
+		if val, exists := index.ValueOf(c.ref, row); val != c.expected || exists != c.exists {
+			t.Errorf("%s: expected %v, %v, got %v, %v", c.ref, c.expected, c.exists, val, exists)
+		}
+	}
+
+	if key, _ := index.KeyOf([]string{"LOWER(email)", "age"}, row); index.Compare(key, index.Key{"ann@example.com", float64(30)}) != 0 {
+		t.Errorf("unexpected composite key: %v", key)
+	}
+	if fn, column, err := index.SplitRef("LOWER(email)"); fn != "LOWER" || column != "email" || err != nil {
+		t.Errorf("unexpected split: %s %s %v", fn, column, err)
+	}
+	if _, _, err := index.SplitRef("TRIM(email)"); err == nil {
+		t.Error("expected an error for an unknown function")
+	}
+}
+
 // TestCatalogCreate tests creating a catalog
 func TestCatalogCreate(t *testing.T) {
 	tempDir := t.TempDir()
@@ -344,3 +624,50 @@ func TestCatalogFilePath(t *testing.T) {
 		t.Error("catalog file was not created on disk")
 	}
 }
+
+// TestCompositeKeys tests composite keys in hash indexes and B-trees
+func TestCompositeKeys(t *testing.T) {
+	if index.Compare(index.Key{float64(1), "b"}, index.Key{float64(2), "a"}) >= 0 {
+		t.Error("expected keys to compare by their first column first")
+	}
+	if index.Compare(index.Key{float64(1)}, index.Key{float64(1), "a"}) >= 0 {
+		t.Error("expected a prefix to sort before the keys extending it")
+	}
+
+	row := storage.Row{"user_id": float64(7), "group_id": float64(3)}
+	if key, ok := index.KeyOf([]string{"group_id", "user_id"}, row); !ok || key.(index.Key).String() != "(3, 7)" {
+		t.Errorf("unexpected key: %v", key)
+	}
+	if _, ok := index.KeyOf([]string{"group_id", "role"}, row); ok {
+		t.Error("expected no key for a row missing a column")
+	}
+
+	hash := index.NewComposite([]string{"group_id", "user_id"})
+	hash.Add(index.Key{float64(3), float64(7)}, 1)
+	if !hash.Exists(index.Key{3, 7}) || hash.Exists(index.Key{float64(7), float64(3)}) {
+		t.Error("expected composite hash lookups to match whole keys in order")
+	}
+
+	bt := index.NewCompositeBTree([]string{"group_id", "user_id"})
+	for g := 1; g <= 3; g++ {
+		for u := 1; u <= 100; u++ {
+			bt.Add(index.Key{float64(g), float64(u)}, int64(g*1000+u))
+		}
+	}
+	var ids []int64
+	bt.AscendKeyPrefix(index.Key{float64(2)}, func(key interface{}, rowIDs []int64) bool {
+		ids = append(ids, rowIDs...)
+		return true
+	})
+	if len(ids) != 100 || ids[0] != 2001 || ids[99] != 2100 {
+		t.Errorf("expected group 2's 100 rows in order, got %d from %v", len(ids), ids[:1])
+	}
+	keys := btreeKeys(func(v index.Visitor) {
+		bt.DescendKeyPrefix(index.Key{float64(3)}, func(key interface{}, rowIDs []int64) bool {
+			return v(key, rowIDs) && key.(index.Key)[1] != float64(98)
+		})
+	})
+	if fmt.Sprint(keys) != "[(3, 100) (3, 99) (3, 98)]" {
+		t.Errorf("unexpected reverse prefix scan: %v", keys)
+	}
+}
@@ -1,6 +1,8 @@
 package unit
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
 	"rdbms/parser"
@@ -139,6 +141,19 @@ func TestParseCreateTable(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:      "not null and default",
+			sql:       "CREATE TABLE users (id INT PRIMARY KEY, name TEXT NOT NULL, active BOOL DEFAULT true)",
+			expectErr: false,
+			checkFunc: func(t *testing.T, stmt *parser.ParsedStatement) {
+				if !stmt.Columns[1].NotNull || stmt.Columns[1].Default != nil {
+					t.Errorf("expected name NOT NULL without default, got %+v", stmt.Columns[1])
+				}
+				if stmt.Columns[2].NotNull || stmt.Columns[2].Default != true {
+					t.Errorf("expected active to default to true, got %+v", stmt.Columns[2])
+				}
+			},
+		},
 		{
 			name:      "case insensitive create table",
 			sql:       "create table mytable (id INT PRIMARY KEY)",
@@ -471,3 +486,367 @@ func TestParseMultipleStatements(t *testing.T) {
 		}
 	}
 }
+
+// TestParseSelectAsOf tests temporal SELECT statements
+func TestParseSelectAsOf(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.Parse("SELECT * FROM users AS OF 42 WHERE name = 'Alice'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stmt.AsOf != 42 {
+		t.Errorf("expected AS OF 42, got %d", stmt.AsOf)
+	}
+	if stmt.Where == nil || stmt.Where.Column != "name" || stmt.Where.Value != "Alice" {
+		t.Errorf("unexpected where clause: %+v", stmt.Where)
+	}
+
+	stmt, err = p.Parse("SELECT * FROM users")
+	if err != nil || stmt.AsOf != 0 {
+		t.Errorf("expected current-state select, got %+v (%v)", stmt, err)
+	}
+
+	if _, err := p.Parse("SELECT * FROM users AS OF yesterday"); err == nil {
+		t.Error("expected error for non-numeric AS OF")
+	}
+}
+
+// TestParseSelectRange tests range conditions, ORDER BY and LIMIT
+func TestParseSelectRange(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.Parse("SELECT * FROM users WHERE age BETWEEN 20 AND 30 ORDER BY age DESC LIMIT 5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w := stmt.Where; w == nil || w.Column != "age" || w.Operator != "BETWEEN" || w.Value != float64(20) || w.High != float64(30) {
+		t.Errorf("unexpected where clause: %+v", stmt.Where)
+	}
+	if stmt.OrderBy == nil || stmt.OrderBy.Column != "age" || !stmt.OrderBy.Desc || stmt.Limit != 5 {
+		t.Errorf("unexpected order/limit: %+v %d", stmt.OrderBy, stmt.Limit)
+	}
+
+	for sql, op := range map[string]string{
+		"SELECT * FROM users WHERE age < 30":       "<",
+		"SELECT * FROM users WHERE age<=30":        "<=",
+		"SELECT * FROM users WHERE age > 30;":      ">",
+		"SELECT * FROM users WHERE name >= 'M'":    ">=",
+		"SELECT * FROM users WHERE name = 'a < b'": "=",
+	} {
+		stmt, err := p.Parse(sql)
+		if err != nil || stmt.Where == nil || stmt.Where.Operator != op {
+			t.Errorf("%s: expected operator %s, got %+v (%v)", sql, op, stmt, err)
+		}
+	}
+
+	stmt, err = p.Parse("SELECT * FROM users ORDER BY name LIMIT 3")
+	if err != nil || stmt.Where != nil || stmt.OrderBy.Desc || stmt.Limit != 3 {
+		t.Errorf("unexpected statement: %+v (%v)", stmt, err)
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM users LIMIT 0",
+		"SELECT * FROM users WHERE age",
+		"SELECT * FROM users ORDER age",
+	} {
+		if _, err := p.Parse(sql); err == nil {
+			t.Errorf("expected error for: %s", sql)
+		}
+	}
+}
+
+func TestParseConjunctions(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.Parse("SELECT * FROM members WHERE group_id = 1 AND age BETWEEN 20 AND 30 AND note = 'black and white' ORDER BY user_id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conds := stmt.Where.Conditions()
+	if len(conds) != 3 {
+		t.Fatalf("expected 3 conditions, got %d", len(conds))
+	}
+	if conds[0].Column != "group_id" || conds[0].Value != float64(1) {
+		t.Errorf("unexpected first condition: %+v", conds[0])
+	}
+	if conds[1].Operator != "BETWEEN" || conds[1].Value != float64(20) || conds[1].High != float64(30) {
+		t.Errorf("unexpected BETWEEN condition: %+v", conds[1])
+	}
+	if conds[2].Value != "black and white" {
+		t.Errorf("unexpected quoted condition: %+v", conds[2])
+	}
+
+	stmt, err = p.Parse("DELETE FROM members WHERE user_id = 2 AND group_id = 3;")
+	if err != nil || len(stmt.Where.Conditions()) != 2 || stmt.Where.And.Value != float64(3) {
+		t.Errorf("unexpected DELETE: %+v (%v)", stmt, err)
+	}
+	stmt, err = p.Parse("UPDATE members SET role = 'admin' WHERE user_id = 2 AND group_id > 3")
+	if err != nil || stmt.SetValue != "admin" || len(stmt.Where.Conditions()) != 2 || stmt.Where.And.Operator != ">" {
+		t.Errorf("unexpected UPDATE: %+v (%v)", stmt, err)
+	}
+
+	stmt, err = p.Parse("CREATE TABLE members (user_id INT, group_id INT, role TEXT DEFAULT 'member', PRIMARY KEY (group_id, user_id))")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stmt.Columns) != 3 || fmt.Sprint(stmt.PrimaryKey) != "[group_id user_id]" {
+		t.Errorf("unexpected CREATE TABLE: columns %+v, key %v", stmt.Columns, stmt.PrimaryKey)
+	}
+}
+
+func TestParseIndexStatements(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.Parse("CREATE UNIQUE INDEX users_email ON users (email) USING hash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stmt.Type != "CREATE_INDEX" || stmt.TableName != "users" {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+	if idx := stmt.Index; idx == nil || idx.Name != "users_email" || !idx.Unique || idx.Method != schema.IndexHash ||
+		len(idx.Columns) != 1 || idx.Columns[0] != "email" {
+		t.Errorf("unexpected index: %+v", stmt.Index)
+	}
+
+	stmt, err = p.Parse("create index by_name_age on users (name, age);")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idx := stmt.Index; idx.Unique || idx.Method != "" || len(idx.Columns) != 2 || idx.Columns[1] != "age" {
+		t.Errorf("unexpected index: %+v", stmt.Index)
+	}
+
+	stmt, err = p.Parse("DROP INDEX users_email")
+	if err != nil || stmt.Type != "DROP_INDEX" || stmt.Index.Name != "users_email" {
+		t.Errorf("unexpected statement: %+v (%v)", stmt, err)
+	}
+
+	for _, sql := range []string{
+		"CREATE INDEX ON users (email) WHERE",
+		"CREATE INDEX i ON users ()",
+		"CREATE INDEX i ON users (email) USING GIST",
+		"DROP INDEX",
+	} {
+		if _, err := p.Parse(sql); err == nil {
+			t.Errorf("expected error for: %s", sql)
+		}
+	}
+}
+
+// TestParsePartialAndExpressionIndexes tests CREATE INDEX with a predicate or
+// LOWER/UPPER columns, and function calls in WHERE
+func TestParsePartialAndExpressionIndexes(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.Parse("CREATE INDEX ON tasks (owner) WHERE completed = FALSE AND priority BETWEEN 1 AND 3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idx := stmt.Index; idx.Name != "tasks_owner_idx" || fmt.Sprint(idx.Columns) != "[owner]" ||
+		idx.Where != "completed = false AND priority BETWEEN 1 AND 3" {
+		t.Errorf("unexpected index: %+v", stmt.Index)
+	}
+
+	stmt, err = p.Parse("create unique index on users (lower( email ), age) using hash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idx := stmt.Index; idx.Name != "users_lower_email_age_idx" || fmt.Sprint(idx.Columns) != "[LOWER(email) age]" ||
+		!idx.Unique || idx.Method != schema.IndexHash || idx.Where != "" {
+		t.Errorf("unexpected index: %+v", stmt.Index)
+	}
+
+	stmt, err = p.Parse("SELECT * FROM users WHERE Upper(name) BETWEEN 'A' AND 'M' AND lower(email) = 'a@b.c'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := stmt.Where.String(); got != "UPPER(name) BETWEEN 'A' AND 'M' AND LOWER(email) = 'a@b.c'" {
+		t.Errorf("unexpected WHERE: %s", got)
+	}
+
+	for _, sql := range []string{
+		"CREATE INDEX ON users (TRIM(email))",
+		"CREATE INDEX ON users (email) WHERE nonsense",
+		"SELECT * FROM users WHERE TRIM(name) = 'a'",
+	} {
+		if _, err := p.Parse(sql); err == nil {
+			t.Errorf("expected error for: %s", sql)
+		}
+	}
+}
+
+// TestParseAlter tests ALTER TABLE statements used by migration files
+func TestParseAlter(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.ParseAlter("ALTER TABLE users ADD COLUMN email TEXT UNIQUE DEFAULT 'a, b', RENAME nick TO name, ALTER COLUMN age TYPE INT, DROP legacy;")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stmt.TableName != "users" || len(stmt.Operations) != 4 {
+		t.Fatalf("unexpected statement: %+v", stmt)
+	}
+
+	add, ok := stmt.Operations[0].(*schema.AddColumnOp)
+	if !ok || add.Column.Name != "email" || add.Column.Type != schema.TypeText || !add.Column.Unique || add.Default != "a, b" {
+		t.Errorf("unexpected add op: %+v", stmt.Operations[0])
+	}
+	if rename, ok := stmt.Operations[1].(*schema.RenameColumnOp); !ok || rename.OldName != "nick" || rename.NewName != "name" {
+		t.Errorf("unexpected rename op: %+v", stmt.Operations[1])
+	}
+	if modify, ok := stmt.Operations[2].(*schema.ModifyColumnOp); !ok || modify.ColumnName != "age" || modify.NewDef.Type != schema.TypeInt {
+		t.Errorf("unexpected modify op: %+v", stmt.Operations[2])
+	}
+	if drop, ok := stmt.Operations[3].(*schema.RemoveColumnOp); !ok || drop.ColumnName != "legacy" {
+		t.Errorf("unexpected drop op: %+v", stmt.Operations[3])
+	}
+
+	stmt, err = p.ParseAlter("ALTER TABLE users ADD email TEXT NOT NULL DEFAULT 'none'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if add := stmt.Operations[0].(*schema.AddColumnOp); !add.Column.NotNull || add.Default != "none" {
+		t.Errorf("unexpected NOT NULL add op: %+v", add)
+	}
+
+	for _, sql := range []string{
+		"ALTER TABLE users",
+		"ALTER TABLE users ADD COLUMN x FLOAT",
+		"ALTER TABLE users ADD COLUMN x TEXT NOT",
+		"ALTER TABLE users TRUNCATE",
+	} {
+		if _, err := p.ParseAlter(sql); err == nil {
+			t.Errorf("expected error for: %s", sql)
+		}
+	}
+}
+
+// TestParseFullText tests CREATE FULLTEXT INDEX and MATCH ... AGAINST
+func TestParseFullText(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.Parse("CREATE FULLTEXT INDEX ON tickets (description)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idx := stmt.Index; stmt.Type != "CREATE_INDEX" || stmt.TableName != "tickets" || idx.Name != "tickets_description_fulltext" ||
+		idx.Method != schema.IndexFullText || idx.Stemming || idx.Stopwords {
+		t.Errorf("unexpected statement: %+v, index %+v", stmt, stmt.Index)
+	}
+
+	stmt, err = p.Parse("create fulltext index search on tickets (description) with (Stemming, stopwords);")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idx := stmt.Index; idx.Name != "search" || !idx.Stemming || !idx.Stopwords {
+		t.Errorf("unexpected index: %+v", stmt.Index)
+	}
+
+	for _, sql := range []string{
+		"CREATE FULLTEXT INDEX ON tickets (title, description)",
+		"CREATE FULLTEXT INDEX ON tickets (description) WITH (soundex)",
+		"CREATE FULLTEXT INDEX ON tickets",
+	} {
+		if _, err := p.Parse(sql); err == nil {
+			t.Errorf("expected an error for %q", sql)
+		}
+	}
+
+	stmt, err = p.Parse("SELECT * FROM tickets WHERE MATCH(description) AGAINST ('disk and network errors') AND status = 'open' LIMIT 5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conds := stmt.Where.Conditions()
+	if len(conds) != 2 || conds[0].Operator != "MATCH" || conds[0].Column != "description" ||
+		conds[0].Value != "disk and network errors" || conds[1].Column != "status" || stmt.Limit != 5 {
+		t.Errorf("unexpected WHERE: %+v", conds)
+	}
+
+	if _, err := p.Parse("DELETE FROM tickets WHERE match (description) against (\"spam\")"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// TestParseAnalyze tests ANALYZE with and without a table
+func TestParseAnalyze(t *testing.T) {
+	p := parser.New()
+
+	for sql, table := range map[string]string{"ANALYZE users": "users", "analyze;": "", "ANALYZE": ""} {
+		stmt, err := p.Parse(sql)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", sql, err)
+		}
+		if stmt.Type != "ANALYZE" || stmt.TableName != table {
+			t.Errorf("%s: unexpected statement: %+v", sql, stmt)
+		}
+	}
+	if _, err := p.Parse("ANALYZE users orders"); err == nil {
+		t.Error("expected error for two tables")
+	}
+}
+
+// TestParseExplain tests EXPLAIN and EXPLAIN ANALYZE around SELECT and JOIN
+func TestParseExplain(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.Parse("EXPLAIN SELECT * FROM users WHERE age > 30 ORDER BY age LIMIT 5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stmt.Type != "EXPLAIN" || stmt.ExplainAnalyze || stmt.Explain.Type != "SELECT" || stmt.Explain.Limit != 5 {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+
+	stmt, err = p.Parse("explain analyze SELECT * FROM users JOIN orders ON users.id = orders.user_id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !stmt.ExplainAnalyze || stmt.Explain.Type != "JOIN" || stmt.Explain.JoinTable != "orders" {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+
+	for _, sql := range []string{"EXPLAIN", "EXPLAIN UPDATE users SET age = 1 WHERE id = 1", "EXPLAIN ANALYZE users"} {
+		if _, err := p.Parse(sql); err == nil {
+			t.Errorf("expected error for: %s", sql)
+		}
+	}
+}
+
+func TestParseSelectList(t *testing.T) {
+	p := parser.New()
+
+	stmt, err := p.Parse("SELECT status, count(*), AVG(age) FROM users WHERE age > 30 GROUP BY status ORDER BY status DESC LIMIT 3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []parser.SelectItem{{Column: "status"}, {Aggregate: "COUNT", Column: "*"}, {Aggregate: "AVG", Column: "age"}}
+	if !reflect.DeepEqual(stmt.Select, expected) {
+		t.Errorf("unexpected SELECT list: %+v", stmt.Select)
+	}
+	if !reflect.DeepEqual(stmt.GroupBy, []string{"status"}) || stmt.Where == nil || stmt.OrderBy.Column != "status" || stmt.Limit != 3 {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+	if stmt.Select[1].Name() != "COUNT(*)" {
+		t.Errorf("unexpected name: %s", stmt.Select[1].Name())
+	}
+
+	stmt, err = p.Parse("SELECT name, email FROM users AS OF 4 GROUP BY name, email")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stmt.Select) != 2 || stmt.AsOf != 4 || !reflect.DeepEqual(stmt.GroupBy, []string{"name", "email"}) {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+
+	if stmt, _ := p.Parse("SELECT * FROM users"); stmt.Select != nil {
+		t.Errorf("expected a nil SELECT list for *, got %+v", stmt.Select)
+	}
+
+	for _, sql := range []string{"SELECT SUM(*) FROM users", "SELECT name age FROM users", "SELECT COUNT(id FROM users"} {
+		if _, err := p.Parse(sql); err == nil {
+			t.Errorf("expected error for: %s", sql)
+		}
+	}
+}